@@ -0,0 +1,356 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: ast.go
+// Package: scan
+// Description: 本文件定义了导出的强类型抽象语法树以及从 ASTNode 的转换
+// 				ASTNode 用 nodeK/nodeT/attribute 和 left/mid/right 的位置约定表示各种节点,
+// 				这里为每种节点定义独立的结构体,子节点按名字访问,下游工具不再依赖位置约定
+// 				转换只读取原有语法树,不修改它;遇到不符合约定的节点时返回带行号的错误
+
+package scan
+
+import "errors"
+
+// 强类型语法树节点
+type Node interface {
+	Pos() int // 节点所处行号
+}
+
+// 声明: *VarDecl 或 *FuncDecl
+type Decl interface {
+	Node
+	declNode()
+}
+
+// 语句: *CompoundStmt、*IfStmt、*WhileStmt、*ReturnStmt 或 *ExprStmt
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// 表达式: *VarExpr、*AssignExpr、*CallExpr、*BinaryExpr 或 *ConstExpr
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// 整个程序,由全局声明按出现顺序组成
+type Program struct {
+	Decls []Decl
+}
+
+// 变量声明,数组声明的 Size 为数组大小
+type VarDecl struct {
+	Line    int
+	Name    string
+	Type    VarType // VAR_TYPE_INT、VAR_TYPE_INT_VECTOR 或 VAR_TYPE_VOID
+	IsArray bool
+	Size    int64
+}
+
+// 函数声明
+type FuncDecl struct {
+	Line       int
+	Name       string
+	ReturnType VarType // VAR_TYPE_INT 或 VAR_TYPE_VOID
+	Params     []*Param
+	Body       *CompoundStmt
+}
+
+// 函数形参,数组形参没有大小
+type Param struct {
+	Line    int
+	Name    string
+	Type    VarType
+	IsArray bool
+}
+
+// 复合语句,局部变量声明在语句之前
+type CompoundStmt struct {
+	Line  int
+	Decls []*VarDecl
+	Stmts []Stmt
+}
+
+// 选择语句,Then 和 Else 为空语句时为 nil
+type IfStmt struct {
+	Line int
+	Cond Expr
+	Then Stmt
+	Else Stmt
+}
+
+// 循环语句
+type WhileStmt struct {
+	Line int
+	Cond Expr
+	Body Stmt
+}
+
+// 返回语句,没有返回值时 Result 为 nil
+type ReturnStmt struct {
+	Line   int
+	Result Expr
+}
+
+// 表达式语句
+type ExprStmt struct {
+	Line int
+	X    Expr
+}
+
+// 变量引用,数组元素的 Index 为下标表达式,否则为 nil
+type VarExpr struct {
+	Line  int
+	Name  string
+	Index Expr
+}
+
+// 赋值表达式
+type AssignExpr struct {
+	Line   int
+	Target *VarExpr
+	Value  Expr
+}
+
+// 函数调用
+type CallExpr struct {
+	Line int
+	Name string
+	Args []Expr
+}
+
+// 二元运算,Op 为 PLUS、MINUS、MUL、DIV 或比较运算符 LT、LE、GT、GE、EQ、NOT_EQ
+type BinaryExpr struct {
+	Line int
+	Op   Token
+	X, Y Expr
+}
+
+// 整数常量
+type ConstExpr struct {
+	Line  int
+	Value int64
+}
+
+func (n *VarDecl) Pos() int      { return n.Line }
+func (n *FuncDecl) Pos() int     { return n.Line }
+func (n *Param) Pos() int        { return n.Line }
+func (n *CompoundStmt) Pos() int { return n.Line }
+func (n *IfStmt) Pos() int       { return n.Line }
+func (n *WhileStmt) Pos() int    { return n.Line }
+func (n *ReturnStmt) Pos() int   { return n.Line }
+func (n *ExprStmt) Pos() int     { return n.Line }
+func (n *VarExpr) Pos() int      { return n.Line }
+func (n *AssignExpr) Pos() int   { return n.Line }
+func (n *CallExpr) Pos() int     { return n.Line }
+func (n *BinaryExpr) Pos() int   { return n.Line }
+func (n *ConstExpr) Pos() int    { return n.Line }
+
+func (*VarDecl) declNode()  {}
+func (*FuncDecl) declNode() {}
+
+func (*CompoundStmt) stmtNode() {}
+func (*IfStmt) stmtNode()       {}
+func (*WhileStmt) stmtNode()    {}
+func (*ReturnStmt) stmtNode()   {}
+func (*ExprStmt) stmtNode()     {}
+
+func (*VarExpr) exprNode()    {}
+func (*AssignExpr) exprNode() {}
+func (*CallExpr) exprNode()   {}
+func (*BinaryExpr) exprNode() {}
+func (*ConstExpr) exprNode()  {}
+
+// 判断是否为比较运算
+func (n *BinaryExpr) IsCompare() bool {
+	switch n.Op {
+	case LT, LE, GT, GE, EQ, NOT_EQ:
+		return true
+	}
+	return false
+}
+
+// 把 Parse 生成的语法树(全局声明的兄弟序列)转换为强类型语法树
+func ConvertProgram(root *ASTNode) (*Program, error) {
+	var c astConverter
+	prog := &Program{}
+	for node := root; node != nil; node = node.sibling {
+		if d := c.decl(node); d != nil {
+			prog.Decls = append(prog.Decls, d)
+		}
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return prog, nil
+}
+
+// 转换单个声明节点,用于 ParseDeclaration 的结果
+func ConvertDecl(node *ASTNode) (Decl, error) {
+	var c astConverter
+	d := c.decl(node)
+	return d, c.err
+}
+
+// 转换单个语句节点,表达式节点转换为 *ExprStmt,用于 ParseStatement 的结果
+func ConvertStmt(node *ASTNode) (Stmt, error) {
+	var c astConverter
+	s := c.stmt(node)
+	return s, c.err
+}
+
+// 转换单个表达式节点,用于 ParseExpression 的结果
+func ConvertExpr(node *ASTNode) (Expr, error) {
+	var c astConverter
+	e := c.expr(node)
+	return e, c.err
+}
+
+// 语法树转换器,记录遇到的第一个错误
+type astConverter struct {
+	err error
+}
+
+// 记录错误,id 为消息编号
+func (c *astConverter) fail(node *ASTNode, id string, a ...interface{}) {
+	if c.err == nil {
+		c.err = errors.New(Msg(MSG_AT_LINE, node.line, Msg(id, a...)))
+	}
+}
+
+// 转换全局声明
+func (c *astConverter) decl(node *ASTNode) Decl {
+	switch {
+	case isStmt(node, VAR_DECLARATION):
+		return c.varDecl(node)
+	case isStmt(node, FUNC_DECLARATION):
+		fn := &FuncDecl{Line: node.line, Name: nodeName(node), ReturnType: VAR_TYPE_VOID}
+		if funcReturnsInt(node) {
+			fn.ReturnType = VAR_TYPE_INT
+		}
+		for _, p := range funcParams(node) {
+			param := &Param{Line: p.line, Name: nodeName(p), Type: VAR_TYPE_INT}
+			if p.left != nil {
+				param.Type = p.left.varT
+			}
+			param.IsArray = param.Type == VAR_TYPE_INT_VECTOR
+			fn.Params = append(fn.Params, param)
+		}
+		if !isStmt(node.right, COMPOUND) {
+			c.fail(node, MSG_CONVERT_NO_BODY, fn.Name)
+			return fn
+		}
+		fn.Body = c.compound(node.right)
+		return fn
+	case node != nil:
+		c.fail(node, MSG_CONVERT_DECL)
+	}
+	return nil
+}
+
+// 转换变量声明
+func (c *astConverter) varDecl(node *ASTNode) *VarDecl {
+	d := &VarDecl{Line: node.line, Name: nodeName(node), Type: VAR_TYPE_INT}
+	if node.left != nil {
+		d.Type = node.left.varT
+	}
+	if d.Type == VAR_TYPE_INT_VECTOR {
+		d.IsArray = true
+		if node.right != nil {
+			d.Size = nodeValue(node.right)
+		}
+	}
+	return d
+}
+
+// 转换复合语句
+func (c *astConverter) compound(node *ASTNode) *CompoundStmt {
+	res := &CompoundStmt{Line: node.line}
+	for d := node.left; d != nil; d = d.sibling {
+		if !isStmt(d, VAR_DECLARATION) {
+			c.fail(d, MSG_CONVERT_LOCAL)
+			continue
+		}
+		res.Decls = append(res.Decls, c.varDecl(d))
+	}
+	for s := node.right; s != nil; s = s.sibling {
+		if stmt := c.stmt(s); stmt != nil {
+			res.Stmts = append(res.Stmts, stmt)
+		}
+	}
+	return res
+}
+
+// 转换语句,空语句返回 nil
+func (c *astConverter) stmt(node *ASTNode) Stmt {
+	if node == nil {
+		return nil
+	}
+	if node.nodeK == EXPRESSION {
+		return &ExprStmt{Line: node.line, X: c.expr(node)}
+	}
+	if node.nodeK != STATEMENT {
+		c.fail(node, MSG_CONVERT_STMT)
+		return nil
+	}
+	switch node.nodeT {
+	case COMPOUND:
+		return c.compound(node)
+	case SELECTION_STMT:
+		return &IfStmt{Line: node.line, Cond: c.expr(node.left), Then: c.stmt(node.mid), Else: c.stmt(node.right)}
+	case ITERATION_STMT:
+		return &WhileStmt{Line: node.line, Cond: c.expr(node.left), Body: c.stmt(node.mid)}
+	case RETURN_STMT:
+		res := &ReturnStmt{Line: node.line}
+		if node.left != nil {
+			res.Result = c.expr(node.left)
+		}
+		return res
+	}
+	c.fail(node, MSG_CONVERT_DECL_HERE)
+	return nil
+}
+
+// 转换表达式
+func (c *astConverter) expr(node *ASTNode) Expr {
+	if node == nil || node.nodeK != EXPRESSION {
+		if node != nil {
+			c.fail(node, MSG_CONVERT_EXPR)
+		} else if c.err == nil {
+			c.err = errors.New(Msg(MSG_CONVERT_MISSING))
+		}
+		return nil
+	}
+	switch node.nodeT {
+	case VAR:
+		return c.varExpr(node)
+	case ASSIGNMENT:
+		if !isExp(node.left, VAR) {
+			c.fail(node, MSG_CONVERT_ASSIGN)
+			return nil
+		}
+		return &AssignExpr{Line: node.line, Target: c.varExpr(node.left), Value: c.expr(node.right)}
+	case CALL:
+		call := &CallExpr{Line: node.line, Name: nodeName(node)}
+		for _, a := range callArgs(node) {
+			call.Args = append(call.Args, c.expr(a))
+		}
+		return call
+	case OPERATION, COMPARE:
+		return &BinaryExpr{Line: node.line, Op: nodeOp(node), X: c.expr(node.left), Y: c.expr(node.right)}
+	case CONST:
+		return &ConstExpr{Line: node.line, Value: nodeValue(node)}
+	}
+	c.fail(node, MSG_CONVERT_UNKNOWN)
+	return nil
+}
+
+// 转换变量引用
+func (c *astConverter) varExpr(node *ASTNode) *VarExpr {
+	v := &VarExpr{Line: node.line, Name: nodeName(node)}
+	if node.left != nil {
+		v.Index = c.expr(node.left)
+	}
+	return v
+}
@@ -0,0 +1,177 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: ast_test.go
+// Package: scan
+// Description: 强类型语法树转换的测试
+// 				转换结果与手写的语法树一致;示例程序转换后格式化输出,重新分析和转换的结果与原来相同(忽略行号)
+
+package scan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConvertProgram(t *testing.T) {
+	src := `int g[10];
+int f(int a[], int n) {
+	int i;
+	i = 0;
+	while (i < n) {
+		a[i] = i * 2;
+		i = i + 1;
+	}
+	if (n == 0) return 0; else ;
+	return a[n - 1];
+}
+void main(void) { output(f(g, 10)); }`
+	want := &Program{Decls: []Decl{
+		&VarDecl{Line: 1, Name: "g", Type: VAR_TYPE_INT_VECTOR, IsArray: true, Size: 10},
+		&FuncDecl{Line: 2, Name: "f", ReturnType: VAR_TYPE_INT,
+			Params: []*Param{
+				{Line: 2, Name: "a", Type: VAR_TYPE_INT_VECTOR, IsArray: true},
+				{Line: 2, Name: "n", Type: VAR_TYPE_INT},
+			},
+			Body: &CompoundStmt{Line: 2,
+				Decls: []*VarDecl{{Line: 3, Name: "i", Type: VAR_TYPE_INT}},
+				Stmts: []Stmt{
+					&ExprStmt{Line: 4, X: &AssignExpr{Line: 4, Target: &VarExpr{Line: 4, Name: "i"}, Value: &ConstExpr{Line: 4}}},
+					&WhileStmt{Line: 5,
+						Cond: &BinaryExpr{Line: 5, Op: LT, X: &VarExpr{Line: 5, Name: "i"}, Y: &VarExpr{Line: 5, Name: "n"}},
+						Body: &CompoundStmt{Line: 5, Stmts: []Stmt{
+							&ExprStmt{Line: 6, X: &AssignExpr{Line: 6,
+								Target: &VarExpr{Line: 6, Name: "a", Index: &VarExpr{Line: 6, Name: "i"}},
+								Value:  &BinaryExpr{Line: 6, Op: MUL, X: &VarExpr{Line: 6, Name: "i"}, Y: &ConstExpr{Line: 6, Value: 2}}}},
+							&ExprStmt{Line: 7, X: &AssignExpr{Line: 7,
+								Target: &VarExpr{Line: 7, Name: "i"},
+								Value:  &BinaryExpr{Line: 7, Op: PLUS, X: &VarExpr{Line: 7, Name: "i"}, Y: &ConstExpr{Line: 7, Value: 1}}}},
+						}},
+					},
+					&IfStmt{Line: 9,
+						Cond: &BinaryExpr{Line: 9, Op: EQ, X: &VarExpr{Line: 9, Name: "n"}, Y: &ConstExpr{Line: 9}},
+						Then: &ReturnStmt{Line: 9, Result: &ConstExpr{Line: 9}}},
+					&ReturnStmt{Line: 10, Result: &VarExpr{Line: 10, Name: "a",
+						Index: &BinaryExpr{Line: 10, Op: MINUS, X: &VarExpr{Line: 10, Name: "n"}, Y: &ConstExpr{Line: 10, Value: 1}}}},
+				},
+			},
+		},
+		&FuncDecl{Line: 12, Name: "main", ReturnType: VAR_TYPE_VOID,
+			Body: &CompoundStmt{Line: 12, Stmts: []Stmt{
+				&ExprStmt{Line: 12, X: &CallExpr{Line: 12, Name: "output", Args: []Expr{
+					&CallExpr{Line: 12, Name: "f", Args: []Expr{&VarExpr{Line: 12, Name: "g"}, &ConstExpr{Line: 12, Value: 10}}},
+				}}},
+			}},
+		},
+	}}
+	root, _, err := ParseProgram(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ConvertProgram(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ConvertProgram did not produce the expected tree")
+		for i := range want.Decls {
+			if i < len(got.Decls) && !reflect.DeepEqual(got.Decls[i], want.Decls[i]) {
+				t.Errorf("declaration %d:\ngot  %#v\nwant %#v", i, got.Decls[i], want.Decls[i])
+			}
+		}
+	}
+}
+
+// 把强类型语法树中所有的行号清零
+func clearLines(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			clearLines(v.Elem())
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			clearLines(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Name == "Line" {
+				v.Field(i).SetInt(0)
+			} else {
+				clearLines(v.Field(i))
+			}
+		}
+	}
+}
+
+// 分析并转换源程序,行号清零
+func convertNoLines(t *testing.T, name, src string) *Program {
+	t.Helper()
+	root, _, err := ParseProgram(src)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	prog, err := ConvertProgram(root)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	clearLines(reflect.ValueOf(prog))
+	return prog
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	for _, s := range samples {
+		text, _, err := Format(s.src)
+		if err != nil {
+			t.Errorf("%s: %v", s.name, err)
+			continue
+		}
+		if !strings.Contains(text, "\n") {
+			t.Errorf("%s: formatted text %q", s.name, text)
+		}
+		before := convertNoLines(t, s.name, s.src)
+		after := convertNoLines(t, s.name, text)
+		if !reflect.DeepEqual(before, after) {
+			t.Errorf("%s: tree changed after formatting:\n%s", s.name, text)
+		}
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	// 赋值号左边不是变量
+	assign := NewASTNode(EXPRESSION, ASSIGNMENT, 3)
+	assign.left = newConst(1, 3)
+	assign.right = newConst(2, 3)
+	if _, err := ConvertExpr(assign); err == nil || err.Error() != "line 3: left side of assignment is not a variable" {
+		t.Errorf("assignment: got %v", err)
+	}
+
+	// 语句中的声明
+	decl, _, err := ParseDeclaration("int x;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConvertStmt(decl); err == nil || err.Error() != "line 1: declaration is not allowed here" {
+		t.Errorf("declaration as statement: got %v", err)
+	}
+
+	// 表达式作为全局声明
+	exp, _, err := ParseExpression("1 + 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConvertProgram(exp); err == nil || !strings.HasPrefix(err.Error(), "line 1: ") {
+		t.Errorf("expression as declaration: got %v", err)
+	}
+
+	// 语句可以单独转换,表达式转换为 *ExprStmt
+	stmt, _, err := ParseStatement("x = 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := ConvertStmt(stmt); err != nil {
+		t.Error(err)
+	} else if _, ok := s.(*ExprStmt); !ok {
+		t.Errorf("statement: got %T, want *ExprStmt", s)
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: batch.go
+// Package: scan
+// Description: 本文件定义了批量分析: 用固定数量的工作协程并行分析多个文件并汇总结果
+// 				每个文件使用独立的缓冲区、语法分析器和符号表,词法分析结果、语法树和符号表输出到各自的文件,
+// 				输出文件名由路径展平得到,展平后重名时按输入顺序加 ~2、~3 等后缀区分
+// 				汇总结果包括每个文件的状态、诊断信息数和耗时,可以输出为文本或 JSON
+
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 文件的分析状态
+const (
+	BATCH_OK     = "ok"     // 没有错误
+	BATCH_ERROR  = "error"  // 有语法错误或语义错误
+	BATCH_FAILED = "failed" // 文件无法读取或输出文件无法创建
+)
+
+// 批量分析选项
+type BatchOptions struct {
+	Workers  int             // 工作协程数,0表示使用CPU个数
+	Lint     bool            // 进行静态检查
+	Disabled map[string]bool // 关闭的警告
+	OutDir   string          // 每个文件的分析结果输出到该目录,为空时不输出
+}
+
+// 单个文件的分析结果
+type FileResult struct {
+	File        string
+	Status      string
+	Diagnostics []Diagnostic
+	Errors      int
+	Warnings    int
+	Duration    time.Duration
+	Output      string // 分析结果的输出文件,没有输出时为空
+	Err         error  // 状态为 BATCH_FAILED 时的错误
+}
+
+// 批量分析的结果,Files 与输入文件的顺序相同
+type BatchResult struct {
+	Files    []FileResult
+	Duration time.Duration
+}
+
+// 展开命令行中的路径: 文件原样保留,通配符展开,目录中递归查找 .cm 文件,重复的路径只保留一次
+func ExpandPaths(args []string) ([]string, error) {
+	var res []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			res = append(res, path)
+		}
+	}
+	for _, arg := range args {
+		paths := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, errors.New(Msg(MSG_NO_FILES_MATCH, arg))
+			}
+			paths = matches
+		}
+		for _, path := range paths {
+			stat, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !stat.IsDir() {
+				add(path)
+				continue
+			}
+			err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() && filepath.Ext(p) == ".cm" {
+					add(p)
+				}
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// 并行分析多个文件
+func RunBatch(files []string, opts BatchOptions) *BatchResult {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	start := time.Now()
+	res := &BatchResult{Files: make([]FileResult, len(files))}
+	var outs []string
+	if len(opts.OutDir) != 0 {
+		for _, name := range batchOutNames(files) {
+			outs = append(outs, filepath.Join(opts.OutDir, name))
+		}
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				out := ""
+				if outs != nil {
+					out = outs[i]
+				}
+				res.Files[i] = analyzeFile(files[i], out, opts)
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	res.Duration = time.Since(start)
+	return res
+}
+
+// 分析单个文件,分析结果输出到 outName,为空时不输出
+func analyzeFile(filename, outName string, opts BatchOptions) (res FileResult) {
+	start := time.Now()
+	res = FileResult{File: filename, Status: BATCH_OK, Output: outName}
+	defer func() { res.Duration = time.Since(start) }()
+
+	source, err := ioutil.ReadFile(filename)
+	if err != nil {
+		res.Status, res.Err = BATCH_FAILED, err
+		return res
+	}
+	var out *os.File
+	if len(outName) != 0 {
+		out, err = os.Create(outName)
+		if err != nil {
+			res.Status, res.Err = BATCH_FAILED, err
+			return res
+		}
+		defer out.Close()
+	}
+
+	parser := NewParser(NewStringBuffer(string(source)))
+	parser.SetOutput(out)
+	root, diags, err := parseWith(parser, (*Parser).declarationList)
+	if err == nil && opts.Lint {
+		diags = append(diags, FilterDiagnostics(Analyze(root), opts.Disabled)...)
+	}
+	if out != nil {
+		HelpPrintDiagnostics(diags, out)
+		HelpPrintTree(root, 0, '-', out)
+		table := parser.table
+		for table != nil && table.Prev() != nil { // 有语法错误时可能停在内层作用域
+			table = table.Prev()
+		}
+		HelpPrintTable(table, 0, '-', out)
+	}
+	res.Diagnostics = diags
+	res.Errors = CountErrors(diags)
+	res.Warnings = len(diags) - res.Errors
+	if res.Errors > 0 {
+		res.Status = BATCH_ERROR
+	}
+	return res
+}
+
+// 输出文件名: 路径中的分隔符换成下划线,避免不同目录中的同名文件冲突
+func batchOutName(filename string) string {
+	name := filepath.ToSlash(filepath.Clean(filename))
+	name = strings.TrimLeft(strings.Replace(name, "../", "", -1), "/")
+	return strings.Replace(name, "/", "_", -1) + ".out"
+}
+
+// 各个文件的输出文件名,展平后重名的(如 a/b.cm 与 a_b.cm、../x.cm 与 x.cm)按输入顺序加后缀区分
+func batchOutNames(files []string) []string {
+	res := make([]string, len(files))
+	used := make(map[string]bool, len(files))
+	for i, f := range files {
+		name := batchOutName(f)
+		base := strings.TrimSuffix(name, ".out")
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s~%d.out", base, n)
+		}
+		used[name] = true
+		res[i] = name
+	}
+	return res
+}
+
+// 统计各状态的文件数以及诊断信息总数
+func (res *BatchResult) Count() (ok, errs, failed, errors, warnings int) {
+	for _, f := range res.Files {
+		switch f.Status {
+		case BATCH_OK:
+			ok++
+		case BATCH_ERROR:
+			errs++
+		case BATCH_FAILED:
+			failed++
+		}
+		errors += f.Errors
+		warnings += f.Warnings
+	}
+	return
+}
+
+// 以文本形式打印汇总结果,有诊断信息的文件在其后打印诊断信息
+func HelpPrintBatch(res *BatchResult, file *os.File) {
+	fmt.Fprintf(file, "%-8s %6s %8s %10s  %s\n", "STATUS", "ERRORS", "WARNINGS", "TIME", "FILE")
+	for _, f := range res.Files {
+		fmt.Fprintf(file, "%-8s %6d %8d %10s  %s\n", f.Status, f.Errors, f.Warnings, f.Duration.Round(time.Microsecond), f.File)
+		if f.Err != nil {
+			fmt.Fprintf(file, "    %s\n", f.Err.Error())
+		}
+		for _, d := range f.Diagnostics {
+			fmt.Fprintf(file, "    %s\n", d.String())
+		}
+	}
+	ok, errs, failed, errors, warnings := res.Count()
+	fmt.Fprintf(file, "%d files: %d ok, %d error, %d failed; %d errors, %d warnings; %s\n",
+		len(res.Files), ok, errs, failed, errors, warnings, res.Duration.Round(time.Microsecond))
+}
+
+// JSON 形式的诊断信息
+type diagnosticJSON struct {
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+// JSON 形式的文件分析结果
+type fileResultJSON struct {
+	File        string           `json:"file"`
+	Status      string           `json:"status"`
+	Errors      int              `json:"errors"`
+	Warnings    int              `json:"warnings"`
+	TimeMS      float64          `json:"time_ms"`
+	Output      string           `json:"output,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	Diagnostics []diagnosticJSON `json:"diagnostics"`
+}
+
+// 转换为 JSON 形式的诊断信息
+func toDiagnosticJSON(diags []Diagnostic) []diagnosticJSON {
+	res := make([]diagnosticJSON, 0, len(diags))
+	for _, d := range diags {
+		severity := "warning"
+		if d.Severity == SEVERITY_ERROR {
+			severity = "error"
+		}
+		res = append(res, diagnosticJSON{Line: d.Line, Severity: severity, Code: d.Code, Message: d.Message})
+	}
+	return res
+}
+
+// 以 JSON 形式打印汇总结果
+func HelpPrintBatchJSON(res *BatchResult, file *os.File) error {
+	ok, errs, failed, errors, warnings := res.Count()
+	out := struct {
+		Files    []fileResultJSON `json:"files"`
+		Total    int              `json:"total"`
+		OK       int              `json:"ok"`
+		Error    int              `json:"error"`
+		Failed   int              `json:"failed"`
+		Errors   int              `json:"errors"`
+		Warnings int              `json:"warnings"`
+		TimeMS   float64          `json:"time_ms"`
+	}{Files: []fileResultJSON{}, Total: len(res.Files), OK: ok, Error: errs, Failed: failed, Errors: errors, Warnings: warnings, TimeMS: durationMS(res.Duration)}
+	for _, f := range res.Files {
+		fj := fileResultJSON{File: f.File, Status: f.Status, Errors: f.Errors, Warnings: f.Warnings, TimeMS: durationMS(f.Duration), Output: f.Output, Diagnostics: toDiagnosticJSON(f.Diagnostics)}
+		if f.Err != nil {
+			fj.Error = f.Err.Error()
+		}
+		out.Files = append(out.Files, fj)
+	}
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// 以毫秒为单位的时间
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
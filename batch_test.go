@@ -0,0 +1,74 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: batch_test.go
+// Package: scan
+// Description: 批量分析的测试
+// 				路径展平后重名的文件必须输出到不同的文件
+
+package scan
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBatchOutNames(t *testing.T) {
+	tests := []struct {
+		files, want []string
+	}{
+		{[]string{"x.cm", "a/b.cm"}, []string{"x.cm.out", "a_b.cm.out"}},
+		{[]string{"a/b.cm", "a_b.cm"}, []string{"a_b.cm.out", "a_b.cm~2.out"}},
+		{[]string{"../x.cm", "x.cm", "../../x.cm"}, []string{"x.cm.out", "x.cm~2.out", "x.cm~3.out"}},
+		{[]string{"a_b.cm", "a/b.cm", "a_b.cm~2"}, []string{"a_b.cm.out", "a_b.cm~2.out", "a_b.cm~2~2.out"}},
+	}
+	for _, tt := range tests {
+		got := batchOutNames(tt.files)
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%v: got %v, want %v", tt.files, got, tt.want)
+		}
+	}
+}
+
+func TestBatchOutputs(t *testing.T) {
+	dir := t.TempDir()
+	outDir := filepath.Join(dir, "out")
+	if err := os.MkdirAll(filepath.Join(dir, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(outDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(dir, "a", "b.cm"): "int first;",
+		filepath.Join(dir, "a_b.cm"):    "int second;",
+	}
+	var paths []string
+	for p, src := range files {
+		if err := ioutil.WriteFile(p, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	res := RunBatch(paths, BatchOptions{Workers: 2, OutDir: outDir})
+	seen := make(map[string]bool)
+	for _, f := range res.Files {
+		if f.Status != BATCH_OK {
+			t.Fatalf("%s: %s %v", f.File, f.Status, f.Err)
+		}
+		if seen[f.Output] {
+			t.Errorf("%s: output %s is shared", f.File, f.Output)
+		}
+		seen[f.Output] = true
+		out, err := ioutil.ReadFile(f.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := strings.TrimSuffix(files[f.File][len("int "):], ";")
+		if !strings.Contains(string(out), name) {
+			t.Errorf("%s: output %s does not mention %s", f.File, f.Output, name)
+		}
+	}
+}
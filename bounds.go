@@ -0,0 +1,557 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: bounds.go
+// Package: scan
+// Description: 本文件定义了基于区间抽象域的数组下标越界检查
+// 				对标量局部变量和形参在语法树上做抽象解释,每个变量的取值用区间[lo, hi]表示,
+// 				分支条件会收窄区间,循环用加宽求不动点后再做两次收窄,从而得到循环归纳变量的范围
+// 				下标区间完全落在数组范围之外时报告错误,部分越界时报告警告,
+// 				下标完全未知(如来自 input)或数组大小未知(数组形参)时不报告
+
+package scan
+
+import (
+	"fmt"
+	"math"
+)
+
+// 区间的无穷大和无穷小
+const (
+	INTERVAL_NEG_INF = math.MinInt64
+	INTERVAL_POS_INF = math.MaxInt64
+)
+
+// 整数区间,lo > hi 表示空区间
+type Interval struct {
+	Lo, Hi int64
+}
+
+// 全集区间
+func topInterval() Interval {
+	return Interval{INTERVAL_NEG_INF, INTERVAL_POS_INF}
+}
+
+// 判断区间是否为空
+func (iv Interval) empty() bool {
+	return iv.Lo > iv.Hi
+}
+
+// 判断区间是否为全集
+func (iv Interval) isTop() bool {
+	return iv.Lo == INTERVAL_NEG_INF && iv.Hi == INTERVAL_POS_INF
+}
+
+// 区间的并
+func (iv Interval) join(other Interval) Interval {
+	if iv.empty() {
+		return other
+	}
+	if other.empty() {
+		return iv
+	}
+	return Interval{minInt64(iv.Lo, other.Lo), maxInt64(iv.Hi, other.Hi)}
+}
+
+// 区间的交
+func (iv Interval) meet(other Interval) Interval {
+	return Interval{maxInt64(iv.Lo, other.Lo), minInt64(iv.Hi, other.Hi)}
+}
+
+// 加宽: 增长的边界直接放到无穷
+func (iv Interval) widen(next Interval) Interval {
+	if iv.empty() {
+		return next
+	}
+	res := iv
+	if next.Lo < iv.Lo {
+		res.Lo = INTERVAL_NEG_INF
+	}
+	if next.Hi > iv.Hi {
+		res.Hi = INTERVAL_POS_INF
+	}
+	return res
+}
+
+// 区间的文本形式
+func (iv Interval) String() string {
+	bound := func(v int64) string {
+		switch v {
+		case INTERVAL_NEG_INF:
+			return "-inf"
+		case INTERVAL_POS_INF:
+			return "+inf"
+		}
+		return fmt.Sprintf("%d", v)
+	}
+	if iv.Lo == iv.Hi {
+		return bound(iv.Lo)
+	}
+	return fmt.Sprintf("[%s, %s]", bound(iv.Lo), bound(iv.Hi))
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// 饱和加法,结果超出范围时取无穷
+func addSat(a, b int64) int64 {
+	if a == INTERVAL_NEG_INF || b == INTERVAL_NEG_INF {
+		if a == INTERVAL_POS_INF || b == INTERVAL_POS_INF {
+			return 0 // 不会出现在合法区间的运算中
+		}
+		return INTERVAL_NEG_INF
+	}
+	if a == INTERVAL_POS_INF || b == INTERVAL_POS_INF {
+		return INTERVAL_POS_INF
+	}
+	s := a + b
+	if a > 0 && b > 0 && s < 0 {
+		return INTERVAL_POS_INF
+	}
+	if a < 0 && b < 0 && s >= 0 {
+		return INTERVAL_NEG_INF
+	}
+	return s
+}
+
+// 饱和取负
+func negSat(a int64) int64 {
+	switch a {
+	case INTERVAL_NEG_INF:
+		return INTERVAL_POS_INF
+	case INTERVAL_POS_INF:
+		return INTERVAL_NEG_INF
+	}
+	return -a
+}
+
+// 饱和乘法
+func mulSat(a, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	neg := (a < 0) != (b < 0)
+	inf := a == INTERVAL_NEG_INF || a == INTERVAL_POS_INF || b == INTERVAL_NEG_INF || b == INTERVAL_POS_INF
+	p := a * b
+	if inf || p/b != a {
+		if neg {
+			return INTERVAL_NEG_INF
+		}
+		return INTERVAL_POS_INF
+	}
+	return p
+}
+
+// 区间上的二元算术运算
+func intervalBinary(op Token, l, r Interval) Interval {
+	if l.empty() || r.empty() {
+		return Interval{1, 0}
+	}
+	switch op {
+	case PLUS:
+		return Interval{addSat(l.Lo, r.Lo), addSat(l.Hi, r.Hi)}
+	case MINUS:
+		return Interval{addSat(l.Lo, negSat(r.Hi)), addSat(l.Hi, negSat(r.Lo))}
+	case MUL:
+		a, b, c, d := mulSat(l.Lo, r.Lo), mulSat(l.Lo, r.Hi), mulSat(l.Hi, r.Lo), mulSat(l.Hi, r.Hi)
+		return Interval{minInt64(minInt64(a, b), minInt64(c, d)), maxInt64(maxInt64(a, b), maxInt64(c, d))}
+	case DIV:
+		// 除数区间包含0或有无穷边界时不做推断
+		if r.Lo <= 0 && r.Hi >= 0 || l.isTop() || l.Lo == INTERVAL_NEG_INF || l.Hi == INTERVAL_POS_INF ||
+			r.Lo == INTERVAL_NEG_INF || r.Hi == INTERVAL_POS_INF {
+			return topInterval()
+		}
+		a, b, c, d := l.Lo/r.Lo, l.Lo/r.Hi, l.Hi/r.Lo, l.Hi/r.Hi
+		return Interval{minInt64(minInt64(a, b), minInt64(c, d)), maxInt64(maxInt64(a, b), maxInt64(c, d))}
+	}
+	// 比较运算
+	if l.Lo == l.Hi && r.Lo == r.Hi {
+		if val, ok := evalBinary(op, l.Lo, r.Lo); ok {
+			return Interval{val, val}
+		}
+	}
+	return Interval{0, 1}
+}
+
+// 下标检查中的标识符,标量变量被跟踪,数组记录大小
+type bvVar struct {
+	isArray bool
+	size    int64 // 数组大小,数组形参为0表示未知
+}
+
+// 下标检查的作用域
+type bvScope struct {
+	vars map[string]*bvVar
+	prev *bvScope
+}
+
+// 由内向外查找标识符
+func (scope *bvScope) lookup(name string) *bvVar {
+	for s := scope; s != nil; s = s.prev {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// 抽象状态: 被跟踪的标量变量的区间,没有记录的变量为全集
+type bvState struct {
+	vals map[*bvVar]Interval
+	dead bool // 不可达
+}
+
+// 复制状态
+func (st *bvState) copy() *bvState {
+	res := &bvState{vals: make(map[*bvVar]Interval, len(st.vals)), dead: st.dead}
+	for v, iv := range st.vals {
+		res.vals[v] = iv
+	}
+	return res
+}
+
+// 读取变量的区间
+func (st *bvState) get(v *bvVar) Interval {
+	if iv, ok := st.vals[v]; ok {
+		return iv
+	}
+	return topInterval()
+}
+
+// 合并两条路径的状态
+func (st *bvState) join(other *bvState) *bvState {
+	if st.dead {
+		return other.copy()
+	}
+	if other.dead {
+		return st.copy()
+	}
+	res := &bvState{vals: make(map[*bvVar]Interval)}
+	for v, iv := range st.vals {
+		if o, ok := other.vals[v]; ok {
+			res.vals[v] = iv.join(o)
+		}
+	}
+	return res
+}
+
+// 对循环头的状态加宽
+func (st *bvState) widen(next *bvState) *bvState {
+	if st.dead {
+		return next.copy()
+	}
+	if next.dead {
+		return st.copy()
+	}
+	res := &bvState{vals: make(map[*bvVar]Interval)}
+	for v, iv := range st.vals {
+		if o, ok := next.vals[v]; ok {
+			if w := iv.widen(o); !w.isTop() {
+				res.vals[v] = w
+			}
+		}
+	}
+	return res
+}
+
+// 判断状态st是否包含于other
+func (st *bvState) leq(other *bvState) bool {
+	if st.dead {
+		return true
+	}
+	if other.dead {
+		return false
+	}
+	for v, o := range other.vals {
+		iv, ok := st.vals[v]
+		if !ok || iv.Lo < o.Lo || iv.Hi > o.Hi {
+			return false
+		}
+	}
+	return true
+}
+
+// 下标检查器
+type boundsChecker struct {
+	diags    []Diagnostic
+	report   bool              // 求不动点时不报告
+	reported map[*ASTNode]bool // 已报告的访问节点
+}
+
+// 对每个函数进行数组下标越界检查,返回按行号排序的诊断信息
+func CheckBounds(root *ASTNode) []Diagnostic {
+	c := &boundsChecker{report: true, reported: make(map[*ASTNode]bool)}
+	global := &bvScope{vars: make(map[string]*bvVar)}
+	for node := root; node != nil; node = node.sibling {
+		if isStmt(node, VAR_DECLARATION) {
+			if info := declInfo(node, SYM_GLOBAL); info.isArray {
+				global.vars[info.name] = &bvVar{isArray: true, size: info.size}
+			} else {
+				global.vars[info.name] = nil // 全局标量可能被调用的函数修改,不跟踪
+			}
+		}
+	}
+	for node := root; node != nil; node = node.sibling {
+		if !isStmt(node, FUNC_DECLARATION) {
+			continue
+		}
+		params := &bvScope{vars: make(map[string]*bvVar), prev: global}
+		for _, p := range funcParams(node) {
+			info := declInfo(p, SYM_PARAM)
+			params.vars[info.name] = &bvVar{isArray: info.isArray}
+		}
+		c.stmt(node.right, params, &bvState{vals: make(map[*bvVar]Interval)})
+	}
+	SortDiagnostics(c.diags)
+	return c.diags
+}
+
+// 分析一条语句,返回语句之后的状态
+func (c *boundsChecker) stmt(node *ASTNode, scope *bvScope, st *bvState) *bvState {
+	if node == nil || st.dead {
+		return st
+	}
+	switch {
+	case isStmt(node, COMPOUND):
+		inner := &bvScope{vars: make(map[string]*bvVar), prev: scope}
+		for d := node.left; d != nil; d = d.sibling {
+			info := declInfo(d, SYM_LOCAL)
+			inner.vars[info.name] = &bvVar{isArray: info.isArray, size: info.size}
+		}
+		for s := node.right; s != nil; s = s.sibling {
+			st = c.stmt(s, inner, st)
+		}
+		return st
+	case isStmt(node, SELECTION_STMT):
+		st, _ = c.exp(node.left, scope, st)
+		thenSt := c.stmt(node.mid, scope, c.refine(node.left, scope, st, true))
+		elseSt := c.stmt(node.right, scope, c.refine(node.left, scope, st, false))
+		return thenSt.join(elseSt)
+	case isStmt(node, ITERATION_STMT):
+		return c.loop(node, scope, st)
+	case isStmt(node, RETURN_STMT):
+		st, _ = c.exp(node.left, scope, st)
+		return &bvState{dead: true}
+	}
+	st, _ = c.exp(node, scope, st)
+	return st
+}
+
+// 分析循环: 先加宽求不动点,再收窄两次,最后在得到的循环头状态上分析并报告
+func (c *boundsChecker) loop(node *ASTNode, scope *bvScope, entry *bvState) *bvState {
+	report := c.report
+	c.report = false
+
+	// 循环头状态经过条件和一次循环体后的结果
+	step := func(head *bvState) *bvState {
+		cond, _ := c.exp(node.left, scope, head.copy())
+		body := c.stmt(node.mid, scope, c.refine(node.left, scope, cond, true))
+		return entry.join(body)
+	}
+	head := entry.copy()
+	for i := 0; ; i++ {
+		next := step(head)
+		if next.leq(head) {
+			break
+		}
+		if i < 2 {
+			head = head.join(next)
+		} else {
+			head = head.widen(head.join(next))
+		}
+	}
+	for i := 0; i < 2; i++ {
+		head = step(head)
+	}
+
+	c.report = report
+	cond, _ := c.exp(node.left, scope, head.copy())
+	c.stmt(node.mid, scope, c.refine(node.left, scope, cond, true))
+	return c.refine(node.left, scope, cond, false)
+}
+
+// 计算表达式的区间,返回表达式求值之后的状态
+func (c *boundsChecker) exp(node *ASTNode, scope *bvScope, st *bvState) (*bvState, Interval) {
+	if node == nil || st.dead {
+		return st, topInterval()
+	}
+	switch {
+	case isExp(node, CONST):
+		v := nodeValue(node)
+		return st, Interval{v, v}
+	case isExp(node, VAR):
+		v := scope.lookup(nodeName(node))
+		if node.left != nil {
+			var idx Interval
+			st, idx = c.exp(node.left, scope, st)
+			if v != nil && v.isArray {
+				c.check(node, v, idx)
+			}
+			return st, topInterval()
+		}
+		if v == nil || v.isArray {
+			return st, topInterval()
+		}
+		return st, st.get(v)
+	case isExp(node, ASSIGNMENT):
+		var val Interval
+		st, val = c.exp(node.right, scope, st)
+		lhs := node.left
+		if lhs == nil {
+			return st, val
+		}
+		v := scope.lookup(nodeName(lhs))
+		if lhs.left != nil {
+			var idx Interval
+			st, idx = c.exp(lhs.left, scope, st)
+			if v != nil && v.isArray {
+				c.check(lhs, v, idx)
+			}
+		} else if v != nil && !v.isArray {
+			st.vals[v] = val
+		}
+		return st, val
+	case isExp(node, CALL):
+		for _, a := range callArgs(node) {
+			st, _ = c.exp(a, scope, st)
+		}
+		return st, topInterval()
+	case isExp(node, OPERATION), isExp(node, COMPARE):
+		var l, r Interval
+		st, l = c.exp(node.left, scope, st)
+		st, r = c.exp(node.right, scope, st)
+		return st, intervalBinary(nodeOp(node), l, r)
+	}
+	return st, topInterval()
+}
+
+// 根据条件为真或为假收窄变量的区间,条件不可能成立时返回不可达状态
+// 只处理比较运算的一侧是被跟踪的标量变量的情况
+func (c *boundsChecker) refine(cond *ASTNode, scope *bvScope, st *bvState, truth bool) *bvState {
+	if st.dead {
+		return st
+	}
+	res := st.copy()
+	if val, ok := evalConstExp(cond); ok {
+		if (val != 0) != truth {
+			return &bvState{dead: true}
+		}
+		return res
+	}
+	if !isExp(cond, COMPARE) {
+		return res
+	}
+	op := nodeOp(cond)
+	if !truth {
+		op = negateCompare(op)
+	}
+	varOf := func(n *ASTNode) *bvVar {
+		if isExp(n, VAR) && n.left == nil {
+			if v := scope.lookup(nodeName(n)); v != nil && !v.isArray {
+				return v
+			}
+		}
+		return nil
+	}
+	_, l := c.exp(cond.left, scope, st.copy())
+	_, r := c.exp(cond.right, scope, st.copy())
+	if v := varOf(cond.left); v != nil {
+		res.vals[v] = narrowCompare(l, op, r)
+	}
+	if v := varOf(cond.right); v != nil {
+		res.vals[v] = narrowCompare(r, swapCompare(op), l)
+	}
+	for _, iv := range res.vals {
+		if iv.empty() {
+			return &bvState{dead: true}
+		}
+	}
+	return res
+}
+
+// 比较运算取反
+func negateCompare(op Token) Token {
+	switch op {
+	case LT:
+		return GE
+	case LE:
+		return GT
+	case GT:
+		return LE
+	case GE:
+		return LT
+	case EQ:
+		return NOT_EQ
+	case NOT_EQ:
+		return EQ
+	}
+	return op
+}
+
+// 交换比较运算的两个操作数
+func swapCompare(op Token) Token {
+	switch op {
+	case LT:
+		return GT
+	case LE:
+		return GE
+	case GT:
+		return LT
+	case GE:
+		return LE
+	}
+	return op
+}
+
+// 在 x op y 成立的条件下收窄x的区间
+func narrowCompare(x Interval, op Token, y Interval) Interval {
+	switch op {
+	case LT:
+		return x.meet(Interval{INTERVAL_NEG_INF, addSat(y.Hi, -1)})
+	case LE:
+		return x.meet(Interval{INTERVAL_NEG_INF, y.Hi})
+	case GT:
+		return x.meet(Interval{addSat(y.Lo, 1), INTERVAL_POS_INF})
+	case GE:
+		return x.meet(Interval{y.Lo, INTERVAL_POS_INF})
+	case EQ:
+		return x.meet(y)
+	case NOT_EQ:
+		if y.Lo == y.Hi { // 只能去掉端点
+			if x.Lo == y.Lo {
+				x.Lo++
+			} else if x.Hi == y.Lo {
+				x.Hi--
+			}
+		}
+	}
+	return x
+}
+
+// 检查一次数组访问
+func (c *boundsChecker) check(node *ASTNode, v *bvVar, idx Interval) {
+	if !c.report || v.size <= 0 || idx.empty() || idx.isTop() || c.reported[node] {
+		return
+	}
+	name := nodeName(node)
+	switch {
+	case idx.Hi < 0 || idx.Lo >= v.size:
+		c.reported[node] = true
+		c.diags = append(c.diags, newError(node.line, DIAG_OUT_OF_BOUNDS,
+			MSG_OUT_OF_BOUNDS, idx, name, v.size-1))
+	case idx.Lo < 0 || idx.Hi >= v.size:
+		c.reported[node] = true
+		c.diags = append(c.diags, newWarning(node.line, DIAG_MAYBE_OUT_OF_BOUNDS,
+			MSG_MAYBE_OUT_OF_BOUNDS, idx, name, v.size-1))
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: bounds_test.go
+// Package: scan
+// Description: 数组下标越界检查的测试
+// 				常量下标和区间下标的越界(E401/W401),以及需要加宽和收窄才能得到精确范围的循环
+
+package scan
+
+import (
+	"testing"
+)
+
+func TestCheckBounds(t *testing.T) {
+	checkDiagnostics(t, "bounds", CheckBounds, []struct{ name, src, want string }{
+		{"constant in range", `void main(void) {
+	int a[3];
+	a[0] = 1; a[2] = a[0];
+}`, ""},
+		{"constant out of range", `int g[4];
+void main(void) {
+	int a[3];
+	a[3] = 1;
+	g[0 - 1] = 2;
+}`, "4:E401 5:E401"},
+		{"interval partly out", `void main(void) {
+	int a[3]; int i;
+	i = input();
+	if (i >= 0)
+		if (i <= 3)
+			a[i] = 1;
+}`, "6:W401"},
+		{"interval in range", `void main(void) {
+	int a[4]; int i;
+	i = input();
+	if (i >= 0)
+		if (i < 4)
+			a[i] = 1;
+}`, ""},
+		{"unknown index", `void main(void) {
+	int a[3];
+	a[input()] = 1;
+}`, ""},
+		{"array param", `void f(int a[]) { a[100] = 1; }
+void main(void) { int b[3]; f(b); }`, ""},
+		// 100 次迭代需要加宽才能收敛,收窄后 i 的范围为 [0, 99]
+		{"loop in range", `void main(void) {
+	int a[100]; int i;
+	i = 0;
+	while (i < 100) {
+		a[i] = i;
+		i = i + 1;
+	}
+}`, ""},
+		{"loop off by one", `void main(void) {
+	int a[100]; int i;
+	i = 0;
+	while (i <= 100) {
+		a[i] = i;
+		i = i + 1;
+	}
+}`, "5:W401"},
+		{"loop entirely out", `void main(void) {
+	int a[10]; int i;
+	i = 10;
+	while (i < 20) {
+		a[i] = i;
+		i = i + 1;
+	}
+}`, "5:E401"},
+		{"after loop", `void main(void) {
+	int a[10]; int i;
+	i = 0;
+	while (i < 10) i = i + 1;
+	a[i] = 1;
+}`, "5:E401"},
+	})
+}
+
+func TestIntervalWiden(t *testing.T) {
+	tests := []struct {
+		iv, next, want Interval
+	}{
+		{Interval{0, 1}, Interval{0, 2}, Interval{0, INTERVAL_POS_INF}},
+		{Interval{0, 1}, Interval{-1, 1}, Interval{INTERVAL_NEG_INF, 1}},
+		{Interval{0, 5}, Interval{1, 3}, Interval{0, 5}},
+		{Interval{1, 0}, Interval{2, 3}, Interval{2, 3}}, // 空区间
+	}
+	for _, tt := range tests {
+		if got := tt.iv.widen(tt.next); got != tt.want {
+			t.Errorf("%v widen %v = %v, want %v", tt.iv, tt.next, got, tt.want)
+		}
+	}
+}
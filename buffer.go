@@ -11,14 +11,18 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
 )
 
 // 输入缓冲区类
 // 使用缓冲区读取文件内容并记录行数
+// 输入末尾的换行符结束最后一行而不是开始新的一行,因此输入结尾位于最后一行
 type Buffer struct {
 	file   *os.File      // 读取的文件指针
 	reader *bufio.Reader // 缓冲区
 	line   int           // 当前读取字符行号
+	last   byte          // 上一个读到的字符
+	eof    bool          // 是否已经读到输入结尾
 }
 
 // 获取指定文件名缓冲区
@@ -35,6 +39,11 @@ func NewBuffer(file string) *Buffer {
 	return &b
 }
 
+// 获取字符串输入的缓冲区,用于交互式输入或分析程序片段
+func NewStringBuffer(src string) *Buffer {
+	return &Buffer{reader: bufio.NewReader(strings.NewReader(src)), line: 1}
+}
+
 // 获取缓冲区输入当前行数
 func (b *Buffer) Lines() int {
 	return b.line
@@ -45,9 +54,18 @@ func (b *Buffer) Next() (res byte) {
 	var err error
 	res, err = b.reader.ReadByte()
 	if err == io.EOF {
-		res = EOF_CHAR
-		b.file.Close() // 读取完后关闭文件
+		if !b.eof {
+			b.eof = true
+			if b.last == '\n' { // 输入以换行符结尾时,结尾处于最后一行
+				b.line--
+			}
+			if b.file != nil {
+				b.file.Close() // 读取完后关闭文件
+			}
+		}
+		return EOF_CHAR
 	}
+	b.last = res
 	if res == '\n' {
 		b.line++
 	}
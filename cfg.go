@@ -0,0 +1,226 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: cfg.go
+// Package: scan
+// Description: 本文件定义了控制流图上的基本操作
+// 				包括建立前驱后继关系、删除不可达块、逆后序遍历、支配树以及自然循环的识别
+
+package scan
+
+import "sort"
+
+// 根据各个块的结束指令重新建立前驱和后继关系
+func BuildCFG(fn *IRFunc) {
+	for _, b := range fn.Blocks {
+		b.Preds = nil
+		b.Succs = nil
+	}
+	for _, b := range fn.Blocks {
+		if t := b.Terminator(); t != nil {
+			for _, s := range t.Targets {
+				b.Succs = append(b.Succs, s)
+				s.Preds = append(s.Preds, b)
+			}
+		}
+	}
+}
+
+// 删除从入口块不可达的基本块,并重新建立控制流图
+// 返回是否删除了块
+func RemoveUnreachable(fn *IRFunc) bool {
+	reach := make(map[*BasicBlock]bool)
+	var visit func(b *BasicBlock)
+	visit = func(b *BasicBlock) {
+		if reach[b] {
+			return
+		}
+		reach[b] = true
+		if t := b.Terminator(); t != nil {
+			for _, s := range t.Targets {
+				visit(s)
+			}
+		}
+	}
+	visit(fn.Entry())
+
+	var blocks []*BasicBlock
+	for _, b := range fn.Blocks {
+		if reach[b] {
+			blocks = append(blocks, b)
+		}
+	}
+	changed := len(blocks) != len(fn.Blocks)
+	fn.Blocks = blocks
+	BuildCFG(fn)
+	return changed
+}
+
+// 合并只有一条无条件跳转边相连的两个块(前者只有这一个后继,后者只有这一个前驱)
+// 返回是否合并了块
+func MergeBlocks(fn *IRFunc) bool {
+	changed := false
+	for i := 0; i < len(fn.Blocks); i++ {
+		b := fn.Blocks[i]
+		t := b.Terminator()
+		if t == nil || t.Op != IR_JUMP {
+			continue
+		}
+		s := t.Targets[0]
+		if s == b || s == fn.Entry() || len(s.Preds) != 1 {
+			continue
+		}
+		b.Instrs = append(b.Instrs[:len(b.Instrs)-1], s.Instrs...)
+		s.Instrs = nil
+		for j, x := range fn.Blocks {
+			if x == s {
+				fn.Blocks = append(fn.Blocks[:j], fn.Blocks[j+1:]...)
+				break
+			}
+		}
+		BuildCFG(fn)
+		changed = true
+		i = -1 // 重新扫描
+	}
+	return changed
+}
+
+// 返回基本块的逆后序序列
+func ReversePostorder(fn *IRFunc) []*BasicBlock {
+	var order []*BasicBlock
+	seen := make(map[*BasicBlock]bool)
+	var visit func(b *BasicBlock)
+	visit = func(b *BasicBlock) {
+		seen[b] = true
+		for _, s := range b.Succs {
+			if !seen[s] {
+				visit(s)
+			}
+		}
+		order = append(order, b)
+	}
+	visit(fn.Entry())
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// 计算支配树,结果保存在各个块的IDom中(入口块的IDom为自身)
+// 采用 Cooper, Harvey, Kennedy 的迭代算法
+func ComputeDominators(fn *IRFunc) {
+	order := ReversePostorder(fn)
+	index := make(map[*BasicBlock]int, len(order))
+	for i, b := range order {
+		index[b] = i
+		b.IDom = nil
+	}
+	entry := fn.Entry()
+	entry.IDom = entry
+
+	intersect := func(a, b *BasicBlock) *BasicBlock {
+		for a != b {
+			for index[a] > index[b] {
+				a = a.IDom
+			}
+			for index[b] > index[a] {
+				b = b.IDom
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order[1:] {
+			var idom *BasicBlock
+			for _, p := range b.Preds {
+				if p.IDom == nil {
+					continue
+				}
+				if idom == nil {
+					idom = p
+				} else {
+					idom = intersect(p, idom)
+				}
+			}
+			if b.IDom != idom {
+				b.IDom = idom
+				changed = true
+			}
+		}
+	}
+}
+
+// 判断a是否支配b,需要先调用ComputeDominators
+func Dominates(a, b *BasicBlock) bool {
+	for {
+		if a == b {
+			return true
+		}
+		if b.IDom == nil || b.IDom == b {
+			return false
+		}
+		b = b.IDom
+	}
+}
+
+// 支配树上各个块的子节点
+func DominatorChildren(fn *IRFunc) map[*BasicBlock][]*BasicBlock {
+	children := make(map[*BasicBlock][]*BasicBlock)
+	for _, b := range fn.Blocks {
+		if b.IDom != nil && b.IDom != b {
+			children[b.IDom] = append(children[b.IDom], b)
+		}
+	}
+	return children
+}
+
+// 自然循环
+type Loop struct {
+	Header *BasicBlock          // 循环头
+	Blocks map[*BasicBlock]bool // 循环体(包括循环头)
+}
+
+// 找出函数中所有的自然循环,同一循环头的多条回边合并为一个循环
+// 结果按循环体大小升序排列,内层循环在前
+func FindLoops(fn *IRFunc) []*Loop {
+	ComputeDominators(fn)
+	loops := make(map[*BasicBlock]*Loop)
+	var res []*Loop
+	for _, b := range fn.Blocks {
+		for _, h := range b.Succs {
+			if !Dominates(h, b) { // 不是回边
+				continue
+			}
+			loop, ok := loops[h]
+			if !ok {
+				loop = &Loop{Header: h, Blocks: map[*BasicBlock]bool{h: true}}
+				loops[h] = loop
+				res = append(res, loop)
+			}
+			// 从回边尾部逆向遍历到循环头
+			work := []*BasicBlock{b}
+			for len(work) > 0 {
+				n := work[len(work)-1]
+				work = work[:len(work)-1]
+				if loop.Blocks[n] {
+					continue
+				}
+				loop.Blocks[n] = true
+				work = append(work, n.Preds...)
+			}
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return len(res[i].Blocks) < len(res[j].Blocks)
+	})
+	return res
+}
+
+// 按块在函数中的顺序重新编号
+func RenumberBlocks(fn *IRFunc) {
+	for i, b := range fn.Blocks {
+		b.ID = i
+	}
+	fn.nextBlock = len(fn.Blocks)
+}
@@ -0,0 +1,304 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: cgen.go
+// Package: scan
+// Description: 本文件定义了C-Minus到C99的源到源翻译器
+// 				所有标识符加上 cm_ 前缀,避免与C关键字、标准库和前导代码中的名字冲突,并用#line指令映射回源文件行号
+// 				输出包含用stdio实现input/output的前导代码,可以直接交给C编译器编译
+
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 翻译后标识符的前缀,C-Minus 的标识符只含字母,加上带下划线的前缀后不会与C关键字、
+// <stdio.h> 中的名字(包括宏)或其他翻译后的标识符冲突
+const C_IDENT_PREFIX = "cm_"
+
+// C 翻译器
+type CGenerator struct {
+	out      io.Writer           // 输出
+	filename string              // 源文件名,用于#line指令
+	funcs    map[string]*ASTNode // 函数声明
+	indent   int                 // 缩进层次
+	lastLine int                 // 上一条#line指令的行号
+	err      error               // 翻译过程中遇到的第一个错误
+}
+
+// C 翻译器工厂函数,filename为C-Minus源文件名
+func NewCGenerator(out io.Writer, filename string) *CGenerator {
+	return &CGenerator{out: out, filename: filename}
+}
+
+// C 语言前导代码
+const cPrelude = `#include <stdio.h>
+
+`
+
+// 运行时函数 input/output 的C实现,名字与翻译后的调用一致
+const cInput = `static int ` + C_IDENT_PREFIX + BUILTIN_INPUT + `(void)
+{
+    int x;
+    if (scanf("%d", &x) != 1)
+        return 0;
+    return x;
+}
+
+`
+
+const cOutput = `static void ` + C_IDENT_PREFIX + BUILTIN_OUTPUT + `(int x)
+{
+    printf("%d\n", x);
+}
+
+`
+
+// 生成整个程序的C代码
+func (g *CGenerator) Generate(root *ASTNode) error {
+	if root == nil {
+		return errors.New(Msg(MSG_GEN_EMPTY))
+	}
+	_, _, funcs, err := collectProgram(root)
+	if err != nil {
+		return err
+	}
+	g.funcs = make(map[string]*ASTNode, len(funcs))
+	for _, fn := range funcs {
+		g.funcs[nodeName(fn)] = fn
+	}
+	main, ok := g.funcs["main"]
+	if !ok {
+		return errors.New(Msg(MSG_GEN_NO_MAIN))
+	}
+
+	fmt.Fprintln(g.out, "/* Generated by CMinusParser, target C99 */")
+	io.WriteString(g.out, cPrelude)
+	if _, ok := g.funcs[BUILTIN_INPUT]; !ok {
+		io.WriteString(g.out, cInput)
+	}
+	if _, ok := g.funcs[BUILTIN_OUTPUT]; !ok {
+		io.WriteString(g.out, cOutput)
+	}
+
+	// 函数原型,C-Minus中的函数可以在定义前调用
+	for _, fn := range funcs {
+		fmt.Fprintf(g.out, "%s;\n", g.funcSignature(fn))
+	}
+	fmt.Fprintln(g.out)
+
+	for node := root; node != nil && g.err == nil; node = node.sibling {
+		switch {
+		case isStmt(node, VAR_DECLARATION):
+			g.genDecl(node)
+		case isStmt(node, FUNC_DECLARATION):
+			g.genFunc(node)
+		}
+	}
+	if g.err != nil {
+		return g.err
+	}
+
+	// C 的 main 必须返回 int
+	fmt.Fprintln(g.out)
+	fmt.Fprintln(g.out, "int main(void)\n{")
+	if funcReturnsInt(main) {
+		fmt.Fprintf(g.out, "    return %s();\n}\n", cIdent("main"))
+	} else {
+		fmt.Fprintf(g.out, "    %s();\n    return 0;\n}\n", cIdent("main"))
+	}
+	return nil
+}
+
+// 翻译标识符
+func cIdent(name string) string {
+	return C_IDENT_PREFIX + name
+}
+
+// 记录第一个错误,id 为消息编号
+func (g *CGenerator) errorf(node *ASTNode, id string, args ...interface{}) {
+	if g.err == nil {
+		g.err = errors.New(Msg(MSG_AT_LINE, node.line, Msg(id, args...)))
+	}
+}
+
+// 按当前缩进输出一行
+func (g *CGenerator) line(format string, args ...interface{}) {
+	io.WriteString(g.out, strings.Repeat("    ", g.indent))
+	fmt.Fprintf(g.out, format, args...)
+	io.WriteString(g.out, "\n")
+}
+
+// 行号变化时输出#line指令
+func (g *CGenerator) lineDirective(node *ASTNode) {
+	if node.line == g.lastLine {
+		return
+	}
+	g.lastLine = node.line
+	fmt.Fprintf(g.out, "#line %d %s\n", node.line, strconv.Quote(g.filename))
+}
+
+// 类型名
+func cType(t VarType) string {
+	if t == VAR_TYPE_VOID {
+		return "void"
+	}
+	return "int"
+}
+
+// 函数签名
+func (g *CGenerator) funcSignature(fn *ASTNode) string {
+	var params []string
+	for _, p := range funcParams(fn) {
+		if p.left != nil && p.left.varT == VAR_TYPE_INT_VECTOR {
+			params = append(params, "int "+cIdent(nodeName(p))+"[]")
+		} else {
+			params = append(params, "int "+cIdent(nodeName(p)))
+		}
+	}
+	if len(params) == 0 {
+		params = append(params, "void")
+	}
+	ret := VAR_TYPE_VOID
+	if fn.left != nil {
+		ret = fn.left.varT
+	}
+	return fmt.Sprintf("%s %s(%s)", cType(ret), cIdent(nodeName(fn)), strings.Join(params, ", "))
+}
+
+// 变量声明
+func (g *CGenerator) genDecl(node *ASTNode) {
+	g.lineDirective(node)
+	info := declInfo(node, SYM_LOCAL)
+	if info.isArray {
+		g.line("int %s[%d];", cIdent(info.name), info.size)
+	} else {
+		g.line("int %s;", cIdent(info.name))
+	}
+}
+
+// 函数定义
+func (g *CGenerator) genFunc(fn *ASTNode) {
+	fmt.Fprintln(g.out)
+	g.lineDirective(fn)
+	g.line("%s", g.funcSignature(fn))
+	body := fn.right
+	if body == nil {
+		g.line("{\n}")
+		return
+	}
+	g.genCompound(body, funcReturnsInt(fn))
+}
+
+// 复合语句,tail为真时在末尾补充 return 0 (int函数可能从末尾返回)
+func (g *CGenerator) genCompound(node *ASTNode, tail bool) {
+	g.line("{")
+	g.indent++
+	for d := node.left; d != nil; d = d.sibling {
+		g.genDecl(d)
+	}
+	for s := node.right; s != nil && g.err == nil; s = s.sibling {
+		g.genStmt(s)
+	}
+	if tail {
+		g.line("return 0;")
+	}
+	g.indent--
+	g.line("}")
+}
+
+// 单条语句
+func (g *CGenerator) genStmt(node *ASTNode) {
+	if node == nil {
+		g.line(";")
+		return
+	}
+	g.lineDirective(node)
+	if node.nodeK == EXPRESSION {
+		g.line("%s;", g.expr(node))
+		return
+	}
+
+	switch node.nodeT {
+	case COMPOUND:
+		g.genCompound(node, false)
+	case SELECTION_STMT:
+		g.line("if (%s)", g.expr(node.left))
+		g.genBody(node.mid)
+		if node.right != nil {
+			g.line("else")
+			g.genBody(node.right)
+		}
+	case ITERATION_STMT:
+		g.line("while (%s)", g.expr(node.left))
+		g.genBody(node.mid)
+	case RETURN_STMT:
+		if node.left != nil {
+			g.line("return %s;", g.expr(node.left))
+		} else {
+			g.line("return;")
+		}
+	default:
+		g.errorf(node, MSG_GEN_UNEXPECTED_STMT)
+	}
+}
+
+// if/while 的子语句,非复合语句时增加缩进
+func (g *CGenerator) genBody(node *ASTNode) {
+	if isStmt(node, COMPOUND) {
+		g.genStmt(node)
+		return
+	}
+	g.indent++
+	g.genStmt(node)
+	g.indent--
+}
+
+// 翻译表达式,二元运算全部加括号以保持语法树的结合方式
+func (g *CGenerator) expr(node *ASTNode) string {
+	if node == nil {
+		g.err = errors.New(Msg(MSG_GEN_MISSING_EXPR))
+		return "0"
+	}
+	switch node.nodeT {
+	case CONST:
+		return strconv.FormatInt(nodeValue(node), 10)
+	case VAR:
+		if node.left != nil {
+			return fmt.Sprintf("%s[%s]", cIdent(nodeName(node)), g.expr(node.left))
+		}
+		return cIdent(nodeName(node))
+	case ASSIGNMENT:
+		return fmt.Sprintf("%s = %s", g.expr(node.left), g.expr(node.right))
+	case CALL:
+		var args []string
+		for _, a := range callArgs(node) {
+			args = append(args, g.expr(a))
+		}
+		return fmt.Sprintf("%s(%s)", cIdent(nodeName(node)), strings.Join(args, ", "))
+	case OPERATION, COMPARE:
+		op, ok := map[Token]string{
+			PLUS: "+", MINUS: "-", MUL: "*", DIV: "/",
+			LT: "<", LE: "<=", GT: ">", GE: ">=", EQ: "==", NOT_EQ: "!=",
+		}[nodeOp(node)]
+		if !ok {
+			g.errorf(node, MSG_GEN_UNKNOWN_OP)
+			return "0"
+		}
+		return fmt.Sprintf("(%s %s %s)", g.subExpr(node.left), op, g.subExpr(node.right))
+	}
+	g.errorf(node, MSG_GEN_UNEXPECTED_EXPR)
+	return "0"
+}
+
+// 作为操作数的表达式,赋值需要加括号
+func (g *CGenerator) subExpr(node *ASTNode) string {
+	if isExp(node, ASSIGNMENT) {
+		return "(" + g.expr(node) + ")"
+	}
+	return g.expr(node)
+}
@@ -0,0 +1,74 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: cgen_test.go
+// Package: scan
+// Description: C 翻译器的测试
+// 				标识符都加上前缀;有C编译器时编译并执行示例程序,比较输出
+
+package scan
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCIdentifiers(t *testing.T) {
+	src := `int EOF;
+void remove(int FILE) { output(FILE); }
+void main(void) { EOF = input(); remove(EOF); }`
+	root, _, err := ParseProgram(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := NewCGenerator(&out, "t.cm").Generate(root); err != nil {
+		t.Fatal(err)
+	}
+	code := out.String()
+	for _, want := range []string{
+		"int cm_EOF;",
+		"void cm_remove(int cm_FILE)",
+		"cm_output(cm_FILE);",
+		"cm_EOF = cm_input();",
+		"static int cm_input(void)",
+		"static void cm_output(int x)",
+		"    cm_main();\n    return 0;",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("output lacks %q:\n%s", want, code)
+		}
+	}
+}
+
+func TestCEndToEnd(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc not found")
+	}
+	dir := t.TempDir()
+	for _, s := range samples {
+		var code strings.Builder
+		if err := NewCGenerator(&code, s.name+".cm").Generate(parseSample(t, s)); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		bin := filepath.Join(dir, s.name)
+		// C-Minus 的 int 运算按32位回绕,C 中有符号溢出是未定义行为
+		build := exec.Command(cc, "-std=c99", "-fwrapv", "-w", "-o", bin, "-x", "c", "-")
+		build.Stdin = strings.NewReader(code.String())
+		if out, err := build.CombinedOutput(); err != nil {
+			t.Fatalf("%s: cc: %v\n%s\n%s", s.name, err, out, code.String())
+		}
+		cmd := exec.Command(bin)
+		cmd.Stdin = strings.NewReader(s.input)
+		got, err := cmd.Output()
+		if err != nil {
+			t.Errorf("%s: %v", s.name, err)
+			continue
+		}
+		if string(got) != s.want {
+			t.Errorf("%s: got %q, want %q", s.name, got, s.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: codegen.go
+// Package: scan
+// Description: 本文件定义了各个目标代码生成器共用的辅助类型和函数
+// 				包括标识符作用域环境、函数声明收集以及语法树属性的读取
+
+package scan
+
+import (
+	"errors"
+)
+
+// 运行时内置函数
+const (
+	BUILTIN_INPUT  = "input"  // int input(void)
+	BUILTIN_OUTPUT = "output" // void output(int x)
+)
+
+// 标识符种类
+type symKind int
+
+const (
+	SYM_GLOBAL symKind = iota // 全局变量
+	SYM_LOCAL                 // 局部变量
+	SYM_PARAM                 // 形式参数
+)
+
+// 代码生成阶段的标识符信息
+type symInfo struct {
+	name    string   // 标识符名字
+	kind    symKind  // 全局、局部或形参
+	isArray bool     // 是否为数组(包括数组形参)
+	size    int64    // 数组大小,数组形参为0
+	index   int      // 在所属函数中的序号(形参和局部变量分别编号)
+	slot    int      // 由各个后端自行使用,如栈偏移
+	irName  string   // 中间代码中的名字
+	decl    *ASTNode // 声明节点
+	uses    int      // 被引用的次数,由静态检查统计
+}
+
+// 代码生成使用的作用域环境,每进入一个复合语句新建一层
+type scopeEnv struct {
+	syms map[string]*symInfo
+	prev *scopeEnv
+}
+
+// 新建一层作用域
+func newScopeEnv(prev *scopeEnv) *scopeEnv {
+	return &scopeEnv{syms: make(map[string]*symInfo), prev: prev}
+}
+
+// 在当前层添加标识符
+func (env *scopeEnv) put(info *symInfo) error {
+	if _, ok := env.syms[info.name]; ok {
+		return errors.New(Msg(MSG_GEN_REDECLARED, info.name))
+	}
+	env.syms[info.name] = info
+	return nil
+}
+
+// 由内向外查找标识符
+func (env *scopeEnv) lookup(name string) *symInfo {
+	for e := env; e != nil; e = e.prev {
+		if info, ok := e.syms[name]; ok {
+			return info
+		}
+	}
+	return nil
+}
+
+// 读取节点的标识符属性
+func nodeName(node *ASTNode) string {
+	if id, ok := node.attribute.(TokenString); ok {
+		return string(id)
+	}
+	return ""
+}
+
+// 读取常量节点的值
+func nodeValue(node *ASTNode) int64 {
+	if val, ok := node.attribute.(int64); ok {
+		return val
+	}
+	return 0
+}
+
+// 读取操作符节点的操作符
+func nodeOp(node *ASTNode) Token {
+	if op, ok := node.attribute.(Token); ok {
+		return op
+	}
+	return ERROR
+}
+
+// 判断节点是否为指定的语句类型
+func isStmt(node *ASTNode, k StmtKind) bool {
+	return node != nil && node.nodeK == STATEMENT && node.nodeT == k
+}
+
+// 判断节点是否为指定的表达式类型
+func isExp(node *ASTNode, k ExpKind) bool {
+	return node != nil && node.nodeK == EXPRESSION && node.nodeT == k
+}
+
+// 根据声明节点生成标识符信息,声明节点为变量声明或形参
+func declInfo(node *ASTNode, kind symKind) *symInfo {
+	info := &symInfo{name: nodeName(node), kind: kind, decl: node}
+	if node.left != nil && node.left.varT == VAR_TYPE_INT_VECTOR {
+		info.isArray = true
+		if node.right != nil {
+			info.size = nodeValue(node.right)
+		}
+	}
+	return info
+}
+
+// 收集全局变量声明和函数声明
+// 返回全局作用域、按出现顺序排列的全局变量以及函数声明
+func collectProgram(root *ASTNode) (*scopeEnv, []*symInfo, []*ASTNode, error) {
+	var globals []*symInfo
+	var funcs []*ASTNode
+	env := newScopeEnv(nil)
+
+	for node := root; node != nil; node = node.sibling {
+		switch {
+		case isStmt(node, VAR_DECLARATION):
+			info := declInfo(node, SYM_GLOBAL)
+			info.index = len(globals)
+			if err := env.put(info); err != nil {
+				return nil, nil, nil, errors.New(Msg(MSG_AT_LINE, node.line, err.Error()))
+			}
+			globals = append(globals, info)
+		case isStmt(node, FUNC_DECLARATION):
+			funcs = append(funcs, node)
+		}
+	}
+	return env, globals, funcs, nil
+}
+
+// 返回函数声明的形参节点序列
+func funcParams(fn *ASTNode) []*ASTNode {
+	var res []*ASTNode
+	if fn.mid == nil {
+		return res
+	}
+	for p := fn.mid.left; p != nil; p = p.sibling {
+		res = append(res, p)
+	}
+	return res
+}
+
+// 返回函数调用的实参节点序列
+func callArgs(call *ASTNode) []*ASTNode {
+	var res []*ASTNode
+	if call.left == nil {
+		return res
+	}
+	for a := call.left.left; a != nil; a = a.sibling {
+		res = append(res, a)
+	}
+	return res
+}
+
+// 判断函数是否有返回值
+func funcReturnsInt(fn *ASTNode) bool {
+	return fn.left != nil && fn.left.varT == VAR_TYPE_INT
+}
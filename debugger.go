@@ -0,0 +1,346 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: debugger.go
+// Package: scan
+// Description: 本文件定义了建立在解释器之上的命令行调试器
+// 				支持按行号或函数名设置断点,step/next/finish/continue,打印变量和数组,调用栈以及当前源代码行
+// 				变量按解释器的作用域链由内向外解析,作用域的嵌套(全局/形参/复合语句)与符号表相同
+// 				不查 SymbolTableNode: 符号表只记录标识符第一次出现的行号,不保存值,标识符的使用也会记入其中,
+// 				语法树节点与符号表节点之间没有对应关系,而且递归调用时同一个作用域同时有多个实例,变量的值只存在于解释器的帧中
+// 				命令可以来自终端,也可以来自命令文件,便于编写测试脚本;命令读完后程序继续运行到结束
+
+package scan
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 调试器的提示符
+const DEBUG_PROMPT = "(cmdb) "
+
+// 程序停下后恢复执行的方式
+type debugMode int
+
+const (
+	DEBUG_CONTINUE debugMode = iota // 运行到下一个断点
+	DEBUG_STEP                      // 停在新的一行,会进入被调用的函数
+	DEBUG_NEXT                      // 停在当前函数或调用者新的一行,跳过被调用的函数
+	DEBUG_FINISH                    // 运行到当前函数返回
+	DEBUG_DETACH                    // 忽略断点运行到结束
+)
+
+// quit 命令结束程序时返回的错误
+var errDebugQuit = errors.New("debugger: quit")
+
+// 调试器
+type Debugger struct {
+	it    *Interpreter
+	src   []string // 源程序各行
+	cmds  *bufio.Reader
+	out   io.Writer
+	echo  bool // 回显读到的命令,命令来自文件时使用
+	lines map[int]bool
+	funcs map[string]bool
+	mode  debugMode
+	depth int       // 发出 finish 命令时的调用深度
+	cur   lineVisit // 正在执行的一行,用于判断是否进入了新的一行或新的函数
+	from  lineVisit // 发出 next 命令时所在的一行
+}
+
+// 在某一调用深度上对一行源代码的一次执行
+// step 和 next 都停在新的一次执行上,同一行的语句再次执行(如单行的循环体)时算作新的一次
+type lineVisit struct {
+	line  int
+	depth int
+	seen  map[*ASTNode]bool // 这次执行中已执行过的语句
+}
+
+// 执行语句前更新,返回是否开始了新的一次执行
+func (v *lineVisit) enter(node *ASTNode, depth int) bool {
+	if node.line == v.line && depth == v.depth && !v.seen[node] {
+		v.seen[node] = true
+		return false
+	}
+	v.line, v.depth, v.seen = node.line, depth, map[*ASTNode]bool{node: true}
+	return true
+}
+
+// 复制一份,供 next 命令记录出发的位置
+func (v lineVisit) clone() lineVisit {
+	seen := make(map[*ASTNode]bool, len(v.seen))
+	for n := range v.seen {
+		seen[n] = true
+	}
+	v.seen = seen
+	return v
+}
+
+// 调试器工厂函数,source 为源程序文本,cmds 为调试命令的来源
+// echo 为真时把读到的命令回显到输出中
+func NewDebugger(it *Interpreter, source string, cmds io.Reader, out io.Writer, echo bool) *Debugger {
+	return &Debugger{
+		it:    it,
+		src:   strings.Split(source, "\n"),
+		cmds:  bufio.NewReader(cmds),
+		out:   out,
+		echo:  echo,
+		lines: make(map[int]bool),
+		funcs: make(map[string]bool),
+		mode:  DEBUG_STEP, // 在 main 的第一条语句处停下
+	}
+}
+
+// 在调试器控制下执行 main 函数
+func (d *Debugger) Run() error {
+	d.it.hook = d.hook
+	defer func() { d.it.hook = nil }()
+	err := d.it.Run()
+	if err == errDebugQuit {
+		fmt.Fprintln(d.out, Msg(MSG_DEBUG_TERMINATED))
+		return nil
+	}
+	if err == nil {
+		fmt.Fprintln(d.out, Msg(MSG_DEBUG_EXITED))
+	}
+	return err
+}
+
+// 每条语句执行前由解释器调用,判断是否停下并读取命令
+func (d *Debugger) hook(node *ASTNode) error {
+	depth := len(d.it.frames)
+	entered := depth > d.cur.depth
+	newLine := d.cur.enter(node, depth)
+
+	stop := false
+	switch d.mode {
+	case DEBUG_STEP:
+		stop = newLine
+	case DEBUG_NEXT:
+		// 跳过被调用的函数,在当前函数中到达新的一行或返回到调用者时停下
+		stop = depth < d.from.depth || (depth == d.from.depth && d.from.enter(node, depth))
+	case DEBUG_FINISH:
+		stop = depth < d.depth
+	}
+	reason := ""
+	if d.mode != DEBUG_DETACH {
+		if newLine && d.lines[node.line] {
+			stop, reason = true, Msg(MSG_DEBUG_BREAK_LINE, node.line)
+		} else if name := nodeName(d.it.frame().fn); entered && d.funcs[name] {
+			stop, reason = true, Msg(MSG_DEBUG_BREAK_FUNC, name)
+		}
+	}
+	if !stop {
+		return nil
+	}
+	if len(reason) != 0 {
+		fmt.Fprintln(d.out, reason)
+	}
+	d.showLine(node.line)
+	return d.prompt()
+}
+
+// 读取并执行命令,直到遇到恢复执行的命令
+func (d *Debugger) prompt() error {
+	for {
+		fmt.Fprint(d.out, DEBUG_PROMPT)
+		line, err := d.cmds.ReadString('\n')
+		if err != nil && len(line) == 0 {
+			fmt.Fprintln(d.out)
+			d.mode = DEBUG_DETACH // 没有更多命令,运行到结束
+			return nil
+		}
+		line = strings.TrimSpace(line)
+		if d.echo {
+			fmt.Fprintln(d.out, line)
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		resume, err := d.command(fields[0], fields[1:])
+		if err != nil {
+			return err
+		}
+		if resume {
+			return nil
+		}
+	}
+}
+
+// 执行一条命令,返回是否恢复程序执行
+func (d *Debugger) command(cmd string, args []string) (bool, error) {
+	depth := len(d.it.frames)
+	switch cmd {
+	case "s", "step":
+		d.mode = DEBUG_STEP
+		return true, nil
+	case "n", "next":
+		d.mode, d.from = DEBUG_NEXT, d.cur.clone()
+		return true, nil
+	case "finish":
+		d.mode, d.depth = DEBUG_FINISH, depth
+		return true, nil
+	case "c", "continue":
+		d.mode = DEBUG_CONTINUE
+		return true, nil
+	case "q", "quit":
+		return false, errDebugQuit
+	case "b", "break":
+		d.setBreak(args, true)
+	case "d", "delete":
+		d.setBreak(args, false)
+	case "p", "print":
+		for _, a := range args {
+			d.print(a)
+		}
+	case "bt", "backtrace":
+		for i, f := range d.it.trace() {
+			fmt.Fprintln(d.out, Msg(MSG_DEBUG_FRAME, i, f.Func, f.Line))
+		}
+	case "l", "list":
+		line := d.it.frame().line
+		for i := line - 2; i <= line+2; i++ {
+			d.showLine(i)
+		}
+	case "where":
+		d.showLine(d.it.frame().line)
+	case "info":
+		d.info(args)
+	case "h", "help":
+		fmt.Fprintln(d.out, Msg(MSG_DEBUG_HELP))
+	default:
+		fmt.Fprintln(d.out, Msg(MSG_DEBUG_UNKNOWN_CMD, cmd))
+	}
+	return false, nil
+}
+
+// 设置或删除断点,参数为行号或函数名
+func (d *Debugger) setBreak(args []string, set bool) {
+	for _, a := range args {
+		if n, err := strconv.Atoi(a); err == nil {
+			if set {
+				d.lines[n] = true
+			} else {
+				delete(d.lines, n)
+			}
+		} else if _, ok := d.it.funcs[a]; ok {
+			if set {
+				d.funcs[a] = true
+			} else {
+				delete(d.funcs, a)
+			}
+		} else {
+			fmt.Fprintln(d.out, Msg(MSG_DEBUG_NO_FUNC, a))
+			continue
+		}
+		if set {
+			fmt.Fprintln(d.out, Msg(MSG_DEBUG_BREAK_SET, a))
+		}
+	}
+}
+
+// 打印变量或数组元素,下标可以是整数或变量名
+func (d *Debugger) print(expr string) {
+	name, index := expr, ""
+	if i := strings.IndexByte(expr, '['); i > 0 && strings.HasSuffix(expr, "]") {
+		name, index = expr[:i], expr[i+1:len(expr)-1]
+	}
+	v := d.it.scope().lookup(name)
+	if v == nil {
+		fmt.Fprintln(d.out, Msg(MSG_DEBUG_NO_SYMBOL, name))
+		return
+	}
+	if len(index) == 0 {
+		if v.isArray {
+			fmt.Fprintf(d.out, "%s = %s\n", name, formatArray(v.arr))
+		} else {
+			fmt.Fprintf(d.out, "%s = %d\n", name, v.val)
+		}
+		return
+	}
+	if !v.isArray {
+		fmt.Fprintln(d.out, Msg(MSG_RUNTIME_NOT_ARRAY, name))
+		return
+	}
+	idx, err := strconv.ParseInt(index, 10, 64)
+	if err != nil {
+		iv := d.it.scope().lookup(index)
+		if iv == nil || iv.isArray {
+			fmt.Fprintln(d.out, Msg(MSG_DEBUG_BAD_INDEX, index))
+			return
+		}
+		idx = iv.val
+	}
+	if idx < 0 || idx >= int64(len(v.arr)) {
+		fmt.Fprintln(d.out, Msg(MSG_RUNTIME_INDEX, idx, name, len(v.arr)))
+		return
+	}
+	fmt.Fprintf(d.out, "%s[%d] = %d\n", name, idx, v.arr[idx])
+}
+
+// info 命令
+func (d *Debugger) info(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(d.out, Msg(MSG_DEBUG_INFO_USAGE))
+		return
+	}
+	switch args[0] {
+	case "breakpoints", "b":
+		var lines []int
+		for n := range d.lines {
+			lines = append(lines, n)
+		}
+		sort.Ints(lines)
+		for _, n := range lines {
+			fmt.Fprintln(d.out, Msg(MSG_DEBUG_INFO_LINE, n))
+		}
+		var funcs []string
+		for name := range d.funcs {
+			funcs = append(funcs, name)
+		}
+		sort.Strings(funcs)
+		for _, name := range funcs {
+			fmt.Fprintln(d.out, Msg(MSG_DEBUG_INFO_FUNC, name))
+		}
+	case "locals":
+		// 由内向外打印当前函数中可见的变量,内层同名变量遮蔽外层
+		seen := make(map[string]bool)
+		for s := d.it.scope(); s != nil && s != d.it.globals; s = s.prev {
+			var names []string
+			for name := range s.vars {
+				if !seen[name] {
+					names = append(names, name)
+					seen[name] = true
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				d.print(name)
+			}
+		}
+	default:
+		fmt.Fprintln(d.out, Msg(MSG_DEBUG_UNKNOWN_INFO, args[0]))
+	}
+}
+
+// 打印源程序的一行
+func (d *Debugger) showLine(line int) {
+	if line >= 1 && line <= len(d.src) {
+		fmt.Fprintf(d.out, "%d\t%s\n", line, strings.TrimRight(d.src[line-1], "\r"))
+	}
+}
+
+// 数组的文本形式
+func formatArray(arr []int64) string {
+	parts := make([]string, len(arr))
+	for i, v := range arr {
+		parts[i] = strconv.FormatInt(v, 10)
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
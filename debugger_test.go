@@ -0,0 +1,157 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: debugger_test.go
+// Package: scan
+// Description: 调试器的测试
+// 				用命令脚本驱动调试器,检查同名变量的遮蔽和递归调用时变量按当前帧的作用域解析,
+// 				以及 step 和 next 按行停下
+
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDebuggerScopes(t *testing.T) {
+	src := `int x;
+void f(int n) {
+	int x;
+	x = n * 10;
+	{ int x; x = n + 100;
+		output(x); }
+	if (n > 0) f(n - 1);
+}
+void main(void) { x = 7; f(1); output(x); }
+`
+	cmds := "p x\nbreak 6 7\nc\np x n\nc\np x n\nc\np x n\nbt\nc\np x n\ndelete 6 7\nc\n"
+	want := `9	void main(void) { x = 7; f(1); output(x); }
+(cmdb) p x
+x = 0
+(cmdb) break 6 7
+Breakpoint set at 6
+Breakpoint set at 7
+(cmdb) c
+Breakpoint at line 6
+6			output(x); }
+(cmdb) p x n
+x = 101
+n = 1
+(cmdb) c
+101
+Breakpoint at line 7
+7		if (n > 0) f(n - 1);
+(cmdb) p x n
+x = 10
+n = 1
+(cmdb) c
+Breakpoint at line 6
+6			output(x); }
+(cmdb) p x n
+x = 100
+n = 0
+(cmdb) bt
+#0  f (line 6)
+#1  f (line 7)
+#2  main (line 9)
+(cmdb) c
+100
+Breakpoint at line 7
+7		if (n > 0) f(n - 1);
+(cmdb) p x n
+x = 0
+n = 0
+(cmdb) delete 6 7
+(cmdb) c
+7
+program exited normally
+`
+	root, _, err := ParseProgram(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	it, err := NewInterpreter(root, strings.NewReader(""), &out, InterpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDebugger(it, src, strings.NewReader(cmds), &out, true).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+// 用命令脚本调试程序,返回调试器的输出
+func runDebugger(t *testing.T, src, cmds string) string {
+	t.Helper()
+	root, _, err := ParseProgram(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	it, err := NewInterpreter(root, strings.NewReader(""), &out, InterpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDebugger(it, src, strings.NewReader(cmds), &out, true).Run(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+const debugStepSrc = `int f(int n) {
+	int r; r = n * 2;
+	return r;
+}
+void main(void) {
+	int i; int x;
+	i = 0; x = f(1); output(x);
+	while (i < 2)
+		i = i + 1;
+	output(i);
+}
+`
+
+func TestDebuggerNext(t *testing.T) {
+	// next 跳过同一行的其余语句和被调用的函数,单行的循环体每次执行都停下
+	want := `7		i = 0; x = f(1); output(x);
+(cmdb) n
+2
+8		while (i < 2)
+(cmdb) n
+9			i = i + 1;
+(cmdb) n
+9			i = i + 1;
+(cmdb) n
+10		output(i);
+(cmdb) n
+2
+program exited normally
+`
+	if got := runDebugger(t, debugStepSrc, "n\nn\nn\nn\nn\n"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDebuggerStep(t *testing.T) {
+	// step 进入被调用的函数,返回调用者后停在调用所在的行
+	want := `7		i = 0; x = f(1); output(x);
+(cmdb) s
+2		int r; r = n * 2;
+(cmdb) s
+3		return r;
+(cmdb) s
+7		i = 0; x = f(1); output(x);
+(cmdb) n
+2
+8		while (i < 2)
+(cmdb) c
+2
+program exited normally
+`
+	if got := runDebugger(t, debugStepSrc, "s\ns\ns\nn\nc\n"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
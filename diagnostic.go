@@ -0,0 +1,133 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: diagnostic.go
+// Package: scan
+// Description: 本文件定义了各个分析、优化阶段共用的诊断信息类型以及打印函数
+
+package scan
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// 诊断信息级别
+type Severity int
+
+const (
+	SEVERITY_ERROR   Severity = iota // 错误
+	SEVERITY_WARNING                 // 警告
+)
+
+// 诊断信息
+type Diagnostic struct {
+	Line     int      // 所在行号
+	Severity Severity // 级别
+	Code     string   // 诊断编号,如W001
+	Message  string   // 诊断内容
+}
+
+// 诊断编号
+const (
+	DIAG_SYNTAX      = "E001" // 语法错误
+	DIAG_TRAILING    = "E002" // 程序片段之后还有多余的输入
+	DIAG_DIV_BY_ZERO = "W001" // 除数为常量0
+
+	DIAG_UNUSED_LOCAL  = "W101" // 未使用的局部变量
+	DIAG_UNUSED_PARAM  = "W102" // 未使用的形参
+	DIAG_UNUSED_GLOBAL = "W103" // 未使用的全局变量
+	DIAG_UNUSED_FUNC   = "W104" // 从未被调用的函数
+
+	DIAG_UNREACHABLE    = "W201" // 不可达的语句
+	DIAG_DEAD_LOOP      = "W202" // 循环体永不执行
+	DIAG_MISSING_RETURN = "W203" // int 函数可能不经过 return 结束
+
+	DIAG_UNINIT       = "W301" // 使用未初始化的变量
+	DIAG_MAYBE_UNINIT = "W302" // 使用可能未初始化的变量
+
+	DIAG_OUT_OF_BOUNDS       = "E401" // 数组下标必定越界
+	DIAG_MAYBE_OUT_OF_BOUNDS = "W401" // 数组下标可能越界
+)
+
+// 警告的名字,用于在命令行中关闭警告
+var WARNING_NAMES = map[string]string{
+	"div-by-zero":         DIAG_DIV_BY_ZERO,
+	"unused-local":        DIAG_UNUSED_LOCAL,
+	"unused-param":        DIAG_UNUSED_PARAM,
+	"unused-global":       DIAG_UNUSED_GLOBAL,
+	"unused-func":         DIAG_UNUSED_FUNC,
+	"unreachable":         DIAG_UNREACHABLE,
+	"dead-loop":           DIAG_DEAD_LOOP,
+	"missing-return":      DIAG_MISSING_RETURN,
+	"uninitialized":       DIAG_UNINIT,
+	"maybe-uninitialized": DIAG_MAYBE_UNINIT,
+	"maybe-out-of-bounds": DIAG_MAYBE_OUT_OF_BOUNDS,
+}
+
+// 新建一条警告,id 为消息编号
+func newWarning(line int, code string, id string, args ...interface{}) Diagnostic {
+	return Diagnostic{Line: line, Severity: SEVERITY_WARNING, Code: code, Message: Msg(id, args...)}
+}
+
+// 新建一条错误,id 为消息编号
+func newError(line int, code string, id string, args ...interface{}) Diagnostic {
+	return Diagnostic{Line: line, Severity: SEVERITY_ERROR, Code: code, Message: Msg(id, args...)}
+}
+
+// 诊断信息的文本形式
+func (d Diagnostic) String() string {
+	level := Msg(MSG_DIAG_WARNING)
+	if d.Severity == SEVERITY_ERROR {
+		level = Msg(MSG_DIAG_ERROR)
+	}
+	return Msg(MSG_DIAG_LINE, d.Line, level, d.Code, d.Message)
+}
+
+// 按行号排序诊断信息
+func SortDiagnostics(diags []Diagnostic) {
+	sort.SliceStable(diags, func(i, j int) bool {
+		return diags[i].Line < diags[j].Line
+	})
+}
+
+// 运行全部静态检查,返回按行号排序的诊断信息
+func Analyze(root *ASTNode) []Diagnostic {
+	var diags []Diagnostic
+	diags = append(diags, Lint(root)...)
+	diags = append(diags, CheckFlow(root)...)
+	diags = append(diags, CheckUninitialized(root)...)
+	diags = append(diags, CheckBounds(root)...)
+	SortDiagnostics(diags)
+	return diags
+}
+
+// 删除被关闭的警告,disabled 的键为诊断编号,错误不能被关闭
+func FilterDiagnostics(diags []Diagnostic, disabled map[string]bool) []Diagnostic {
+	var res []Diagnostic
+	for _, d := range diags {
+		if d.Severity == SEVERITY_WARNING && disabled[d.Code] {
+			continue
+		}
+		res = append(res, d)
+	}
+	return res
+}
+
+// 统计错误级别的诊断数
+func CountErrors(diags []Diagnostic) int {
+	n := 0
+	for _, d := range diags {
+		if d.Severity == SEVERITY_ERROR {
+			n++
+		}
+	}
+	return n
+}
+
+// 打印诊断信息
+func HelpPrintDiagnostics(diags []Diagnostic, file *os.File) {
+	for _, d := range diags {
+		fmt.Fprintln(file, d.String())
+	}
+}
@@ -0,0 +1,457 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: diff.go
+// Package: scan
+// Description: 本文件定义了两棵抽象语法树之间的结构化比较,格式和注释的差异不影响结果
+// 				节点匹配分为四步(与 GumTree 算法类似):
+// 				1. 名字相同的全局声明互相匹配
+// 				2. 自大到小匹配完全相同的子树,有多个候选时优先父节点已经匹配的、位置相近的
+// 				3. 自底向上匹配后代节点大多已经匹配的同类节点(Dice 系数不小于 0.5)
+// 				4. 自顶向下在已经匹配的节点之间按顺序匹配剩余的同类子节点
+// 				没有匹配的节点为删除或插入,标签不同的匹配节点为修改,父节点改变或在兄弟中的次序改变的为移动
+// 				形参列表、实参列表和类型节点不参与比较,类型并入声明节点的标签
+
+package scan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 编辑操作种类
+type EditKind int
+
+const (
+	EDIT_INSERT EditKind = iota // 新程序中插入的子树
+	EDIT_DELETE                 // 旧程序中删除的子树
+	EDIT_CHANGE                 // 标签(名字、值、运算符或类型)改变的节点
+	EDIT_MOVE                   // 移到其他父节点下或在兄弟中次序改变的子树
+)
+
+// 编辑操作种类的名字
+func (k EditKind) String() string {
+	switch k {
+	case EDIT_INSERT:
+		return "inserted"
+	case EDIT_DELETE:
+		return "deleted"
+	case EDIT_CHANGE:
+		return "changed"
+	case EDIT_MOVE:
+		return "moved"
+	}
+	return "unknown"
+}
+
+// 一个编辑操作,插入时 Old 为 nil,删除时 New 为 nil
+type Edit struct {
+	Kind     EditKind
+	Old, New *ASTNode
+	OldDesc  string // 节点在旧程序中的标签
+	NewDesc  string // 节点在新程序中的标签
+	sortLine int    // 排序用的旧程序行号
+}
+
+// 比较两个程序的语法树,返回按旧程序行号排列的编辑操作,两棵树相同时返回空
+func DiffAST(oldRoot, newRoot *ASTNode) []Edit {
+	a, b := buildDiffTree(oldRoot), buildDiffTree(newRoot)
+	a.root.link(b.root)
+	matchDecls(a, b)
+	matchIdentical(a, b)
+	matchBottomUp(a, b)
+	for _, n := range a.nodes {
+		if n.match != nil {
+			recoverChildren(n, n.match)
+		}
+	}
+	return diffEdits(a, b)
+}
+
+// 打印编辑操作,行号分别对应旧文件和新文件
+func HelpPrintEdits(oldName, newName string, edits []Edit, file *os.File) {
+	for _, e := range edits {
+		switch e.Kind {
+		case EDIT_INSERT:
+			fmt.Fprintf(file, "%s:%d: %s %s\n", newName, e.New.line, e.Kind, e.NewDesc)
+		case EDIT_DELETE:
+			fmt.Fprintf(file, "%s:%d: %s %s\n", oldName, e.Old.line, e.Kind, e.OldDesc)
+		case EDIT_CHANGE:
+			fmt.Fprintf(file, "%s:%d -> %s:%d: %s %s -> %s\n", oldName, e.Old.line, newName, e.New.line, e.Kind, e.OldDesc, e.NewDesc)
+		case EDIT_MOVE:
+			fmt.Fprintf(file, "%s:%d -> %s:%d: %s %s\n", oldName, e.Old.line, newName, e.New.line, e.Kind, e.NewDesc)
+		}
+	}
+}
+
+// 参与比较的树节点
+type diffNode struct {
+	ast      *ASTNode // 虚拟的根节点为 nil
+	kind     string
+	label    string
+	parent   *diffNode
+	children []*diffNode
+	hash     uint64 // 子树的哈希值
+	size     int    // 子树的节点数
+	order    int    // 先序编号,后代的编号在 (order, order+size) 之间
+	match    *diffNode
+}
+
+// 参与比较的树
+type diffTree struct {
+	root  *diffNode
+	nodes []*diffNode // 按先序排列
+}
+
+// 匹配两个节点
+func (n *diffNode) link(m *diffNode) {
+	n.match, m.match = m, n
+}
+
+// 判断 m 是否为 n 的后代
+func (n *diffNode) contains(m *diffNode) bool {
+	return m.order > n.order && m.order < n.order+n.size
+}
+
+// 由以 root 开始的兄弟序列建立比较用的树,根节点为虚拟的 Program 节点
+func buildDiffTree(root *ASTNode) *diffTree {
+	t := &diffTree{root: &diffNode{kind: "Program", label: "Program"}}
+	t.add(t.root, root)
+	t.number(t.root)
+	return t
+}
+
+// 把 node 开始的兄弟序列加为 parent 的子节点,形参列表和实参列表展开,类型节点和数组大小略去
+func (t *diffTree) add(parent *diffNode, node *ASTNode) {
+	for ; node != nil; node = node.sibling {
+		switch node.nodeK {
+		case PARAMS, ARGS:
+			t.add(parent, node.left)
+			continue
+		case TYPE:
+			continue
+		}
+		n := &diffNode{ast: node, kind: NodeKindName(node), label: diffLabel(node), parent: parent}
+		parent.children = append(parent.children, n)
+		if isStmt(node, VAR_DECLARATION) {
+			continue // 类型和数组大小已并入声明节点的标签
+		}
+		t.add(n, node.left)
+		t.add(n, node.mid)
+		t.add(n, node.right)
+	}
+}
+
+// 先序编号,计算子树大小和哈希值
+func (t *diffTree) number(n *diffNode) {
+	n.order = len(t.nodes)
+	t.nodes = append(t.nodes, n)
+	h := fnv.New64a()
+	h.Write([]byte(n.label))
+	n.size = 1
+	var buf [8]byte
+	for _, c := range n.children {
+		t.number(c)
+		n.size += c.size
+		binary.LittleEndian.PutUint64(buf[:], c.hash)
+		h.Write(buf[:])
+	}
+	n.hash = h.Sum64()
+}
+
+// 节点的标签,声明节点加上类型,形如可以用作查询的选择器
+func diffLabel(node *ASTNode) string {
+	desc := DescribeNode(node)
+	if !isStmt(node, VAR_DECLARATION) && !isStmt(node, FUNC_DECLARATION) && node.nodeK != PARAM {
+		return desc
+	}
+	if node.left == nil {
+		return desc
+	}
+	desc += "[type=" + varTypeName(node.left.varT) + "]"
+	if isStmt(node, VAR_DECLARATION) && node.right != nil {
+		desc += fmt.Sprintf("[size=%d]", nodeValue(node.right))
+	}
+	return desc
+}
+
+// 第一步: 名字和种类相同的全局声明互相匹配
+func matchDecls(a, b *diffTree) {
+	for _, x := range a.root.children {
+		for _, y := range b.root.children {
+			if y.match == nil && x.kind == y.kind && nodeName(x.ast) == nodeName(y.ast) {
+				x.link(y)
+				break
+			}
+		}
+	}
+}
+
+// 第二步: 自大到小匹配完全相同的子树,只有一个节点的子树留给后面按位置匹配
+func matchIdentical(a, b *diffTree) {
+	byHash := make(map[uint64][]*diffNode)
+	for _, y := range b.nodes {
+		byHash[y.hash] = append(byHash[y.hash], y)
+	}
+	xs := append([]*diffNode(nil), a.nodes...)
+	sort.SliceStable(xs, func(i, j int) bool { return xs[i].size > xs[j].size })
+	for _, x := range xs {
+		if x.size < 2 || x.match != nil || x.parent == nil {
+			continue
+		}
+		var best *diffNode
+		for _, y := range byHash[x.hash] {
+			if y.match != nil || y.parent == nil || !sameShape(x, y) {
+				continue
+			}
+			if best == nil || closer(x, y, best) {
+				best = y
+			}
+		}
+		if best != nil {
+			linkSubtree(x, best)
+		}
+	}
+}
+
+// 判断两棵子树是否完全相同,排除哈希冲突
+func sameShape(x, y *diffNode) bool {
+	if x.label != y.label || len(x.children) != len(y.children) {
+		return false
+	}
+	for i := range x.children {
+		if !sameShape(x.children[i], y.children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// 判断候选 y 是否比 best 更适合与 x 匹配: 父节点已经匹配的优先,
+// 其次是位于 x 最近的已匹配祖先的对应节点之中的,最后比较在该祖先中的相对位置
+func closer(x, y, best *diffNode) bool {
+	yParent, bestParent := x.parent.match == y.parent, x.parent.match == best.parent
+	if yParent != bestParent {
+		return yParent
+	}
+	anc := x.parent
+	for anc.match == nil {
+		anc = anc.parent
+	}
+	yIn, bestIn := anc.match.contains(y), anc.match.contains(best)
+	if yIn != bestIn {
+		return yIn
+	}
+	pos := func(n, root *diffNode) float64 { return float64(n.order-root.order) / float64(root.size) }
+	dy, db := pos(x, anc)-pos(y, anc.match), pos(x, anc)-pos(best, anc.match)
+	return dy*dy < db*db
+}
+
+// 匹配两棵完全相同的子树中的全部节点
+func linkSubtree(x, y *diffNode) {
+	if x.match == nil && y.match == nil {
+		x.link(y)
+	}
+	for i := range x.children {
+		linkSubtree(x.children[i], y.children[i])
+	}
+}
+
+// 第三步: 自底向上匹配后代节点大多已经匹配的同类节点
+func matchBottomUp(a, b *diffTree) {
+	for i := len(a.nodes) - 1; i >= 0; i-- { // 先序的逆序中后代在祖先之前
+		x := a.nodes[i]
+		if x.match != nil || len(x.children) == 0 {
+			continue
+		}
+		var best *diffNode
+		bestDice := 0.5
+		for _, y := range b.nodes {
+			if y.match != nil || y.kind != x.kind || y.parent == nil {
+				continue
+			}
+			common := 0
+			for _, d := range a.nodes[x.order+1 : x.order+x.size] {
+				if d.match != nil && y.contains(d.match) {
+					common++
+				}
+			}
+			dice := 2 * float64(common) / float64(x.size-1+y.size-1)
+			if dice >= bestDice && (best == nil || dice > bestDice || closer(x, y, best)) {
+				best, bestDice = y, dice
+			}
+		}
+		if best != nil {
+			x.link(best)
+		}
+	}
+}
+
+// 第四步: 在匹配的节点 x、y 之间按顺序匹配剩余的同类子节点
+// 同类候选有多个时选择子树标签重合最多的
+func recoverChildren(x, y *diffNode) {
+	j := 0
+	for _, cx := range x.children {
+		if cx.match != nil {
+			continue
+		}
+		var best *diffNode
+		bestIdx, bestScore := 0, -1
+		for k := j; k < len(y.children); k++ {
+			cy := y.children[k]
+			if cy.match != nil || cy.kind != cx.kind {
+				continue
+			}
+			if score := labelOverlap(cx, cy); score > bestScore {
+				best, bestIdx, bestScore = cy, k, score
+			}
+		}
+		if best != nil {
+			cx.link(best)
+			j = bestIdx + 1
+		}
+	}
+}
+
+// 两棵子树中相同标签的个数(按多重集合计算)
+func labelOverlap(x, y *diffNode) int {
+	count := make(map[string]int)
+	var collect func(n *diffNode, d int)
+	collect = func(n *diffNode, d int) {
+		count[n.label] += d
+		for _, c := range n.children {
+			collect(c, d)
+		}
+	}
+	collect(x, 1)
+	res := 0
+	var take func(n *diffNode)
+	take = func(n *diffNode) {
+		if count[n.label] > 0 {
+			count[n.label]--
+			res++
+		}
+		for _, c := range n.children {
+			take(c)
+		}
+	}
+	take(y)
+	return res
+}
+
+// 根据匹配结果生成编辑操作
+func diffEdits(a, b *diffTree) []Edit {
+	var edits []Edit
+	for _, x := range a.nodes[1:] {
+		switch {
+		case x.match == nil:
+			if x.parent.match != nil { // 只报告删除的子树的根
+				edits = append(edits, Edit{Kind: EDIT_DELETE, Old: x.ast, OldDesc: x.label, sortLine: x.ast.line})
+			}
+		case x.label != x.match.label:
+			edits = append(edits, Edit{Kind: EDIT_CHANGE, Old: x.ast, New: x.match.ast, OldDesc: x.label, NewDesc: x.match.label, sortLine: x.ast.line})
+		}
+		if x.match != nil && x.parent.match != x.match.parent {
+			edits = append(edits, Edit{Kind: EDIT_MOVE, Old: x.ast, New: x.match.ast, OldDesc: x.label, NewDesc: x.match.label, sortLine: x.ast.line})
+		}
+	}
+	for _, x := range a.nodes {
+		for _, c := range reordered(x) {
+			edits = append(edits, Edit{Kind: EDIT_MOVE, Old: c.ast, New: c.match.ast, OldDesc: c.label, NewDesc: c.match.label, sortLine: c.ast.line})
+		}
+	}
+	for _, y := range b.nodes[1:] {
+		if y.match == nil && y.parent.match != nil {
+			edits = append(edits, Edit{Kind: EDIT_INSERT, New: y.ast, NewDesc: y.label, sortLine: insertLine(y)})
+		}
+	}
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].sortLine != edits[j].sortLine {
+			return edits[i].sortLine < edits[j].sortLine
+		}
+		return edits[i].Kind < edits[j].Kind
+	})
+	return edits
+}
+
+// 父节点不变但在兄弟中次序改变的子节点: 不在最长递增子序列中的
+func reordered(x *diffNode) []*diffNode {
+	if x.match == nil {
+		return nil
+	}
+	index := make(map[*diffNode]int)
+	for i, c := range x.match.children {
+		index[c] = i
+	}
+	var seq []*diffNode
+	for _, c := range x.children {
+		if c.match != nil && c.match.parent == x.match {
+			seq = append(seq, c)
+		}
+	}
+	// O(n^2) 的最长递增子序列,子节点个数很少
+	n := len(seq)
+	length, prev := make([]int, n), make([]int, n)
+	last := -1
+	for i := range seq {
+		length[i], prev[i] = 1, -1
+		for j := 0; j < i; j++ {
+			if index[seq[j].match] < index[seq[i].match] && length[j]+1 > length[i] {
+				length[i], prev[i] = length[j]+1, j
+			}
+		}
+		if last < 0 || length[i] > length[last] {
+			last = i
+		}
+	}
+	keep := make(map[int]bool)
+	for i := last; i >= 0; i = prev[i] {
+		keep[i] = true
+	}
+	var res []*diffNode
+	for i, c := range seq {
+		if !keep[i] {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// 插入的节点在旧程序中对应的行号: 前一个已匹配兄弟或父节点在旧程序中的行号
+func insertLine(y *diffNode) int {
+	line := 0
+	if p := y.parent.match; p != nil && p.ast != nil {
+		line = p.ast.line
+	}
+	for _, c := range y.parent.children {
+		if c == y {
+			break
+		}
+		if c.match != nil {
+			line = c.match.ast.line
+		}
+	}
+	return line
+}
+
+// 编辑操作的文本形式
+func (e Edit) String() string {
+	var parts []string
+	if e.Old != nil {
+		parts = append(parts, fmt.Sprintf("old line %d", e.Old.line))
+	}
+	if e.New != nil {
+		parts = append(parts, fmt.Sprintf("new line %d", e.New.line))
+	}
+	desc := e.NewDesc
+	switch e.Kind {
+	case EDIT_DELETE:
+		desc = e.OldDesc
+	case EDIT_CHANGE:
+		desc = e.OldDesc + " -> " + e.NewDesc
+	}
+	return fmt.Sprintf("%s: %s %s", strings.Join(parts, ", "), e.Kind, desc)
+}
@@ -0,0 +1,65 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: diff_test.go
+// Package: scan
+// Description: 语法树结构化比较的测试
+// 				插入、删除、修改和移动分别报告,格式和注释的差异不报告
+
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffAST(t *testing.T) {
+	base := `int g;
+int f(int a) {
+	return a * 2;
+}
+void main(void) {
+	int x;
+	x = input();
+	g = f(x);
+	output(g);
+}`
+	tests := []struct {
+		name, new, want string
+	}{
+		{"identical", base, ""},
+		{"layout and comments", `int g;
+/* 只改格式 */
+int f(int a) { return a*2; }
+void main(void) { int x; x = input(); g = f(x); output(g); }`, ""},
+		{"inserted", strings.Replace(base, "\toutput(g);", "\toutput(g);\n\toutput(x);", 1),
+			"new line 10: inserted Call[name=output]"},
+		{"deleted", strings.Replace(base, "\tg = f(x);\n", "", 1),
+			"old line 8: deleted Assign"},
+		{"changed", strings.Replace(base, "a * 2", "a * 3", 1),
+			"old line 3, new line 3: changed Const[value=2] -> Const[value=3]"},
+		// 数组大小并入声明的标签,不单独报告
+		{"declaration type", strings.Replace(base, "int g;", "int g[4];", 1),
+			"old line 1, new line 1: changed VarDecl[name=g][type=int] -> VarDecl[name=g][type=int[]][size=4]"},
+		{"reordered", strings.Replace(base, "\tx = input();\n\tg = f(x);", "\tg = f(x);\n\tx = input();", 1),
+			"old line 8, new line 7: moved Assign"},
+		{"moved into a block", strings.Replace(base, "\toutput(g);", "\tif (g > 0) {\n\t\toutput(g);\n\t}", 1),
+			"new line 9: inserted If\nold line 9, new line 10: moved Call[name=output]"},
+	}
+	oldRoot, _, err := ParseProgram(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		newRoot, _, err := ParseProgram(tt.new)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for _, e := range DiffAST(oldRoot, newRoot) {
+			got = append(got, e.String())
+		}
+		if s := strings.Join(got, "\n"); s != tt.want {
+			t.Errorf("%s:\ngot\n%s\nwant\n%s", tt.name, s, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: flow.go
+// Package: scan
+// Description: 本文件定义了语法树上的控制流检查
+// 				沿语句顺序传递"可达"状态: return 之后不可达,while 的条件为常量0时循环体不可达,
+// 				条件为常量非0时循环不会正常结束(C-Minus 没有 break),if 的条件为常量时只有一个分支可达
+// 				报告不可达的语句、永不执行的循环体以及可能不经过 return 就结束的 int 函数
+
+package scan
+
+// 控制流检查器
+type flowChecker struct {
+	diags []Diagnostic
+}
+
+// 对语法树进行控制流检查,返回按行号排序的诊断信息
+func CheckFlow(root *ASTNode) []Diagnostic {
+	c := &flowChecker{}
+	for node := root; node != nil; node = node.sibling {
+		if !isStmt(node, FUNC_DECLARATION) || node.right == nil {
+			continue
+		}
+		if c.stmt(node.right, true) && funcReturnsInt(node) {
+			c.diags = append(c.diags, newWarning(c.lastLine(node.right), DIAG_MISSING_RETURN,
+				MSG_MISSING_RETURN, nodeName(node)))
+		}
+	}
+	SortDiagnostics(c.diags)
+	return c.diags
+}
+
+// 检查一条语句,reachable 表示语句是否可达
+// 返回语句结束后控制流能否继续执行下一条语句
+// 不可达的语句只在最外层报告一次,内部不再重复报告
+func (c *flowChecker) stmt(node *ASTNode, reachable bool) bool {
+	if node == nil {
+		return reachable
+	}
+	switch {
+	case isStmt(node, RETURN_STMT):
+		return false
+	case isStmt(node, COMPOUND):
+		entry := reachable
+		for s := node.right; s != nil; s = s.sibling {
+			if !reachable {
+				if entry {
+					c.diags = append(c.diags, newWarning(s.line, DIAG_UNREACHABLE, MSG_UNREACHABLE))
+				}
+				for ; s != nil; s = s.sibling {
+					c.stmt(s, false)
+				}
+				break
+			}
+			reachable = c.stmt(s, true)
+		}
+		return reachable
+	case isStmt(node, SELECTION_STMT):
+		cond, isConst := evalConstExp(node.left)
+		thenReach := reachable && (!isConst || cond != 0)
+		elseReach := reachable && (!isConst || cond == 0)
+		if reachable && isConst && node.mid != nil && !thenReach {
+			c.diags = append(c.diags, newWarning(node.mid.line, DIAG_UNREACHABLE, MSG_IF_NEVER_TAKEN))
+		}
+		if reachable && isConst && node.right != nil && !elseReach {
+			c.diags = append(c.diags, newWarning(node.right.line, DIAG_UNREACHABLE, MSG_ELSE_NEVER_TAKEN))
+		}
+		thenOut := c.stmt(node.mid, thenReach)
+		if node.right == nil {
+			return thenOut || elseReach
+		}
+		return thenOut || c.stmt(node.right, elseReach)
+	case isStmt(node, ITERATION_STMT):
+		cond, isConst := evalConstExp(node.left)
+		if isConst && cond == 0 {
+			if reachable && node.mid != nil {
+				c.diags = append(c.diags, newWarning(node.mid.line, DIAG_DEAD_LOOP, MSG_DEAD_LOOP))
+			}
+			c.stmt(node.mid, false)
+			return reachable
+		}
+		c.stmt(node.mid, reachable)
+		return reachable && !isConst // while(非0常量) 不会正常结束
+	}
+	return reachable
+}
+
+// 函数体最后一条语句的行号,用于报告缺少 return 的位置
+func (c *flowChecker) lastLine(body *ASTNode) int {
+	line := body.line
+	for s := body.right; s != nil; s = s.sibling {
+		line = s.line
+	}
+	return line
+}
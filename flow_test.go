@@ -0,0 +1,65 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: flow_test.go
+// Package: scan
+// Description: 控制流检查的测试
+// 				检查不可达的语句、永不执行的循环体和可能缺少 return 的 int 函数报告在哪一行
+
+package scan
+
+import (
+	"testing"
+)
+
+func TestCheckFlow(t *testing.T) {
+	checkDiagnostics(t, "flow", CheckFlow, []struct{ name, src, want string }{
+		{"clean", `int f(int n) {
+	if (n > 0) return 1;
+	else return 2;
+}
+void main(void) { output(f(1)); }`, ""},
+		{"after return", `int f(int n) {
+	return n;
+	n = n + 1;
+	output(n);
+}
+void main(void) { output(f(1)); }`, "3:W201"},
+		{"after return in block", `void main(void) {
+	int x;
+	x = input();
+	if (x > 0) {
+		return;
+		output(x);
+	}
+	output(0);
+}`, "6:W201"},
+		{"if without else", `int f(int n) {
+	if (n > 0) return 1;
+}
+void main(void) { output(f(1)); }`, "2:W203"}, // 报告在函数体的最后一行
+		{"return after if", `int f(int n) {
+	if (n > 0) return 1;
+	return 0;
+}
+void main(void) { output(f(1)); }`, ""},
+		{"while false", `void main(void) {
+	while (0) output(1);
+	output(2);
+}`, "2:W202"},
+		{"while true", `int f(void) {
+	while (1) output(1);
+	output(2);
+}
+void main(void) { output(f()); }`, "3:W201"},
+		{"constant if", `int f(void) {
+	if (1) return 1;
+	else return 2;
+}
+void main(void) { output(f()); }`, "3:W201"},
+		{"constant if false", `void main(void) {
+	if (0)
+		output(1);
+	output(2);
+}`, "3:W201"},
+	})
+}
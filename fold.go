@@ -0,0 +1,170 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: fold.go
+// Package: scan
+// Description: 本文件定义了抽象语法树上的常量折叠和代数化简优化
+// 				折叠常量算术运算和比较运算,化简 x*1、x+0、x-0、x/1,
+// 				在x没有副作用时将 x*0 化简为0,并对除数为常量0的情况给出诊断
+// 				折叠的结果按32位 int 回绕,与解释器和各后端的运行结果一致
+// 				遍历和替换节点使用 walk.go 中的 Apply
+
+package scan
+
+// 对整棵语法树进行常量折叠,原地修改并返回新的根节点
+// 用 Apply 后序遍历,子表达式先于所在的运算折叠
+func FoldConstants(root *ASTNode) (*ASTNode, []Diagnostic) {
+	var diags []Diagnostic
+	root = Apply(root, nil, func(c *Cursor) bool {
+		node := c.Node()
+		if isExp(node, OPERATION) || isExp(node, COMPARE) {
+			if res := foldBinary(node, &diags); res != node {
+				c.Replace(res) // 替换后的节点接替原节点在兄弟序列(如实参序列)中的位置
+			}
+		}
+		return true
+	})
+	return root, diags
+}
+
+// 判断表达式是否为常量,并返回常量值
+func constValue(node *ASTNode) (int64, bool) {
+	if isExp(node, CONST) {
+		return nodeValue(node), true
+	}
+	return 0, false
+}
+
+// 计算只由常量和运算组成的表达式的值,不能在编译时求值时返回false
+func evalConstExp(node *ASTNode) (int64, bool) {
+	if val, ok := constValue(node); ok {
+		return val, true
+	}
+	if !isExp(node, OPERATION) && !isExp(node, COMPARE) {
+		return 0, false
+	}
+	l, lok := evalConstExp(node.left)
+	r, rok := evalConstExp(node.right)
+	if !lok || !rok {
+		return 0, false
+	}
+	return evalBinary32(nodeOp(node), l, r)
+}
+
+// 判断表达式是否有副作用(函数调用或赋值)或者可能在运行时出错(除数可能为0、数组下标可能越界),
+// 这样的表达式不能被化简掉
+func hasSideEffects(node *ASTNode) bool {
+	if node == nil {
+		return false
+	}
+	if isExp(node, CALL) || isExp(node, ASSIGNMENT) {
+		return true
+	}
+	if isExp(node, VAR) && node.left != nil { // 数组元素
+		return true
+	}
+	if isExp(node, OPERATION) && nodeOp(node) == DIV {
+		// 除数是0和-1以外的常量时不会出错(-1 可能溢出)
+		if r, ok := constValue(node.right); !ok || r == 0 || r == -1 {
+			return true
+		}
+	}
+	return hasSideEffects(node.left) || hasSideEffects(node.mid) || hasSideEffects(node.right)
+}
+
+// 新建一个常量节点
+func newConst(val int64, line int) *ASTNode {
+	node := NewASTNode(EXPRESSION, CONST, line)
+	node.SetAttr(val)
+	node.SetExpType(EXP_INT)
+	return node
+}
+
+// 计算二元运算,结果不回绕(解释器据此做溢出检查),除数为0时返回false
+func evalBinary(op Token, l, r int64) (int64, bool) {
+	b2i := func(b bool) int64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	switch op {
+	case PLUS:
+		return l + r, true
+	case MINUS:
+		return l - r, true
+	case MUL:
+		return l * r, true
+	case DIV:
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	case LT:
+		return b2i(l < r), true
+	case LE:
+		return b2i(l <= r), true
+	case GT:
+		return b2i(l > r), true
+	case GE:
+		return b2i(l >= r), true
+	case EQ:
+		return b2i(l == r), true
+	case NOT_EQ:
+		return b2i(l != r), true
+	}
+	return 0, false
+}
+
+// 按32位 int 计算二元运算,结果回绕到32位,除数为0时返回false
+func evalBinary32(op Token, l, r int64) (int64, bool) {
+	val, ok := evalBinary(op, l, r)
+	return int64(int32(val)), ok
+}
+
+// 折叠或化简一个二元运算节点,不能化简时返回原节点
+func foldBinary(node *ASTNode, diags *[]Diagnostic) *ASTNode {
+	op := nodeOp(node)
+	l, lok := constValue(node.left)
+	r, rok := constValue(node.right)
+
+	if op == DIV && rok && r == 0 {
+		*diags = append(*diags, newWarning(node.line, DIAG_DIV_BY_ZERO, MSG_DIV_BY_ZERO))
+		return node
+	}
+	if lok && rok {
+		if val, ok := evalBinary32(op, l, r); ok {
+			return newConst(val, node.line)
+		}
+		return node
+	}
+
+	// 代数化简
+	switch op {
+	case PLUS:
+		if rok && r == 0 { // x+0
+			return node.left
+		}
+		if lok && l == 0 { // 0+x
+			return node.right
+		}
+	case MINUS:
+		if rok && r == 0 { // x-0
+			return node.left
+		}
+	case MUL:
+		if rok && r == 1 { // x*1
+			return node.left
+		}
+		if lok && l == 1 { // 1*x
+			return node.right
+		}
+		if (rok && r == 0 && !hasSideEffects(node.left)) || (lok && l == 0 && !hasSideEffects(node.right)) {
+			return newConst(0, node.line) // x*0
+		}
+	case DIV:
+		if rok && r == 1 { // x/1
+			return node.left
+		}
+	}
+	return node
+}
@@ -0,0 +1,154 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: fold_test.go
+// Package: scan
+// Description: 常量折叠和常量传播的测试
+// 				折叠后的程序与折叠前的运行结果必须相同,包括32位溢出回绕的情况
+
+package scan
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFoldSamples(t *testing.T) {
+	for _, s := range samples {
+		root, _ := FoldConstants(parseSample(t, s))
+		var out strings.Builder
+		it, err := NewInterpreter(root, strings.NewReader(s.input), &out, InterpOptions{})
+		if err == nil {
+			err = it.Run()
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if out.String() != s.want {
+			t.Errorf("%s: got %q, want %q", s.name, out.String(), s.want)
+		}
+	}
+}
+
+func TestEvalBinary32(t *testing.T) {
+	tests := []struct {
+		op   Token
+		l, r int64
+		want int64
+	}{
+		{PLUS, 2147483647, 1, -2147483648},
+		{MINUS, -2147483648, 1, 2147483647},
+		{MUL, 65536, 65536, 0},
+		{DIV, -2147483648, -1, -2147483648},
+		{LT, 1, 2, 1},
+	}
+	for _, tt := range tests {
+		if got, ok := evalBinary32(tt.op, tt.l, tt.r); !ok || got != tt.want {
+			t.Errorf("%d %s %d = %d, want %d", tt.l, tokenSymbol(tt.op), tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestConstantPropagationWraps(t *testing.T) {
+	root, _, err := ParseProgram(`void main(void) { int x; x = 2147483647; output(x + 1); }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := GenerateIR(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Optimize(prog, OptLevel(1))
+	for _, b := range prog.Funcs[0].Blocks {
+		for _, ins := range b.Instrs {
+			if ins.Op == IR_CALL && ins.Sym == "output" {
+				if a := ins.Args[0]; a.Kind != OPD_CONST || a.Value != -2147483648 {
+					t.Errorf("output argument %s, want -2147483648", a)
+				}
+				return
+			}
+		}
+	}
+	t.Error("no call to output")
+}
+
+func TestFoldKeepsTraps(t *testing.T) {
+	tests := []struct {
+		src    string
+		folded bool // 是否折叠为常量0
+	}{
+		{"x * 0", true},
+		{"0 * x", true},
+		{"(x / 2) * 0", true},
+		{"(x + y) * 0", true},
+		{"(1 / y) * 0", false},
+		{"0 * (x / y)", false},
+		{"(x / 0) * 0", false},
+		{"(x / (0 - 1)) * 0", false}, // 0-1 先折叠为 -1, 除以 -1 可能溢出
+		{"a[i] * 0", false},
+		{"0 * (a[1] + 1)", false},
+		{"f(x) * 0", false},
+		{"(x = 1) * 0", false},
+	}
+	for _, tt := range tests {
+		node, _, err := ParseExpression(tt.src)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.src, err)
+		}
+		node, _ = FoldConstants(node)
+		if _, ok := constValue(node); ok != tt.folded {
+			t.Errorf("%s: folded to a constant: %v, want %v", tt.src, ok, tt.folded)
+		}
+	}
+}
+
+func TestFoldKeepsRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		src, input string
+		kind       RuntimeErrorKind
+	}{
+		{`void main(void) { int y; y = input(); output((1 / y) * 0); }`, "0", RUNTIME_DIV_BY_ZERO},
+		{`void main(void) { int a[3]; int i; i = input(); output(a[i] * 0); }`, "5", RUNTIME_INDEX_OUT_OF_RANGE},
+	}
+	for _, tt := range tests {
+		root, _, err := ParseProgram(tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, _ = FoldConstants(root)
+		it, err := NewInterpreter(root, strings.NewReader(tt.input), &strings.Builder{}, InterpOptions{})
+		if err == nil {
+			err = it.Run()
+		}
+		rtErr, ok := err.(*RuntimeError)
+		if !ok || rtErr.Kind != tt.kind {
+			t.Errorf("%s: got %v, want runtime error kind %v", tt.src, err, tt.kind)
+		}
+	}
+}
+
+func TestFoldArguments(t *testing.T) {
+	// 实参序列中被替换的节点要接上后面的实参
+	root, _, err := ParseProgram(`int f(int a, int b, int c) { return a + b + c; }
+void main(void) { int x; x = 4; output(f(1 + 2, x * 1, 2 * 3)); }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ = FoldConstants(root)
+	var args []string
+	Inspect(root, func(node *ASTNode) bool {
+		if isExp(node, CALL) && nodeName(node) == "f" {
+			for _, a := range callArgs(node) {
+				if val, ok := constValue(a); ok {
+					args = append(args, strconv.FormatInt(val, 10))
+				} else {
+					args = append(args, nodeName(a))
+				}
+			}
+		}
+		return true
+	})
+	if got := strings.Join(args, " "); got != "3 x 6" {
+		t.Errorf("arguments after folding: %q, want \"3 x 6\"", got)
+	}
+}
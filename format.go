@@ -0,0 +1,289 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: format.go
+// Package: scan
+// Description: 本文件定义了源程序格式化
+// 				按强类型语法树重新输出程序: 四个空格缩进,函数体的花括号另起一行,if/while 的花括号与条件同行
+// 				每个声明单独一行,全局声明之间空一行,表达式只保留必要的括号
+// 				注释按行号放回: 语句之前的注释单独成行,与声明或简单语句同行的注释留在行尾
+// 				格式化结果重新分析后与原程序的语法树比较(忽略行号),不同时返回错误而不输出
+
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 格式化的缩进
+const FORMAT_INDENT = "    "
+
+// 源程序中的注释
+type formatComment struct {
+	line int // 注释开始的行号
+	text string
+}
+
+// 格式化器
+type formatter struct {
+	buf      strings.Builder
+	indent   int
+	comments []formatComment // 尚未输出的注释,按行号排列
+}
+
+// 格式化源程序,有语法错误时返回诊断信息和错误,不修改源程序
+func Format(source string) (string, []Diagnostic, error) {
+	root, diags, err := ParseProgram(source)
+	if err != nil {
+		return "", diags, err
+	}
+	prog, err := ConvertProgram(root)
+	if err != nil {
+		return "", diags, err
+	}
+	f := &formatter{comments: collectComments(source)}
+	for i, d := range prog.Decls {
+		if i > 0 && (isFunc(d) || isFunc(prog.Decls[i-1])) {
+			f.blank()
+		}
+		f.leading(d.Pos())
+		f.decl(d)
+	}
+	for _, c := range f.comments {
+		f.line("%s", c.text)
+	}
+	text := f.buf.String()
+	// 格式化结果必须分析出与原程序相同的语法树
+	if formatted, _, err := ParseProgram(text); err != nil || !sameTree(root, formatted) {
+		return "", diags, errors.New(Msg(MSG_FORMAT_CHANGED))
+	}
+	return text, diags, nil
+}
+
+// 两棵语法树(包括兄弟节点)的结构是否相同,不比较行号
+func sameTree(a, b *ASTNode) bool {
+	for ; a != nil && b != nil; a, b = a.sibling, b.sibling {
+		if a.nodeK != b.nodeK || a.nodeT != b.nodeT || a.varT != b.varT || diffLabel(a) != diffLabel(b) {
+			return false
+		}
+		if !sameTree(a.left, b.left) || !sameTree(a.mid, b.mid) || !sameTree(a.right, b.right) {
+			return false
+		}
+	}
+	return a == nil && b == nil
+}
+
+// 是否为函数声明
+func isFunc(d Decl) bool {
+	_, ok := d.(*FuncDecl)
+	return ok
+}
+
+// 扫描源程序中的注释
+func collectComments(source string) []formatComment {
+	var res []formatComment
+	buf := NewStringBuffer(source)
+	scanner := NewScanner(buf)
+	scanner.SetOutput(nil)
+	for token, lexeme := scanner.getToken(); token != EOF_TOKEN; token, lexeme = scanner.getToken() {
+		if token == COMMENT {
+			text := string(lexeme)
+			res = append(res, formatComment{line: buf.Lines() - strings.Count(text, "\n"), text: text})
+		}
+	}
+	return res
+}
+
+// 输出一行
+func (f *formatter) line(format string, args ...interface{}) {
+	f.buf.WriteString(strings.Repeat(FORMAT_INDENT, f.indent))
+	fmt.Fprintf(&f.buf, format, args...)
+	f.buf.WriteString("\n")
+}
+
+// 输出空行,不重复输出
+func (f *formatter) blank() {
+	s := f.buf.String()
+	if len(s) != 0 && !strings.HasSuffix(s, "\n\n") {
+		f.buf.WriteString("\n")
+	}
+}
+
+// 输出行号在 line 之前的注释,每个注释单独成行
+func (f *formatter) leading(line int) {
+	for len(f.comments) > 0 && f.comments[0].line < line {
+		f.line("%s", f.comments[0].text)
+		f.comments = f.comments[1:]
+	}
+}
+
+// 行号为 line 的单行注释,作为行尾注释
+func (f *formatter) trailing(line int) string {
+	var res string
+	for len(f.comments) > 0 && f.comments[0].line == line && !strings.Contains(f.comments[0].text, "\n") {
+		res += " " + f.comments[0].text
+		f.comments = f.comments[1:]
+	}
+	return res
+}
+
+// 全局声明
+func (f *formatter) decl(d Decl) {
+	switch d := d.(type) {
+	case *VarDecl:
+		f.varDecl(d)
+	case *FuncDecl:
+		var params []string
+		for _, p := range d.Params {
+			if p.IsArray {
+				params = append(params, "int "+p.Name+"[]")
+			} else {
+				params = append(params, "int "+p.Name)
+			}
+		}
+		if len(params) == 0 {
+			params = append(params, "void")
+		}
+		f.line("%s %s(%s)%s", formatType(d.ReturnType), d.Name, strings.Join(params, ", "), f.trailing(d.Line))
+		f.compound(d.Body, "")
+	}
+}
+
+// 变量声明
+func (f *formatter) varDecl(d *VarDecl) {
+	if d.IsArray {
+		f.line("int %s[%d];%s", d.Name, d.Size, f.trailing(d.Line))
+	} else {
+		f.line("%s %s;%s", formatType(d.Type), d.Name, f.trailing(d.Line))
+	}
+}
+
+// 类型名
+func formatType(t VarType) string {
+	if t == VAR_TYPE_VOID {
+		return "void"
+	}
+	return "int"
+}
+
+// 复合语句,head 非空时花括号跟在 head 之后,否则单独成行
+func (f *formatter) compound(s *CompoundStmt, head string) {
+	if len(head) != 0 {
+		f.line("%s {", head)
+	} else {
+		f.line("{")
+	}
+	f.indent++
+	if s != nil {
+		for _, d := range s.Decls {
+			f.leading(d.Line)
+			f.varDecl(d)
+		}
+		for _, st := range s.Stmts {
+			f.stmt(st)
+		}
+	}
+	f.indent--
+	f.line("}")
+}
+
+// 语句
+func (f *formatter) stmt(s Stmt) {
+	if s == nil {
+		f.line(";")
+		return
+	}
+	f.leading(s.Pos())
+	switch s := s.(type) {
+	case *CompoundStmt:
+		f.compound(s, "")
+	case *IfStmt:
+		f.ifStmt("if", s)
+	case *WhileStmt:
+		f.body("while ("+f.expr(s.Cond, 0)+")", s.Body)
+	case *ReturnStmt:
+		if s.Result != nil {
+			f.line("return %s;%s", f.expr(s.Result, 0), f.trailing(s.Line))
+		} else {
+			f.line("return;%s", f.trailing(s.Line))
+		}
+	case *ExprStmt:
+		f.line("%s;%s", f.expr(s.X, 0), f.trailing(s.Line))
+	}
+}
+
+// 选择语句,else 分支为选择语句时输出为 else if 链
+func (f *formatter) ifStmt(keyword string, s *IfStmt) {
+	f.body(keyword+" ("+f.expr(s.Cond, 0)+")", s.Then)
+	if elseIf, ok := s.Else.(*IfStmt); ok {
+		f.leading(elseIf.Line)
+		f.ifStmt("else if", elseIf)
+	} else if s.Else != nil {
+		f.body("else", s.Else)
+	}
+}
+
+// if/while/else 的子语句: 复合语句的花括号跟在 head 之后,其他语句另起一行并增加缩进
+func (f *formatter) body(head string, s Stmt) {
+	if c, ok := s.(*CompoundStmt); ok {
+		f.compound(c, head)
+		return
+	}
+	f.line("%s", head)
+	f.indent++
+	f.stmt(s)
+	f.indent--
+}
+
+// 运算符的优先级,越大结合越紧
+func formatPrec(e Expr) int {
+	switch e := e.(type) {
+	case *AssignExpr:
+		return 0
+	case *BinaryExpr:
+		switch {
+		case e.IsCompare():
+			return 1
+		case e.Op == PLUS || e.Op == MINUS:
+			return 2
+		}
+		return 3
+	}
+	return 4
+}
+
+// 表达式,优先级低于 prec 的表达式加括号
+func (f *formatter) expr(e Expr, prec int) string {
+	var res string
+	switch e := e.(type) {
+	case *ConstExpr:
+		res = strconv.FormatInt(e.Value, 10)
+	case *VarExpr:
+		res = e.Name
+		if e.Index != nil {
+			res += "[" + f.expr(e.Index, 0) + "]"
+		}
+	case *AssignExpr:
+		res = f.expr(e.Target, 0) + " = " + f.expr(e.Value, 0)
+	case *CallExpr:
+		var args []string
+		for _, a := range e.Args {
+			args = append(args, f.expr(a, 0))
+		}
+		res = e.Name + "(" + strings.Join(args, ", ") + ")"
+	case *BinaryExpr:
+		p := formatPrec(e)
+		// 加减乘除左结合,右操作数优先级相同时要加括号;比较运算不能连用,两边都要加括号
+		left, right := p, p+1
+		if e.IsCompare() {
+			left = p + 1
+		}
+		res = f.expr(e.X, left) + " " + tokenSymbol(e.Op) + " " + f.expr(e.Y, right)
+	}
+	if formatPrec(e) < prec {
+		return "(" + res + ")"
+	}
+	return res
+}
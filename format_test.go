@@ -0,0 +1,94 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: format_test.go
+// Package: scan
+// Description: 源程序格式化的测试
+// 				格式化只去掉多余的括号,结果重新分析后语法树不变,再次格式化不再改变,执行结果与原程序相同
+
+package scan
+
+import (
+	"testing"
+)
+
+func TestFormatParens(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"a - (a / b) * c", "a - a / b * c"},
+		{"a / (b * c)", "a / (b * c)"},
+		{"a / b * c", "a / b * c"},
+		{"(a - b) - c", "a - b - c"},
+		{"a - (b - c)", "a - (b - c)"},
+		{"a - (b + c)", "a - (b + c)"},
+		{"(a + b) * c", "(a + b) * c"},
+		{"(a < b) == (b < c)", "(a < b) == (b < c)"},
+		{"a = (b = c)", "a = b = c"},
+		{"x[(a)] + (f(a, (b)))", "x[a] + f(a, b)"},
+	}
+	for _, tt := range tests {
+		src := "void main(void) {\n    " + tt.src + ";\n}\n"
+		want := "void main(void)\n{\n    " + tt.want + ";\n}\n"
+		got, _, err := Format(src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.src, got, want)
+		}
+	}
+}
+
+func TestFormatSamples(t *testing.T) {
+	for _, s := range samples {
+		text, _, err := Format(s.src)
+		if err != nil {
+			t.Errorf("%s: %v", s.name, err)
+			continue
+		}
+		if again, _, err := Format(text); err != nil || again != text {
+			t.Errorf("%s: formatting again changed the program (%v):\n%s", s.name, err, again)
+		}
+		formatted := s
+		formatted.src = text
+		if got := interpSample(t, formatted); got != s.want {
+			t.Errorf("%s: formatted program printed %q, want %q", s.name, got, s.want)
+		}
+	}
+}
+
+func TestSameTree(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"a - b - c", "(a - b) - c", true},
+		{"a - b - c", "a - (b - c)", false},
+		{"a / b * c", "(a / b) * c", true},
+		{"a / b * c", "a / (b * c)", false},
+		{"a + b", "a\n+\nb", true},
+		{"f(a, b)", "f(a)", false},
+		{"x[1]", "x[2]", false},
+		{"a < b", "a <= b", false},
+	}
+	for _, tt := range tests {
+		a, _, err := ParseExpression(tt.a)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.a, err)
+		}
+		b, _, err := ParseExpression(tt.b)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.b, err)
+		}
+		if got := sameTree(a, b); got != tt.same {
+			t.Errorf("sameTree(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.same)
+		}
+	}
+	// 声明的类型和数组大小也要比较
+	a, _, _ := ParseProgram("int x[3];")
+	b, _, _ := ParseProgram("int x[4];")
+	if sameTree(a, b) {
+		t.Error("sameTree ignores array sizes")
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: fragment.go
+// Package: scan
+// Description: 本文件定义了分析程序片段的入口函数
+// 				Parser.Parse 总是从 declarationList 开始并要求以 EOF_TOKEN 结束,
+// 				这里的函数分别把字符串作为完整的程序、单个表达式、语句或声明分析,返回语法树节点和诊断信息,
+// 				片段之后还有多余的token时返回错误;语法错误不打印到标准输出
+
+package scan
+
+import "errors"
+
+// 分析完整的程序,与 Parse 相同但语法错误不打印到标准输出
+// 有语法错误时仍返回已经生成的语法树
+func ParseProgram(src string) (*ASTNode, []Diagnostic, error) {
+	return parseFragment(src, (*Parser).declarationList)
+}
+
+// 把字符串作为单个表达式分析,表达式之后不能有分号
+func ParseExpression(src string) (*ASTNode, []Diagnostic, error) {
+	return parseFragment(src, (*Parser).expression)
+}
+
+// 把字符串作为单条语句分析(表达式语句、复合语句、if、while、return)
+func ParseStatement(src string) (*ASTNode, []Diagnostic, error) {
+	return parseFragment(src, (*Parser).statement)
+}
+
+// 把字符串作为单个变量声明或函数声明分析
+func ParseDeclaration(src string) (*ASTNode, []Diagnostic, error) {
+	return parseFragment(src, func(parser *Parser) *ASTNode {
+		if parser.aheadToken != INT && parser.aheadToken != VOID {
+			parser.syntaxError() // declaration 不检查类型说明符
+			return nil
+		}
+		return parser.declaration()
+	})
+}
+
+// 分析程序片段,parse 为语法分析器中对应的递归下降函数
+func parseFragment(src string, parse func(*Parser) *ASTNode) (node *ASTNode, diags []Diagnostic, err error) {
+	parser := NewParser(NewStringBuffer(src))
+	parser.SetOutput(nil)
+	return parseWith(parser, parse)
+}
+
+// 用给定的语法分析器分析,语法错误只记录为诊断信息,分析过程中的 panic 转换为语法错误
+func parseWith(parser *Parser, parse func(*Parser) *ASTNode) (node *ASTNode, diags []Diagnostic, err error) {
+	parser.quiet = true
+	defer func() {
+		// 残缺的输入可能使递归下降函数访问空节点
+		if r := recover(); r != nil {
+			node = nil
+			diags = append(parser.diags, newError(parser.buffer.Lines(), DIAG_SYNTAX, MSG_SYNTAX_MALFORMED, parser.tokenText()))
+			err = errors.New(diags[len(diags)-1].String())
+		}
+	}()
+
+	parser.begin()
+	node = parse(parser)
+	if node == nil && parser.errCount == 0 { // 没有分析出任何内容,如空的表达式
+		parser.syntaxError()
+	}
+	if parser.aheadToken != EOF_TOKEN && parser.errCount == 0 {
+		parser.diags = append(parser.diags, newError(parser.buffer.Lines(), DIAG_TRAILING, MSG_SYNTAX_TRAILING, parser.tokenText()))
+	}
+	diags = parser.diags
+	if n := CountErrors(diags); n > 0 {
+		return node, diags, errors.New(Msg(MSG_SYNTAX_FAILED, diags[0].String(), n))
+	}
+	return node, diags, nil
+}
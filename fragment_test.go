@@ -0,0 +1,61 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: fragment_test.go
+// Package: scan
+// Description: 程序片段分析的测试
+// 				空的片段是语法错误,输入结尾处的错误报告在最后一行
+
+package scan
+
+import (
+	"testing"
+)
+
+func TestParseFragments(t *testing.T) {
+	parsers := map[string]func(string) (*ASTNode, []Diagnostic, error){
+		"expression":  ParseExpression,
+		"statement":   ParseStatement,
+		"declaration": ParseDeclaration,
+		"program":     ParseProgram,
+	}
+	tests := []struct {
+		kind, src string
+		line      int // 第一个错误所在的行,0表示没有错误
+	}{
+		{"expression", "x = 1", 0},
+		{"expression", "x", 0},
+		{"expression", "42", 0},
+		{"expression", "", 1},
+		{"expression", "  \n", 1},
+		{"expression", "x +", 1},
+		{"expression", "x = 1;", 1},
+		{"statement", "x = 1;", 0},
+		{"statement", "return", 1},
+		{"statement", "x = 1", 1},
+		{"statement", "x = 1\n", 1},
+		{"statement", "{\nx = 1;\n", 2},
+		{"statement", "", 1},
+		{"declaration", "int x;", 0},
+		{"declaration", "int x", 1},
+		{"declaration", "", 1},
+		{"program", "int x;\nint y", 2},
+		{"program", "int x;\nint y\n", 2},
+		{"program", "void main(void) {\n\toutput(1);\n", 2},
+	}
+	for _, tt := range tests {
+		_, diags, err := parsers[tt.kind](tt.src)
+		if tt.line == 0 {
+			if err != nil {
+				t.Errorf("%s %q: %v", tt.kind, tt.src, err)
+			}
+			continue
+		}
+		if err == nil || len(diags) == 0 {
+			t.Errorf("%s %q: no error", tt.kind, tt.src)
+			continue
+		}
+		if diags[0].Line != tt.line {
+			t.Errorf("%s %q: error on line %d, want %d: %v", tt.kind, tt.src, diags[0].Line, tt.line, err)
+		}
+	}
+}
@@ -13,25 +13,35 @@ import (
 // 将词法分析结果输入到文件中
 func HelpPrintFile(token Token, lexeme TokenString, line int, file *os.File) {
 	fmt.Fprintf(file, "[Line %d]:", line)
+	if token == EOF_TOKEN {
+		fmt.Fprint(file, " EOF ")
+	} else if category := tokenCategory(token); len(category) != 0 {
+		fmt.Fprintf(file, " %s ---->  ", category)
+	}
+	fmt.Fprintln(file, string(lexeme))
+}
+
+// token 的类别: ID、NUM、KEY、OP、SEP、COMMENT、ERROR 或 EOF
+func tokenCategory(token Token) string {
 	switch token {
 	case ID:
-		fmt.Fprint(file, " ID ---->  ")
+		return "ID"
 	case NUM:
-		fmt.Fprint(file, " NUM ---->  ")
+		return "NUM"
 	case IF, ELSE, WHILE, INT, VOID, RETURN:
-		fmt.Fprint(file, " KEY ---->  ")
+		return "KEY"
 	case PLUS, MINUS, MUL, DIV, LT, GT, LE, GE, EQ, ASSIGN, NOT_EQ:
-		fmt.Fprint(file, " OP ---->  ")
+		return "OP"
 	case SEMI, COMMA, L_PARE_L, L_PARE_M, L_PARE_S, R_PARE_L, R_PARE_M, R_PARE_S:
-		fmt.Fprint(file, " SEP ---->  ")
+		return "SEP"
 	case COMMENT:
-		fmt.Fprint(file, " COMMENT ---->  ")
+		return "COMMENT"
 	case ERROR:
-		fmt.Fprint(file, " ERROR ---->  ")
+		return "ERROR"
 	case EOF_TOKEN:
-		fmt.Fprint(file, " EOF ")
+		return "EOF"
 	}
-	fmt.Fprintln(file, string(lexeme))
+	return ""
 }
 
 // 打印抽象语法树
@@ -16,13 +16,6 @@ var (
 	ParserConst  *Parser  // 语法分析器
 )
 
-// 符号表
-// 扫描阶段即可开始设置其中的一些值
-// 符号表移动策略，遇到{移到下一层，遇到}移到上一层
-// match函数处进行符号表的基本操作
-var curTable, nextTable, prevTable *SymbolTableNode
-var hasSiblings bool = false // 判断是否有同层次的并列的scope
-
 // 词法分析needed
 type Token int          // 扫描的token类型
 type TokenString []byte // 扫描的词素类型
@@ -0,0 +1,551 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: interp.go
+// Package: scan
+// Description: 本文件定义了直接在抽象语法树上执行 C-Minus 程序的解释器
+// 				int 按32位有符号整数处理(与C和WebAssembly后端一致),默认溢出时回绕,可选择溢出时报错
+// 				数组下标越界、除数为0、递归过深等都作为运行时错误返回,不会引起Go的panic
+// 				运行时错误带有 C-Minus 的调用栈,每一帧记录函数名和正在执行的行号
+
+package scan
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// 默认的最大递归深度
+const INTERP_DEFAULT_MAX_DEPTH = 10000
+
+// 最大递归深度的上限,解释器在 Go 的栈上递归执行,更深的递归会耗尽 Go 的栈
+const INTERP_MAX_DEPTH_LIMIT = 100000
+
+// 默认的数组单元总数上限(每个单元8字节,共128MB),单个数组的大小超过上限时报告运行时错误
+const INTERP_DEFAULT_MAX_CELLS = 1 << 24
+
+// 语句和表达式执行的最大嵌套层数,保证函数体嵌套很深时也不会耗尽 Go 的栈
+const INTERP_MAX_NESTING = 1000000
+
+// 交互式执行时顶层语句所在帧的函数名
+const INTERP_TOP_LEVEL = "<top>"
+
+// 运行时错误种类
+type RuntimeErrorKind int
+
+const (
+	RUNTIME_INDEX_OUT_OF_RANGE RuntimeErrorKind = iota // 数组下标越界
+	RUNTIME_DIV_BY_ZERO                                // 除数为0
+	RUNTIME_OVERFLOW                                   // 整数溢出
+	RUNTIME_STACK_OVERFLOW                             // 递归过深
+	RUNTIME_INPUT                                      // 读取输入失败
+	RUNTIME_BAD_PROGRAM                                // 程序本身有误,如未声明的标识符
+	RUNTIME_ARRAY_TOO_LARGE                            // 数组大小超过数组单元总数上限
+)
+
+// 调用栈的一帧
+type TraceFrame struct {
+	Func string // 函数名
+	Line int    // 正在执行的行号
+}
+
+// 运行时错误
+type RuntimeError struct {
+	Kind    RuntimeErrorKind
+	Message string
+	Line    int          // 出错的行号
+	Trace   []TraceFrame // 调用栈,最内层在前
+}
+
+func (e *RuntimeError) Error() string {
+	return Msg(MSG_RUNTIME_ERROR, e.Line, e.Message)
+}
+
+// 打印运行时错误及调用栈
+func HelpPrintRuntimeError(err *RuntimeError, file *os.File) {
+	fmt.Fprintln(file, err.Error())
+	helpPrintTrace(err.Trace, file)
+}
+
+// 打印调用栈,递归产生的相同帧只打印一次
+func helpPrintTrace(trace []TraceFrame, file *os.File) {
+	for i := 0; i < len(trace); i++ {
+		f := trace[i]
+		fmt.Fprintln(file, Msg(MSG_RUNTIME_AT, f.Func, f.Line))
+		n := 0
+		for i+1 < len(trace) && trace[i+1] == f {
+			i++
+			n++
+		}
+		if n > 0 {
+			fmt.Fprintln(file, Msg(MSG_RUNTIME_REPEATED, n))
+		}
+	}
+}
+
+// 解释器选项
+type InterpOptions struct {
+	CheckOverflow bool  // 整数溢出时报错,否则按32位回绕
+	MaxDepth      int   // 最大递归深度,0表示使用默认值,超过 INTERP_MAX_DEPTH_LIMIT 时按上限处理
+	MaxSteps      int64 // 最大执行步数(每条语句和每个表达式算一步),0表示不限制
+	MaxCells      int64 // 同时存在的数组单元总数上限,0表示使用默认值
+	MaxOutput     int64 // 输出字节数上限,0表示不限制
+}
+
+// 变量,数组形参与对应实参共享同一个切片
+type interpVar struct {
+	val     int64
+	arr     []int64
+	isArray bool
+}
+
+// 作用域
+type interpScope struct {
+	vars map[string]*interpVar
+	prev *interpScope
+}
+
+// 由内向外查找变量
+func (scope *interpScope) lookup(name string) *interpVar {
+	for s := scope; s != nil; s = s.prev {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// 函数调用的一帧
+type interpFrame struct {
+	fn    *ASTNode
+	line  int // 正在执行的行号
+	scope *interpScope
+}
+
+// 语法树解释器
+type Interpreter struct {
+	opts    InterpOptions
+	in      *bufio.Reader
+	out     io.Writer
+	globals *interpScope
+	funcs   map[string]*ASTNode
+	frames  []*interpFrame
+	nesting int // 当前 exec 和 eval 的嵌套层数
+
+	hook func(node *ASTNode) error // 每条语句(复合语句除外)执行前调用,供调试器使用
+
+	// 资源使用情况
+	ctx      context.Context
+	steps    int64
+	cells    int64
+	outBytes int64
+}
+
+// 解释器工厂函数,加载程序中的全局变量和函数
+func NewInterpreter(root *ASTNode, in io.Reader, out io.Writer, opts InterpOptions) (*Interpreter, error) {
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = INTERP_DEFAULT_MAX_DEPTH
+	} else if opts.MaxDepth > INTERP_MAX_DEPTH_LIMIT {
+		opts.MaxDepth = INTERP_MAX_DEPTH_LIMIT
+	}
+	if opts.MaxCells <= 0 {
+		opts.MaxCells = INTERP_DEFAULT_MAX_CELLS
+	}
+	it := &Interpreter{
+		opts:    opts,
+		in:      bufio.NewReader(in),
+		out:     out,
+		globals: &interpScope{vars: make(map[string]*interpVar)},
+		funcs:   make(map[string]*ASTNode),
+	}
+	if err := it.Load(root); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// 加载声明序列,全局变量初始化为0,同名函数以后加载的为准
+func (it *Interpreter) Load(root *ASTNode) error {
+	for node := root; node != nil; node = node.sibling {
+		switch {
+		case isStmt(node, VAR_DECLARATION):
+			info := declInfo(node, SYM_GLOBAL)
+			if _, ok := it.globals.vars[info.name]; ok {
+				return errors.New(Msg(MSG_RUNTIME_REDECLARED, node.line, info.name))
+			}
+			v, err := it.newVar(node, info)
+			if err != nil {
+				return err
+			}
+			it.globals.vars[info.name] = v
+		case isStmt(node, FUNC_DECLARATION):
+			it.funcs[nodeName(node)] = node
+		}
+	}
+	return nil
+}
+
+// 根据声明新建变量,数组占用的单元计入内存限制
+// 单个数组超过上限时无论如何都无法分配,报告运行时错误而不是资源限制错误
+func (it *Interpreter) newVar(node *ASTNode, info *symInfo) (*interpVar, error) {
+	if info.isArray {
+		if info.size > it.opts.MaxCells {
+			return nil, it.newError(node.line, RUNTIME_ARRAY_TOO_LARGE, MSG_RUNTIME_ARRAY_SIZE, nodeName(node), info.size, it.opts.MaxCells)
+		}
+		if err := it.allocCells(node.line, info.size); err != nil {
+			return nil, err
+		}
+		return &interpVar{isArray: true, arr: make([]int64, info.size)}, nil
+	}
+	return &interpVar{}, nil
+}
+
+// 执行 main 函数,不限制运行时间时使用
+func (it *Interpreter) Run() error {
+	if _, ok := it.funcs["main"]; !ok {
+		return errors.New(Msg(MSG_RUNTIME_NO_MAIN))
+	}
+	_, err := it.Call("main")
+	return err
+}
+
+// 以整数实参调用函数,返回函数的返回值
+func (it *Interpreter) Call(name string, args ...int64) (val int64, err error) {
+	fn, ok := it.funcs[name]
+	if !ok {
+		return 0, errors.New(Msg(MSG_RUNTIME_UNDEFINED, name))
+	}
+	defer it.recoverPanic(&err)
+	vars := make([]*interpVar, len(args))
+	for i, a := range args {
+		vars[i] = &interpVar{val: a}
+	}
+	return it.invoke(fn, vars, fn)
+}
+
+// 在全局作用域中执行一条语句或表达式,用于交互式执行
+// 表达式返回其值且 isValue 为真,语句中的 return 只结束这条语句
+func (it *Interpreter) Exec(node *ASTNode) (val int64, isValue bool, err error) {
+	defer it.recoverPanic(&err)
+	top := NewASTNode(STATEMENT, FUNC_DECLARATION, node.line)
+	top.SetAttr(TokenString(INTERP_TOP_LEVEL))
+	it.frames = append(it.frames, &interpFrame{fn: top, line: node.line, scope: it.globals})
+	if node.nodeK == EXPRESSION {
+		val, err = it.eval(node)
+		return val, err == nil, err
+	}
+	_, _, err = it.exec(node)
+	return 0, false, err
+}
+
+// 把执行过程中意外的panic(如语法错误导致的残缺语法树)转换为运行时错误,并清空调用栈
+func (it *Interpreter) recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		line := 0
+		if n := len(it.frames); n > 0 {
+			line = it.frames[n-1].line
+		}
+		*err = it.newError(line, RUNTIME_BAD_PROGRAM, MSG_RUNTIME_PANIC, r)
+	}
+	it.frames = it.frames[:0]
+}
+
+// 生成运行时错误,记录当前的调用栈,id 为消息编号
+func (it *Interpreter) newError(line int, kind RuntimeErrorKind, id string, args ...interface{}) *RuntimeError {
+	return &RuntimeError{Kind: kind, Message: Msg(id, args...), Line: line, Trace: it.trace()}
+}
+
+// 当前的调用栈,最内层在前
+func (it *Interpreter) trace() []TraceFrame {
+	var res []TraceFrame
+	for i := len(it.frames) - 1; i >= 0; i-- {
+		f := it.frames[i]
+		res = append(res, TraceFrame{Func: nodeName(f.fn), Line: f.line})
+	}
+	return res
+}
+
+// 当前帧
+func (it *Interpreter) frame() *interpFrame {
+	return it.frames[len(it.frames)-1]
+}
+
+// 当前作用域,没有函数调用时为全局作用域
+func (it *Interpreter) scope() *interpScope {
+	if len(it.frames) == 0 {
+		return it.globals
+	}
+	return it.frame().scope
+}
+
+// 调用函数,call 为调用处的节点(用于报告错误)
+func (it *Interpreter) invoke(fn *ASTNode, args []*interpVar, call *ASTNode) (int64, error) {
+	if len(it.frames) >= it.opts.MaxDepth {
+		return 0, it.newError(call.line, RUNTIME_STACK_OVERFLOW, MSG_RUNTIME_DEPTH, it.opts.MaxDepth, nodeName(fn))
+	}
+	scope := &interpScope{vars: make(map[string]*interpVar), prev: it.globals}
+	var params []*ASTNode
+	for _, p := range funcParams(fn) {
+		if nodeName(p) != "" {
+			params = append(params, p)
+		}
+	}
+	if len(params) != len(args) {
+		return 0, it.newError(call.line, RUNTIME_BAD_PROGRAM, MSG_RUNTIME_ARG_COUNT, nodeName(fn), len(params), len(args))
+	}
+	for i, p := range params {
+		info := declInfo(p, SYM_PARAM)
+		if info.isArray != args[i].isArray {
+			return 0, it.newError(call.line, RUNTIME_BAD_PROGRAM, MSG_RUNTIME_ARG_TYPE, i+1, nodeName(fn))
+		}
+		scope.vars[info.name] = args[i]
+	}
+
+	it.frames = append(it.frames, &interpFrame{fn: fn, line: fn.line, scope: scope})
+	_, val, err := it.exec(fn.right)
+	it.frames = it.frames[:len(it.frames)-1]
+	return val, err
+}
+
+// 进入一层语句或表达式的执行,嵌套过深时报错
+func (it *Interpreter) enter(node *ASTNode) error {
+	if it.nesting >= INTERP_MAX_NESTING {
+		return it.newError(node.line, RUNTIME_STACK_OVERFLOW, MSG_RUNTIME_NESTING, INTERP_MAX_NESTING)
+	}
+	it.nesting++
+	return nil
+}
+
+// 离开一层语句或表达式的执行
+func (it *Interpreter) leave() {
+	it.nesting--
+}
+
+// 执行语句,返回是否执行了 return 及其返回值
+func (it *Interpreter) exec(node *ASTNode) (bool, int64, error) {
+	if node == nil {
+		return false, 0, nil
+	}
+	if len(it.frames) > 0 {
+		it.frame().line = node.line
+	}
+	if err := it.step(node); err != nil {
+		return false, 0, err
+	}
+	if err := it.enter(node); err != nil {
+		return false, 0, err
+	}
+	defer it.leave()
+	if it.hook != nil && !isStmt(node, COMPOUND) {
+		if err := it.hook(node); err != nil {
+			return false, 0, err
+		}
+	}
+	switch {
+	case isStmt(node, COMPOUND):
+		f := it.frame()
+		saved, cells := f.scope, it.cells
+		f.scope = &interpScope{vars: make(map[string]*interpVar), prev: saved}
+		defer func() { f.scope, it.cells = saved, cells }() // 离开作用域时释放局部数组
+		for d := node.left; d != nil; d = d.sibling {
+			v, err := it.newVar(d, declInfo(d, SYM_LOCAL))
+			if err != nil {
+				return false, 0, err
+			}
+			f.scope.vars[nodeName(d)] = v
+		}
+		for s := node.right; s != nil; s = s.sibling {
+			if ret, val, err := it.exec(s); ret || err != nil {
+				return ret, val, err
+			}
+		}
+		return false, 0, nil
+	case isStmt(node, SELECTION_STMT):
+		cond, err := it.eval(node.left)
+		if err != nil {
+			return false, 0, err
+		}
+		if cond != 0 {
+			return it.exec(node.mid)
+		}
+		return it.exec(node.right)
+	case isStmt(node, ITERATION_STMT):
+		for {
+			cond, err := it.eval(node.left)
+			if err != nil || cond == 0 {
+				return false, 0, err
+			}
+			if ret, val, err := it.exec(node.mid); ret || err != nil {
+				return ret, val, err
+			}
+			it.frame().line = node.line
+		}
+	case isStmt(node, RETURN_STMT):
+		if node.left == nil {
+			return true, 0, nil
+		}
+		val, err := it.eval(node.left)
+		return err == nil, val, err
+	}
+	_, err := it.eval(node)
+	return false, 0, err
+}
+
+// 对表达式求值
+func (it *Interpreter) eval(node *ASTNode) (int64, error) {
+	if node != nil {
+		if err := it.step(node); err != nil {
+			return 0, err
+		}
+		if err := it.enter(node); err != nil {
+			return 0, err
+		}
+		defer it.leave()
+	}
+	switch {
+	case isExp(node, CONST):
+		return it.wrap(node, nodeValue(node))
+	case isExp(node, VAR):
+		v, idx, err := it.lvalue(node)
+		if err != nil {
+			return 0, err
+		}
+		if idx >= 0 {
+			return v.arr[idx], nil
+		}
+		if v.isArray {
+			return 0, it.newError(node.line, RUNTIME_BAD_PROGRAM, MSG_RUNTIME_ARRAY_VALUE, nodeName(node))
+		}
+		return v.val, nil
+	case isExp(node, ASSIGNMENT):
+		val, err := it.eval(node.right)
+		if err != nil {
+			return 0, err
+		}
+		v, idx, err := it.lvalue(node.left)
+		if err != nil {
+			return 0, err
+		}
+		if idx >= 0 {
+			v.arr[idx] = val
+		} else if v.isArray {
+			return 0, it.newError(node.line, RUNTIME_BAD_PROGRAM, MSG_RUNTIME_ARRAY_ASSIGN, nodeName(node.left))
+		} else {
+			v.val = val
+		}
+		return val, nil
+	case isExp(node, CALL):
+		return it.call(node)
+	case isExp(node, OPERATION), isExp(node, COMPARE):
+		l, err := it.eval(node.left)
+		if err != nil {
+			return 0, err
+		}
+		r, err := it.eval(node.right)
+		if err != nil {
+			return 0, err
+		}
+		return it.binary(node, l, r)
+	}
+	line := 0
+	if node != nil {
+		line = node.line
+	}
+	return 0, it.newError(line, RUNTIME_BAD_PROGRAM, MSG_RUNTIME_MALFORMED)
+}
+
+// 求变量节点对应的变量和下标,没有下标时下标为-1
+func (it *Interpreter) lvalue(node *ASTNode) (*interpVar, int64, error) {
+	v := it.scope().lookup(nodeName(node))
+	if v == nil {
+		return nil, -1, it.newError(node.line, RUNTIME_BAD_PROGRAM, MSG_RUNTIME_UNDECLARED, nodeName(node))
+	}
+	if node.left == nil {
+		return v, -1, nil
+	}
+	if !v.isArray {
+		return nil, -1, it.newError(node.line, RUNTIME_BAD_PROGRAM, MSG_RUNTIME_NOT_ARRAY, nodeName(node))
+	}
+	idx, err := it.eval(node.left)
+	if err != nil {
+		return nil, -1, err
+	}
+	if idx < 0 || idx >= int64(len(v.arr)) {
+		return nil, -1, it.newError(node.line, RUNTIME_INDEX_OUT_OF_RANGE, MSG_RUNTIME_INDEX, idx, nodeName(node), len(v.arr))
+	}
+	return v, idx, nil
+}
+
+// 计算二元运算
+func (it *Interpreter) binary(node *ASTNode, l, r int64) (int64, error) {
+	op := nodeOp(node)
+	if op == DIV && r == 0 {
+		return 0, it.newError(node.line, RUNTIME_DIV_BY_ZERO, MSG_RUNTIME_DIV_BY_ZERO)
+	}
+	val, ok := evalBinary(op, l, r)
+	if !ok {
+		return 0, it.newError(node.line, RUNTIME_BAD_PROGRAM, MSG_RUNTIME_OPERATOR)
+	}
+	return it.wrap(node, val)
+}
+
+// 把运算结果截断为32位,溢出检查模式下超出范围时报错
+func (it *Interpreter) wrap(node *ASTNode, val int64) (int64, error) {
+	if val >= math.MinInt32 && val <= math.MaxInt32 {
+		return val, nil
+	}
+	if it.opts.CheckOverflow {
+		return 0, it.newError(node.line, RUNTIME_OVERFLOW, MSG_RUNTIME_OVERFLOW, val)
+	}
+	return int64(int32(val)), nil
+}
+
+// 函数调用,包括内置的 input 和 output
+func (it *Interpreter) call(node *ASTNode) (int64, error) {
+	name := nodeName(node)
+	var args []*interpVar
+	for _, a := range callArgs(node) {
+		if isExp(a, VAR) && a.left == nil {
+			if v := it.scope().lookup(nodeName(a)); v != nil && v.isArray {
+				args = append(args, v) // 数组按引用传递
+				continue
+			}
+		}
+		val, err := it.eval(a)
+		if err != nil {
+			return 0, err
+		}
+		args = append(args, &interpVar{val: val})
+	}
+
+	fn, ok := it.funcs[name]
+	if !ok {
+		return it.builtin(node, args)
+	}
+	return it.invoke(fn, args, node)
+}
+
+// 内置函数
+func (it *Interpreter) builtin(node *ASTNode, args []*interpVar) (int64, error) {
+	switch nodeName(node) {
+	case BUILTIN_INPUT:
+		var val int64
+		if _, err := fmt.Fscan(it.in, &val); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return 0, it.newError(node.line, RUNTIME_INPUT, MSG_RUNTIME_INPUT_EOF)
+			}
+			return 0, it.newError(node.line, RUNTIME_INPUT, MSG_RUNTIME_INPUT_INT)
+		}
+		return it.wrap(node, val)
+	case BUILTIN_OUTPUT:
+		if len(args) != 1 || args[0].isArray {
+			return 0, it.newError(node.line, RUNTIME_BAD_PROGRAM, MSG_RUNTIME_OUTPUT_ARG)
+		}
+		return 0, it.write(node.line, fmt.Sprintf("%d\n", args[0].val))
+	}
+	return 0, it.newError(node.line, RUNTIME_BAD_PROGRAM, MSG_RUNTIME_UNDEFINED, nodeName(node))
+}
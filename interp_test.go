@@ -0,0 +1,100 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: interp_test.go
+// Package: scan
+// Description: 解释器的测试
+// 				递归过深或嵌套过深时必须报告运行时错误,而不是耗尽 Go 的栈;
+// 				数组过大时必须报告运行时错误,而不是耗尽内存
+
+package scan
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// 执行程序,返回运行时错误
+func runInterp(t *testing.T, src, input string, opts InterpOptions) error {
+	t.Helper()
+	root, _, err := ParseProgram(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := NewInterpreter(root, strings.NewReader(input), ioutil.Discard, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return it.Run()
+}
+
+func TestInterpMaxDepth(t *testing.T) {
+	src := `int f(int n) { return f(n + 1); } void main(void) { f(0); }`
+	tests := []struct {
+		maxDepth, want int
+	}{
+		{0, INTERP_DEFAULT_MAX_DEPTH},
+		{50, 50},
+		{1000000, INTERP_MAX_DEPTH_LIMIT},
+	}
+	for _, tt := range tests {
+		err := runInterp(t, src, "", InterpOptions{MaxDepth: tt.maxDepth})
+		rtErr, ok := err.(*RuntimeError)
+		if !ok || rtErr.Kind != RUNTIME_STACK_OVERFLOW {
+			t.Errorf("max depth %d: got %v, want stack overflow", tt.maxDepth, err)
+			continue
+		}
+		if n := len(rtErr.Trace); n != tt.want { // 包括 main 所在的帧
+			t.Errorf("max depth %d: %d frames, want %d", tt.maxDepth, n, tt.want)
+		}
+	}
+}
+
+func TestInterpMaxCells(t *testing.T) {
+	tests := []struct {
+		src      string
+		maxCells int64
+		want     RuntimeErrorKind
+	}{
+		{`void main(void) { int a[2000000000]; a[0] = 1; }`, 0, RUNTIME_ARRAY_TOO_LARGE},
+		{`void main(void) { int a[100]; a[0] = 1; }`, 99, RUNTIME_ARRAY_TOO_LARGE},
+	}
+	for _, tt := range tests {
+		err := runInterp(t, tt.src, "", InterpOptions{MaxCells: tt.maxCells})
+		if rtErr, ok := err.(*RuntimeError); !ok || rtErr.Kind != tt.want || rtErr.Line != 1 {
+			t.Errorf("%s: got %v, want array too large", tt.src, err)
+		}
+	}
+
+	// 全局数组在加载时分配
+	root, _, err := ParseProgram(`int g[2000000000]; void main(void) { g[0] = 1; }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewInterpreter(root, strings.NewReader(""), ioutil.Discard, InterpOptions{}); err == nil {
+		t.Error("global array: want an error")
+	} else if rtErr, ok := err.(*RuntimeError); !ok || rtErr.Kind != RUNTIME_ARRAY_TOO_LARGE {
+		t.Errorf("global array: got %v, want array too large", err)
+	}
+
+	// 默认上限以内的数组可以正常使用
+	if err := runInterp(t, `void main(void) { int a[1000000]; a[999999] = 1; output(a[999999]); }`, "", InterpOptions{}); err != nil {
+		t.Errorf("array within the default limit: %v", err)
+	}
+}
+
+func TestInterpNesting(t *testing.T) {
+	// 每层递归嵌套很多层语句和表达式,在达到最大递归深度之前先达到嵌套上限
+	src := `int f(int n) {
+	if (n == 0) return 0;
+	{ if (1) { while (1) { if (1) { { if (1) { return ((f(n - 1) + 1) * 1 + 0) * 1; } } } } } }
+}
+void main(void) { output(f(input())); }`
+	err := runInterp(t, src, "100000", InterpOptions{MaxDepth: INTERP_MAX_DEPTH_LIMIT})
+	if rtErr, ok := err.(*RuntimeError); !ok || rtErr.Kind != RUNTIME_STACK_OVERFLOW {
+		t.Errorf("got %v, want stack overflow", err)
+	}
+	if err := runInterp(t, src, "1000", InterpOptions{}); err != nil {
+		t.Errorf("depth 1000: %v", err)
+	}
+}
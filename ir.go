@@ -0,0 +1,287 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: ir.go
+// Package: scan
+// Description: 本文件定义了三地址中间代码(IR)以及基本块、函数、程序等类型和打印函数
+// 				标量局部变量和形参直接作为IR变量,全局变量和数组通过LOAD/STORE访问内存
+// 				全局变量名以@开头,临时变量名以%开头,同名的局部变量加.n后缀区分
+
+package scan
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// IR 指令操作码
+type IROp int
+
+const (
+	IR_COPY   IROp = iota // Dst = Args[0]
+	IR_BINARY             // Dst = Args[0] OpTok Args[1]
+	IR_LOAD               // Dst = Sym[Args[0]], 全局标量没有下标
+	IR_STORE              // Sym[Args[0]] = Args[1], 全局标量没有下标
+	IR_ADDR               // Dst = &Sym, 数组首地址,用于传递数组实参
+	IR_CALL               // Dst = Sym(Args...), 无返回值时Dst为空
+	IR_PHI                // Dst = phi(Args...), Args与所在块的Preds一一对应(SSA)
+	IR_JUMP               // goto Targets[0]
+	IR_BRANCH             // if Args[0] != 0 goto Targets[0] else goto Targets[1]
+	IR_RETURN             // return Args[0], 可选
+)
+
+// 操作数类型
+type OperandKind int
+
+const (
+	OPD_NONE  OperandKind = iota // 空操作数
+	OPD_CONST                    // 常量
+	OPD_VAR                      // 变量(局部变量、形参或临时变量)
+)
+
+// 操作数
+type Operand struct {
+	Kind  OperandKind
+	Name  string // 变量名
+	Value int64  // 常量值
+}
+
+// 常量操作数
+func ConstOperand(v int64) Operand {
+	return Operand{Kind: OPD_CONST, Value: v}
+}
+
+// 变量操作数
+func VarOperand(name string) Operand {
+	return Operand{Kind: OPD_VAR, Name: name}
+}
+
+// 操作数的文本形式
+func (o Operand) String() string {
+	switch o.Kind {
+	case OPD_CONST:
+		return strconv.FormatInt(o.Value, 10)
+	case OPD_VAR:
+		return o.Name
+	}
+	return "_"
+}
+
+// IR 指令
+type IRInstr struct {
+	Op      IROp
+	Dst     string        // 目的变量
+	Args    []Operand     // 操作数
+	Sym     string        // 内存变量名(LOAD/STORE/ADDR)或函数名(CALL)
+	OpTok   Token         // 运算符(BINARY)
+	Targets []*BasicBlock // 跳转目标(JUMP/BRANCH)
+	Line    int           // 对应的源程序行号
+}
+
+// 指令定义的变量,没有时返回空串
+func (ins *IRInstr) Def() string {
+	return ins.Dst
+}
+
+// 指令使用的变量
+func (ins *IRInstr) Uses() []string {
+	var res []string
+	for _, a := range ins.Args {
+		if a.Kind == OPD_VAR {
+			res = append(res, a.Name)
+		}
+	}
+	return res
+}
+
+// 判断是否为基本块结束指令
+func (ins *IRInstr) IsTerminator() bool {
+	return ins.Op == IR_JUMP || ins.Op == IR_BRANCH || ins.Op == IR_RETURN
+}
+
+// 判断指令是否有除定义Dst以外的作用,有则不能删除或移动
+func (ins *IRInstr) HasSideEffects() bool {
+	switch ins.Op {
+	case IR_STORE, IR_CALL, IR_JUMP, IR_BRANCH, IR_RETURN:
+		return true
+	}
+	return false
+}
+
+// 指令的文本形式
+func (ins *IRInstr) String() string {
+	var args []string
+	for _, a := range ins.Args {
+		args = append(args, a.String())
+	}
+	index := func(i int) string { // 内存访问的下标部分
+		if ins.Args[i].Kind == OPD_NONE {
+			return ins.Sym
+		}
+		return fmt.Sprintf("%s[%s]", ins.Sym, ins.Args[i].String())
+	}
+
+	switch ins.Op {
+	case IR_COPY:
+		return fmt.Sprintf("%s = %s", ins.Dst, args[0])
+	case IR_BINARY:
+		return fmt.Sprintf("%s = %s %s %s", ins.Dst, args[0], tokenSymbol(ins.OpTok), args[1])
+	case IR_LOAD:
+		return fmt.Sprintf("%s = load %s", ins.Dst, index(0))
+	case IR_STORE:
+		return fmt.Sprintf("store %s, %s", index(0), args[1])
+	case IR_ADDR:
+		return fmt.Sprintf("%s = addr %s", ins.Dst, ins.Sym)
+	case IR_CALL:
+		call := fmt.Sprintf("call %s(%s)", ins.Sym, strings.Join(args, ", "))
+		if ins.Dst != "" {
+			return ins.Dst + " = " + call
+		}
+		return call
+	case IR_PHI:
+		return fmt.Sprintf("%s = phi(%s)", ins.Dst, strings.Join(args, ", "))
+	case IR_JUMP:
+		return "jump " + ins.Targets[0].Label()
+	case IR_BRANCH:
+		return fmt.Sprintf("branch %s, %s, %s", args[0], ins.Targets[0].Label(), ins.Targets[1].Label())
+	case IR_RETURN:
+		if len(args) > 0 {
+			return "return " + args[0]
+		}
+		return "return"
+	}
+	return "?"
+}
+
+// 运算符的文本形式
+func tokenSymbol(t Token) string {
+	switch t {
+	case PLUS:
+		return "+"
+	case MINUS:
+		return "-"
+	case MUL:
+		return "*"
+	case DIV:
+		return "/"
+	case LT:
+		return "<"
+	case LE:
+		return "<="
+	case GT:
+		return ">"
+	case GE:
+		return ">="
+	case EQ:
+		return "=="
+	case NOT_EQ:
+		return "!="
+	}
+	return "?"
+}
+
+// 基本块
+type BasicBlock struct {
+	ID     int           // 块编号
+	Instrs []*IRInstr    // 指令序列,最后一条为结束指令
+	Preds  []*BasicBlock // 前驱
+	Succs  []*BasicBlock // 后继
+	IDom   *BasicBlock   // 直接支配节点
+}
+
+// 块标号
+func (b *BasicBlock) Label() string {
+	return fmt.Sprintf("B%d", b.ID)
+}
+
+// 块的结束指令
+func (b *BasicBlock) Terminator() *IRInstr {
+	if len(b.Instrs) == 0 {
+		return nil
+	}
+	if last := b.Instrs[len(b.Instrs)-1]; last.IsTerminator() {
+		return last
+	}
+	return nil
+}
+
+// 函数中的内存变量(数组和数组形参)
+type IRArray struct {
+	Name    string // IR 中的名字
+	Size    int64  // 数组大小,数组形参为0
+	IsParam bool   // 是否为数组形参
+}
+
+// IR 函数
+type IRFunc struct {
+	Name       string
+	Params     []string            // 形参对应的IR变量名,数组形参也在其中
+	ReturnsInt bool                // 是否有返回值
+	Arrays     map[string]*IRArray // 局部数组和数组形参
+	Blocks     []*BasicBlock       // 基本块,第一个为入口块
+	nextBlock  int                 // 块编号计数器
+	nextTemp   int                 // 临时变量计数器
+}
+
+// 新建一个基本块并加入函数
+func (fn *IRFunc) NewBlock() *BasicBlock {
+	b := &BasicBlock{ID: fn.nextBlock}
+	fn.nextBlock++
+	fn.Blocks = append(fn.Blocks, b)
+	return b
+}
+
+// 新建一个临时变量
+func (fn *IRFunc) NewTemp() string {
+	fn.nextTemp++
+	return fmt.Sprintf("%%t%d", fn.nextTemp)
+}
+
+// 入口块
+func (fn *IRFunc) Entry() *BasicBlock {
+	return fn.Blocks[0]
+}
+
+// IR 程序
+type IRProgram struct {
+	Globals []*IRArray // 全局变量,标量的Size为0
+	Funcs   []*IRFunc
+}
+
+// 打印IR程序
+func HelpPrintIR(prog *IRProgram, file *os.File) {
+	for _, g := range prog.Globals {
+		if g.Size > 0 {
+			fmt.Fprintf(file, "global %s[%d]\n", g.Name, g.Size)
+		} else {
+			fmt.Fprintf(file, "global %s\n", g.Name)
+		}
+	}
+	for _, fn := range prog.Funcs {
+		HelpPrintIRFunc(fn, file)
+	}
+}
+
+// 打印IR函数
+func HelpPrintIRFunc(fn *IRFunc, file *os.File) {
+	ret := "void"
+	if fn.ReturnsInt {
+		ret = "int"
+	}
+	fmt.Fprintf(file, "\nfunc %s %s(%s)\n", ret, fn.Name, strings.Join(fn.Params, ", "))
+	for _, b := range fn.Blocks {
+		var preds []string
+		for _, p := range b.Preds {
+			preds = append(preds, p.Label())
+		}
+		if len(preds) > 0 {
+			fmt.Fprintf(file, "%s:                ; preds: %s\n", b.Label(), strings.Join(preds, ", "))
+		} else {
+			fmt.Fprintf(file, "%s:\n", b.Label())
+		}
+		for _, ins := range b.Instrs {
+			fmt.Fprintf(file, "    %s\n", ins.String())
+		}
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: ir_test.go
+// Package: scan
+// Description: 中间代码生成的测试
+// 				包括一个直接执行IR(含phi)的参考解释器,示例程序翻译为IR后执行结果必须与期望输出相同,
+// 				优化和SSA的测试也使用它;以及选择语句翻译结果的黄金测试
+
+package scan
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// IR 函数的文本形式,不含前驱注释,用于黄金测试
+func irText(fn *IRFunc) string {
+	var sb strings.Builder
+	for _, b := range fn.Blocks {
+		sb.WriteString(b.Label() + ":\n")
+		for _, ins := range b.Instrs {
+			sb.WriteString("    " + ins.String() + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// 分析示例程序并翻译为IR
+func irSample(t *testing.T, s sample) *IRProgram {
+	t.Helper()
+	prog, err := GenerateIR(parseSample(t, s))
+	if err != nil {
+		t.Fatalf("%s: %v", s.name, err)
+	}
+	return prog
+}
+
+// 查找IR函数
+func irFunc(prog *IRProgram, name string) *IRFunc {
+	for _, fn := range prog.Funcs {
+		if fn.Name == name {
+			return fn
+		}
+	}
+	return nil
+}
+
+// IR 参考解释器,数组以句柄(mem 的下标)表示
+type irMachine struct {
+	prog    *IRProgram
+	mem     [][]int64
+	globals map[string]int64 // 全局变量名到句柄
+	in      *strings.Reader
+	out     strings.Builder
+	steps   int
+	depth   int
+}
+
+// 执行IR程序的 main,返回输出
+func runIR(prog *IRProgram, input string) (string, error) {
+	m := &irMachine{prog: prog, globals: make(map[string]int64), in: strings.NewReader(input)}
+	for _, g := range prog.Globals {
+		m.globals[g.Name] = m.alloc(g.Size)
+	}
+	_, err := m.call("main", nil)
+	return m.out.String(), err
+}
+
+// 分配一块内存,返回句柄,标量占一个单元
+func (m *irMachine) alloc(size int64) int64 {
+	if size == 0 {
+		size = 1
+	}
+	m.mem = append(m.mem, make([]int64, size))
+	return int64(len(m.mem) - 1)
+}
+
+// 调用函数
+func (m *irMachine) call(name string, args []int64) (int64, error) {
+	switch name {
+	case BUILTIN_INPUT:
+		var v int64
+		if _, err := fmt.Fscan(m.in, &v); err != nil {
+			return 0, fmt.Errorf("input: %v", err)
+		}
+		return v, nil
+	case BUILTIN_OUTPUT:
+		fmt.Fprintf(&m.out, "%d\n", args[0])
+		return 0, nil
+	}
+	fn := irFunc(m.prog, name)
+	if fn == nil {
+		return 0, fmt.Errorf("unknown function %s", name)
+	}
+	if m.depth++; m.depth > 10000 {
+		return 0, fmt.Errorf("call depth exceeded")
+	}
+	defer func() { m.depth-- }()
+
+	vars := make(map[string]int64)
+	for i, p := range fn.Params {
+		vars[p] = args[i]
+	}
+	for _, a := range fn.Arrays {
+		if !a.IsParam {
+			vars[a.Name] = m.alloc(a.Size)
+		}
+	}
+	// 内存变量的句柄: 局部数组和数组形参在 vars 中,其余为全局变量
+	handle := func(sym string) (int64, error) {
+		if _, ok := fn.Arrays[sym]; ok {
+			return vars[sym], nil
+		}
+		if h, ok := m.globals[sym]; ok {
+			return h, nil
+		}
+		return 0, fmt.Errorf("unknown memory variable %s", sym)
+	}
+	cell := func(sym string, idx Operand) (*int64, error) {
+		h, err := handle(sym)
+		if err != nil {
+			return nil, err
+		}
+		i := int64(0)
+		if idx.Kind != OPD_NONE {
+			i = m.eval(vars, idx)
+		}
+		if h < 0 || h >= int64(len(m.mem)) || i < 0 || i >= int64(len(m.mem[h])) {
+			return nil, fmt.Errorf("%s[%d] out of range", sym, i)
+		}
+		return &m.mem[h][i], nil
+	}
+
+	var prev *BasicBlock
+	b := fn.Entry()
+	for {
+		// phi 在进入块时按来源前驱同时求值
+		k := 0
+		if prev != nil {
+			j := -1
+			for n, p := range b.Preds {
+				if p == prev {
+					j = n
+				}
+			}
+			vals := make(map[string]int64)
+			for ; k < len(b.Instrs) && b.Instrs[k].Op == IR_PHI; k++ {
+				if j < 0 {
+					return 0, fmt.Errorf("%s: %s is not a predecessor", b.Label(), prev.Label())
+				}
+				vals[b.Instrs[k].Dst] = m.eval(vars, b.Instrs[k].Args[j])
+			}
+			for v, x := range vals {
+				vars[v] = x
+			}
+		}
+		var next *BasicBlock
+		for ; k < len(b.Instrs); k++ {
+			if m.steps++; m.steps > 10000000 {
+				return 0, fmt.Errorf("step limit exceeded")
+			}
+			ins := b.Instrs[k]
+			switch ins.Op {
+			case IR_COPY:
+				vars[ins.Dst] = m.eval(vars, ins.Args[0])
+			case IR_BINARY:
+				l, r := m.eval(vars, ins.Args[0]), m.eval(vars, ins.Args[1])
+				if ins.OpTok == DIV && r == 0 {
+					return 0, fmt.Errorf("division by zero")
+				}
+				v, ok := evalBinary32(ins.OpTok, l, r)
+				if !ok {
+					return 0, fmt.Errorf("bad operator in %s", ins)
+				}
+				vars[ins.Dst] = v
+			case IR_LOAD:
+				c, err := cell(ins.Sym, ins.Args[0])
+				if err != nil {
+					return 0, err
+				}
+				vars[ins.Dst] = *c
+			case IR_STORE:
+				c, err := cell(ins.Sym, ins.Args[0])
+				if err != nil {
+					return 0, err
+				}
+				*c = m.eval(vars, ins.Args[1])
+			case IR_ADDR:
+				h, err := handle(ins.Sym)
+				if err != nil {
+					return 0, err
+				}
+				vars[ins.Dst] = h
+			case IR_CALL:
+				var args []int64
+				for _, a := range ins.Args {
+					args = append(args, m.eval(vars, a))
+				}
+				v, err := m.call(ins.Sym, args)
+				if err != nil {
+					return 0, err
+				}
+				if ins.Dst != "" {
+					vars[ins.Dst] = v
+				}
+			case IR_PHI:
+				return 0, fmt.Errorf("%s: phi after other instructions", b.Label())
+			case IR_JUMP:
+				next = ins.Targets[0]
+			case IR_BRANCH:
+				next = ins.Targets[1]
+				if m.eval(vars, ins.Args[0]) != 0 {
+					next = ins.Targets[0]
+				}
+			case IR_RETURN:
+				if len(ins.Args) > 0 {
+					return m.eval(vars, ins.Args[0]), nil
+				}
+				return 0, nil
+			}
+			if next != nil {
+				break
+			}
+		}
+		if next == nil {
+			return 0, fmt.Errorf("%s: falls off the end of the block", b.Label())
+		}
+		prev, b = b, next
+	}
+}
+
+// 操作数的值,未赋值的变量为0
+func (m *irMachine) eval(vars map[string]int64, o Operand) int64 {
+	if o.Kind == OPD_CONST {
+		return o.Value
+	}
+	return vars[o.Name]
+}
+
+// 执行IR程序并与示例的期望输出比较
+func checkIRSample(t *testing.T, what string, s sample, prog *IRProgram) {
+	t.Helper()
+	got, err := runIR(prog, s.input)
+	if err != nil {
+		t.Errorf("%s %s: %v", what, s.name, err)
+	} else if got != s.want {
+		t.Errorf("%s %s: got %q, want %q", what, s.name, got, s.want)
+	}
+}
+
+func TestIRSamples(t *testing.T) {
+	for _, s := range samples {
+		checkIRSample(t, "ir", s, irSample(t, s))
+	}
+}
+
+func TestIRSelection(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"if-else", `void main(void) { int n; int t; n = input(); if (n > 0) t = 1; else t = 2; output(t); }`, `B0:
+    %t1 = call input()
+    n = %t1
+    %t2 = n > 0
+    branch %t2, B1, B3
+B1:
+    t = 1
+    jump B2
+B2:
+    call output(t)
+    return
+B3:
+    t = 2
+    jump B2
+`},
+		{"if", `void main(void) { int n; n = input(); if (n > 0) n = 0; output(n); }`, `B0:
+    %t1 = call input()
+    n = %t1
+    %t2 = n > 0
+    branch %t2, B1, B2
+B1:
+    n = 0
+    jump B2
+B2:
+    call output(n)
+    return
+`},
+		{"return in then", `int f(int n) { if (n > 0) return 1; else n = 2; return n; }`, `B0:
+    %t1 = n > 0
+    branch %t1, B1, B3
+B1:
+    return 1
+B2:
+    return n
+B3:
+    n = 2
+    jump B2
+`},
+	}
+	for _, tt := range tests {
+		root, _, err := ParseProgram(tt.src)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		prog, err := GenerateIR(root)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := irText(prog.Funcs[0]); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: irgen.go
+// Package: scan
+// Description: 本文件定义了由抽象语法树生成三地址中间代码的翻译器
+// 				翻译同时划分基本块,生成后的每个函数都已经建立好控制流图
+
+package scan
+
+import (
+	"errors"
+	"fmt"
+)
+
+// IR 翻译器
+type irGenerator struct {
+	prog  *IRProgram
+	funcs map[string]*ASTNode // 函数声明
+	env   *scopeEnv           // 当前作用域
+	fn    *IRFunc             // 当前函数
+	cur   *BasicBlock         // 当前基本块
+	names map[string]int      // 当前函数中各个名字出现的次数,用于重命名同名局部变量
+	err   error               // 第一个错误
+}
+
+// 将语法树翻译为IR程序
+func GenerateIR(root *ASTNode) (*IRProgram, error) {
+	if root == nil {
+		return nil, errors.New(Msg(MSG_GEN_EMPTY))
+	}
+	env, globals, funcs, err := collectProgram(root)
+	if err != nil {
+		return nil, err
+	}
+	g := &irGenerator{prog: &IRProgram{}, env: env, funcs: make(map[string]*ASTNode, len(funcs))}
+	for _, info := range globals {
+		g.prog.Globals = append(g.prog.Globals, &IRArray{Name: "@" + info.name, Size: info.size})
+	}
+	for _, fn := range funcs {
+		g.funcs[nodeName(fn)] = fn
+	}
+	for _, fn := range funcs {
+		g.genFunc(fn, env)
+		if g.err != nil {
+			return nil, g.err
+		}
+	}
+	return g.prog, nil
+}
+
+// 记录第一个错误,id 为消息编号
+func (g *irGenerator) errorf(node *ASTNode, id string, args ...interface{}) {
+	if g.err == nil {
+		g.err = errors.New(Msg(MSG_AT_LINE, node.line, Msg(id, args...)))
+	}
+}
+
+// 在当前块末尾添加指令
+func (g *irGenerator) emit(ins *IRInstr) {
+	g.cur.Instrs = append(g.cur.Instrs, ins)
+}
+
+// 结束当前块并切换到新块
+func (g *irGenerator) startBlock(b *BasicBlock) {
+	if g.cur != nil && g.cur.Terminator() == nil {
+		g.emit(&IRInstr{Op: IR_JUMP, Targets: []*BasicBlock{b}})
+	}
+	g.cur = b
+}
+
+// 为局部变量或形参生成IR中唯一的名字
+func (g *irGenerator) uniqueName(name string) string {
+	n := g.names[name]
+	g.names[name] = n + 1
+	if n == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s.%d", name, n)
+}
+
+// 变量在IR中的名字,全局变量加@前缀
+func irName(info *symInfo) string {
+	if info.kind == SYM_GLOBAL {
+		return "@" + info.name
+	}
+	return info.irName
+}
+
+// 翻译函数
+func (g *irGenerator) genFunc(node *ASTNode, globals *scopeEnv) {
+	fn := &IRFunc{Name: nodeName(node), ReturnsInt: funcReturnsInt(node), Arrays: make(map[string]*IRArray)}
+	g.prog.Funcs = append(g.prog.Funcs, fn)
+	g.fn = fn
+	g.cur = nil
+	g.names = make(map[string]int)
+	g.env = newScopeEnv(globals)
+	g.startBlock(fn.NewBlock())
+
+	for i, p := range funcParams(node) {
+		info := declInfo(p, SYM_PARAM)
+		info.index = i
+		info.irName = g.uniqueName(info.name)
+		if g.env.put(info) != nil {
+			g.errorf(p, MSG_GEN_REDECLARED, info.name)
+			return
+		}
+		fn.Params = append(fn.Params, info.irName)
+		if info.isArray {
+			fn.Arrays[info.irName] = &IRArray{Name: info.irName, IsParam: true}
+		}
+	}
+
+	g.genStmt(node.right)
+
+	// 函数末尾补充返回指令
+	if g.cur.Terminator() == nil {
+		ret := &IRInstr{Op: IR_RETURN, Line: node.line}
+		if fn.ReturnsInt {
+			ret.Args = []Operand{ConstOperand(0)}
+		}
+		g.emit(ret)
+	}
+	g.env = globals
+	BuildCFG(fn)
+	RemoveUnreachable(fn)
+}
+
+// 翻译语句
+func (g *irGenerator) genStmt(node *ASTNode) {
+	if node == nil || g.err != nil {
+		return
+	}
+	// return 之后的语句放到新的(不可达)块中
+	if g.cur.Terminator() != nil {
+		g.cur = g.fn.NewBlock()
+	}
+	if node.nodeK == EXPRESSION {
+		g.genExpr(node)
+		return
+	}
+
+	switch node.nodeT {
+	case COMPOUND:
+		g.env = newScopeEnv(g.env)
+		for d := node.left; d != nil; d = d.sibling {
+			info := declInfo(d, SYM_LOCAL)
+			info.irName = g.uniqueName(info.name)
+			if g.env.put(info) != nil {
+				g.errorf(d, MSG_GEN_REDECLARED, info.name)
+				return
+			}
+			if info.isArray {
+				g.fn.Arrays[info.irName] = &IRArray{Name: info.irName, Size: info.size}
+			}
+		}
+		for s := node.right; s != nil; s = s.sibling {
+			g.genStmt(s)
+		}
+		g.env = g.env.prev
+	case SELECTION_STMT:
+		cond := g.genValue(node.left)
+		then, join := g.fn.NewBlock(), g.fn.NewBlock()
+		els := join
+		if node.right != nil {
+			els = g.fn.NewBlock()
+		}
+		g.emit(&IRInstr{Op: IR_BRANCH, Args: []Operand{cond}, Targets: []*BasicBlock{then, els}, Line: node.line})
+		g.startBlock(then)
+		g.genStmt(node.mid)
+		if node.right != nil {
+			// then 分支结束后跳过 else 分支
+			if g.cur.Terminator() == nil {
+				g.emit(&IRInstr{Op: IR_JUMP, Targets: []*BasicBlock{join}, Line: node.line})
+			}
+			g.startBlock(els)
+			g.genStmt(node.right)
+		}
+		g.startBlock(join)
+	case ITERATION_STMT:
+		header, body, exit := g.fn.NewBlock(), g.fn.NewBlock(), g.fn.NewBlock()
+		g.startBlock(header)
+		cond := g.genValue(node.left)
+		g.emit(&IRInstr{Op: IR_BRANCH, Args: []Operand{cond}, Targets: []*BasicBlock{body, exit}, Line: node.line})
+		g.startBlock(body)
+		g.genStmt(node.mid)
+		if g.cur.Terminator() == nil {
+			g.emit(&IRInstr{Op: IR_JUMP, Targets: []*BasicBlock{header}, Line: node.line})
+		}
+		g.startBlock(exit)
+	case RETURN_STMT:
+		ret := &IRInstr{Op: IR_RETURN, Line: node.line}
+		if node.left != nil {
+			ret.Args = []Operand{g.genValue(node.left)}
+		} else if g.fn.ReturnsInt {
+			ret.Args = []Operand{ConstOperand(0)}
+		}
+		g.emit(ret)
+	default:
+		g.errorf(node, MSG_GEN_UNEXPECTED_STMT)
+	}
+}
+
+// 翻译必须产生值的表达式
+func (g *irGenerator) genValue(node *ASTNode) Operand {
+	if node == nil {
+		return ConstOperand(0)
+	}
+	res := g.genExpr(node)
+	if res.Kind == OPD_NONE && g.err == nil {
+		g.errorf(node, MSG_GEN_VOID_VALUE)
+	}
+	return res
+}
+
+// 查找变量
+func (g *irGenerator) lookupVar(node *ASTNode) *symInfo {
+	name := nodeName(node)
+	info := g.env.lookup(name)
+	if info == nil {
+		g.errorf(node, MSG_GEN_UNDEFINED_VAR, name)
+		return nil
+	}
+	if node.left != nil && !info.isArray {
+		g.errorf(node, MSG_GEN_NOT_ARRAY, name)
+		return nil
+	}
+	return info
+}
+
+// 翻译表达式,返回结果操作数
+func (g *irGenerator) genExpr(node *ASTNode) Operand {
+	if node == nil || g.err != nil {
+		return Operand{}
+	}
+	switch node.nodeT {
+	case CONST:
+		return ConstOperand(nodeValue(node))
+	case VAR:
+		info := g.lookupVar(node)
+		if info == nil {
+			return Operand{}
+		}
+		switch {
+		case info.isArray && node.left == nil: // 数组名作为实参
+			t := g.fn.NewTemp()
+			g.emit(&IRInstr{Op: IR_ADDR, Dst: t, Sym: irName(info), Line: node.line})
+			return VarOperand(t)
+		case info.isArray:
+			idx := g.genValue(node.left)
+			t := g.fn.NewTemp()
+			g.emit(&IRInstr{Op: IR_LOAD, Dst: t, Sym: irName(info), Args: []Operand{idx}, Line: node.line})
+			return VarOperand(t)
+		case info.kind == SYM_GLOBAL:
+			t := g.fn.NewTemp()
+			g.emit(&IRInstr{Op: IR_LOAD, Dst: t, Sym: irName(info), Args: []Operand{{}}, Line: node.line})
+			return VarOperand(t)
+		}
+		return VarOperand(irName(info))
+	case ASSIGNMENT:
+		info := g.lookupVar(node.left)
+		if info == nil {
+			return Operand{}
+		}
+		if info.isArray && node.left.left == nil {
+			g.errorf(node, MSG_GEN_ARRAY_ASSIGN, info.name)
+			return Operand{}
+		}
+		if !info.isArray && info.kind != SYM_GLOBAL {
+			val := g.genValue(node.right)
+			g.emit(&IRInstr{Op: IR_COPY, Dst: irName(info), Args: []Operand{val}, Line: node.line})
+			return VarOperand(irName(info))
+		}
+		idx := Operand{}
+		if info.isArray {
+			idx = g.genValue(node.left.left)
+		}
+		val := g.genValue(node.right)
+		g.emit(&IRInstr{Op: IR_STORE, Sym: irName(info), Args: []Operand{idx, val}, Line: node.line})
+		return val
+	case CALL:
+		return g.genCall(node)
+	case OPERATION, COMPARE:
+		l := g.genValue(node.left)
+		r := g.genValue(node.right)
+		t := g.fn.NewTemp()
+		g.emit(&IRInstr{Op: IR_BINARY, Dst: t, OpTok: nodeOp(node), Args: []Operand{l, r}, Line: node.line})
+		return VarOperand(t)
+	}
+	g.errorf(node, MSG_GEN_UNEXPECTED_EXPR)
+	return Operand{}
+}
+
+// 翻译函数调用
+func (g *irGenerator) genCall(node *ASTNode) Operand {
+	name := nodeName(node)
+	args := callArgs(node)
+	returns := false
+	if fn, ok := g.funcs[name]; ok {
+		if len(funcParams(fn)) != len(args) {
+			g.errorf(node, MSG_GEN_ARG_COUNT, name, len(funcParams(fn)), len(args))
+			return Operand{}
+		}
+		returns = funcReturnsInt(fn)
+	} else if name == BUILTIN_INPUT || name == BUILTIN_OUTPUT {
+		returns = name == BUILTIN_INPUT
+	} else {
+		g.errorf(node, MSG_GEN_UNDEFINED_FUNC, name)
+		return Operand{}
+	}
+
+	ins := &IRInstr{Op: IR_CALL, Sym: name, Line: node.line}
+	for _, a := range args {
+		ins.Args = append(ins.Args, g.genValue(a))
+	}
+	if returns {
+		ins.Dst = g.fn.NewTemp()
+	}
+	g.emit(ins)
+	if returns {
+		return VarOperand(ins.Dst)
+	}
+	return Operand{}
+}
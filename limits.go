@@ -0,0 +1,133 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: limits.go
+// Package: scan
+// Description: 本文件定义了解释器的资源限制,用于执行不可信的程序
+// 				包括执行步数、运行时间(通过 context.Context)、数组占用的单元总数和输出的字节数
+// 				超出限制时返回 *LimitError,其中记录了超出的是哪一项限制
+
+package scan
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// 每执行多少步检查一次 context
+const LIMIT_CHECK_INTERVAL = 1024
+
+// 资源限制种类
+type LimitKind int
+
+const (
+	LIMIT_STEPS  LimitKind = iota // 执行步数
+	LIMIT_TIME                    // 运行时间(context 超时或被取消)
+	LIMIT_MEMORY                  // 数组单元总数
+	LIMIT_OUTPUT                  // 输出字节数
+)
+
+// 资源限制种类的名字
+func (k LimitKind) String() string {
+	switch k {
+	case LIMIT_STEPS:
+		return "step"
+	case LIMIT_TIME:
+		return "time"
+	case LIMIT_MEMORY:
+		return "memory"
+	case LIMIT_OUTPUT:
+		return "output"
+	}
+	return "unknown"
+}
+
+// 超出资源限制的错误
+type LimitError struct {
+	Kind  LimitKind
+	Limit int64        // 限制的值,时间限制为0
+	Line  int          // 超出限制时执行到的行号
+	Trace []TraceFrame // 调用栈,最内层在前
+	Err   error        // 时间限制时为 context 的错误
+}
+
+func (e *LimitError) Error() string {
+	if e.Kind == LIMIT_TIME {
+		return Msg(MSG_LIMIT_EXCEEDED, e.Line, e.Kind.message(), e.Err.Error())
+	}
+	return Msg(MSG_LIMIT_OF, e.Line, e.Kind.message(), e.Limit)
+}
+
+// 资源限制种类在当前语言中的名字
+func (k LimitKind) message() string {
+	switch k {
+	case LIMIT_STEPS:
+		return Msg(MSG_LIMIT_STEP)
+	case LIMIT_TIME:
+		return Msg(MSG_LIMIT_TIME)
+	case LIMIT_MEMORY:
+		return Msg(MSG_LIMIT_MEMORY)
+	case LIMIT_OUTPUT:
+		return Msg(MSG_LIMIT_OUTPUT)
+	}
+	return Msg(MSG_LIMIT_UNKNOWN)
+}
+
+// 使 errors.Is(err, context.DeadlineExceeded) 可以识别时间限制
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// 打印超出资源限制的错误及调用栈
+func HelpPrintLimitError(err *LimitError, file *os.File) {
+	fmt.Fprintln(file, err.Error())
+	helpPrintTrace(err.Trace, file)
+}
+
+// 生成超出资源限制的错误
+func (it *Interpreter) newLimitError(line int, kind LimitKind, limit int64, err error) *LimitError {
+	return &LimitError{Kind: kind, Limit: limit, Line: line, Trace: it.trace(), Err: err}
+}
+
+// 计数一步执行,检查步数和时间限制
+func (it *Interpreter) step(node *ASTNode) error {
+	it.steps++
+	if it.opts.MaxSteps > 0 && it.steps > it.opts.MaxSteps {
+		return it.newLimitError(node.line, LIMIT_STEPS, it.opts.MaxSteps, nil)
+	}
+	if it.ctx != nil && it.steps%LIMIT_CHECK_INTERVAL == 0 {
+		if err := it.ctx.Err(); err != nil {
+			return it.newLimitError(node.line, LIMIT_TIME, 0, err)
+		}
+	}
+	return nil
+}
+
+// 分配数组单元,检查内存限制
+func (it *Interpreter) allocCells(line int, n int64) error {
+	if it.cells+n > it.opts.MaxCells {
+		return it.newLimitError(line, LIMIT_MEMORY, it.opts.MaxCells, nil)
+	}
+	it.cells += n
+	return nil
+}
+
+// 输出字符串,检查输出限制
+func (it *Interpreter) write(line int, s string) error {
+	if it.opts.MaxOutput > 0 && it.outBytes+int64(len(s)) > it.opts.MaxOutput {
+		return it.newLimitError(line, LIMIT_OUTPUT, it.opts.MaxOutput, nil)
+	}
+	it.outBytes += int64(len(s))
+	_, err := fmt.Fprint(it.out, s)
+	return err
+}
+
+// 在 context 的控制下执行 main 函数,context 超时或被取消时返回 LIMIT_TIME 错误
+func (it *Interpreter) RunContext(ctx context.Context) error {
+	it.ctx = ctx
+	defer func() { it.ctx = nil }()
+	if err := ctx.Err(); err != nil {
+		return it.newLimitError(0, LIMIT_TIME, 0, err)
+	}
+	return it.Run()
+}
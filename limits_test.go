@@ -0,0 +1,167 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: limits_test.go
+// Package: scan
+// Description: 解释器资源限制的测试
+// 				超出步数、运行时间、数组单元数和输出字节数的限制时都要返回 *LimitError,
+// 				时间限制每执行 LIMIT_CHECK_INTERVAL 步检查一次
+
+package scan
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 新建解释器,输出写入 out
+func newLimitInterp(t *testing.T, src string, out *strings.Builder, opts InterpOptions) *Interpreter {
+	t.Helper()
+	root, _, err := ParseProgram(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := NewInterpreter(root, strings.NewReader(""), out, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return it
+}
+
+// 检查错误是否为给定种类的 *LimitError
+func wantLimitError(t *testing.T, err error, kind LimitKind, limit int64, line int) *LimitError {
+	t.Helper()
+	var limErr *LimitError
+	if !errors.As(err, &limErr) {
+		t.Fatalf("got %v, want a %s limit error", err, kind)
+	}
+	if limErr.Kind != kind || limErr.Limit != limit || limErr.Line != line {
+		t.Errorf("got %s limit %d at line %d, want %s limit %d at line %d",
+			limErr.Kind, limErr.Limit, limErr.Line, kind, limit, line)
+	}
+	return limErr
+}
+
+const limitLoopSrc = `void main(void) {
+	int i;
+	i = 0;
+	while (1)
+		i = i + 1;
+}`
+
+func TestLimitSteps(t *testing.T) {
+	var out strings.Builder
+	it := newLimitInterp(t, limitLoopSrc, &out, InterpOptions{MaxSteps: 1000})
+	err := it.Run()
+	limErr := wantLimitError(t, err, LIMIT_STEPS, 1000, 5)
+	if limErr.Unwrap() != nil {
+		t.Errorf("step limit wraps %v", limErr.Unwrap())
+	}
+	if len(limErr.Trace) == 0 || limErr.Trace[0].Func != "main" {
+		t.Errorf("trace = %v", limErr.Trace)
+	}
+	if got, want := err.Error(), "line 5: step limit of 1000 exceeded"; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+}
+
+func TestLimitTime(t *testing.T) {
+	var out strings.Builder
+	// 检查 context 时可能执行到循环条件或循环体,两者写在同一行
+	it := newLimitInterp(t, `void main(void) {
+	int i;
+	i = 0;
+	while (1) i = i + 1;
+}`, &out, InterpOptions{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := it.RunContext(ctx)
+	limErr := wantLimitError(t, err, LIMIT_TIME, 0, 4)
+	if !errors.Is(err, context.DeadlineExceeded) || limErr.Unwrap() != context.DeadlineExceeded {
+		t.Errorf("time limit wraps %v, want context.DeadlineExceeded", limErr.Unwrap())
+	}
+	// context 只在每 LIMIT_CHECK_INTERVAL 步时检查
+	if it.steps < LIMIT_CHECK_INTERVAL || it.steps%LIMIT_CHECK_INTERVAL != 0 {
+		t.Errorf("stopped after %d steps, want a multiple of %d", it.steps, LIMIT_CHECK_INTERVAL)
+	}
+	if it.ctx != nil {
+		t.Error("context not cleared after RunContext")
+	}
+}
+
+func TestLimitTimeCheckInterval(t *testing.T) {
+	// 已取消的 context 在两次检查之间不会被发现,执行步数不足 LIMIT_CHECK_INTERVAL 的程序正常结束
+	var out strings.Builder
+	it := newLimitInterp(t, `void main(void) { output(1); }`, &out, InterpOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	it.ctx = ctx
+	cancel()
+	if err := it.Run(); err != nil {
+		t.Errorf("short program: %v", err)
+	}
+	if it.steps >= LIMIT_CHECK_INTERVAL {
+		t.Fatalf("short program took %d steps", it.steps)
+	}
+
+	// RunContext 在开始执行前检查一次
+	it = newLimitInterp(t, `void main(void) { output(1); }`, &out, InterpOptions{})
+	err := it.RunContext(ctx)
+	wantLimitError(t, err, LIMIT_TIME, 0, 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if it.steps != 0 {
+		t.Errorf("canceled context: executed %d steps", it.steps)
+	}
+}
+
+func TestLimitMemory(t *testing.T) {
+	src := `void f(void) {
+	int a[100];
+	a[0] = 1;
+}
+void main(void) {
+	int b[100];
+	f();
+	f();
+	{
+		int c[100];
+		c[0] = 1;
+	}
+}`
+	var out strings.Builder
+	// 函数返回后局部数组被释放,同时存在的单元数最多为200
+	if err := newLimitInterp(t, src, &out, InterpOptions{MaxCells: 200}).Run(); err != nil {
+		t.Errorf("max cells 200: %v", err)
+	}
+	err := newLimitInterp(t, src, &out, InterpOptions{MaxCells: 199}).Run()
+	limErr := wantLimitError(t, err, LIMIT_MEMORY, 199, 2)
+	if limErr.Unwrap() != nil {
+		t.Errorf("memory limit wraps %v", limErr.Unwrap())
+	}
+	if len(limErr.Trace) != 2 || limErr.Trace[0].Func != "f" || limErr.Trace[1].Func != "main" {
+		t.Errorf("trace = %v", limErr.Trace)
+	}
+}
+
+func TestLimitOutput(t *testing.T) {
+	var out strings.Builder
+	it := newLimitInterp(t, `void main(void) {
+	int i;
+	i = 0;
+	while (i < 100) {
+		output(i);
+		i = i + 1;
+	}
+}`, &out, InterpOptions{MaxOutput: 10})
+	limErr := wantLimitError(t, it.Run(), LIMIT_OUTPUT, 10, 5)
+	if limErr.Unwrap() != nil {
+		t.Errorf("output limit wraps %v", limErr.Unwrap())
+	}
+	// 超出限制的那次输出不写出
+	if got, want := out.String(), "0\n1\n2\n3\n4\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
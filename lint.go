@@ -0,0 +1,144 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: lint.go
+// Package: scan
+// Description: 本文件定义了语法树上的静态检查
+// 				报告未使用的局部变量、形参、全局变量以及从未被调用的函数(main除外)
+// 				标识符用代码生成器共用的作用域环境 scopeEnv 解析,引用次数记在 symInfo 中;
+// 				语法分析的符号表把引用也作为当前作用域的条目保存,分不出声明和使用,不能用于统计
+
+package scan
+
+// 静态检查中声明的标识符
+type lintDecl struct {
+	info *symInfo
+	fn   string // 形参所属的函数
+}
+
+// 静态检查器
+type linter struct {
+	funcs map[string]*ASTNode // 函数声明
+	calls map[string]int      // 函数被其他函数调用的次数
+	curFn string              // 当前函数名
+	diags []Diagnostic
+	decls []lintDecl // 按声明顺序保存的标识符,用于最后报告
+}
+
+// 对语法树进行静态检查,返回按行号排序的诊断信息
+func Lint(root *ASTNode) []Diagnostic {
+	l := &linter{funcs: make(map[string]*ASTNode), calls: make(map[string]int)}
+	global := newScopeEnv(nil)
+	var order []string
+	for node := root; node != nil; node = node.sibling {
+		switch {
+		case isStmt(node, VAR_DECLARATION):
+			l.declare(global, node, SYM_GLOBAL)
+		case isStmt(node, FUNC_DECLARATION):
+			name := nodeName(node)
+			if _, ok := l.funcs[name]; !ok {
+				order = append(order, name)
+			}
+			l.funcs[name] = node
+		}
+	}
+	for node := root; node != nil; node = node.sibling {
+		if isStmt(node, FUNC_DECLARATION) {
+			l.function(node, global)
+		}
+	}
+
+	for _, d := range l.decls {
+		if d.info.uses > 0 {
+			continue
+		}
+		line := d.info.decl.line
+		switch d.info.kind {
+		case SYM_GLOBAL:
+			l.diags = append(l.diags, newWarning(line, DIAG_UNUSED_GLOBAL, MSG_UNUSED_GLOBAL, d.info.name))
+		case SYM_LOCAL:
+			l.diags = append(l.diags, newWarning(line, DIAG_UNUSED_LOCAL, MSG_UNUSED_LOCAL, d.info.name))
+		case SYM_PARAM:
+			l.diags = append(l.diags, newWarning(line, DIAG_UNUSED_PARAM, MSG_UNUSED_PARAM, d.info.name, d.fn))
+		}
+	}
+	for _, name := range order {
+		if name != "main" && l.calls[name] == 0 {
+			l.diags = append(l.diags, newWarning(l.funcs[name].line, DIAG_UNUSED_FUNC, MSG_UNUSED_FUNC, name))
+		}
+	}
+	SortDiagnostics(l.diags)
+	return l.diags
+}
+
+// 在作用域中声明标识符,重复声明的以第一次为准
+func (l *linter) declare(env *scopeEnv, node *ASTNode, kind symKind) {
+	info := declInfo(node, kind)
+	if info.name == "" || env.put(info) != nil {
+		return
+	}
+	l.decls = append(l.decls, lintDecl{info: info, fn: l.curFn})
+}
+
+// 检查函数声明,形参位于单独的一层作用域
+func (l *linter) function(fn *ASTNode, global *scopeEnv) {
+	l.curFn = nodeName(fn)
+	params := newScopeEnv(global)
+	for _, p := range funcParams(fn) {
+		l.declare(params, p, SYM_PARAM) // void 形参列表没有名字,不会声明
+	}
+	l.stmts(fn.right, params)
+}
+
+// 检查语句序列
+func (l *linter) stmts(node *ASTNode, env *scopeEnv) {
+	for ; node != nil; node = node.sibling {
+		l.stmt(node, env)
+	}
+}
+
+// 检查单条语句
+func (l *linter) stmt(node *ASTNode, env *scopeEnv) {
+	switch {
+	case isStmt(node, COMPOUND):
+		inner := newScopeEnv(env)
+		for d := node.left; d != nil; d = d.sibling {
+			l.declare(inner, d, SYM_LOCAL)
+		}
+		l.stmts(node.right, inner)
+	case isStmt(node, SELECTION_STMT):
+		l.exp(node.left, env)
+		l.stmt(node.mid, env)
+		l.stmt(node.right, env)
+	case isStmt(node, ITERATION_STMT):
+		l.exp(node.left, env)
+		l.stmt(node.mid, env)
+	case isStmt(node, RETURN_STMT):
+		l.exp(node.left, env)
+	default:
+		l.exp(node, env)
+	}
+}
+
+// 检查表达式,记录变量引用和函数调用
+func (l *linter) exp(node *ASTNode, env *scopeEnv) {
+	if node == nil {
+		return
+	}
+	switch {
+	case isExp(node, VAR):
+		if info := env.lookup(nodeName(node)); info != nil {
+			info.uses++
+		}
+		l.exp(node.left, env)
+	case isExp(node, CALL):
+		if name := nodeName(node); name != l.curFn {
+			l.calls[name]++
+		}
+		for _, a := range callArgs(node) {
+			l.exp(a, env)
+		}
+	default:
+		l.exp(node.left, env)
+		l.exp(node.right, env)
+	}
+}
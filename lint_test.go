@@ -0,0 +1,79 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: lint_test.go
+// Package: scan
+// Description: 静态检查的测试
+// 				每个用例给出程序和期望的诊断信息(行号:编号),诊断信息按行号排列
+
+package scan
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// 诊断信息的行号和编号,形如 3:W101
+func diagCodes(diags []Diagnostic) string {
+	var res []string
+	for _, d := range diags {
+		res = append(res, fmt.Sprintf("%d:%s", d.Line, d.Code))
+	}
+	return strings.Join(res, " ")
+}
+
+// 对每个用例运行一种检查,比较诊断信息
+func checkDiagnostics(t *testing.T, name string, check func(*ASTNode) []Diagnostic, tests []struct{ name, src, want string }) {
+	t.Helper()
+	for _, tt := range tests {
+		root, _, err := ParseProgram(tt.src)
+		if err != nil {
+			t.Fatalf("%s %s: %v", name, tt.name, err)
+		}
+		if got := diagCodes(check(root)); got != tt.want {
+			t.Errorf("%s %s: got %q, want %q", name, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	checkDiagnostics(t, "lint", Lint, []struct{ name, src, want string }{
+		{"clean", `int g;
+int f(int a) { return a + g; }
+void main(void) { output(f(1)); }`, ""},
+		{"unused local", `void main(void) {
+	int x;
+	int y;
+	y = 1;
+}`, "2:W101"},
+		{"unused param", `int f(int a, int b) {
+	return a;
+}
+void main(void) { output(f(1, 2)); }`, "1:W102"},
+		{"unused global", `int g;
+int h[3];
+void main(void) { h[0] = 1; }`, "1:W103"},
+		{"unused function", `void main(void) { }
+
+int f(void) { return 1; }`, "3:W104"},
+		{"recursion is not a use", `int f(int n) { return f(n - 1); }
+void main(void) { }`, "1:W104"},
+		{"shadowed global", `int x;
+void main(void) {
+	int x;
+	x = 1;
+}`, "1:W103"},
+		{"inner scope", `void main(void) {
+	int x;
+	{
+		int y;
+		output(x);
+	}
+}`, "4:W101"},
+	})
+	// 未使用的形参的消息包含函数名
+	root, _, _ := ParseProgram("int f(int a) { return 0; }\nvoid main(void) { output(f(1)); }")
+	if diags := Lint(root); len(diags) != 1 || !strings.Contains(diags[0].Message, "f") {
+		t.Errorf("unused param: %v", diags)
+	}
+}
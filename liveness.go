@@ -0,0 +1,144 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: liveness.go
+// Package: scan
+// Description: 本文件定义了控制流图上的活跃变量分析
+
+package scan
+
+// 变量集合
+type VarSet map[string]bool
+
+// 复制集合
+func (s VarSet) copy() VarSet {
+	res := make(VarSet, len(s))
+	for k := range s {
+		res[k] = true
+	}
+	return res
+}
+
+// 判断两个集合是否相等
+func (s VarSet) equal(t VarSet) bool {
+	if len(s) != len(t) {
+		return false
+	}
+	for k := range s {
+		if !t[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// 活跃变量分析结果
+type Liveness struct {
+	In  map[*BasicBlock]VarSet // 块入口处活跃的变量
+	Out map[*BasicBlock]VarSet // 块出口处活跃的变量
+}
+
+// 计算活跃变量
+// phi 指令的操作数视为在对应前驱块出口处使用
+func ComputeLiveness(fn *IRFunc) *Liveness {
+	live := &Liveness{In: make(map[*BasicBlock]VarSet), Out: make(map[*BasicBlock]VarSet)}
+	use := make(map[*BasicBlock]VarSet)
+	def := make(map[*BasicBlock]VarSet)
+	for _, b := range fn.Blocks {
+		use[b], def[b] = make(VarSet), make(VarSet)
+		for _, ins := range b.Instrs {
+			if ins.Op != IR_PHI {
+				for _, u := range ins.Uses() {
+					if !def[b][u] {
+						use[b][u] = true
+					}
+				}
+			}
+			if d := ins.Def(); d != "" {
+				def[b][d] = true
+			}
+		}
+		live.In[b], live.Out[b] = make(VarSet), make(VarSet)
+	}
+
+	// 逆序迭代直到不动点
+	order := ReversePostorder(fn)
+	for changed := true; changed; {
+		changed = false
+		for i := len(order) - 1; i >= 0; i-- {
+			b := order[i]
+			out := make(VarSet)
+			for _, s := range b.Succs {
+				for v := range live.In[s] {
+					if !phiDefines(s, v) {
+						out[v] = true
+					}
+				}
+				for _, v := range phiUses(s, b) {
+					out[v] = true
+				}
+			}
+			in := out.copy()
+			for v := range def[b] {
+				delete(in, v)
+			}
+			for v := range use[b] {
+				in[v] = true
+			}
+			if !out.equal(live.Out[b]) || !in.equal(live.In[b]) {
+				live.Out[b], live.In[b] = out, in
+				changed = true
+			}
+		}
+	}
+	return live
+}
+
+// 判断变量是否由块开头的phi指令定义
+func phiDefines(b *BasicBlock, v string) bool {
+	for _, ins := range b.Instrs {
+		if ins.Op != IR_PHI {
+			break
+		}
+		if ins.Dst == v {
+			return true
+		}
+	}
+	return false
+}
+
+// 块b中的phi指令在来自pred的边上使用的变量
+func phiUses(b, pred *BasicBlock) []string {
+	var res []string
+	for i, p := range b.Preds {
+		if p != pred {
+			continue
+		}
+		for _, ins := range b.Instrs {
+			if ins.Op != IR_PHI {
+				break
+			}
+			if a := ins.Args[i]; a.Kind == OPD_VAR {
+				res = append(res, a.Name)
+			}
+		}
+	}
+	return res
+}
+
+// 计算每条指令之后活跃的变量,回调参数为指令及其之后的活跃变量集合
+// 回调中不能修改集合
+func walkLiveAfter(b *BasicBlock, live *Liveness, fn func(i int, ins *IRInstr, after VarSet)) {
+	cur := live.Out[b].copy()
+	for i := len(b.Instrs) - 1; i >= 0; i-- {
+		ins := b.Instrs[i]
+		fn(i, ins, cur)
+		if d := ins.Def(); d != "" {
+			delete(cur, d)
+		}
+		if ins.Op != IR_PHI {
+			for _, u := range ins.Uses() {
+				cur[u] = true
+			}
+		}
+	}
+}
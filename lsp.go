@@ -0,0 +1,325 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: lsp.go
+// Package: scan
+// Description: 本文件定义了语言服务器(Language Server Protocol),通过标准输入输出与编辑器通信
+// 				消息为带 Content-Length 头的 JSON-RPC 2.0,文档以全量方式同步
+// 				支持诊断信息推送(语法错误和静态检查)、文档格式化和文档符号(全局变量、函数及其形参和局部变量)
+// 				诊断信息只有行号,范围为整行
+
+package scan
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// JSON-RPC 错误码
+const (
+	LSP_PARSE_ERROR      = -32700
+	LSP_METHOD_NOT_FOUND = -32601
+	LSP_INVALID_PARAMS   = -32602
+)
+
+// LSP 中的符号种类和诊断级别
+const (
+	lspSymbolFunction = 12
+	lspSymbolVariable = 13
+	lspSeverityError  = 1
+	lspSeverityWarn   = 2
+)
+
+// 语言服务器
+type LanguageServer struct {
+	in       *bufio.Reader
+	out      io.Writer
+	disabled map[string]bool   // 关闭的警告
+	docs     map[string]string // 打开的文档: URI 及其内容
+	shutdown bool              // 是否已收到 shutdown 请求
+}
+
+// 语言服务器工厂函数,disabled 的键为关闭的警告编号
+func NewLanguageServer(in io.Reader, out io.Writer, disabled map[string]bool) *LanguageServer {
+	return &LanguageServer{in: bufio.NewReader(in), out: out, disabled: disabled, docs: make(map[string]string)}
+}
+
+// 请求或通知,通知没有 ID
+type lspMessage struct {
+	ID     *json.RawMessage `json:"id,omitempty"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params,omitempty"`
+}
+
+// 响应,成功时必须有 result (可以为 null),失败时只有 error
+type lspResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type lspErrorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   *lspError        `json:"error"`
+}
+
+// 响应中的错误
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// 服务器发出的通知
+type lspNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDocumentSymbol struct {
+	Name           string               `json:"name"`
+	Kind           int                  `json:"kind"`
+	Range          lspRange             `json:"range"`
+	SelectionRange lspRange             `json:"selectionRange"`
+	Children       []*lspDocumentSymbol `json:"children,omitempty"`
+}
+
+// 请求参数中的文档
+type lspDocumentParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+// 循环处理消息,收到 exit 通知或输入结束时返回
+// 收到 exit 之前没有收到 shutdown 时返回错误
+func (s *LanguageServer) Run() error {
+	for {
+		body, err := s.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			s.respond(nil, nil, &lspError{Code: LSP_PARSE_ERROR, Message: err.Error()})
+			continue
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		result, lerr := s.handle(&msg)
+		if msg.ID != nil {
+			s.respond(msg.ID, result, lerr)
+		}
+	}
+}
+
+// 读入一条消息的内容
+func (s *LanguageServer) read() ([]byte, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && len(line) != 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			break
+		}
+		if i := strings.IndexByte(line, ':'); i > 0 && strings.EqualFold(line[:i], "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[i+1:])); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %s", line[i+1:])
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(s.in, body)
+	return body, err
+}
+
+// 发送一条消息
+func (s *LanguageServer) write(v interface{}) {
+	body, _ := json.Marshal(v)
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// 发送响应
+func (s *LanguageServer) respond(id *json.RawMessage, result interface{}, err *lspError) {
+	if err != nil {
+		s.write(lspErrorResponse{JSONRPC: "2.0", ID: id, Error: err})
+	} else {
+		s.write(lspResponse{JSONRPC: "2.0", ID: id, Result: result})
+	}
+}
+
+// 处理一条请求或通知,返回响应的结果
+func (s *LanguageServer) handle(msg *lspMessage) (interface{}, *lspError) {
+	var params lspDocumentParams
+	if len(msg.Params) != 0 && strings.HasPrefix(msg.Method, "textDocument/") {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{Code: LSP_INVALID_PARAMS, Message: err.Error()}
+		}
+	}
+	uri := params.TextDocument.URI
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":           1, // 全量同步
+				"documentFormattingProvider": true,
+				"documentSymbolProvider":     true,
+			},
+			"serverInfo": map[string]string{"name": "CMinusParser", "version": "1.0.1"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		s.docs[uri] = params.TextDocument.Text
+		s.publish(uri)
+		return nil, nil
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[uri] = params.ContentChanges[n-1].Text
+		}
+		s.publish(uri)
+		return nil, nil
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		s.write(lspNotification{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics",
+			Params: map[string]interface{}{"uri": uri, "diagnostics": []lspDiagnostic{}}})
+		return nil, nil
+	case "textDocument/formatting":
+		return s.formatting(uri), nil
+	case "textDocument/documentSymbol":
+		return s.documentSymbols(uri), nil
+	}
+	if msg.ID == nil {
+		return nil, nil // 不支持的通知直接忽略
+	}
+	return nil, &lspError{Code: LSP_METHOD_NOT_FOUND, Message: "method not found: " + msg.Method}
+}
+
+// 整行的范围,line 从1开始
+func lspLineRange(source string, line int) lspRange {
+	lines := strings.Split(source, "\n")
+	if line > len(lines) {
+		line = len(lines) // 输入结尾处的错误
+	}
+	if line < 1 {
+		line = 1
+	}
+	end := len(strings.TrimRight(lines[line-1], "\r"))
+	return lspRange{Start: lspPosition{Line: line - 1}, End: lspPosition{Line: line - 1, Character: end}}
+}
+
+// 分析文档并推送诊断信息
+func (s *LanguageServer) publish(uri string) {
+	source := s.docs[uri]
+	root, diags, err := ParseProgram(source)
+	if err == nil {
+		diags = append(diags, FilterDiagnostics(Analyze(root), s.disabled)...)
+	}
+	res := []lspDiagnostic{}
+	for _, d := range diags {
+		severity := lspSeverityWarn
+		if d.Severity == SEVERITY_ERROR {
+			severity = lspSeverityError
+		}
+		res = append(res, lspDiagnostic{Range: lspLineRange(source, d.Line), Severity: severity, Code: d.Code, Source: "cminus", Message: d.Message})
+	}
+	s.write(lspNotification{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics",
+		Params: map[string]interface{}{"uri": uri, "diagnostics": res}})
+}
+
+// 格式化整个文档,有语法错误、已经格式化或格式化会改变程序结构时没有修改
+func (s *LanguageServer) formatting(uri string) []lspTextEdit {
+	source := s.docs[uri]
+	text, _, err := Format(source)
+	if err != nil || text == source {
+		return []lspTextEdit{}
+	}
+	lines := strings.Split(source, "\n")
+	end := lspPosition{Line: len(lines) - 1, Character: len(lines[len(lines)-1])}
+	return []lspTextEdit{{Range: lspRange{End: end}, NewText: text}}
+}
+
+// 文档中的全局变量和函数,函数的子符号为形参和函数体最外层的局部变量
+func (s *LanguageServer) documentSymbols(uri string) []*lspDocumentSymbol {
+	source := s.docs[uri]
+	res := []*lspDocumentSymbol{}
+	root, _, err := ParseProgram(source)
+	if err != nil {
+		return res
+	}
+	prog, err := ConvertProgram(root)
+	if err != nil {
+		return res
+	}
+	symbol := func(name string, kind, line int) *lspDocumentSymbol {
+		r := lspLineRange(source, line)
+		return &lspDocumentSymbol{Name: name, Kind: kind, Range: r, SelectionRange: r}
+	}
+	for _, d := range prog.Decls {
+		switch d := d.(type) {
+		case *VarDecl:
+			res = append(res, symbol(d.Name, lspSymbolVariable, d.Line))
+		case *FuncDecl:
+			fn := symbol(d.Name, lspSymbolFunction, d.Line)
+			for _, p := range d.Params {
+				fn.Children = append(fn.Children, symbol(p.Name, lspSymbolVariable, p.Line))
+			}
+			if d.Body != nil {
+				for _, v := range d.Body.Decls {
+					fn.Children = append(fn.Children, symbol(v.Name, lspSymbolVariable, v.Line))
+				}
+			}
+			res = append(res, fn)
+		}
+	}
+	return res
+}
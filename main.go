@@ -1,111 +1,1301 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 	"scan"
 )
 
+// 版本信息
+const VERSION = "CMinusParser/1.0.1"
+
+// 退出码
+const (
+	EXIT_OK    = 0 // 成功
+	EXIT_DIAG  = 1 // 程序有错误(语法错误、语义错误、运行时错误), 或 diff 有差异、query 没有匹配
+	EXIT_USAGE = 2 // 命令行参数有误, 或文件无法读取、创建
+)
+
+// 命令行的消息编号, 两种语言的文本见 cliMessages
+const (
+	MSG_USAGE      = "cli.usage"
+	MSG_USAGE_HELP = "cli.usage-help"
+	MSG_USAGE_EXIT = "cli.usage-exit"
+	MSG_CMD_USAGE  = "cli.cmd-usage"
+	MSG_OPTIONS    = "cli.options"
+	MSG_VERSION    = "cli.version"
+
+	MSG_CMD_LEX             = "cli.cmd.lex"
+	MSG_CMD_PARSE           = "cli.cmd.parse"
+	MSG_CMD_PARSE_LONG      = "cli.cmd.parse-long"
+	MSG_CMD_CHECK           = "cli.cmd.check"
+	MSG_CMD_RUN             = "cli.cmd.run"
+	MSG_CMD_BUILD           = "cli.cmd.build"
+	MSG_CMD_FMT             = "cli.cmd.fmt"
+	MSG_CMD_LSP             = "cli.cmd.lsp"
+	MSG_CMD_REPL            = "cli.cmd.repl"
+	MSG_CMD_QUERY           = "cli.cmd.query"
+	MSG_CMD_DIFF            = "cli.cmd.diff"
+	MSG_CMD_BATCH           = "cli.cmd.batch"
+	MSG_CMD_SIMILARITY      = "cli.cmd.similarity"
+	MSG_CMD_COMPLETION      = "cli.cmd.completion"
+	MSG_CMD_COMPLETION_LONG = "cli.cmd.completion-long"
+	MSG_CMD_VERSION         = "cli.cmd.version"
+	MSG_CMD_HELP            = "cli.cmd.help"
+
+	MSG_FLAG_O              = "cli.flag.o"
+	MSG_FLAG_FORMAT         = "cli.flag.format"
+	MSG_FLAG_TOKENS         = "cli.flag.tokens"
+	MSG_FLAG_AST            = "cli.flag.ast"
+	MSG_FLAG_SYMBOLS        = "cli.flag.symbols"
+	MSG_FLAG_FOLD           = "cli.flag.fold"
+	MSG_FLAG_WNO            = "cli.flag.Wno"
+	MSG_FLAG_WERROR         = "cli.flag.Werror"
+	MSG_FLAG_CHECK_OVERFLOW = "cli.flag.check-overflow"
+	MSG_FLAG_MAX_DEPTH      = "cli.flag.max-depth"
+	MSG_FLAG_MAX_STEPS      = "cli.flag.max-steps"
+	MSG_FLAG_MAX_CELLS      = "cli.flag.max-cells"
+	MSG_FLAG_MAX_OUTPUT     = "cli.flag.max-output"
+	MSG_FLAG_TIMEOUT        = "cli.flag.timeout"
+	MSG_FLAG_DEBUG          = "cli.flag.debug"
+	MSG_FLAG_X              = "cli.flag.x"
+	MSG_FLAG_TARGET         = "cli.flag.target"
+	MSG_FLAG_BUILD_LINT     = "cli.flag.build-lint"
+	MSG_FLAG_LINT           = "cli.flag.lint"
+	MSG_FLAG_SSA            = "cli.flag.ssa"
+	MSG_FLAG_DUMP_LIVENESS  = "cli.flag.dump-liveness"
+	MSG_FLAG_REGS           = "cli.flag.regs"
+	MSG_FLAG_O0             = "cli.flag.O0"
+	MSG_FLAG_O1             = "cli.flag.O1"
+	MSG_FLAG_FNO_CONSTPROP  = "cli.flag.fno-constprop"
+	MSG_FLAG_FNO_COPYPROP   = "cli.flag.fno-copyprop"
+	MSG_FLAG_FNO_DCE        = "cli.flag.fno-dce"
+	MSG_FLAG_FNO_CSE        = "cli.flag.fno-cse"
+	MSG_FLAG_FNO_LICM       = "cli.flag.fno-licm"
+	MSG_FLAG_W              = "cli.flag.w"
+	MSG_FLAG_L              = "cli.flag.l"
+	MSG_FLAG_J              = "cli.flag.j"
+	MSG_FLAG_JSON           = "cli.flag.json"
+	MSG_FLAG_OUTDIR         = "cli.flag.outdir"
+	MSG_FLAG_THRESHOLD      = "cli.flag.threshold"
+	MSG_FLAG_SOURCE         = "cli.flag.source"
+	MSG_FLAG_LANG           = "cli.flag.lang"
+
+	MSG_UNKNOWN_COMMAND     = "cli.unknown-command"
+	MSG_NEED_FILE           = "cli.need-file"
+	MSG_NEED_FILES          = "cli.need-files"
+	MSG_NEED_TWO_FILES      = "cli.need-two-files"
+	MSG_NEED_TWO_FILES_MORE = "cli.need-two-files-more"
+	MSG_NEED_QUERY          = "cli.need-query"
+	MSG_CREATE_FAILED       = "cli.create-failed"
+	MSG_BAD_TARGET          = "cli.bad-target"
+	MSG_BAD_SHELL           = "cli.bad-shell"
+	MSG_BAD_MAX_DEPTH       = "cli.bad-max-depth"
+	MSG_SYNTAX_NO_CODE      = "cli.syntax-no-code"
+	MSG_SEMANTIC_NO_CODE    = "cli.semantic-no-code"
+	MSG_SYNTAX_NO_RUN       = "cli.syntax-no-run"
+	MSG_FMT_NEED_FILES      = "cli.fmt-need-files"
+	MSG_LEX_ONLY            = "cli.lex-only"
+	MSG_READ_FAILED         = "cli.read-failed"
+	MSG_SYNTAX_ERROR        = "cli.syntax-error"
+)
+
+// 命令行的一条消息, 英文和中文两个格式串都必须给出
+type cliMessage struct {
+	en, zh string
+}
+
+// 命令行的消息目录, 选项说明中反引号内的名字用于补全, 两种语言必须相同
+var cliMessages = map[string]cliMessage{
+	MSG_USAGE: {"CMinusParser version: %s\nUsage: %s <command> [options] [arguments]\n\nCommands:",
+		"CMinusParser 版本: %s\n用法: %s <子命令> [选项] [参数]\n\n子命令:"},
+	MSG_USAGE_HELP: {"\nRun \"%s help <command>\" for the options of a command",
+		"\n使用 \"%s help <command>\" 查看子命令的选项"},
+	MSG_USAGE_EXIT: {"Exit status: 0 success, 1 the program has errors (or diff found differences, query found no match), 2 bad arguments or files",
+		"退出码: 0 成功, 1 程序有错误(diff 有差异、query 没有匹配), 2 命令行参数或文件有误"},
+	MSG_CMD_USAGE: {"Usage: %s %s %s\n", "用法: %s %s %s\n"},
+	MSG_OPTIONS:   {"\nOptions:", "\n选项:"},
+	MSG_VERSION:   {"CMinusParser version: %s", "CMinusParser 版本: %s"},
+
+	MSG_CMD_LEX:   {"lexical analysis, print the token sequence", "词法分析, 打印token序列"},
+	MSG_CMD_PARSE: {"syntax analysis, print the syntax tree and symbol table", "语法分析, 打印语法树和符号表"},
+	MSG_CMD_PARSE_LONG: {"Syntax analysis, print the syntax tree and symbol table. With -tokens the token sequence is printed too;\nthe results named by -tokens, -ast and -symbols go to their own files, the rest go to the -o file or standard output",
+		"语法分析, 打印语法树和符号表. 给出 -tokens 时同时打印token序列;\n-tokens、-ast、-symbols 指定的结果输出到各自的文件, 其余输出到 -o 指定的文件或标准输出"},
+	MSG_CMD_CHECK:      {"syntax analysis and static checks, print diagnostics", "语法分析和静态检查, 打印诊断信息"},
+	MSG_CMD_RUN:        {"interpret the program, input reads from standard input", "解释执行程序, 从标准输入读取 input 的数据"},
+	MSG_CMD_BUILD:      {"generate target code", "生成目标代码"},
+	MSG_CMD_FMT:        {"format source files, or standard input when no file is given", "格式化源程序, 没有文件时格式化标准输入"},
+	MSG_CMD_LSP:        {"start the language server, talking to the editor over standard input and output", "启动语言服务器, 通过标准输入输出与编辑器通信"},
+	MSG_CMD_REPL:       {"interactive interpreter", "交互式解释环境"},
+	MSG_CMD_QUERY:      {"query the syntax tree, print the matching positions", "在语法树中查询, 打印匹配的位置"},
+	MSG_CMD_DIFF:       {"compare the syntax trees of two files", "比较两个文件的语法树"},
+	MSG_CMD_BATCH:      {"analyze many files in parallel and print a summary", "并行分析多个文件并打印汇总结果"},
+	MSG_CMD_SIMILARITY: {"compare the similarity of every pair of files", "两两比较文件的相似度"},
+	MSG_CMD_COMPLETION: {"generate a shell completion script", "生成 shell 补全脚本"},
+	MSG_CMD_COMPLETION_LONG: {"Generate a shell completion script, e.g.:\n  source <(%[1]s completion bash)\n  source <(%[1]s completion zsh)",
+		"生成 shell 补全脚本, 如:\n  source <(%[1]s completion bash)\n  source <(%[1]s completion zsh)"},
+	MSG_CMD_VERSION: {"version information", "版本信息"},
+	MSG_CMD_HELP:    {"help information", "帮助信息"},
+
+	MSG_FLAG_O:              {"output file `filename`, - for standard output", "输出文件 `filename`, - 表示标准输出"},
+	MSG_FLAG_FORMAT:         {"output format: text, json, dot", "输出格式: text, json, dot"},
+	MSG_FLAG_TOKENS:         {"write the token sequence to file `filename`", "token序列输出到文件 `filename`"},
+	MSG_FLAG_AST:            {"write the syntax tree to file `filename`", "语法树单独输出到文件 `filename`"},
+	MSG_FLAG_SYMBOLS:        {"write the symbol table to file `filename`", "符号表单独输出到文件 `filename`"},
+	MSG_FLAG_FOLD:           {"constant folding and algebraic simplification", "常量折叠与代数化简"},
+	MSG_FLAG_WNO:            {"disabled warnings, comma-separated names or codes, e.g. unused-param,W104", "关闭的警告, 以逗号分隔的名字或编号, 如 unused-param,W104"},
+	MSG_FLAG_WERROR:         {"treat warnings as errors", "警告也作为错误"},
+	MSG_FLAG_CHECK_OVERFLOW: {"report integer overflow as an error", "整数溢出报错"},
+	MSG_FLAG_MAX_DEPTH:      {"maximum recursion depth", "最大递归深度"},
+	MSG_FLAG_MAX_STEPS:      {"maximum number of steps, 0 for no limit", "最大执行步数, 0表示不限制"},
+	MSG_FLAG_MAX_CELLS:      {"maximum total number of array cells, 0 for the default", "数组单元总数的上限, 0表示使用默认值"},
+	MSG_FLAG_MAX_OUTPUT:     {"maximum number of output bytes, 0 for no limit", "输出字节数的上限, 0表示不限制"},
+	MSG_FLAG_TIMEOUT:        {"maximum running time, e.g. 2s, 0 for no limit", "最长运行时间, 如 2s, 0表示不限制"},
+	MSG_FLAG_DEBUG:          {"run the program in the debugger", "在调试器中执行程序"},
+	MSG_FLAG_X:              {"read debugger commands from file `cmdfile`", "从文件 `cmdfile` 读取调试命令"},
+	MSG_FLAG_TARGET: {"target: x86_64, wasm, c, ir (print the intermediate code), ssa (print the SSA form), regalloc (print the register allocation)",
+		"目标代码: x86_64, wasm, c, ir(打印中间代码), ssa(打印SSA形式), regalloc(打印寄存器分配结果)"},
+	MSG_FLAG_BUILD_LINT:    {"static checks, no target code is generated when there are errors", "静态检查, 有错误时不生成目标代码"},
+	MSG_FLAG_LINT:          {"static checks", "静态检查"},
+	MSG_FLAG_SSA:           {"convert the intermediate code to SSA form and back", "中间代码转换为SSA形式后再转换回来"},
+	MSG_FLAG_DUMP_LIVENESS: {"print the liveness analysis of the intermediate code", "打印中间代码的活跃变量分析结果"},
+	MSG_FLAG_REGS:          {"number of registers for register allocation, 0 for the x86_64 general purpose registers", "寄存器分配使用的寄存器个数, 0表示使用x86_64的通用寄存器"},
+	MSG_FLAG_O0:            {"no intermediate code optimization (default)", "不进行中间代码优化(默认)"},
+	MSG_FLAG_O1:            {"enable all intermediate code optimizations", "打开全部中间代码优化"},
+	MSG_FLAG_FNO_CONSTPROP: {"disable constant propagation", "关闭常量传播"},
+	MSG_FLAG_FNO_COPYPROP:  {"disable copy propagation", "关闭复写传播"},
+	MSG_FLAG_FNO_DCE:       {"disable dead code elimination", "关闭死代码删除"},
+	MSG_FLAG_FNO_CSE:       {"disable common subexpression elimination", "关闭公共子表达式删除"},
+	MSG_FLAG_FNO_LICM:      {"disable loop invariant code motion", "关闭循环不变代码外提"},
+	MSG_FLAG_W:             {"write the result back to the file", "格式化结果写回文件"},
+	MSG_FLAG_L:             {"only list the files whose formatting differs", "只列出格式需要修改的文件"},
+	MSG_FLAG_J:             {"number of workers, 0 for the number of CPUs", "工作协程数, 0表示使用CPU个数"},
+	MSG_FLAG_JSON:          {"print the summary as JSON", "汇总结果输出为JSON"},
+	MSG_FLAG_OUTDIR:        {"write the diagnostics, syntax tree and symbol table of each file to directory `dir`", "每个文件的诊断信息、语法树和符号表输出到目录 `dir`"},
+	MSG_FLAG_THRESHOLD:     {"only report pairs whose similarity is at least this value (0~1)", "只报告相似度不低于该值(0~1)的文件对"},
+	MSG_FLAG_SOURCE:        {"also print the source of the matching regions", "同时打印匹配区域的源程序"},
+	MSG_FLAG_LANG:          {"message language: zh, en; defaults to LC_ALL, LC_MESSAGES or LANG", "提示信息的语言: zh, en, 默认由 LC_ALL、LC_MESSAGES 或 LANG 决定"},
+
+	MSG_UNKNOWN_COMMAND:     {"unknown command: %s", "未知的子命令: %s"},
+	MSG_NEED_FILE:           {"please give the path of one file!", "请输入一个文件完整路径名!"},
+	MSG_NEED_FILES:          {"please give the file paths!", "请输入文件完整路径名!"},
+	MSG_NEED_TWO_FILES:      {"please give the paths of two files!", "请输入两个文件完整路径名!"},
+	MSG_NEED_TWO_FILES_MORE: {"at least two files are needed!", "至少需要两个文件!"},
+	MSG_NEED_QUERY:          {"please give a query!", "请输入查询语句!"},
+	MSG_CREATE_FAILED:       {"cannot create output file %s: %s", "输出文件 %s 创建失败: %s"},
+	MSG_BAD_TARGET:          {"unsupported target: %s", "不支持的目标: %s"},
+	MSG_BAD_SHELL:           {"unsupported shell: %s", "不支持的 shell: %s"},
+	MSG_BAD_MAX_DEPTH:       {"-max-depth must not exceed %d", "-max-depth 不能超过 %d"},
+	MSG_SYNTAX_NO_CODE:      {"syntax errors, no target code generated!", "存在语法错误, 不生成目标代码!"},
+	MSG_SEMANTIC_NO_CODE:    {"semantic errors, no target code generated!", "存在语义错误, 不生成目标代码!"},
+	MSG_SYNTAX_NO_RUN:       {"syntax errors, the program is not run!", "存在语法错误, 不执行程序!"},
+	MSG_FMT_NEED_FILES:      {"-w and -l need file arguments!", "-w 和 -l 需要文件参数!"},
+	MSG_LEX_ONLY:            {"%s: %s, comparing tokens only", "%s: %s, 只比较词法"},
+	MSG_READ_FAILED:         {"%s: read failed", "%s: 读取失败"},
+	MSG_SYNTAX_ERROR:        {"%s: syntax error", "%s: 存在语法错误"},
+}
+
 var (
-	f    string
-	v, V bool
-	h    bool
-	s, p bool
-	c  bool
+	f string // 当前分析的文件
+
+	target string // 目标代码类型
+	o      string // 目标代码或分析结果的输出文件
+
+	// 各分析结果的输出文件和输出格式
+	tokensOut  string
+	astOut     string
+	symbolsOut string
+	formatName string
+
+	fold bool // 常量折叠
+	ssa  bool // 经过SSA形式往返转换
+
+	lint   bool   // 静态检查
+	wno    string // 关闭的警告
+	werror bool   // 警告作为错误
+
+	checkOverflow bool   // 解释执行时检查整数溢出
+	maxDepth      int    // 解释执行时的最大递归深度
+	debug         bool   // 在调试器中执行
+	debugCmds     string // 调试命令文件
+
+	// 解释执行时的资源限制
+	maxSteps  int64
+	timeout   time.Duration
+	maxCells  int64
+	maxOutput int64
+
+	jobs    int    // 批量分析的工作协程数
+	jsonOut bool   // 批量分析的汇总结果输出为JSON
+	outDir  string // 批量分析时每个文件的分析结果输出目录
+
+	threshold  float64 // 相似度报告的下限
+	showSource bool    // 相似度报告中打印匹配区域的源程序
+
+	write    bool // 格式化结果写回文件
+	listDiff bool // 只列出格式需要修改的文件
+
+	dumpLiveness bool // 打印活跃变量分析结果
+	regs         int  // 寄存器分配使用的寄存器个数
+
+	// 中间代码优化选项
+	o0, o1                                        bool
+	noConstProp, noCopyProp, noDCE, noCSE, noLICM bool
+
+	langName string // 界面语言, 由 selectLang 在解析子命令的选项之前处理
 )
 
+// 子命令
+type command struct {
+	name  string
+	args  string                 // 参数形式, 用于帮助信息
+	short string                 // 一行说明
+	long  string                 // 详细说明, 为空时使用 short
+	flags func(fs *flag.FlagSet) // 定义子命令的选项
+	run   func(args []string) int
+	words []string // 补全位置参数时的候选词, 为空时补全文件名
+	fs    *flag.FlagSet
+}
+
+var commands []*command
+
+// 选项取值的候选词, 用于补全
+var flagValues = map[string][]string{
+	"format": {"text", "json", "dot"},
+	"target": {"x86_64", "wasm", "c", "ir", "ssa", "regalloc"},
+	"lang":   {"zh", "en"},
+}
+
 func init() {
-	flag.BoolVar(&h, "h", false, "帮助信息")
-	flag.BoolVar(&v, "v", false, "版本信息")
-	flag.BoolVar(&V, "V", false, "版本信息")
-	flag.BoolVar(&s, "s", false, "词法分析")
-	flag.BoolVar(&p, "p", false, "语法分析")
-	flag.BoolVar(&c, "c", false, "标准输出")
+	msgs := make(map[string]scan.Message)
+	for id, m := range cliMessages {
+		msgs[id] = scan.Message{EN: m.en, ZH: m.zh}
+	}
+	if err := scan.AddMessages(msgs); err != nil {
+		panic(err)
+	}
+}
 
-	flag.StringVar(&f, "f", "", "`filename`")
-	flag.Usage = usage
+// 构造子命令及其选项, 帮助信息使用当前语言, 需要在选择语言之后调用
+func setupCommands() {
+	commands = []*command{
+		{name: "lex", args: "[-o output] [-format text|json|dot] file", short: scan.Msg(MSG_CMD_LEX),
+			flags: outputFlags, run: lexCmd},
+		{name: "parse", args: "[-o output] [-tokens file] [-ast file] [-symbols file] [-format text|json|dot] [-fold] file", short: scan.Msg(MSG_CMD_PARSE),
+			long:  scan.Msg(MSG_CMD_PARSE_LONG),
+			flags: parseFlags, run: parseCmd},
+		{name: "check", args: "[-Wno list] [-Werror] file...", short: scan.Msg(MSG_CMD_CHECK),
+			flags: checkFlags, run: checkCmd},
+		{name: "run", args: "[-debug [-x cmdfile]] [-check-overflow] [-max-xxx N] [-timeout T] file", short: scan.Msg(MSG_CMD_RUN),
+			flags: runFlags, run: runCmd},
+		{name: "build", args: "[-target x86_64|wasm|c|ir|ssa|regalloc] [-ssa] [-O0|-O1] [-fno-xxx] [-lint] [-o output] file", short: scan.Msg(MSG_CMD_BUILD),
+			flags: buildFlags, run: buildCmd},
+		{name: "fmt", args: "[-w] [-l] [file...]", short: scan.Msg(MSG_CMD_FMT),
+			flags: fmtFlags, run: fmtCmd},
+		{name: "lsp", args: "[-Wno list]", short: scan.Msg(MSG_CMD_LSP),
+			flags: lintFlags, run: lspCmd},
+		{name: "repl", args: "[-check-overflow] [-max-xxx N]", short: scan.Msg(MSG_CMD_REPL),
+			flags: interpFlags, run: replCmd},
+		{name: "query", args: "'FuncDecl[name=main] Call[name=output]' file...", short: scan.Msg(MSG_CMD_QUERY),
+			run: query},
+		{name: "diff", args: "old.cm new.cm", short: scan.Msg(MSG_CMD_DIFF),
+			run: diff},
+		{name: "batch", args: "[-j N] [-json] [-lint] [-Wno list] [-outdir dir] file|glob|dir...", short: scan.Msg(MSG_CMD_BATCH),
+			flags: batchFlags, run: batch},
+		{name: "similarity", args: "[-threshold 0.5] [-source] dir|file...", short: scan.Msg(MSG_CMD_SIMILARITY),
+			flags: similarityFlags, run: similarity},
+		{name: "completion", args: "bash|zsh", short: scan.Msg(MSG_CMD_COMPLETION),
+			long: scan.Msg(MSG_CMD_COMPLETION_LONG, progName()),
+			run:  completionCmd, words: []string{"bash", "zsh"}},
+		{name: "version", short: scan.Msg(MSG_CMD_VERSION), run: versionCmd},
+		{name: "help", args: "[command]", short: scan.Msg(MSG_CMD_HELP), run: helpCmd},
+	}
+	for _, cmd := range commands {
+		cmd.fs = flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		if cmd.flags != nil {
+			cmd.flags(cmd.fs)
+		}
+		cmd.fs.StringVar(&langName, "lang", "", scan.Msg(MSG_FLAG_LANG))
+		c := cmd
+		cmd.fs.Usage = func() { cmdUsage(c, os.Stderr) }
+		if cmd.name == "help" {
+			for _, other := range commands {
+				cmd.words = append(cmd.words, other.name)
+			}
+		}
+	}
+	var names []string
+	for name := range scan.WARNING_NAMES {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	flagValues["Wno"] = names
 }
 
-func usage() {
-	fmt.Fprintf(os.Stderr, `CMinusParser version: CMinusParser/1.0.1
-Usage: CMinusParser [-hvV] -sp -f filename
+// 输出文件和输出格式选项
+func outputFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o, "o", "-", scan.Msg(MSG_FLAG_O))
+	fs.StringVar(&formatName, "format", "text", scan.Msg(MSG_FLAG_FORMAT))
+}
 
-Options:
-`)
-	flag.PrintDefaults()
+// 语法分析选项
+func parseFlags(fs *flag.FlagSet) {
+	outputFlags(fs)
+	fs.StringVar(&tokensOut, "tokens", "", scan.Msg(MSG_FLAG_TOKENS))
+	fs.StringVar(&astOut, "ast", "", scan.Msg(MSG_FLAG_AST))
+	fs.StringVar(&symbolsOut, "symbols", "", scan.Msg(MSG_FLAG_SYMBOLS))
+	fs.BoolVar(&fold, "fold", false, scan.Msg(MSG_FLAG_FOLD))
 }
 
-func main() {
-	flag.Parse()
+// 关闭警告的选项
+func lintFlags(fs *flag.FlagSet) {
+	fs.StringVar(&wno, "Wno", "", scan.Msg(MSG_FLAG_WNO))
+}
 
-	if h {
-		flag.Usage()
-		return
+// 静态检查选项
+func checkFlags(fs *flag.FlagSet) {
+	lintFlags(fs)
+	fs.BoolVar(&werror, "Werror", false, scan.Msg(MSG_FLAG_WERROR))
+}
+
+// 解释执行选项
+func interpFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&checkOverflow, "check-overflow", false, scan.Msg(MSG_FLAG_CHECK_OVERFLOW))
+	fs.IntVar(&maxDepth, "max-depth", scan.INTERP_DEFAULT_MAX_DEPTH, scan.Msg(MSG_FLAG_MAX_DEPTH))
+	fs.Int64Var(&maxSteps, "max-steps", 0, scan.Msg(MSG_FLAG_MAX_STEPS))
+	fs.Int64Var(&maxCells, "max-cells", 0, scan.Msg(MSG_FLAG_MAX_CELLS))
+	fs.Int64Var(&maxOutput, "max-output", 0, scan.Msg(MSG_FLAG_MAX_OUTPUT))
+}
+
+// 执行程序的选项
+func runFlags(fs *flag.FlagSet) {
+	interpFlags(fs)
+	fs.DurationVar(&timeout, "timeout", 0, scan.Msg(MSG_FLAG_TIMEOUT))
+	fs.BoolVar(&debug, "debug", false, scan.Msg(MSG_FLAG_DEBUG))
+	fs.StringVar(&debugCmds, "x", "", scan.Msg(MSG_FLAG_X))
+}
+
+// 生成目标代码的选项
+func buildFlags(fs *flag.FlagSet) {
+	fs.StringVar(&target, "target", "x86_64", scan.Msg(MSG_FLAG_TARGET))
+	fs.StringVar(&o, "o", "-", scan.Msg(MSG_FLAG_O))
+	fs.BoolVar(&fold, "fold", false, scan.Msg(MSG_FLAG_FOLD))
+	fs.BoolVar(&lint, "lint", false, scan.Msg(MSG_FLAG_BUILD_LINT))
+	lintFlags(fs)
+	fs.BoolVar(&ssa, "ssa", false, scan.Msg(MSG_FLAG_SSA))
+	fs.BoolVar(&dumpLiveness, "dump-liveness", false, scan.Msg(MSG_FLAG_DUMP_LIVENESS))
+	fs.IntVar(&regs, "regs", 0, scan.Msg(MSG_FLAG_REGS))
+	fs.BoolVar(&o0, "O0", false, scan.Msg(MSG_FLAG_O0))
+	fs.BoolVar(&o1, "O1", false, scan.Msg(MSG_FLAG_O1))
+	fs.BoolVar(&noConstProp, "fno-constprop", false, scan.Msg(MSG_FLAG_FNO_CONSTPROP))
+	fs.BoolVar(&noCopyProp, "fno-copyprop", false, scan.Msg(MSG_FLAG_FNO_COPYPROP))
+	fs.BoolVar(&noDCE, "fno-dce", false, scan.Msg(MSG_FLAG_FNO_DCE))
+	fs.BoolVar(&noCSE, "fno-cse", false, scan.Msg(MSG_FLAG_FNO_CSE))
+	fs.BoolVar(&noLICM, "fno-licm", false, scan.Msg(MSG_FLAG_FNO_LICM))
+}
+
+// 格式化选项
+func fmtFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&write, "w", false, scan.Msg(MSG_FLAG_W))
+	fs.BoolVar(&listDiff, "l", false, scan.Msg(MSG_FLAG_L))
+}
+
+// 批量分析选项
+func batchFlags(fs *flag.FlagSet) {
+	fs.IntVar(&jobs, "j", 0, scan.Msg(MSG_FLAG_J))
+	fs.BoolVar(&jsonOut, "json", false, scan.Msg(MSG_FLAG_JSON))
+	fs.BoolVar(&lint, "lint", false, scan.Msg(MSG_FLAG_LINT))
+	lintFlags(fs)
+	fs.StringVar(&outDir, "outdir", "", scan.Msg(MSG_FLAG_OUTDIR))
+}
+
+// 相似度检测选项
+func similarityFlags(fs *flag.FlagSet) {
+	fs.Float64Var(&threshold, "threshold", 0, scan.Msg(MSG_FLAG_THRESHOLD))
+	fs.BoolVar(&showSource, "source", false, scan.Msg(MSG_FLAG_SOURCE))
+}
+
+// 程序名
+func progName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// 总的帮助信息
+func usage(file *os.File) {
+	fmt.Fprintln(file, scan.Msg(MSG_USAGE, VERSION, progName()))
+	for _, cmd := range commands {
+		fmt.Fprintf(file, "  %-11s %s\n", cmd.name, cmd.short)
 	}
+	fmt.Fprintln(file, scan.Msg(MSG_USAGE_HELP, progName()))
+	fmt.Fprintln(file, scan.Msg(MSG_USAGE_EXIT))
+}
 
-	if v || V {
-		fmt.Fprintf(os.Stderr, `CMinusParser version: CMinusParser/1.0.1`)
-		return
+// 子命令的帮助信息
+func cmdUsage(cmd *command, file *os.File) {
+	fmt.Fprintln(file, scan.Msg(MSG_CMD_USAGE, progName(), cmd.name, cmd.args))
+	if len(cmd.long) != 0 {
+		fmt.Fprintln(file, cmd.long)
+	} else {
+		fmt.Fprintln(file, cmd.short)
 	}
+	hasFlags := false
+	cmd.fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintln(file, scan.Msg(MSG_OPTIONS))
+		cmd.fs.SetOutput(file)
+		cmd.fs.PrintDefaults()
+	}
+}
 
-	if len(f) == 0 {
-		fmt.Println("请输入文件完整路径名!")
-		return
+// 查找子命令
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
 	}
+	return nil
+}
 
-	filename := f
+// 选择界面语言: 命令行中的 -lang 优先, 其次为环境变量 LC_ALL、LC_MESSAGES、LANG, 都没有时使用中文
+// -lang 可以出现在子命令之前或之后的任何位置, 处理后从参数中去掉; 子命令中定义的 -lang 选项只用于帮助信息和补全
+func selectLang(args []string) ([]string, error) {
+	lang, ok := scan.LangFromEnv()
+	if !ok {
+		lang = scan.LANG_ZH
+	}
+	scan.SetLang(lang)
+	var res []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			res = append(res, args[i:]...)
+			break
+		}
+		name, value := strings.TrimLeft(arg, "-"), ""
+		if !strings.HasPrefix(arg, "-") || !strings.HasPrefix(name, "lang") {
+			res = append(res, arg)
+			continue
+		}
+		switch {
+		case name == "lang" && i+1 < len(args):
+			value = args[i+1]
+		case strings.HasPrefix(name, "lang="):
+			value = name[len("lang="):]
+		default:
+			res = append(res, arg)
+			continue
+		}
+		lang, err := scan.ParseLang(value)
+		if err != nil {
+			return nil, err
+		}
+		scan.SetLang(lang)
+		if name == "lang" {
+			i++
+		}
+	}
+	return res, nil
+}
 
-	// 获取文件名字
-	stat, err := os.Stat(filename)
+func main() {
+	args, err := selectLang(os.Args[1:])
+	setupCommands()
 	if err != nil {
-		fmt.Println("输入文件有误!")
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(EXIT_USAGE)
+	}
+	if len(args) < 1 {
+		usage(os.Stderr)
+		os.Exit(EXIT_USAGE)
+	}
+	name := args[0]
+	switch name {
+	case "-h", "-help", "--help":
+		usage(os.Stdout)
 		return
+	case "-v", "-V", "-version", "--version":
+		name = "version"
 	}
-	name := stat.Name()
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_UNKNOWN_COMMAND, name))
+		fmt.Fprintln(os.Stderr)
+		usage(os.Stderr)
+		os.Exit(EXIT_USAGE)
+	}
+	if err := cmd.fs.Parse(args[1:]); err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		os.Exit(EXIT_USAGE)
+	}
+	os.Exit(cmd.run(cmd.fs.Args()))
+}
 
-	// 获取输入文件路径
-	dir := strings.TrimRight(filename, name)
+// 子命令需要恰好一个文件时检查参数并读取文件
+func oneFile(name string, args []string) (string, int) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_NEED_FILE))
+		findCommand(name).fs.Usage()
+		return "", EXIT_USAGE
+	}
+	f = args[0]
+	source, ok := readSource(f)
+	if !ok {
+		return "", EXIT_USAGE
+	}
+	return source, EXIT_OK
+}
+
+// 读取文件, 错误信息打印到标准错误
+func readSource(filename string) (string, bool) {
+	source, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return "", false
+	}
+	return string(source), true
+}
+
+// 分析源程序, 有语法错误时把诊断信息打印到标准错误
+func parseSource(filename, source string) (*scan.ASTNode, bool) {
+	astRoot, diags, err := scan.ParseProgram(source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s:\n", filename)
+		scan.HelpPrintDiagnostics(diags, os.Stderr)
+		return nil, false
+	}
+	return astRoot, true
+}
+
+// 词法分析
+func lexCmd(args []string) int {
+	source, status := oneFile("lex", args)
+	if status != EXIT_OK {
+		return status
+	}
+	format, err := scan.ParseOutputFormat(formatName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return EXIT_USAGE
+	}
+	a := &scan.Artifacts{Tokens: scan.ScanTokens(scan.NewStringBuffer(source))}
+	if err := printArtifacts(a, scan.ARTIFACT_TOKENS, format); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return EXIT_USAGE
+	}
+	for _, t := range a.Tokens {
+		if t.Token == scan.ERROR {
+			return EXIT_DIAG
+		}
+	}
+	return EXIT_OK
+}
 
-	//fmt.Println(dir)  // C:/Users/lzff1/Desktop/
+// 语法分析
+func parseCmd(args []string) int {
+	source, status := oneFile("parse", args)
+	if status != EXIT_OK {
+		return status
+	}
+	format, err := scan.ParseOutputFormat(formatName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return EXIT_USAGE
+	}
+	parser := scan.NewParser(scan.NewStringBuffer(source))
+	parser.SetOutput(nil)
+	parser.SetQuiet(true)
+	astRoot, tableRoot := parser.Parse()
+	diags := parser.Diagnostics()
+	if fold && len(diags) == 0 {
+		var foldDiags []scan.Diagnostic
+		astRoot, foldDiags = scan.FoldConstants(astRoot)
+		diags = scan.FilterDiagnostics(foldDiags, disabledWarnings())
+	}
+	scan.HelpPrintDiagnostics(diags, os.Stderr)
 
+	parts := scan.ARTIFACT_AST | scan.ARTIFACT_SYMBOLS
+	if len(tokensOut) != 0 {
+		parts |= scan.ARTIFACT_TOKENS
+	}
+	a := &scan.Artifacts{Tokens: scan.ScanTokens(scan.NewStringBuffer(source)), AST: astRoot, Symbols: tableRoot}
+	if err := printArtifacts(a, parts, format); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return EXIT_USAGE
+	}
+	if scan.CountErrors(diags) > 0 {
+		return EXIT_DIAG
+	}
+	return EXIT_OK
+}
 
-	newName := dir + "CMinusParserOut.txt"
+// 按输出文件分组打印分析结果: -tokens、-ast、-symbols 指定的结果输出到各自的文件, 其余输出到 -o 指定的文件
+// 输出到同一文件的结果一起打印, 这样 json 格式的输出仍是一个合法的 JSON 值
+func printArtifacts(a *scan.Artifacts, parts int, format scan.OutputFormat) error {
+	var names []string
+	groups := make(map[string]int)
+	for _, dest := range []struct {
+		part int
+		name string
+	}{{scan.ARTIFACT_TOKENS, tokensOut}, {scan.ARTIFACT_AST, astOut}, {scan.ARTIFACT_SYMBOLS, symbolsOut}} {
+		if parts&dest.part == 0 {
+			continue
+		}
+		name := dest.name
+		if len(name) == 0 {
+			name = o
+		}
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] |= dest.part
+	}
+	for _, name := range names {
+		file, err := createOutput(name)
+		if err != nil {
+			return err
+		}
+		err = scan.HelpPrintArtifacts(a, groups[name], format, file)
+		if file != os.Stdout {
+			file.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// 先将文件删除
-	err = os.Remove(newName)
+// 创建输出文件, 已存在时清空, 为空或 - 时表示标准输出
+func createOutput(name string) (*os.File, error) {
+	if len(name) == 0 || name == "-" {
+		return os.Stdout, nil
+	}
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
 	if err != nil {
-		// 删除失败不需要提示
+		return nil, errors.New(scan.Msg(MSG_CREATE_FAILED, name, err.Error()))
 	}
+	return file, nil
+}
 
-	// 输出到标准输出
-	if c {
-		scan.FileOut = os.Stdout
-	} else {
-		// 新建一个文件作为输入
-		scan.FileOut, err = os.OpenFile(newName, os.O_CREATE|os.O_APPEND, 0777)
+// 语法分析和静态检查, 打印各文件的诊断信息
+func checkCmd(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_NEED_FILES))
+		findCommand("check").fs.Usage()
+		return EXIT_USAGE
+	}
+	status := EXIT_OK
+	for _, filename := range args {
+		source, ok := readSource(filename)
+		if !ok {
+			return EXIT_USAGE
+		}
+		astRoot, diags, err := scan.ParseProgram(source)
+		if err == nil {
+			diags = append(diags, scan.FilterDiagnostics(scan.Analyze(astRoot), disabledWarnings())...)
+		}
+		if len(diags) != 0 {
+			fmt.Printf("%s:\n", filename)
+			scan.HelpPrintDiagnostics(diags, os.Stdout)
+		}
+		if scan.CountErrors(diags) > 0 || werror && len(diags) > 0 {
+			status = EXIT_DIAG
+		}
+	}
+	return status
+}
+
+// 生成目标代码
+func buildCmd(args []string) int {
+	source, status := oneFile("build", args)
+	if status != EXIT_OK {
+		return status
+	}
+	switch target {
+	case "x86_64", "x86-64", "amd64", "wasm", "wat", "c", "ir", "ssa", "regalloc":
+	default:
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_BAD_TARGET, target))
+		return EXIT_USAGE
+	}
+	astRoot, ok := parseSource(f, source)
+	if !ok {
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_SYNTAX_NO_CODE))
+		return EXIT_DIAG
+	}
+	if lint {
+		diags := scan.FilterDiagnostics(scan.Analyze(astRoot), disabledWarnings())
+		scan.HelpPrintDiagnostics(diags, os.Stderr)
+		if scan.CountErrors(diags) > 0 {
+			fmt.Fprintln(os.Stderr, scan.Msg(MSG_SEMANTIC_NO_CODE))
+			return EXIT_DIAG
+		}
+	}
+	if fold {
+		var diags []scan.Diagnostic
+		astRoot, diags = scan.FoldConstants(astRoot)
+		scan.HelpPrintDiagnostics(scan.FilterDiagnostics(diags, disabledWarnings()), os.Stderr)
+	}
+
+	out, err := createOutput(o)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return EXIT_USAGE
+	}
+	if out != os.Stdout {
+		defer out.Close()
+	}
+
+	switch target {
+	case "x86_64", "x86-64", "amd64":
+		err = scan.NewX86Generator(out).Generate(astRoot)
+	case "wasm", "wat":
+		err = scan.NewWATGenerator(out).Generate(astRoot)
+	case "c":
+		err = scan.NewCGenerator(out, f).Generate(astRoot)
+	case "ir", "ssa", "regalloc":
+		var prog *scan.IRProgram
+		prog, err = scan.GenerateIR(astRoot)
 		if err != nil {
-			fmt.Println("输出文件创建失败!")
-			return
+			break
+		}
+		scan.Optimize(prog, optOptions())
+		if target == "ssa" || ssa {
+			if err = scan.BuildProgramSSA(prog); err != nil {
+				break
+			}
+		}
+		if target != "ssa" && ssa {
+			scan.DestroyProgramSSA(prog)
+			scan.Optimize(prog, optOptions()) // 清理消除phi时引入的复制
 		}
+		scan.HelpPrintIR(prog, out)
+		if dumpLiveness {
+			for _, fn := range prog.Funcs {
+				scan.HelpPrintLiveness(fn, out)
+			}
+		}
+		if target == "regalloc" {
+			regTarget := scan.X86_64Registers
+			if regs > 0 {
+				regTarget = scan.GenericRegisters(regs)
+			}
+			for _, fn := range prog.Funcs {
+				scan.HelpPrintAllocation(scan.AllocateRegisters(fn, regTarget), out)
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return EXIT_DIAG
 	}
+	return EXIT_OK
+}
 
+// 解释执行
+func runCmd(args []string) int {
+	if !checkInterpOptions("run") {
+		return EXIT_USAGE
+	}
+	source, status := oneFile("run", args)
+	if status != EXIT_OK {
+		return status
+	}
+	astRoot, ok := parseSource(f, source)
+	if !ok {
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_SYNTAX_NO_RUN))
+		return EXIT_DIAG
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	stdin := bufio.NewReader(os.Stdin) // 没有命令文件时调试命令和程序输入共用标准输入
+	it, err := scan.NewInterpreter(astRoot, stdin, os.Stdout, interpOptions())
+	if err == nil && debug {
+		err = runDebugger(it, source, stdin)
+	} else if err == nil {
+		err = it.RunContext(ctx)
+	}
+	if rtErr, ok := err.(*scan.RuntimeError); ok {
+		scan.HelpPrintRuntimeError(rtErr, os.Stderr)
+		return EXIT_DIAG
+	} else if limErr, ok := err.(*scan.LimitError); ok {
+		scan.HelpPrintLimitError(limErr, os.Stderr)
+		return EXIT_DIAG
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return EXIT_DIAG
+	}
+	return EXIT_OK
+}
 
-	// 初始化缓冲区
-	scan.BufferConst = scan.NewBuffer(filename)
+// 在调试器中执行程序
+func runDebugger(it *scan.Interpreter, source string, stdin io.Reader) error {
+	cmds, echo := stdin, false
+	if len(debugCmds) != 0 {
+		file, err := os.Open(debugCmds)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		cmds, echo = file, true
+	}
+	return scan.NewDebugger(it, source, cmds, os.Stdout, echo).Run()
+}
+
+// 检查解释执行选项,有误时打印提示并返回 false
+func checkInterpOptions(name string) bool {
+	if maxDepth > scan.INTERP_MAX_DEPTH_LIMIT {
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_BAD_MAX_DEPTH, scan.INTERP_MAX_DEPTH_LIMIT))
+		findCommand(name).fs.Usage()
+		return false
+	}
+	return true
+}
 
-	// 语法分析
-	if p {
-		scan.ParserConst = scan.NewParser(scan.BufferConst)
-		astRoot, tableRoot := scan.ParserConst.Parse()
-		scan.HelpPrintTree(astRoot, 0, '-', scan.FileOut)
-		scan.HelpPrintTable(tableRoot, 0, '-',  scan.FileOut)
-	} else if s {
-		// 只进行词法分析
-		scan.ScannerConst = scan.NewScanner(scan.BufferConst)
-		scan.ScannerConst.ScanAll()
+// 命令行参数中的解释执行选项
+func interpOptions() scan.InterpOptions {
+	return scan.InterpOptions{
+		CheckOverflow: checkOverflow,
+		MaxDepth:      maxDepth,
+		MaxSteps:      maxSteps,
+		MaxCells:      maxCells,
+		MaxOutput:     maxOutput,
+	}
+}
+
+// 交互式解释环境
+func replCmd(args []string) int {
+	if !checkInterpOptions("repl") {
+		return EXIT_USAGE
+	}
+	scan.NewRepl(os.Stdin, os.Stdout, interpOptions()).Run()
+	return EXIT_OK
+}
+
+// 格式化源程序: 没有 -w 和 -l 时打印格式化结果
+func fmtCmd(args []string) int {
+	if len(args) == 0 {
+		if write || listDiff {
+			fmt.Fprintln(os.Stderr, scan.Msg(MSG_FMT_NEED_FILES))
+			return EXIT_USAGE
+		}
+		source, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return EXIT_USAGE
+		}
+		return formatFile("<stdin>", string(source))
+	}
+	status := EXIT_OK
+	for _, filename := range args {
+		source, ok := readSource(filename)
+		if !ok {
+			return EXIT_USAGE
+		}
+		if s := formatFile(filename, source); s > status {
+			status = s
+		}
+	}
+	return status
+}
+
+// 格式化一个文件
+func formatFile(filename, source string) int {
+	text, diags, err := scan.Format(source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s:\n", filename)
+		scan.HelpPrintDiagnostics(diags, os.Stderr)
+		if len(diags) == 0 {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		return EXIT_DIAG
+	}
+	if listDiff && text != source {
+		fmt.Println(filename)
+	}
+	if write && text != source {
+		if err := ioutil.WriteFile(filename, []byte(text), 0666); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return EXIT_USAGE
+		}
+	}
+	if !write && !listDiff {
+		fmt.Print(text)
+	}
+	return EXIT_OK
+}
+
+// 语言服务器
+func lspCmd(args []string) int {
+	if err := scan.NewLanguageServer(os.Stdin, os.Stdout, disabledWarnings()).Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return EXIT_DIAG
+	}
+	return EXIT_OK
+}
+
+// 版本信息
+func versionCmd(args []string) int {
+	fmt.Println(scan.Msg(MSG_VERSION, VERSION))
+	return EXIT_OK
+}
+
+// 帮助信息
+func helpCmd(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stdout)
+		return EXIT_OK
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_UNKNOWN_COMMAND, args[0]))
+		return EXIT_USAGE
+	}
+	cmdUsage(cmd, os.Stdout)
+	return EXIT_OK
+}
+
+// 在各个文件的语法树中查询,打印匹配的位置
+// 有匹配时返回0,没有匹配时返回1,查询或文件有误时返回2
+func query(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_NEED_QUERY))
+		return EXIT_USAGE
+	}
+	q, err := scan.CompileQuery(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return EXIT_USAGE
+	}
+	files := args[1:]
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_NEED_FILES))
+		return EXIT_USAGE
+	}
+	status := EXIT_DIAG
+	for _, filename := range files {
+		astRoot, err := parseFile(filename)
+		if err != nil {
+			return EXIT_USAGE
+		}
+		matches := q.Match(astRoot)
+		scan.HelpPrintMatches(filename, matches, os.Stdout)
+		if len(matches) > 0 {
+			status = EXIT_OK
+		}
+	}
+	return status
+}
+
+// 比较两个文件的语法树,打印插入、删除、修改和移动的节点
+// 相同时返回0,不同时返回1,文件有误时返回2
+func diff(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_NEED_TWO_FILES))
+		return EXIT_USAGE
+	}
+	var roots [2]*scan.ASTNode
+	for i, filename := range args {
+		astRoot, err := parseFile(filename)
+		if err != nil {
+			return EXIT_USAGE
+		}
+		roots[i] = astRoot
+	}
+	edits := scan.DiffAST(roots[0], roots[1])
+	scan.HelpPrintEdits(args[0], args[1], edits, os.Stdout)
+	if len(edits) > 0 {
+		return EXIT_DIAG
+	}
+	return EXIT_OK
+}
+
+// 并行分析多个文件并打印汇总结果
+// 全部文件没有错误时返回0,有文件存在错误或无法读取时返回1,参数有误时返回2
+func batch(args []string) int {
+	files, err := scan.ExpandPaths(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return EXIT_USAGE
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_NEED_FILES))
+		return EXIT_USAGE
+	}
+	if len(outDir) != 0 {
+		if err := os.MkdirAll(outDir, 0777); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return EXIT_USAGE
+		}
+	}
+	res := scan.RunBatch(files, scan.BatchOptions{Workers: jobs, Lint: lint, Disabled: disabledWarnings(), OutDir: outDir})
+	if jsonOut {
+		scan.HelpPrintBatchJSON(res, os.Stdout)
 	} else {
-		flag.Usage()
+		scan.HelpPrintBatch(res, os.Stdout)
+	}
+	if ok, _, _, _, _ := res.Count(); ok != len(res.Files) {
+		return EXIT_DIAG
+	}
+	return EXIT_OK
+}
+
+// 两两比较目录中(递归)的 .cm 文件和指定的文件,按相似度从高到低打印
+func similarity(args []string) int {
+	var files []string
+	for _, arg := range args {
+		stat, err := os.Stat(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return EXIT_USAGE
+		}
+		if !stat.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && filepath.Ext(path) == ".cm" {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	if len(files) < 2 {
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_NEED_TWO_FILES_MORE))
+		return EXIT_USAGE
+	}
+	var subs []*scan.Submission
+	for _, filename := range files {
+		source, ok := readSource(filename)
+		if !ok {
+			return EXIT_USAGE
+		}
+		sub := scan.NewSubmission(filename, source)
+		if sub.Err != nil {
+			fmt.Fprintln(os.Stderr, scan.Msg(MSG_LEX_ONLY, filename, sub.Err.Error()))
+		}
+		subs = append(subs, sub)
+	}
+	scan.HelpPrintSimilarity(scan.CompareSubmissions(subs), threshold, showSource, os.Stdout)
+	return EXIT_OK
+}
+
+// 读取并分析文件,错误信息打印到标准错误
+func parseFile(filename string) (*scan.ASTNode, error) {
+	source, ok := readSource(filename)
+	if !ok {
+		return nil, errors.New(scan.Msg(MSG_READ_FAILED, filename))
+	}
+	astRoot, ok := parseSource(filename, source)
+	if !ok {
+		return nil, errors.New(scan.Msg(MSG_SYNTAX_ERROR, filename))
+	}
+	return astRoot, nil
+}
+
+// 生成 shell 补全脚本
+func completionCmd(args []string) int {
+	if len(args) != 1 {
+		findCommand("completion").fs.Usage()
+		return EXIT_USAGE
+	}
+	switch args[0] {
+	case "bash":
+		completionBash(os.Stdout)
+	case "zsh":
+		completionZsh(os.Stdout)
+	default:
+		fmt.Fprintln(os.Stderr, scan.Msg(MSG_BAD_SHELL, args[0]))
+		return EXIT_USAGE
+	}
+	return EXIT_OK
+}
+
+// 选项的补全方式: 候选词、文件名(file)、目录名(dir), 布尔选项(bool)和其他选项没有补全
+func flagCompletion(fl *flag.Flag) (words []string, kind string) {
+	if b, ok := fl.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+		return nil, "bool"
+	}
+	if words, ok := flagValues[fl.Name]; ok {
+		return words, ""
+	}
+	name, _ := flag.UnquoteUsage(fl)
+	switch {
+	case name == "dir":
+		return nil, "dir"
+	case strings.Contains(name, "file"):
+		return nil, "file"
+	}
+	return nil, ""
+}
+
+// shell 函数名中不能出现的字符换成下划线
+func shellIdent(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// bash 补全脚本
+func completionBash(file *os.File) {
+	prog := progName()
+	fn := "_" + shellIdent(prog)
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	fmt.Fprintf(file, "# bash completion for %s\n", prog)
+	fmt.Fprintf(file, "# source <(%s completion bash)\n", prog)
+	fmt.Fprintf(file, "%s() {\n", fn)
+	fmt.Fprintln(file, `    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" opts words`)
+	fmt.Fprintln(file, `    if [ "$COMP_CWORD" -eq 1 ]; then`)
+	fmt.Fprintf(file, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintln(file, "        return")
+	fmt.Fprintln(file, "    fi")
+	fmt.Fprintln(file, `    case "${COMP_WORDS[1]} $prev" in`)
+	for _, cmd := range commands {
+		cmd.fs.VisitAll(func(fl *flag.Flag) {
+			words, kind := flagCompletion(fl)
+			if kind == "bool" {
+				return
+			}
+			fmt.Fprintf(file, "    %q)\n", cmd.name+" -"+fl.Name)
+			switch {
+			case len(words) != 0:
+				fmt.Fprintf(file, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(words, " "))
+			case kind == "file":
+				fmt.Fprintln(file, `        COMPREPLY=($(compgen -f -- "$cur"))`)
+			case kind == "dir":
+				fmt.Fprintln(file, `        COMPREPLY=($(compgen -d -- "$cur"))`)
+			default:
+				fmt.Fprintln(file, "        COMPREPLY=()")
+			}
+			fmt.Fprintln(file, "        return ;;")
+		})
+	}
+	fmt.Fprintln(file, "    esac")
+	fmt.Fprintln(file, `    case "${COMP_WORDS[1]}" in`)
+	for _, cmd := range commands {
+		var opts []string
+		cmd.fs.VisitAll(func(fl *flag.Flag) { opts = append(opts, "-"+fl.Name) })
+		fmt.Fprintf(file, "    %s) opts=%q words=%q ;;\n", cmd.name, strings.Join(opts, " "), strings.Join(cmd.words, " "))
+	}
+	fmt.Fprintln(file, "    esac")
+	fmt.Fprintln(file, `    if [[ "$cur" == -* ]]; then`)
+	fmt.Fprintln(file, `        COMPREPLY=($(compgen -W "$opts" -- "$cur"))`)
+	fmt.Fprintln(file, `    elif [ -n "$words" ]; then`)
+	fmt.Fprintln(file, `        COMPREPLY=($(compgen -W "$words" -- "$cur"))`)
+	fmt.Fprintln(file, "    else")
+	fmt.Fprintln(file, `        COMPREPLY=($(compgen -f -- "$cur"))`)
+	fmt.Fprintln(file, "    fi")
+	fmt.Fprintln(file, "}")
+	fmt.Fprintf(file, "complete -o filenames -F %s %s\n", fn, prog)
+}
+
+// zsh 补全说明中的特殊字符转义
+func zshQuote(s string) string {
+	s = strings.Replace(s, "\n", " ", -1)
+	s = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(s)
+	return strings.Replace(s, "'", `'\''`, -1)
+}
+
+// zsh 补全脚本
+func completionZsh(file *os.File) {
+	prog := progName()
+	fn := "_" + shellIdent(prog)
+	fmt.Fprintf(file, "#compdef %s\n", prog)
+	fmt.Fprintf(file, "# source <(%s completion zsh)\n\n", prog)
+	fmt.Fprintf(file, "%s() {\n", fn)
+	fmt.Fprintln(file, "    local -a commands")
+	fmt.Fprintln(file, "    commands=(")
+	for _, cmd := range commands {
+		fmt.Fprintf(file, "        '%s:%s'\n", cmd.name, zshQuote(cmd.short))
+	}
+	fmt.Fprintln(file, "    )")
+	fmt.Fprintln(file, "    if (( CURRENT == 2 )); then")
+	fmt.Fprintln(file, "        _describe -t commands 'command' commands")
+	fmt.Fprintln(file, "        return")
+	fmt.Fprintln(file, "    fi")
+	fmt.Fprintln(file, "    local cmd=$words[2]")
+	fmt.Fprintln(file, "    shift words")
+	fmt.Fprintln(file, "    (( CURRENT-- ))")
+	fmt.Fprintln(file, "    case $cmd in")
+	for _, cmd := range commands {
+		fmt.Fprintf(file, "    %s)\n", cmd.name)
+		fmt.Fprint(file, "        _arguments")
+		cmd.fs.VisitAll(func(fl *flag.Flag) {
+			_, usage := flag.UnquoteUsage(fl)
+			spec := fmt.Sprintf("-%s[%s]", fl.Name, zshQuote(usage))
+			words, kind := flagCompletion(fl)
+			switch {
+			case len(words) != 0:
+				spec += fmt.Sprintf(":%s:(%s)", fl.Name, strings.Join(words, " "))
+			case kind == "file":
+				spec += ":filename:_files"
+			case kind == "dir":
+				spec += ":dir:_files -/"
+			case kind != "bool":
+				spec += ":" + fl.Name + ": "
+			}
+			fmt.Fprintf(file, " \\\n            '%s'", spec)
+		})
+		switch {
+		case len(cmd.words) != 0:
+			fmt.Fprintf(file, " \\\n            '*:argument:(%s)'", strings.Join(cmd.words, " "))
+		case strings.Contains(cmd.args, "file") || strings.Contains(cmd.args, ".cm"):
+			fmt.Fprint(file, " \\\n            '*:file:_files'")
+		}
+		fmt.Fprintln(file)
+		fmt.Fprintln(file, "        ;;")
+	}
+	fmt.Fprintln(file, "    esac")
+	fmt.Fprintln(file, "}")
+	fmt.Fprintf(file, "\ncompdef %s %s\n", fn, prog)
+}
+
+// 根据命令行参数得到中间代码优化选项
+func optOptions() scan.OptOptions {
+	level := 0
+	if o1 && !o0 {
+		level = 1
+	}
+	opts := scan.OptLevel(level)
+	opts.ConstProp = opts.ConstProp && !noConstProp
+	opts.CopyProp = opts.CopyProp && !noCopyProp
+	opts.DCE = opts.DCE && !noDCE
+	opts.CSE = opts.CSE && !noCSE
+	opts.LICM = opts.LICM && !noLICM
+	return opts
+}
+
+// 命令行中关闭的警告编号
+func disabledWarnings() map[string]bool {
+	res := make(map[string]bool)
+	for _, w := range strings.Split(wno, ",") {
+		w = strings.TrimSpace(w)
+		if code, ok := scan.WARNING_NAMES[w]; ok {
+			res[code] = true
+		} else if len(w) != 0 {
+			res[strings.ToUpper(w)] = true
+		}
 	}
+	return res
 }
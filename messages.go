@@ -0,0 +1,410 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: messages.go
+// Package: scan
+// Description: 本文件定义了中英文消息目录
+// 				诊断信息、运行时错误、代码生成错误、调试器和 REPL 的输出以及命令行的提示都以消息编号引用,由 Msg 按当前语言格式化
+// 				目录项是 {英文, 中文} 两个格式串,少写一种语言时无法通过编译;CheckMessages 还检查译文为空和两种语言的格式动词不一致
+// 				包的默认语言为英文,命令行程序根据 -lang 或环境变量选择语言
+
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 界面语言
+type Lang int
+
+const (
+	LANG_EN Lang = iota // 英文
+	LANG_ZH             // 中文
+)
+
+// 一条消息的两种语言的格式串
+type Message struct {
+	EN, ZH string
+}
+
+// 当前语言
+var curLang = LANG_EN
+
+// 消息编号
+const (
+	MSG_DIAG_LINE    = "diag.line"
+	MSG_DIAG_ERROR   = "diag.error"
+	MSG_DIAG_WARNING = "diag.warning"
+	MSG_AT_LINE      = "diag.at-line"
+
+	MSG_SYNTAX_UNEXPECTED = "syntax.unexpected"
+	MSG_SYNTAX_MALFORMED  = "syntax.malformed"
+	MSG_SYNTAX_TRAILING   = "syntax.trailing"
+	MSG_SYNTAX_EOF        = "syntax.eof"
+	MSG_SYNTAX_FAILED     = "syntax.failed"
+	MSG_SYNTAX_LINE       = "syntax.line"
+
+	MSG_DIV_BY_ZERO         = "lint.div-by-zero"
+	MSG_UNUSED_GLOBAL       = "lint.unused-global"
+	MSG_UNUSED_LOCAL        = "lint.unused-local"
+	MSG_UNUSED_PARAM        = "lint.unused-param"
+	MSG_UNUSED_FUNC         = "lint.unused-func"
+	MSG_MISSING_RETURN      = "flow.missing-return"
+	MSG_UNREACHABLE         = "flow.unreachable"
+	MSG_IF_NEVER_TAKEN      = "flow.if-never-taken"
+	MSG_ELSE_NEVER_TAKEN    = "flow.else-never-taken"
+	MSG_DEAD_LOOP           = "flow.dead-loop"
+	MSG_UNINIT              = "uninit.uninitialized"
+	MSG_MAYBE_UNINIT        = "uninit.maybe-uninitialized"
+	MSG_OUT_OF_BOUNDS       = "bounds.out-of-bounds"
+	MSG_MAYBE_OUT_OF_BOUNDS = "bounds.maybe-out-of-bounds"
+
+	MSG_RUNTIME_ERROR        = "runtime.error"
+	MSG_RUNTIME_AT           = "runtime.at"
+	MSG_RUNTIME_REPEATED     = "runtime.repeated"
+	MSG_RUNTIME_PANIC        = "runtime.panic"
+	MSG_RUNTIME_DEPTH        = "runtime.depth"
+	MSG_RUNTIME_NESTING      = "runtime.nesting"
+	MSG_RUNTIME_ARG_COUNT    = "runtime.arg-count"
+	MSG_RUNTIME_ARG_TYPE     = "runtime.arg-type"
+	MSG_RUNTIME_ARRAY_VALUE  = "runtime.array-value"
+	MSG_RUNTIME_ARRAY_ASSIGN = "runtime.array-assign"
+	MSG_RUNTIME_MALFORMED    = "runtime.malformed"
+	MSG_RUNTIME_UNDECLARED   = "runtime.undeclared"
+	MSG_RUNTIME_NOT_ARRAY    = "runtime.not-array"
+	MSG_RUNTIME_INDEX        = "runtime.index"
+	MSG_RUNTIME_ARRAY_SIZE   = "runtime.array-size"
+	MSG_RUNTIME_DIV_BY_ZERO  = "runtime.div-by-zero"
+	MSG_RUNTIME_OPERATOR     = "runtime.operator"
+	MSG_RUNTIME_OVERFLOW     = "runtime.overflow"
+	MSG_RUNTIME_INPUT_EOF    = "runtime.input-eof"
+	MSG_RUNTIME_INPUT_INT    = "runtime.input-int"
+	MSG_RUNTIME_OUTPUT_ARG   = "runtime.output-arg"
+	MSG_RUNTIME_UNDEFINED    = "runtime.undefined"
+	MSG_RUNTIME_NO_MAIN      = "runtime.no-main"
+	MSG_RUNTIME_REDECLARED   = "runtime.redeclared"
+
+	MSG_CONVERT_NO_BODY   = "convert.no-body"
+	MSG_CONVERT_DECL      = "convert.expected-declaration"
+	MSG_CONVERT_LOCAL     = "convert.expected-local"
+	MSG_CONVERT_STMT      = "convert.expected-statement"
+	MSG_CONVERT_DECL_HERE = "convert.declaration-not-allowed"
+	MSG_CONVERT_EXPR      = "convert.expected-expression"
+	MSG_CONVERT_MISSING   = "convert.missing-expression"
+	MSG_CONVERT_ASSIGN    = "convert.assign-target"
+	MSG_CONVERT_UNKNOWN   = "convert.unknown-expression"
+
+	MSG_GEN_EMPTY           = "gen.empty"
+	MSG_GEN_NO_MAIN         = "gen.no-main"
+	MSG_GEN_REDECLARED      = "gen.redeclared"
+	MSG_GEN_UNEXPECTED_STMT = "gen.unexpected-statement"
+	MSG_GEN_UNEXPECTED_EXPR = "gen.unexpected-expression"
+	MSG_GEN_MISSING_EXPR    = "gen.missing-expression"
+	MSG_GEN_UNKNOWN_OP      = "gen.unknown-operator"
+	MSG_GEN_VOID_VALUE      = "gen.void-value"
+	MSG_GEN_UNDEFINED_VAR   = "gen.undefined-variable"
+	MSG_GEN_NOT_ARRAY       = "gen.not-array"
+	MSG_GEN_ARRAY_ASSIGN    = "gen.array-assign"
+	MSG_GEN_ARG_COUNT       = "gen.arg-count"
+	MSG_GEN_UNDEFINED_FUNC  = "gen.undefined-function"
+	MSG_GEN_INVALID_WAT     = "gen.invalid-wat"
+
+	MSG_WAT_EOF             = "wat.eof"
+	MSG_WAT_UNBALANCED_OPEN = "wat.unbalanced-open"
+	MSG_WAT_UNBALANCED_CLOS = "wat.unbalanced-close"
+	MSG_WAT_UNDECLARED_CALL = "wat.undeclared-call"
+	MSG_WAT_OUTSIDE_FUNC    = "wat.outside-function"
+	MSG_WAT_UNDECLARED_LOC  = "wat.undeclared-local"
+	MSG_WAT_NO_LABEL        = "wat.no-label"
+	MSG_WAT_UNKNOWN_LABEL   = "wat.unknown-label"
+
+	MSG_DEBUG_TERMINATED   = "debug.terminated"
+	MSG_DEBUG_EXITED       = "debug.exited"
+	MSG_DEBUG_BREAK_LINE   = "debug.break-line"
+	MSG_DEBUG_BREAK_FUNC   = "debug.break-function"
+	MSG_DEBUG_BREAK_SET    = "debug.break-set"
+	MSG_DEBUG_NO_FUNC      = "debug.no-function"
+	MSG_DEBUG_NO_SYMBOL    = "debug.no-symbol"
+	MSG_DEBUG_BAD_INDEX    = "debug.bad-index"
+	MSG_DEBUG_FRAME        = "debug.frame"
+	MSG_DEBUG_HELP         = "debug.help"
+	MSG_DEBUG_UNKNOWN_CMD  = "debug.unknown-command"
+	MSG_DEBUG_INFO_USAGE   = "debug.info-usage"
+	MSG_DEBUG_INFO_LINE    = "debug.info-line"
+	MSG_DEBUG_INFO_FUNC    = "debug.info-function"
+	MSG_DEBUG_UNKNOWN_INFO = "debug.unknown-info"
+
+	MSG_REPL_PROMPT_HELP = "repl.prompt-help"
+	MSG_REPL_HELP        = "repl.help"
+	MSG_REPL_NEAR        = "repl.near"
+	MSG_REPL_IGNORED     = "repl.ignored"
+
+	MSG_LIMIT_EXCEEDED = "limit.exceeded"
+	MSG_LIMIT_OF       = "limit.of"
+	MSG_LIMIT_STEP     = "limit.step"
+	MSG_LIMIT_TIME     = "limit.time"
+	MSG_LIMIT_MEMORY   = "limit.memory"
+	MSG_LIMIT_OUTPUT   = "limit.output"
+	MSG_LIMIT_UNKNOWN  = "limit.unknown"
+
+	MSG_UNKNOWN_LANG   = "lang.unknown"
+	MSG_UNKNOWN_FORMAT = "output.unknown-format"
+	MSG_NO_FILES_MATCH = "batch.no-files-match"
+	MSG_FORMAT_CHANGED = "format.changed"
+
+	MSG_QUERY_ERROR            = "query.error"
+	MSG_QUERY_UNEXPECTED_CHILD = "query.unexpected-child"
+	MSG_QUERY_MISSING_KIND     = "query.missing-kind"
+	MSG_QUERY_EMPTY            = "query.empty"
+	MSG_QUERY_UNEXPECTED       = "query.unexpected"
+	MSG_QUERY_UNKNOWN_KIND     = "query.unknown-kind"
+	MSG_QUERY_UNKNOWN_ATTR     = "query.unknown-attribute"
+	MSG_QUERY_MISSING_BRACKET  = "query.missing-bracket"
+	MSG_QUERY_UNTERMINATED     = "query.unterminated"
+	MSG_QUERY_MISSING_VALUE    = "query.missing-value"
+)
+
+// 消息目录
+var messages = map[string]Message{
+	MSG_DIAG_LINE:    {"Line %d: %s [%s]: %s", "第 %d 行: %s [%s]: %s"},
+	MSG_DIAG_ERROR:   {"error", "错误"},
+	MSG_DIAG_WARNING: {"warning", "警告"},
+	MSG_AT_LINE:      {"line %d: %s", "第 %d 行: %s"},
+
+	MSG_SYNTAX_UNEXPECTED: {"unexpected %s", "意外的 %s"},
+	MSG_SYNTAX_MALFORMED:  {"malformed input near %s", "%s 附近的输入不完整"},
+	MSG_SYNTAX_TRAILING:   {"unexpected trailing input %s", "多余的输入 %s"},
+	MSG_SYNTAX_EOF:        {"end of input", "输入结尾"},
+	MSG_SYNTAX_FAILED:     {"%s (%d errors)", "%s (共 %d 个错误)"},
+	MSG_SYNTAX_LINE:       {"Syntax Error in Line: [%d]. Token [%d]", "语法错误, 行: [%d]. Token [%d]"},
+
+	MSG_DIV_BY_ZERO:         {"division by constant zero", "除数为常量 0"},
+	MSG_UNUSED_GLOBAL:       {"global variable '%s' is never used", "全局变量 '%s' 未被使用"},
+	MSG_UNUSED_LOCAL:        {"local variable '%s' is never used", "局部变量 '%s' 未被使用"},
+	MSG_UNUSED_PARAM:        {"parameter '%s' of '%s' is never used", "形参 '%s' (函数 '%s') 未被使用"},
+	MSG_UNUSED_FUNC:         {"function '%s' is never called", "函数 '%s' 从未被调用"},
+	MSG_MISSING_RETURN:      {"control reaches end of int function '%s' without return", "int 函数 '%s' 可能不经过 return 结束"},
+	MSG_UNREACHABLE:         {"unreachable statement", "不可达的语句"},
+	MSG_IF_NEVER_TAKEN:      {"if branch is never taken", "if 分支永不执行"},
+	MSG_ELSE_NEVER_TAKEN:    {"else branch is never taken", "else 分支永不执行"},
+	MSG_DEAD_LOOP:           {"loop body is never executed", "循环体永不执行"},
+	MSG_UNINIT:              {"variable '%s' is used uninitialized", "变量 '%s' 未初始化就被使用"},
+	MSG_MAYBE_UNINIT:        {"variable '%s' may be used uninitialized", "变量 '%s' 可能未初始化就被使用"},
+	MSG_OUT_OF_BOUNDS:       {"index %s of array '%s' is out of bounds [0, %d]", "数组 '%[2]s' 的下标 %[1]s 越界 [0, %[3]d]"},
+	MSG_MAYBE_OUT_OF_BOUNDS: {"index %s of array '%s' may be out of bounds [0, %d]", "数组 '%[2]s' 的下标 %[1]s 可能越界 [0, %[3]d]"},
+
+	MSG_RUNTIME_ERROR:        {"line %d: runtime error: %s", "第 %d 行: 运行时错误: %s"},
+	MSG_RUNTIME_AT:           {"    at %s (line %d)", "    位于 %s (第 %d 行)"},
+	MSG_RUNTIME_REPEATED:     {"    ... repeated %d more times", "    ... 又重复 %d 次"},
+	MSG_RUNTIME_PANIC:        {"%v", "%v"},
+	MSG_RUNTIME_DEPTH:        {"maximum recursion depth %d exceeded calling %s", "调用 %[2]s 时超过最大递归深度 %[1]d"},
+	MSG_RUNTIME_NESTING:      {"statements and expressions nested more than %d levels deep", "语句和表达式的嵌套超过 %d 层"},
+	MSG_RUNTIME_ARG_COUNT:    {"%s expects %d arguments, got %d", "%s 需要 %d 个参数, 实际为 %d 个"},
+	MSG_RUNTIME_ARG_TYPE:     {"argument %d of %s has the wrong type", "%[2]s 的第 %[1]d 个参数类型错误"},
+	MSG_RUNTIME_ARRAY_VALUE:  {"array %s used as a value", "数组 %s 被当作值使用"},
+	MSG_RUNTIME_ARRAY_ASSIGN: {"cannot assign to array %s", "不能给数组 %s 赋值"},
+	MSG_RUNTIME_MALFORMED:    {"malformed expression", "表达式不完整"},
+	MSG_RUNTIME_UNDECLARED:   {"undeclared identifier %s", "未声明的标识符 %s"},
+	MSG_RUNTIME_NOT_ARRAY:    {"%s is not an array", "%s 不是数组"},
+	MSG_RUNTIME_INDEX:        {"index %d out of range for array %s of size %d", "下标 %d 超出数组 %s 的范围, 数组大小为 %d"},
+	MSG_RUNTIME_ARRAY_SIZE:   {"array %s of size %d exceeds the limit of %d cells", "数组 %s 的大小 %d 超过数组单元总数上限 %d"},
+	MSG_RUNTIME_DIV_BY_ZERO:  {"integer division by zero", "整数除以 0"},
+	MSG_RUNTIME_OPERATOR:     {"unknown operator", "未知的运算符"},
+	MSG_RUNTIME_OVERFLOW:     {"integer overflow: %d does not fit in int", "整数溢出: %d 超出 int 的范围"},
+	MSG_RUNTIME_INPUT_EOF:    {"input: unexpected end of input", "input: 输入意外结束"},
+	MSG_RUNTIME_INPUT_INT:    {"input: expected an integer", "input: 需要一个整数"},
+	MSG_RUNTIME_OUTPUT_ARG:   {"output expects one int argument", "output 需要一个 int 参数"},
+	MSG_RUNTIME_UNDEFINED:    {"undefined function %s", "未定义的函数 %s"},
+	MSG_RUNTIME_NO_MAIN:      {"no main function", "没有 main 函数"},
+	MSG_RUNTIME_REDECLARED:   {"line %d: redeclaration of %s", "第 %d 行: 重复声明 %s"},
+
+	MSG_CONVERT_NO_BODY:   {"function %s has no body", "函数 %s 没有函数体"},
+	MSG_CONVERT_DECL:      {"expected a declaration", "应为声明"},
+	MSG_CONVERT_LOCAL:     {"expected a local variable declaration", "应为局部变量声明"},
+	MSG_CONVERT_STMT:      {"expected a statement", "应为语句"},
+	MSG_CONVERT_DECL_HERE: {"declaration is not allowed here", "此处不允许声明"},
+	MSG_CONVERT_EXPR:      {"expected an expression", "应为表达式"},
+	MSG_CONVERT_MISSING:   {"missing expression", "缺少表达式"},
+	MSG_CONVERT_ASSIGN:    {"left side of assignment is not a variable", "赋值号左边不是变量"},
+	MSG_CONVERT_UNKNOWN:   {"unknown expression kind", "未知的表达式种类"},
+
+	MSG_GEN_EMPTY:           {"empty program", "程序为空"},
+	MSG_GEN_NO_MAIN:         {"no main function", "没有 main 函数"},
+	MSG_GEN_REDECLARED:      {"redeclaration of %s", "重复声明 %s"},
+	MSG_GEN_UNEXPECTED_STMT: {"unexpected statement", "意外的语句"},
+	MSG_GEN_UNEXPECTED_EXPR: {"unexpected expression", "意外的表达式"},
+	MSG_GEN_MISSING_EXPR:    {"missing expression", "缺少表达式"},
+	MSG_GEN_UNKNOWN_OP:      {"unknown operator", "未知的运算符"},
+	MSG_GEN_VOID_VALUE:      {"void value used in expression", "表达式中使用了 void 值"},
+	MSG_GEN_UNDEFINED_VAR:   {"undefined variable %s", "未定义的变量 %s"},
+	MSG_GEN_NOT_ARRAY:       {"%s is not an array", "%s 不是数组"},
+	MSG_GEN_ARRAY_ASSIGN:    {"cannot assign to array %s", "不能给数组 %s 赋值"},
+	MSG_GEN_ARG_COUNT:       {"function %s expects %d arguments, got %d", "函数 %s 需要 %d 个参数, 实际为 %d 个"},
+	MSG_GEN_UNDEFINED_FUNC:  {"undefined function %s", "未定义的函数 %s"},
+	MSG_GEN_INVALID_WAT:     {"generated module is invalid: %s", "生成的模块无效: %s"},
+
+	MSG_WAT_EOF:             {"unexpected end of input", "输入意外结束"},
+	MSG_WAT_UNBALANCED_OPEN: {"unbalanced '('", "'(' 没有配对"},
+	MSG_WAT_UNBALANCED_CLOS: {"unbalanced ')'", "')' 没有配对"},
+	MSG_WAT_UNDECLARED_CALL: {"call to undeclared function near token %d", "第 %d 个 token 附近调用了未声明的函数"},
+	MSG_WAT_OUTSIDE_FUNC:    {"%s outside of a function", "%s 在函数之外"},
+	MSG_WAT_UNDECLARED_LOC:  {"%s of undeclared local near token %d", "第 %[2]d 个 token 附近的 %[1]s 使用了未声明的局部变量"},
+	MSG_WAT_NO_LABEL:        {"%s without label", "%s 缺少标号"},
+	MSG_WAT_UNKNOWN_LABEL:   {"%s to unknown label %s", "%s 跳转到未知的标号 %s"},
+
+	MSG_DEBUG_TERMINATED:   {"program terminated", "程序已终止"},
+	MSG_DEBUG_EXITED:       {"program exited normally", "程序正常结束"},
+	MSG_DEBUG_BREAK_LINE:   {"Breakpoint at line %d", "断点: 第 %d 行"},
+	MSG_DEBUG_BREAK_FUNC:   {"Breakpoint at function %s", "断点: 函数 %s"},
+	MSG_DEBUG_BREAK_SET:    {"Breakpoint set at %s", "已在 %s 设置断点"},
+	MSG_DEBUG_NO_FUNC:      {"no function named %s", "没有名为 %s 的函数"},
+	MSG_DEBUG_NO_SYMBOL:    {"no symbol %s in current scope", "当前作用域中没有符号 %s"},
+	MSG_DEBUG_BAD_INDEX:    {"bad index %s", "无效的下标 %s"},
+	MSG_DEBUG_FRAME:        {"#%d  %s (line %d)", "#%d  %s (第 %d 行)"},
+	MSG_DEBUG_HELP:         {"break LINE|FUNC, delete LINE|FUNC, step, next, finish, continue,\nprint VAR|VAR[INDEX], backtrace, list, where, info breakpoints|locals, quit", "break 行号|函数, delete 行号|函数, step, next, finish, continue,\nprint 变量|变量[下标], backtrace, list, where, info breakpoints|locals, quit"},
+	MSG_DEBUG_UNKNOWN_CMD:  {"unknown command %q, try help", "未知的命令 %q, 输入 help 查看帮助"},
+	MSG_DEBUG_INFO_USAGE:   {"info breakpoints|locals", "info breakpoints|locals"},
+	MSG_DEBUG_INFO_LINE:    {"line %d", "第 %d 行"},
+	MSG_DEBUG_INFO_FUNC:    {"function %s", "函数 %s"},
+	MSG_DEBUG_UNKNOWN_INFO: {"unknown info command %q", "未知的 info 命令 %q"},
+
+	MSG_REPL_PROMPT_HELP: {"enter declarations, statements or expressions; :help for help, :quit to exit", "输入声明、语句或表达式; :help 查看帮助, :quit 退出"},
+	MSG_REPL_HELP: {"  int x;  int f(int a) { return a * 2; }   declare globals and functions\n  x = f(21);  while (x > 40) x = x - 1;     run statements\n  x + 1                                      print the value of an expression\n  :globals  :quit",
+		"  int x;  int f(int a) { return a * 2; }   声明全局变量和函数\n  x = f(21);  while (x > 40) x = x - 1;     执行语句\n  x + 1                                      打印表达式的值\n  :globals  :quit"},
+	MSG_REPL_NEAR:    {"syntax error near %s", "%s 附近有语法错误"},
+	MSG_REPL_IGNORED: {"%s, input ignored", "%s, 输入已忽略"},
+
+	MSG_LIMIT_EXCEEDED: {"line %d: %s limit exceeded: %s", "第 %d 行: 超出%s限制: %s"},
+	MSG_LIMIT_OF:       {"line %d: %s limit of %d exceeded", "第 %d 行: 超出%s限制 %d"},
+	MSG_LIMIT_STEP:     {"step", "执行步数"},
+	MSG_LIMIT_TIME:     {"time", "运行时间"},
+	MSG_LIMIT_MEMORY:   {"memory", "数组单元数"},
+	MSG_LIMIT_OUTPUT:   {"output", "输出字节数"},
+	MSG_LIMIT_UNKNOWN:  {"unknown", "未知"},
+
+	MSG_UNKNOWN_LANG:   {"unknown language %q, expected zh or en", "未知的语言 %q, 应为 zh 或 en"},
+	MSG_UNKNOWN_FORMAT: {"unknown output format %q, expected text, json or dot", "未知的输出格式 %q, 应为 text、json 或 dot"},
+	MSG_NO_FILES_MATCH: {"no files match %s", "没有与 %s 匹配的文件"},
+	MSG_FORMAT_CHANGED: {"formatting would change the program structure, source left unchanged", "格式化会改变程序的结构, 未修改源程序"},
+
+	MSG_QUERY_ERROR:            {"query: %s at offset %d", "查询: 第 %[2]d 个字符处%[1]s"},
+	MSG_QUERY_UNEXPECTED_CHILD: {"unexpected '>'", "意外的 '>'"},
+	MSG_QUERY_MISSING_KIND:     {"missing node kind after '>'", "'>' 之后缺少节点种类"},
+	MSG_QUERY_EMPTY:            {"empty selector", "选择器为空"},
+	MSG_QUERY_UNEXPECTED:       {"unexpected %q", "意外的 %q"},
+	MSG_QUERY_UNKNOWN_KIND:     {"unknown node kind %q", "未知的节点种类 %q"},
+	MSG_QUERY_UNKNOWN_ATTR:     {"unknown attribute %q", "未知的属性 %q"},
+	MSG_QUERY_MISSING_BRACKET:  {"missing ']'", "缺少 ']'"},
+	MSG_QUERY_UNTERMINATED:     {"unterminated string", "字符串没有结束"},
+	MSG_QUERY_MISSING_VALUE:    {"missing value", "缺少属性值"},
+}
+
+// 设置当前语言
+func SetLang(lang Lang) {
+	curLang = lang
+}
+
+// 当前语言
+func CurrentLang() Lang {
+	return curLang
+}
+
+// 根据名字得到语言,如 zh、zh_CN.UTF-8、en、en_US
+func ParseLang(name string) (Lang, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasPrefix(lower, "zh"):
+		return LANG_ZH, nil
+	case strings.HasPrefix(lower, "en"):
+		return LANG_EN, nil
+	}
+	return curLang, errors.New(Msg(MSG_UNKNOWN_LANG, name))
+}
+
+// 根据环境变量 LC_ALL、LC_MESSAGES、LANG 中第一个非空的值得到语言,都为空时 ok 为假
+// zh 开头的为中文,其他(包括 C 和 POSIX)为英文
+func LangFromEnv() (lang Lang, ok bool) {
+	for _, key := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(key); len(value) != 0 {
+			if strings.HasPrefix(strings.ToLower(value), "zh") {
+				return LANG_ZH, true
+			}
+			return LANG_EN, true
+		}
+	}
+	return LANG_EN, false
+}
+
+// 添加消息,用于命令行程序等包外的消息;编号已存在时返回错误
+func AddMessages(msgs map[string]Message) error {
+	for id, m := range msgs {
+		if _, ok := messages[id]; ok {
+			return fmt.Errorf("duplicate message id %s", id)
+		}
+		messages[id] = m
+	}
+	return nil
+}
+
+// 按当前语言格式化消息,未知的编号原样返回
+func Msg(id string, args ...interface{}) string {
+	m, ok := messages[id]
+	if !ok {
+		return id
+	}
+	format := m.EN
+	if curLang == LANG_ZH {
+		format = m.ZH
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// 格式动词,如 %d、%[2]s、%q
+var msgVerb = regexp.MustCompile(`%(\[\d+\])?[-+# 0]*\d*(\.\d+)?[a-zA-Z%]`)
+
+// 格式串中各参数的动词(不含下标),按参数顺序排列
+func msgVerbs(format string) []string {
+	var res []string
+	arg := 0
+	for _, v := range msgVerb.FindAllStringSubmatch(format, -1) {
+		if strings.HasSuffix(v[0], "%") {
+			continue
+		}
+		if len(v[1]) != 0 {
+			fmt.Sscanf(v[1], "[%d]", &arg)
+			arg--
+		}
+		for len(res) <= arg {
+			res = append(res, "")
+		}
+		res[arg] = v[0][len(v[0])-1:]
+		arg++
+	}
+	return res
+}
+
+// 检查消息目录: 每条消息的两种语言都不能为空,且对每个参数使用相同的格式动词
+// 返回发现的问题,按消息编号排列
+func CheckMessages() []string {
+	var res []string
+	for id, m := range messages {
+		if len(m.EN) == 0 {
+			res = append(res, id+": missing English text")
+		}
+		if len(m.ZH) == 0 {
+			res = append(res, id+": missing Chinese text")
+		}
+		if en, zh := msgVerbs(m.EN), msgVerbs(m.ZH); strings.Join(en, ",") != strings.Join(zh, ",") {
+			res = append(res, fmt.Sprintf("%s: arguments differ: %v (en) vs %v (zh)", id, en, zh))
+		}
+	}
+	sort.Strings(res)
+	return res
+}
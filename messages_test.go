@@ -0,0 +1,199 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: messages_test.go
+// Package: scan
+// Description: 消息目录的测试
+// 				每个消息编号都要在目录中有英文和中文两种文本,且格式动词一致;
+// 				命令行程序的消息目录在 main 包中,这里从 main.go 的源代码中读出后用同样的方法检查
+
+package scan
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCheckMessages(t *testing.T) {
+	for _, problem := range CheckMessages() {
+		t.Error(problem)
+	}
+}
+
+func TestCheckMessagesReports(t *testing.T) {
+	bad := map[string]Message{
+		"test.missing-zh": {"only English", ""},
+		"test.verbs":      {"%s at line %d", "第 %d 行的 %s"},
+	}
+	if err := AddMessages(bad); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for id := range bad {
+			delete(messages, id)
+		}
+	}()
+	got := strings.Join(CheckMessages(), "\n")
+	for _, want := range []string{"test.missing-zh: missing Chinese text", "test.verbs: arguments differ"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("CheckMessages did not report %q:\n%s", want, got)
+		}
+	}
+}
+
+// 源文件中的消息编号常量和消息目录
+type sourceCatalog struct {
+	ids     map[string]string  // 常量名 -> 消息编号
+	entries map[string]Message // 常量名 -> 目录项
+}
+
+// 字符串常量表达式的值,只支持字面量和字面量的连接
+func constString(t *testing.T, fset *token.FileSet, e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		s, err := strconv.Unquote(e.Value)
+		if err != nil {
+			t.Fatalf("%s: %v", fset.Position(e.Pos()), err)
+		}
+		return s
+	case *ast.BinaryExpr:
+		if e.Op == token.ADD {
+			return constString(t, fset, e.X) + constString(t, fset, e.Y)
+		}
+	}
+	t.Fatalf("%s: not a string constant", fset.Position(e.Pos()))
+	return ""
+}
+
+// 读出源文件中 MSG_ 开头的常量和名为 catalog 的消息目录
+func readCatalog(t *testing.T, filename, catalog string) sourceCatalog {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := sourceCatalog{ids: make(map[string]string), entries: make(map[string]Message)}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			vs, ok := spec.(*ast.ValueSpec)
+			switch {
+			case !ok:
+				continue
+			case gen.Tok == token.CONST && len(vs.Values) == len(vs.Names):
+				for i, name := range vs.Names {
+					if strings.HasPrefix(name.Name, "MSG_") {
+						res.ids[name.Name] = constString(t, fset, vs.Values[i])
+					}
+				}
+			case gen.Tok == token.VAR && len(vs.Names) == 1 && vs.Names[0].Name == catalog:
+				lit := vs.Values[0].(*ast.CompositeLit)
+				for _, elt := range lit.Elts {
+					kv := elt.(*ast.KeyValueExpr)
+					key := kv.Key.(*ast.Ident).Name
+					texts := kv.Value.(*ast.CompositeLit).Elts
+					if len(texts) != 2 {
+						t.Fatalf("%s: %s does not have two texts", fset.Position(kv.Pos()), key)
+					}
+					if _, ok := res.entries[key]; ok {
+						t.Errorf("%s: duplicate entry %s", fset.Position(kv.Pos()), key)
+					}
+					res.entries[key] = Message{EN: constString(t, fset, texts[0]), ZH: constString(t, fset, texts[1])}
+				}
+			}
+		}
+	}
+	if len(res.entries) == 0 {
+		t.Fatalf("%s: catalog %s not found", filename, catalog)
+	}
+	return res
+}
+
+func TestCatalogComplete(t *testing.T) {
+	pkg := readCatalog(t, "messages.go", "messages")
+	cli := readCatalog(t, "main.go", "cliMessages")
+	for _, c := range []sourceCatalog{pkg, cli} {
+		for name := range c.ids {
+			if _, ok := c.entries[name]; !ok {
+				t.Errorf("%s has no catalog entry", name)
+			}
+		}
+		for name := range c.entries {
+			if _, ok := c.ids[name]; !ok {
+				t.Errorf("catalog entry %s has no constant", name)
+			}
+		}
+	}
+
+	// 命令行的消息目录加入包的目录后整体检查
+	msgs := make(map[string]Message)
+	for name, m := range cli.entries {
+		msgs[cli.ids[name]] = m
+	}
+	if err := AddMessages(msgs); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for id := range msgs {
+			delete(messages, id)
+		}
+	}()
+	for _, problem := range CheckMessages() {
+		t.Error(problem)
+	}
+}
+
+func TestMessagesChinese(t *testing.T) {
+	SetLang(LANG_ZH)
+	defer SetLang(LANG_EN)
+
+	root, _, err := ParseProgram("int f(void) { return 1; }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := NewCGenerator(&out, "f.cm").Generate(root); err == nil || err.Error() != "没有 main 函数" {
+		t.Errorf("CGenerator: got %v", err)
+	}
+	root, _, err = ParseProgram("void main(void) { int x; x = y; }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateIR(root); err == nil || err.Error() != "第 1 行: 未定义的变量 y" {
+		t.Errorf("GenerateIR: got %v", err)
+	}
+
+	out.Reset()
+	root, _, err = ParseProgram("void main(void) {\n\tint x;\n\tx = input();\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := NewInterpreter(root, strings.NewReader("abc"), &out, InterpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := it.Run(); err == nil || !strings.Contains(err.Error(), "input: 需要一个整数") {
+		t.Errorf("input: got %v", err)
+	}
+
+	out.Reset()
+	it, err = NewInterpreter(root, strings.NewReader(""), &out, InterpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDebugger(it, "", strings.NewReader("b main\nfoo\nc\n"), &out, false)
+	if err := d.Run(); err == nil || !strings.Contains(err.Error(), "input: 输入意外结束") {
+		t.Errorf("debugger: got %v", err)
+	}
+	for _, want := range []string{"已在 main 设置断点", "未知的命令 \"foo\", 输入 help 查看帮助"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("debugger output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
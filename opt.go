@@ -0,0 +1,583 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: opt.go
+// Package: scan
+// Description: 本文件定义了中间代码上的过程内优化
+// 				常量传播、复写传播、死代码删除、公共子表达式删除(块内值编号)以及循环不变代码外提
+// 				每个优化都可以单独开关,优化在非SSA形式的IR上进行
+
+package scan
+
+import (
+	"fmt"
+)
+
+// 优化选项
+type OptOptions struct {
+	ConstProp bool // 常量传播
+	CopyProp  bool // 复写传播
+	DCE       bool // 死代码删除
+	CSE       bool // 公共子表达式删除
+	LICM      bool // 循环不变代码外提
+}
+
+// 根据优化级别返回优化选项,0为不优化,1及以上打开全部优化
+func OptLevel(level int) OptOptions {
+	if level <= 0 {
+		return OptOptions{}
+	}
+	return OptOptions{ConstProp: true, CopyProp: true, DCE: true, CSE: true, LICM: true}
+}
+
+// 对整个程序进行优化
+func Optimize(prog *IRProgram, opts OptOptions) {
+	for _, fn := range prog.Funcs {
+		OptimizeFunc(fn, opts)
+	}
+}
+
+// 对单个函数反复进行各项优化,直到没有变化(最多10轮)
+func OptimizeFunc(fn *IRFunc, opts OptOptions) {
+	for round := 0; round < 10; round++ {
+		changed := false
+		if opts.ConstProp && ConstantPropagation(fn) {
+			changed = true
+		}
+		if opts.CopyProp && CopyPropagation(fn) {
+			changed = true
+		}
+		if opts.CSE && CommonSubexpressionElimination(fn) {
+			changed = true
+		}
+		if opts.LICM && LoopInvariantCodeMotion(fn) {
+			changed = true
+		}
+		if opts.DCE && DeadCodeElimination(fn) {
+			changed = true
+		}
+		if !changed {
+			break
+		}
+	}
+	RenumberBlocks(fn)
+}
+
+// ---------------- 常量传播 ----------------
+
+// 常量格的取值
+const (
+	LAT_UNDEF = iota // 尚未确定
+	LAT_CONST        // 常量
+	LAT_NAC          // 不是常量
+)
+
+type latValue struct {
+	kind int
+	val  int64
+}
+
+// 格上的交运算
+func meetLat(a, b latValue) latValue {
+	switch {
+	case a.kind == LAT_UNDEF:
+		return b
+	case b.kind == LAT_UNDEF:
+		return a
+	case a.kind == LAT_CONST && b.kind == LAT_CONST && a.val == b.val:
+		return a
+	}
+	return latValue{kind: LAT_NAC}
+}
+
+// 常量传播的状态:变量到格值的映射,没有出现的变量为UNDEF
+type constState map[string]latValue
+
+func (s constState) copy() constState {
+	res := make(constState, len(s))
+	for k, v := range s {
+		res[k] = v
+	}
+	return res
+}
+
+func (s constState) equal(t constState) bool {
+	if len(s) != len(t) {
+		return false
+	}
+	for k, v := range s {
+		if t[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 操作数在状态下的格值
+func (s constState) eval(o Operand) latValue {
+	switch o.Kind {
+	case OPD_CONST:
+		return latValue{kind: LAT_CONST, val: o.Value}
+	case OPD_VAR:
+		return s[o.Name]
+	}
+	return latValue{kind: LAT_NAC}
+}
+
+// 指令对状态的作用
+func (s constState) transfer(ins *IRInstr) {
+	if ins.Dst == "" {
+		return
+	}
+	switch ins.Op {
+	case IR_COPY:
+		s[ins.Dst] = s.eval(ins.Args[0])
+	case IR_BINARY:
+		l, r := s.eval(ins.Args[0]), s.eval(ins.Args[1])
+		switch {
+		case l.kind == LAT_CONST && r.kind == LAT_CONST:
+			if v, ok := evalBinary32(ins.OpTok, l.val, r.val); ok {
+				s[ins.Dst] = latValue{kind: LAT_CONST, val: v}
+			} else {
+				s[ins.Dst] = latValue{kind: LAT_NAC}
+			}
+		case l.kind == LAT_NAC || r.kind == LAT_NAC:
+			s[ins.Dst] = latValue{kind: LAT_NAC}
+		default:
+			s[ins.Dst] = latValue{}
+		}
+	default: // LOAD、CALL、ADDR、PHI 等
+		s[ins.Dst] = latValue{kind: LAT_NAC}
+	}
+}
+
+// 全局常量传播
+// 先在控制流图上求出每个块入口处的常量状态,再改写指令中的变量为常量、
+// 折叠常量运算,条件为常量的分支改为无条件跳转并删除不可达块
+func ConstantPropagation(fn *IRFunc) bool {
+	in := make(map[*BasicBlock]constState)
+	out := make(map[*BasicBlock]constState)
+	entry := make(constState)
+	for _, p := range fn.Params {
+		entry[p] = latValue{kind: LAT_NAC}
+	}
+
+	order := ReversePostorder(fn)
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order {
+			var st constState
+			if b == fn.Entry() {
+				st = entry.copy()
+			} else {
+				st = make(constState)
+				for _, p := range b.Preds {
+					for k, v := range out[p] {
+						st[k] = meetLat(st[k], v)
+					}
+				}
+			}
+			in[b] = st
+			res := st.copy()
+			for _, ins := range b.Instrs {
+				res.transfer(ins)
+			}
+			if o, ok := out[b]; !ok || !o.equal(res) {
+				out[b] = res
+				changed = true
+			}
+		}
+	}
+
+	// 改写指令
+	changed := false
+	for _, b := range fn.Blocks {
+		st := in[b].copy()
+		for _, ins := range b.Instrs {
+			if ins.Op != IR_PHI {
+				for i, a := range ins.Args {
+					if v := st.eval(a); a.Kind == OPD_VAR && v.kind == LAT_CONST {
+						ins.Args[i] = ConstOperand(v.val)
+						changed = true
+					}
+				}
+			}
+			st.transfer(ins)
+			switch {
+			case ins.Op == IR_BINARY && st[ins.Dst].kind == LAT_CONST:
+				ins.Op = IR_COPY
+				ins.Args = []Operand{ConstOperand(st[ins.Dst].val)}
+				changed = true
+			case ins.Op == IR_BRANCH && ins.Args[0].Kind == OPD_CONST:
+				target := ins.Targets[1]
+				if ins.Args[0].Value != 0 {
+					target = ins.Targets[0]
+				}
+				ins.Op = IR_JUMP
+				ins.Args = nil
+				ins.Targets = []*BasicBlock{target}
+				changed = true
+			}
+		}
+	}
+	if changed {
+		RemoveUnreachable(fn)
+	}
+	return changed
+}
+
+// ---------------- 复写传播 ----------------
+
+// 可用复写集合: 目的变量到源变量的映射
+type copySet map[string]string
+
+// 删除所有与变量v有关的复写
+func (s copySet) kill(v string) {
+	for d, src := range s {
+		if d == v || src == v {
+			delete(s, d)
+		}
+	}
+}
+
+// 指令对可用复写集合的作用
+func (s copySet) transfer(ins *IRInstr) {
+	if ins.Dst == "" {
+		return
+	}
+	s.kill(ins.Dst)
+	if ins.Op == IR_COPY && ins.Args[0].Kind == OPD_VAR && ins.Args[0].Name != ins.Dst {
+		s[ins.Dst] = ins.Args[0].Name
+	}
+}
+
+// 全局复写传播,把对x的使用替换为对y的使用(x = y在使用处可用时)
+func CopyPropagation(fn *IRFunc) bool {
+	in := make(map[*BasicBlock]copySet)
+	out := make(map[*BasicBlock]copySet)
+	order := ReversePostorder(fn)
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order {
+			st := make(copySet)
+			if b != fn.Entry() {
+				first := true
+				for _, p := range b.Preds {
+					po, ok := out[p]
+					if !ok { // 尚未计算的前驱视为全集
+						continue
+					}
+					if first {
+						for k, v := range po {
+							st[k] = v
+						}
+						first = false
+						continue
+					}
+					for k, v := range st {
+						if po[k] != v {
+							delete(st, k)
+						}
+					}
+				}
+			}
+			in[b] = st
+			res := make(copySet, len(st))
+			for k, v := range st {
+				res[k] = v
+			}
+			for _, ins := range b.Instrs {
+				res.transfer(ins)
+			}
+			if o, ok := out[b]; !ok || !copySetEqual(o, res) {
+				out[b] = res
+				changed = true
+			}
+		}
+	}
+
+	changed := false
+	for _, b := range fn.Blocks {
+		st := make(copySet)
+		for k, v := range in[b] {
+			st[k] = v
+		}
+		for _, ins := range b.Instrs {
+			if ins.Op != IR_PHI {
+				for i, a := range ins.Args {
+					if src, ok := st[a.Name]; a.Kind == OPD_VAR && ok {
+						ins.Args[i] = VarOperand(src)
+						changed = true
+					}
+				}
+			}
+			st.transfer(ins)
+		}
+	}
+	return changed
+}
+
+func copySetEqual(a, b copySet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ---------------- 死代码删除 ----------------
+
+// 删除不可达块、合并相连的块,再删除结果不再被使用且没有副作用的指令,直到没有变化
+func DeadCodeElimination(fn *IRFunc) bool {
+	changed := RemoveUnreachable(fn)
+	if MergeBlocks(fn) {
+		changed = true
+	}
+	for {
+		live := ComputeLiveness(fn)
+		removed := false
+		for _, b := range fn.Blocks {
+			dead := make(map[int]bool)
+			walkLiveAfter(b, live, func(i int, ins *IRInstr, after VarSet) {
+				if ins.Dst != "" && !ins.HasSideEffects() && !after[ins.Dst] {
+					dead[i] = true
+				}
+				// 自身复制 x = x 也是无用的
+				if ins.Op == IR_COPY && ins.Args[0].Kind == OPD_VAR && ins.Args[0].Name == ins.Dst {
+					dead[i] = true
+				}
+			})
+			if len(dead) == 0 {
+				continue
+			}
+			var kept []*IRInstr
+			for i, ins := range b.Instrs {
+				if !dead[i] {
+					kept = append(kept, ins)
+				}
+			}
+			b.Instrs = kept
+			removed = true
+		}
+		if !removed {
+			return changed
+		}
+		changed = true
+	}
+}
+
+// ---------------- 公共子表达式删除 ----------------
+
+// 可交换的运算符
+func commutative(op Token) bool {
+	return op == PLUS || op == MUL || op == EQ || op == NOT_EQ
+}
+
+// 二元运算的值编号键
+func exprKey(ins *IRInstr) string {
+	a, b := ins.Args[0].String(), ins.Args[1].String()
+	if commutative(ins.OpTok) && a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%s %d %s", a, ins.OpTok, b)
+}
+
+// 块内公共子表达式删除
+// 在同一块内重复计算的二元运算改为复制先前保存结果的变量
+func CommonSubexpressionElimination(fn *IRFunc) bool {
+	changed := false
+	for _, b := range fn.Blocks {
+		avail := make(map[string]string)      // 表达式键到保存结果的变量
+		operands := make(map[string][]string) // 表达式键用到的变量
+		for _, ins := range b.Instrs {
+			var key string
+			if ins.Op == IR_BINARY {
+				key = exprKey(ins)
+				if holder, ok := avail[key]; ok {
+					ins.Op = IR_COPY
+					ins.Args = []Operand{VarOperand(holder)}
+					changed = true
+				}
+			}
+			if ins.Dst == "" {
+				continue
+			}
+			// 定义使得依赖该变量的表达式失效
+			for k, holder := range avail {
+				if holder == ins.Dst {
+					delete(avail, k)
+					continue
+				}
+				for _, v := range operands[k] {
+					if v == ins.Dst {
+						delete(avail, k)
+						break
+					}
+				}
+			}
+			if ins.Op == IR_BINARY {
+				uses := ins.Uses()
+				selfRef := false
+				for _, u := range uses {
+					if u == ins.Dst {
+						selfRef = true
+					}
+				}
+				if !selfRef {
+					avail[key] = ins.Dst
+					operands[key] = uses
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// ---------------- 循环不变代码外提 ----------------
+
+// 判断指令是否可以安全外提(不会产生运行时错误)
+func hoistable(ins *IRInstr) bool {
+	switch ins.Op {
+	case IR_COPY:
+		return true
+	case IR_BINARY:
+		// 除数不是非零常量时可能产生除零错误
+		return ins.OpTok != DIV || (ins.Args[1].Kind == OPD_CONST && ins.Args[1].Value != 0)
+	}
+	return false
+}
+
+// 为循环找到或建立前置块(唯一的循环外前驱,且只跳转到循环头)
+func loopPreheader(fn *IRFunc, loop *Loop) *BasicBlock {
+	var outside []*BasicBlock
+	for _, p := range loop.Header.Preds {
+		if !loop.Blocks[p] {
+			outside = append(outside, p)
+		}
+	}
+	if len(outside) == 1 && len(outside[0].Succs) == 1 {
+		return outside[0]
+	}
+	pre := fn.NewBlock()
+	pre.Instrs = []*IRInstr{{Op: IR_JUMP, Targets: []*BasicBlock{loop.Header}}}
+	for _, p := range outside {
+		t := p.Terminator()
+		for i, s := range t.Targets {
+			if s == loop.Header {
+				t.Targets[i] = pre
+			}
+		}
+	}
+	// 入口块就是循环头时,前置块成为新的入口块
+	if fn.Entry() == loop.Header {
+		copy(fn.Blocks[1:], fn.Blocks[:len(fn.Blocks)-1])
+		fn.Blocks[0] = pre
+	}
+	BuildCFG(fn)
+	return pre
+}
+
+// 循环不变代码外提
+// 指令的操作数都是常量或在循环外定义(或者由已外提的指令定义),
+// 目的变量在循环中只定义一次且在循环头入口处不活跃,
+// 并且指令所在块支配所有循环出口或目的变量在循环出口处不活跃时,将其移到前置块
+// 每次外提后控制流图可能增加前置块,因此重新识别循环,直到没有可以外提的指令
+func LoopInvariantCodeMotion(fn *IRFunc) bool {
+	changed := false
+	for hoistLoop(fn) {
+		changed = true
+	}
+	return changed
+}
+
+// 对内层优先的第一个可以外提指令的循环进行外提,返回是否外提了指令
+func hoistLoop(fn *IRFunc) bool {
+	for _, loop := range FindLoops(fn) {
+		live := ComputeLiveness(fn)
+		ComputeDominators(fn)
+
+		// 循环中各个变量的定义次数
+		defs := make(map[string]int)
+		for b := range loop.Blocks {
+			for _, ins := range b.Instrs {
+				if ins.Dst != "" {
+					defs[ins.Dst]++
+				}
+			}
+		}
+		// 循环出口: 循环中有循环外后继的块
+		var exits []*BasicBlock
+		liveAtExit := make(VarSet)
+		for b := range loop.Blocks {
+			for _, s := range b.Succs {
+				if !loop.Blocks[s] {
+					exits = append(exits, b)
+					for v := range live.In[s] {
+						liveAtExit[v] = true
+					}
+				}
+			}
+		}
+
+		invariant := make(map[*IRInstr]bool)
+		var hoisted []*IRInstr
+		invariantDef := make(VarSet)
+		for found := true; found; {
+			found = false
+			for _, b := range ReversePostorder(fn) {
+				if !loop.Blocks[b] {
+					continue
+				}
+				for _, ins := range b.Instrs {
+					if invariant[ins] || !hoistable(ins) || defs[ins.Dst] != 1 || live.In[loop.Header][ins.Dst] {
+						continue
+					}
+					ok := true
+					for _, u := range ins.Uses() {
+						if defs[u] > 0 && !invariantDef[u] {
+							ok = false
+						}
+					}
+					if !ok {
+						continue
+					}
+					domExits := true
+					for _, e := range exits {
+						if !Dominates(b, e) {
+							domExits = false
+						}
+					}
+					if !domExits && liveAtExit[ins.Dst] {
+						continue
+					}
+					invariant[ins] = true
+					invariantDef[ins.Dst] = true
+					hoisted = append(hoisted, ins)
+					found = true
+				}
+			}
+		}
+		if len(hoisted) == 0 {
+			continue
+		}
+
+		pre := loopPreheader(fn, loop)
+		for b := range loop.Blocks {
+			var kept []*IRInstr
+			for _, ins := range b.Instrs {
+				if !invariant[ins] {
+					kept = append(kept, ins)
+				}
+			}
+			b.Instrs = kept
+		}
+		term := pre.Instrs[len(pre.Instrs)-1]
+		pre.Instrs = append(append(pre.Instrs[:len(pre.Instrs)-1:len(pre.Instrs)-1], hoisted...), term)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: opt_test.go
+// Package: scan
+// Description: 中间代码优化的测试
+// 				每个优化单独打开时的前后黄金测试,以及各个优化分别和全部打开时示例程序的执行结果
+
+package scan
+
+import (
+	"testing"
+)
+
+func TestOptGolden(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          OptOptions
+		src           string
+		before, after string
+	}{
+		{"constprop", OptOptions{ConstProp: true},
+			`void main(void) { int a; int b; a = 2; b = a * 3; output(b + a); }`, `B0:
+    a = 2
+    %t1 = a * 3
+    b = %t1
+    %t2 = b + a
+    call output(%t2)
+    return
+`, `B0:
+    a = 2
+    %t1 = 6
+    b = 6
+    %t2 = 8
+    call output(8)
+    return
+`},
+		{"constprop join", OptOptions{ConstProp: true},
+			`void main(void) { int a; int b; a = input(); if (a > 0) b = 1; else b = 2; output(b); }`, `B0:
+    %t1 = call input()
+    a = %t1
+    %t2 = a > 0
+    branch %t2, B1, B3
+B1:
+    b = 1
+    jump B2
+B2:
+    call output(b)
+    return
+B3:
+    b = 2
+    jump B2
+`, `B0:
+    %t1 = call input()
+    a = %t1
+    %t2 = a > 0
+    branch %t2, B1, B3
+B1:
+    b = 1
+    jump B2
+B2:
+    call output(b)
+    return
+B3:
+    b = 2
+    jump B2
+`},
+		{"copyprop", OptOptions{CopyProp: true},
+			`void main(void) { int a; int b; a = input(); b = a; output(b); }`, `B0:
+    %t1 = call input()
+    a = %t1
+    b = a
+    call output(b)
+    return
+`, `B0:
+    %t1 = call input()
+    a = %t1
+    b = %t1
+    call output(%t1)
+    return
+`},
+		{"dce", OptOptions{DCE: true},
+			`void main(void) { int a; int b; a = input(); b = a * 2; output(a); }`, `B0:
+    %t1 = call input()
+    a = %t1
+    %t2 = a * 2
+    b = %t2
+    call output(a)
+    return
+`, `B0:
+    %t1 = call input()
+    a = %t1
+    call output(a)
+    return
+`},
+		{"cse", OptOptions{CSE: true},
+			`void main(void) { int a; int b; a = input(); b = input(); output(a * b); output(b * a); }`, `B0:
+    %t1 = call input()
+    a = %t1
+    %t2 = call input()
+    b = %t2
+    %t3 = a * b
+    call output(%t3)
+    %t4 = b * a
+    call output(%t4)
+    return
+`, `B0:
+    %t1 = call input()
+    a = %t1
+    %t2 = call input()
+    b = %t2
+    %t3 = a * b
+    call output(%t3)
+    %t4 = %t3
+    call output(%t4)
+    return
+`},
+		{"licm", OptOptions{LICM: true},
+			`void main(void) { int i; int n; int s; n = input(); i = 0; s = 0; while (i < 10) { s = s + n * 2; i = i + 1; } output(s); }`, `B0:
+    %t1 = call input()
+    n = %t1
+    i = 0
+    s = 0
+    jump B1
+B1:
+    %t2 = i < 10
+    branch %t2, B2, B3
+B2:
+    %t3 = n * 2
+    %t4 = s + %t3
+    s = %t4
+    %t5 = i + 1
+    i = %t5
+    jump B1
+B3:
+    call output(s)
+    return
+`, `B0:
+    %t1 = call input()
+    n = %t1
+    i = 0
+    s = 0
+    %t3 = n * 2
+    jump B1
+B1:
+    %t2 = i < 10
+    branch %t2, B2, B3
+B2:
+    %t4 = s + %t3
+    s = %t4
+    %t5 = i + 1
+    i = %t5
+    jump B1
+B3:
+    call output(s)
+    return
+`},
+	}
+	for _, tt := range tests {
+		root, _, err := ParseProgram(tt.src)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		prog, err := GenerateIR(root)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		fn := prog.Funcs[0]
+		if got := irText(fn); got != tt.before {
+			t.Errorf("%s: before: got\n%s\nwant\n%s", tt.name, got, tt.before)
+			continue
+		}
+		OptimizeFunc(fn, tt.opts)
+		if got := irText(fn); got != tt.after {
+			t.Errorf("%s: after: got\n%s\nwant\n%s", tt.name, got, tt.after)
+		}
+	}
+}
+
+func TestOptSamples(t *testing.T) {
+	passes := map[string]OptOptions{
+		"constprop": {ConstProp: true},
+		"copyprop":  {CopyProp: true},
+		"dce":       {DCE: true},
+		"cse":       {CSE: true},
+		"licm":      {LICM: true},
+		"O1":        OptLevel(1),
+	}
+	for name, opts := range passes {
+		for _, s := range samples {
+			prog := irSample(t, s)
+			Optimize(prog, opts)
+			checkIRSample(t, name, s, prog)
+		}
+	}
+}
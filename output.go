@@ -0,0 +1,319 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: output.go
+// Package: scan
+// Description: 本文件定义了词法分析结果、语法树和符号表的输出格式
+// 				text 为原有的文本格式(HelpPrintFile、HelpPrintTree、HelpPrintTable)
+// 				json 输出 JSON,同一文件中有多种结果时合并为一个对象,键为 tokens、ast、symbols
+// 				dot 输出 Graphviz 的 digraph,每种结果一个图
+
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 输出格式
+type OutputFormat int
+
+const (
+	FORMAT_TEXT OutputFormat = iota
+	FORMAT_JSON
+	FORMAT_DOT
+)
+
+// 分析结果的种类,可以按位组合
+const (
+	ARTIFACT_TOKENS  = 1 << iota // 词法分析结果
+	ARTIFACT_AST                 // 抽象语法树
+	ARTIFACT_SYMBOLS             // 符号表
+)
+
+// 根据名字得到输出格式
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	switch name {
+	case "text", "":
+		return FORMAT_TEXT, nil
+	case "json":
+		return FORMAT_JSON, nil
+	case "dot":
+		return FORMAT_DOT, nil
+	}
+	return FORMAT_TEXT, errors.New(Msg(MSG_UNKNOWN_FORMAT, name))
+}
+
+// 一个token及其所在行号
+type TokenRecord struct {
+	Line   int
+	Token  Token
+	Lexeme TokenString
+}
+
+// 一次分析的全部结果
+type Artifacts struct {
+	Tokens  []TokenRecord
+	AST     *ASTNode
+	Symbols *SymbolTableNode
+}
+
+// 扫描缓冲区中的全部token,包括注释和错误token
+func ScanTokens(buf *Buffer) []TokenRecord {
+	var res []TokenRecord
+	scanner := NewScanner(buf)
+	for token, lexeme := scanner.getToken(); token != EOF_TOKEN; token, lexeme = scanner.getToken() {
+		res = append(res, TokenRecord{Line: buf.Lines(), Token: token, Lexeme: lexeme})
+	}
+	return res
+}
+
+// 按格式打印 parts 指定的分析结果
+func HelpPrintArtifacts(a *Artifacts, parts int, format OutputFormat, file *os.File) error {
+	switch format {
+	case FORMAT_JSON:
+		return helpPrintJSON(a, parts, file)
+	case FORMAT_DOT:
+		if parts&ARTIFACT_TOKENS != 0 {
+			helpPrintTokensDot(a.Tokens, file)
+		}
+		if parts&ARTIFACT_AST != 0 {
+			helpPrintTreeDot(a.AST, file)
+		}
+		if parts&ARTIFACT_SYMBOLS != 0 {
+			helpPrintTableDot(a.Symbols, file)
+		}
+		return nil
+	}
+	if parts&ARTIFACT_TOKENS != 0 {
+		for _, t := range a.Tokens {
+			HelpPrintFile(t.Token, t.Lexeme, t.Line, file)
+		}
+	}
+	if parts&ARTIFACT_AST != 0 {
+		HelpPrintTree(a.AST, 0, '-', file)
+	}
+	if parts&ARTIFACT_SYMBOLS != 0 && a.Symbols != nil {
+		HelpPrintTable(a.Symbols, 0, '-', file)
+	}
+	return nil
+}
+
+// JSON 形式的 token
+type tokenJSON struct {
+	Line   int    `json:"line"`
+	Kind   string `json:"kind"`
+	Lexeme string `json:"lexeme"`
+}
+
+// JSON 形式的语法树节点,子节点按 left、mid、right 的顺序展开兄弟序列
+type astJSON struct {
+	Kind     string     `json:"kind"`
+	Line     int        `json:"line"`
+	Name     string     `json:"name,omitempty"`
+	Value    *int64     `json:"value,omitempty"`
+	Op       string     `json:"op,omitempty"`
+	Type     string     `json:"type,omitempty"`
+	Children []*astJSON `json:"children,omitempty"`
+}
+
+// JSON 形式的符号
+type symbolJSON struct {
+	Name string `json:"name"`
+	Line int    `json:"line"`
+}
+
+// JSON 形式的作用域
+type scopeJSON struct {
+	Symbols []symbolJSON `json:"symbols"`
+	Scopes  []*scopeJSON `json:"scopes,omitempty"`
+}
+
+// 以 JSON 形式打印,只有一种结果时直接输出该结果
+func helpPrintJSON(a *Artifacts, parts int, file *os.File) error {
+	obj := make(map[string]interface{})
+	if parts&ARTIFACT_TOKENS != 0 {
+		tokens := make([]tokenJSON, 0, len(a.Tokens))
+		for _, t := range a.Tokens {
+			tokens = append(tokens, tokenJSON{Line: t.Line, Kind: tokenCategory(t.Token), Lexeme: string(t.Lexeme)})
+		}
+		obj["tokens"] = tokens
+	}
+	if parts&ARTIFACT_AST != 0 {
+		obj["ast"] = astChainJSON(a.AST)
+	}
+	if parts&ARTIFACT_SYMBOLS != 0 {
+		obj["symbols"] = scopeToJSON(a.Symbols)
+	}
+	var out interface{} = obj
+	if len(obj) == 1 {
+		for _, v := range obj {
+			out = v
+		}
+	}
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// 节点种类名,形参列表、实参列表和类型节点也有名字
+func astKindName(node *ASTNode) string {
+	switch node.nodeK {
+	case PARAMS:
+		return "Params"
+	case ARGS:
+		return "Args"
+	case TYPE:
+		return "Type"
+	}
+	return NodeKindName(node)
+}
+
+// 兄弟序列转换为 JSON 数组
+func astChainJSON(node *ASTNode) []*astJSON {
+	res := []*astJSON{}
+	for ; node != nil; node = node.sibling {
+		res = append(res, astToJSON(node))
+	}
+	return res
+}
+
+// 语法树节点转换为 JSON 对象
+func astToJSON(node *ASTNode) *astJSON {
+	res := &astJSON{Kind: astKindName(node), Line: node.line, Name: nodeName(node)}
+	switch {
+	case isExp(node, CONST):
+		val := nodeValue(node)
+		res.Value = &val
+	case isExp(node, OPERATION), isExp(node, COMPARE):
+		res.Op = tokenSymbol(nodeOp(node))
+	case node.nodeK == TYPE:
+		res.Type = varTypeName(node.varT)
+	}
+	for _, child := range []*ASTNode{node.left, node.mid, node.right} {
+		for c := child; c != nil; c = c.sibling {
+			res.Children = append(res.Children, astToJSON(c))
+		}
+	}
+	return res
+}
+
+// 作用域中按行号和名字排列的符号
+func scopeSymbols(table *SymbolTableNode) []symbolJSON {
+	res := []symbolJSON{}
+	for name, value := range table.data {
+		line := 0
+		if c, ok := value.(*content); ok {
+			line = c.line
+		}
+		res = append(res, symbolJSON{Name: name, Line: line})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Line != res[j].Line {
+			return res[i].Line < res[j].Line
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// 作用域的子作用域: next 及其右兄弟
+func childScopes(table *SymbolTableNode) []*SymbolTableNode {
+	var res []*SymbolTableNode
+	for c := table.next; c != nil; c = c.rightSib {
+		res = append(res, c)
+	}
+	return res
+}
+
+// 符号表转换为 JSON 对象
+func scopeToJSON(table *SymbolTableNode) *scopeJSON {
+	if table == nil {
+		return nil
+	}
+	res := &scopeJSON{Symbols: scopeSymbols(table)}
+	for _, c := range childScopes(table) {
+		res.Scopes = append(res.Scopes, scopeToJSON(c))
+	}
+	return res
+}
+
+// 以 dot 形式打印 token 序列,每个 token 一个节点,依次相连
+func helpPrintTokensDot(tokens []TokenRecord, file *os.File) {
+	fmt.Fprintln(file, "digraph tokens {")
+	fmt.Fprintln(file, "  rankdir=LR;")
+	fmt.Fprintln(file, "  node [shape=box];")
+	for i, t := range tokens {
+		fmt.Fprintf(file, "  t%d [label=%q];\n", i, fmt.Sprintf("%s\n%s\nline %d", tokenCategory(t.Token), string(t.Lexeme), t.Line))
+		if i > 0 {
+			fmt.Fprintf(file, "  t%d -> t%d;\n", i-1, i)
+		}
+	}
+	fmt.Fprintln(file, "}")
+}
+
+// 以 dot 形式打印语法树,全局声明挂在虚拟的 Program 节点下
+func helpPrintTreeDot(root *ASTNode, file *os.File) {
+	fmt.Fprintln(file, "digraph ast {")
+	fmt.Fprintln(file, "  node [shape=box];")
+	fmt.Fprintln(file, "  n0 [label=\"Program\"];")
+	id := 0
+	var walk func(parent int, node *ASTNode)
+	walk = func(parent int, node *ASTNode) {
+		for ; node != nil; node = node.sibling {
+			id++
+			self := id
+			fmt.Fprintf(file, "  n%d [label=%q];\n", self, dotLabel(node))
+			fmt.Fprintf(file, "  n%d -> n%d;\n", parent, self)
+			walk(self, node.left)
+			walk(self, node.mid)
+			walk(self, node.right)
+		}
+	}
+	walk(0, root)
+	fmt.Fprintln(file, "}")
+}
+
+// 语法树节点在 dot 图中的标签
+func dotLabel(node *ASTNode) string {
+	label := astKindName(node)
+	switch {
+	case len(nodeName(node)) != 0:
+		label += "\n" + nodeName(node)
+	case isExp(node, CONST):
+		label += fmt.Sprintf("\n%d", nodeValue(node))
+	case isExp(node, OPERATION), isExp(node, COMPARE):
+		label += "\n" + tokenSymbol(nodeOp(node))
+	case node.nodeK == TYPE:
+		label += "\n" + varTypeName(node.varT)
+	}
+	return fmt.Sprintf("%s\nline %d", label, node.line)
+}
+
+// 以 dot 形式打印符号表,每个作用域一个节点,列出其中的符号
+func helpPrintTableDot(root *SymbolTableNode, file *os.File) {
+	fmt.Fprintln(file, "digraph symbols {")
+	fmt.Fprintln(file, "  node [shape=box];")
+	id := 0
+	var walk func(table *SymbolTableNode) int
+	walk = func(table *SymbolTableNode) int {
+		self := id
+		id++
+		var lines []string
+		for _, s := range scopeSymbols(table) {
+			lines = append(lines, fmt.Sprintf("%s (line %d)", s.Name, s.Line))
+		}
+		fmt.Fprintf(file, "  s%d [label=%q];\n", self, fmt.Sprintf("scope %d\n%s", self, strings.Join(lines, "\n")))
+		for _, c := range childScopes(table) {
+			fmt.Fprintf(file, "  s%d -> s%d;\n", self, walk(c))
+		}
+		return self
+	}
+	if root != nil {
+		walk(root)
+	}
+	fmt.Fprintln(file, "}")
+}
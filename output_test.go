@@ -0,0 +1,234 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: output_test.go
+// Package: scan
+// Description: 分析结果输出格式的测试
+// 				json 输出可以解析回原来的结构,多种结果合并为一个对象;dot 输出每种结果一个图
+
+package scan
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const outputSrc = `int x; /* 全局 */
+int f(int a[]) { return a[0]; }
+void main(void) {
+	int y[2];
+	x = 0 + f(y);
+}
+`
+
+// 分析源程序得到全部结果
+func outputArtifacts(t *testing.T, src string) *Artifacts {
+	t.Helper()
+	parser := NewParser(NewStringBuffer(src))
+	parser.SetOutput(nil)
+	parser.SetQuiet(true)
+	root, table := parser.Parse()
+	if diags := parser.Diagnostics(); len(diags) > 0 {
+		t.Fatal(diags[0].String())
+	}
+	return &Artifacts{Tokens: ScanTokens(NewStringBuffer(src)), AST: root, Symbols: table}
+}
+
+// 按格式打印分析结果,返回输出的文本
+func printArtifacts(t *testing.T, a *Artifacts, parts int, format OutputFormat) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "out")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := HelpPrintArtifacts(a, parts, format, file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestOutputTokensJSON(t *testing.T) {
+	// 词法分析结果不要求是完整的程序
+	a := &Artifacts{Tokens: ScanTokens(NewStringBuffer("int x; /* c */\nx = 10;"))}
+	var got []tokenJSON
+	if err := json.Unmarshal([]byte(printArtifacts(t, a, ARTIFACT_TOKENS, FORMAT_JSON)), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []tokenJSON{
+		{1, "KEY", "int"}, {1, "ID", "x"}, {1, "SEP", ";"}, {1, "COMMENT", "/* c */"},
+		{2, "ID", "x"}, {2, "OP", "="}, {2, "NUM", "10"}, {2, "SEP", ";"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokens:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+// 紧凑形式的 JSON 语法树,便于比较
+func compactAST(nodes []*astJSON) string {
+	var parts []string
+	for _, n := range nodes {
+		s := n.Kind
+		switch {
+		case len(n.Name) != 0:
+			s += ":" + n.Name
+		case n.Value != nil:
+			s += ":" + strings.TrimSpace(string(mustJSON(*n.Value)))
+		case len(n.Op) != 0:
+			s += ":" + n.Op
+		case len(n.Type) != 0:
+			s += ":" + n.Type
+		}
+		if len(n.Children) != 0 {
+			s += "(" + compactAST(n.Children) + ")"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+func mustJSON(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+func TestOutputASTJSON(t *testing.T) {
+	a := outputArtifacts(t, outputSrc)
+	var got []*astJSON
+	if err := json.Unmarshal([]byte(printArtifacts(t, a, ARTIFACT_AST, FORMAT_JSON)), &got); err != nil {
+		t.Fatal(err)
+	}
+	// 值为0的常量也要输出 value
+	want := "VarDecl:x(Type:int) " +
+		"FuncDecl:f(Type:int Params(Param:a(Type:int[])) Compound(Return(Var:a(Const:0)))) " +
+		"FuncDecl:main(Type:void Params Compound(VarDecl:y(Type:int[] Const:2) Assign(Var:x Binary:+(Const:0 Call:f(Args(Var:y))))))"
+	if s := compactAST(got); s != want {
+		t.Errorf("ast:\ngot  %s\nwant %s", s, want)
+	}
+	if assign := got[2].Children[2].Children[1]; got[2].Line != 3 || assign.Line != 5 {
+		t.Errorf("wrong line numbers: main at %d, assignment at %d", got[2].Line, assign.Line)
+	}
+}
+
+func TestOutputSymbolsJSON(t *testing.T) {
+	a := outputArtifacts(t, outputSrc)
+	var got scopeJSON
+	if err := json.Unmarshal([]byte(printArtifacts(t, a, ARTIFACT_SYMBOLS, FORMAT_JSON)), &got); err != nil {
+		t.Fatal(err)
+	}
+	var names func(s *scopeJSON) string
+	names = func(s *scopeJSON) string {
+		var res []string
+		for _, sym := range s.Symbols {
+			res = append(res, sym.Name)
+		}
+		for _, c := range s.Scopes {
+			res = append(res, "{"+names(c)+"}")
+		}
+		return strings.Join(res, " ")
+	}
+	// 函数的作用域记录形参,函数体的作用域是它的子作用域,记录其中出现的标识符(包括引用的外层标识符)
+	if s, want := names(&got), "x f main {a {a}} {{y f x}}"; s != want {
+		t.Errorf("symbols: got %s, want %s", s, want)
+	}
+}
+
+func TestOutputCombinedJSON(t *testing.T) {
+	// 多种结果合并为一个对象
+	a := outputArtifacts(t, outputSrc)
+	var got map[string]json.RawMessage
+	out := printArtifacts(t, a, ARTIFACT_TOKENS|ARTIFACT_AST|ARTIFACT_SYMBOLS, FORMAT_JSON)
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for k := range got {
+		keys = append(keys, k)
+	}
+	if len(keys) != 3 || got["tokens"] == nil || got["ast"] == nil || got["symbols"] == nil {
+		t.Errorf("keys %v, want tokens, ast and symbols", keys)
+	}
+	// 合并后的各部分与单独输出时相同
+	single := printArtifacts(t, a, ARTIFACT_AST, FORMAT_JSON)
+	var x, y interface{}
+	json.Unmarshal(got["ast"], &x)
+	json.Unmarshal([]byte(single), &y)
+	if !reflect.DeepEqual(x, y) {
+		t.Error("combined ast differs from the ast alone")
+	}
+}
+
+// dot 输出中的图名和边数
+func dotGraphs(out string) (names []string, edges []int) {
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "digraph "):
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(line, "digraph "), " {"))
+			edges = append(edges, 0)
+		case strings.Contains(line, " -> "):
+			edges[len(edges)-1]++
+		}
+	}
+	return names, edges
+}
+
+func TestOutputDot(t *testing.T) {
+	a := outputArtifacts(t, outputSrc)
+	out := printArtifacts(t, a, ARTIFACT_TOKENS|ARTIFACT_AST|ARTIFACT_SYMBOLS, FORMAT_DOT)
+	names, edges := dotGraphs(out)
+	if strings.Join(names, " ") != "tokens ast symbols" {
+		t.Fatalf("graphs %v, want tokens ast symbols", names)
+	}
+	// token 依次相连;语法树每个节点一条入边;符号表为全局作用域、两个函数作用域和两个函数体作用域
+	if edges[0] != len(a.Tokens)-1 {
+		t.Errorf("token edges %d, want %d", edges[0], len(a.Tokens)-1)
+	}
+	count := 0
+	Inspect(a.AST, func(node *ASTNode) bool {
+		if node != nil {
+			count++
+		}
+		return true
+	})
+	if edges[1] != count {
+		t.Errorf("ast edges %d, want %d", edges[1], count)
+	}
+	if edges[2] != 4 {
+		t.Errorf("symbol table edges %d, want 4", edges[2])
+	}
+	for _, want := range []string{
+		`t0 [label="KEY\nint\nline 1"];`,
+		`n0 [label="Program"];`,
+		`[label="Binary\n+\nline 5"];`,
+		`[label="Const\n2\nline 4"];`,
+		`s0 [label="scope 0\nx (line 1)\nf (line 2)\nmain (line 3)"];`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dot output lacks %s", want)
+		}
+	}
+
+	// 只有语法树时只输出一个图
+	if names, _ := dotGraphs(printArtifacts(t, a, ARTIFACT_AST, FORMAT_DOT)); len(names) != 1 || names[0] != "ast" {
+		t.Errorf("graphs %v, want ast", names)
+	}
+}
+
+func TestParseOutputFormat(t *testing.T) {
+	for name, want := range map[string]OutputFormat{"": FORMAT_TEXT, "text": FORMAT_TEXT, "json": FORMAT_JSON, "dot": FORMAT_DOT} {
+		if got, err := ParseOutputFormat(name); err != nil || got != want {
+			t.Errorf("%q: got %v, %v", name, got, err)
+		}
+	}
+	if _, err := ParseOutputFormat("xml"); err == nil {
+		t.Error("xml: want an error")
+	}
+}
@@ -8,6 +8,7 @@ package scan
 
 import (
 	"fmt"
+	"os"
 	"strconv"
 )
 
@@ -15,10 +16,16 @@ import (
 Parser类定义
 */
 type Parser struct {
-	buffer     *Buffer     // 输入缓冲区
-	aheadToken Token       // 前向Token
-	lexeme     TokenString // 扫描出的词素
-	scanner    *Scanner    // 词法分析器
+	buffer     *Buffer          // 输入缓冲区
+	aheadToken Token            // 前向Token
+	lexeme     TokenString      // 扫描出的词素
+	scanner    *Scanner         // 词法分析器
+	errCount   int              // 语法错误数
+	diags      []Diagnostic     // 语法错误的诊断信息
+	quiet      bool             // 不把语法错误打印到标准输出
+	out        *os.File         // 词法分析结果的输出文件
+	table      *SymbolTableNode // 当前作用域的符号表
+	siblings   bool             // 当前作用域下是否已有并列的作用域
 }
 
 // 语法分析器工厂函数
@@ -26,9 +33,21 @@ func NewParser(buffer *Buffer) *Parser {
 	var parser Parser
 	parser.buffer = buffer
 	parser.scanner = NewScanner(buffer)
+	parser.out = FileOut
 	return &parser
 }
 
+// 设置词法分析结果的输出文件,为 nil 时不输出
+func (parser *Parser) SetOutput(file *os.File) {
+	parser.out = file
+	parser.scanner.out = file
+}
+
+// 设置是否把语法错误和分析进度打印到标准输出,不打印时可以通过 Diagnostics 获取语法错误
+func (parser *Parser) SetQuiet(quiet bool) {
+	parser.quiet = quiet
+}
+
 // 分析类型节点
 func (parser *Parser) typeSpecifier() *ASTNode {
 	var node *ASTNode
@@ -62,18 +81,18 @@ func (parser *Parser) declaration() *ASTNode {
 
 	typeNode = parser.typeSpecifier() // 类型节点
 	identifier = parser.lexeme
-	AddIdentifier(string(identifier)) // 添加到符号表
+	parser.addIdentifier(string(identifier)) // 添加到符号表
 	parser.match(ID)
 	// 根据后一个token类型判断是函数声明还是变量声明，以及是否数组声明
 	// 函数声明
 	if parser.aheadToken == L_PARE_S {
-		MoveDown()                                           // 函数参数也属于下一层作用域
+		parser.moveDown()                                    // 函数参数也属于下一层作用域
 		node = NewASTNode(STATEMENT, FUNC_DECLARATION, line) // 表达式类型、函数声明
 		parser.match(L_PARE_S)
 		p := parser.params()
 		parser.match(R_PARE_S)
 		c := parser.compoundStmt()
-		MoveUp() // 作用域离开函数声明
+		parser.moveUp() // 作用域离开函数声明
 		node.SetMid(p)
 		node.SetRight(c)
 	} else { // 变量、数组声明
@@ -103,10 +122,10 @@ func (parser *Parser) params() *ASTNode {
 	typeNode = parser.typeSpecifier()
 	// 说明是有参数的函数
 	if parser.aheadToken == ID {
-		AddIdentifier(string(parser.lexeme)) // 参数标识符添加到符号表
-		cur = NewASTNode(PARAM, nil, line)   // 单个参数
-		cur.SetAttr(parser.lexeme)           // 设置参数ID
-		cur.SetLeft(typeNode)                // 设置类型
+		parser.addIdentifier(string(parser.lexeme)) // 参数标识符添加到符号表
+		cur = NewASTNode(PARAM, nil, line)          // 单个参数
+		cur.SetAttr(parser.lexeme)                  // 设置参数ID
+		cur.SetLeft(typeNode)                       // 设置类型
 		parser.match(ID)
 
 		// 判断是否是数组参数
@@ -141,8 +160,8 @@ func (parser *Parser) param() *ASTNode {
 	cur = NewASTNode(PARAM, nil, line)
 	cur.SetLeft(typeNode)
 
-	cur.SetAttr(parser.lexeme)           // 设置形参ID
-	AddIdentifier(string(parser.lexeme)) // 参数标识符添加到符号表
+	cur.SetAttr(parser.lexeme)                  // 设置形参ID
+	parser.addIdentifier(string(parser.lexeme)) // 参数标识符添加到符号表
 	parser.match(ID)
 
 	// 判断是否是数组参数
@@ -207,8 +226,8 @@ func (parser *Parser) varDeclaration() *ASTNode {
 	typeNode = parser.typeSpecifier()
 	node.SetLeft(typeNode)
 
-	node.SetAttr(parser.lexeme)          // 设置变量ID属性
-	AddIdentifier(string(parser.lexeme)) // 变量标识符添加到符号表
+	node.SetAttr(parser.lexeme)                 // 设置变量ID属性
+	parser.addIdentifier(string(parser.lexeme)) // 变量标识符添加到符号表
 	parser.match(ID)
 
 	if parser.aheadToken == L_PARE_M { // 数组声明
@@ -292,7 +311,7 @@ func (parser *Parser) expression() *ASTNode {
 		res = cur
 	case ID: // 有三种可能，一是var,二是call,三是赋值语句，需要后面再进行判断
 		id = parser.lexeme
-		AddIdentifier(string(parser.lexeme)) // 标识符添加到符号表
+		parser.addIdentifier(string(parser.lexeme)) // 标识符添加到符号表
 		parser.match(ID)
 
 		switch parser.aheadToken {
@@ -503,7 +522,7 @@ func (parser *Parser) term() *ASTNode {
 		next.SetAttr(parser.aheadToken)                // 设置操作符
 		parser.match(parser.aheadToken)
 
-		t := parser.term()
+		t := parser.factor() // 左结合: a / b * c 为 (a / b) * c
 		next.SetLeft(cur)
 		next.SetRight(t)
 		cur = next
@@ -529,7 +548,7 @@ func (parser *Parser) factor() *ASTNode {
 		parser.match(R_PARE_S)
 	case ID: // 左值变量或者函数调用
 		id = parser.lexeme
-		AddIdentifier(string(parser.lexeme)) // 标识符添加到符号表
+		parser.addIdentifier(string(parser.lexeme)) // 标识符添加到符号表
 		parser.match(ID)
 		if parser.aheadToken == L_PARE_S { // 函数调用
 			res = NewASTNode(EXPRESSION, CALL, line)
@@ -576,22 +595,57 @@ func (parser *Parser) args() *ASTNode {
 
 // 利用递归下降法生成抽象语法树
 func (parser *Parser) Parse() (*ASTNode, *SymbolTableNode) {
-	// 获取第一个token
-	for parser.aheadToken, parser.lexeme = parser.scanner.getToken(); parser.aheadToken == COMMENT || parser.aheadToken == ERROR; parser.aheadToken, parser.lexeme = parser.scanner.getToken() {
-		// 将词法打印到文件
-		HelpPrintFile(parser.aheadToken, parser.lexeme, parser.buffer.Lines(), FileOut)
-	}
-
-	// 初始化当前符号表
-	curTable = NewTable()
+	parser.begin()
 
 	// 语法树以声明列表的形式调用
 	astNode := parser.declarationList()
 	if parser.aheadToken != EOF_TOKEN {
 		parser.syntaxError()
 	}
-	fmt.Println("Parser Done!")
-	return astNode, curTable
+	if !parser.quiet {
+		fmt.Println("Parser Done!")
+	}
+	return astNode, parser.table
+}
+
+// 开始分析: 初始化符号表并获取第一个token
+func (parser *Parser) begin() {
+	parser.table = NewTable()
+	parser.siblings = false
+
+	// 获取第一个token
+	for parser.aheadToken, parser.lexeme = parser.scanner.getToken(); parser.aheadToken == COMMENT || parser.aheadToken == ERROR; parser.aheadToken, parser.lexeme = parser.scanner.getToken() {
+		// 将词法打印到文件
+		HelpPrintFile(parser.aheadToken, parser.lexeme, parser.buffer.Lines(), parser.out)
+	}
+}
+
+// 分析交互式输入: 任意顺序的声明和语句组成的序列,直到输入结束
+// 表达式语句以表达式节点的形式出现在序列中,空语句被忽略,遇到语法错误时停止
+func (parser *Parser) ParseUnits() *ASTNode {
+	var res, cur, next *ASTNode
+	parser.begin()
+	for parser.aheadToken != EOF_TOKEN && parser.errCount == 0 {
+		switch parser.aheadToken {
+		case INT, VOID:
+			next = parser.declaration()
+		case SEMI:
+			parser.match(SEMI)
+			continue
+		default:
+			next = parser.statement()
+		}
+		if next == nil {
+			continue
+		}
+		if res == nil {
+			res = next
+		} else {
+			cur.SetSibling(next)
+		}
+		cur = next
+	}
+	return res
 }
 
 // 匹配期待的token并获取下一个token
@@ -599,27 +653,49 @@ func (parser *Parser) match(t Token) {
 	if t == parser.aheadToken {
 		// 符号表操作
 		if t == R_PARE_L {
-			MoveUp()
+			parser.moveUp()
 		} else if t == L_PARE_L {
-			MoveDown()
+			parser.moveDown()
 		}
-		HelpPrintFile(parser.aheadToken, parser.lexeme, parser.buffer.Lines(), FileOut)
+		HelpPrintFile(parser.aheadToken, parser.lexeme, parser.buffer.Lines(), parser.out)
 
 		// 获取下一个token,将注释token和错误token过滤
 		for parser.aheadToken, parser.lexeme = parser.scanner.getToken(); parser.aheadToken == COMMENT || parser.aheadToken == ERROR; parser.aheadToken, parser.lexeme = parser.scanner.getToken() {
 			// 将词法打印到文件
-			HelpPrintFile(parser.aheadToken, parser.lexeme, parser.buffer.Lines(), FileOut)
+			HelpPrintFile(parser.aheadToken, parser.lexeme, parser.buffer.Lines(), parser.out)
 		}
 	} else {
 		parser.syntaxError()
 	}
 }
 
+// 当前token的文本形式,用于错误信息
+func (parser *Parser) tokenText() string {
+	if parser.aheadToken == EOF_TOKEN {
+		return Msg(MSG_SYNTAX_EOF)
+	}
+	return fmt.Sprintf("%q", string(parser.lexeme))
+}
+
+// 返回语法分析过程中遇到的语法错误数
+func (parser *Parser) Errors() int {
+	return parser.errCount
+}
+
+// 返回语法错误的诊断信息
+func (parser *Parser) Diagnostics() []Diagnostic {
+	return parser.diags
+}
+
 // 语法错误时打印错误消息
 func (parser *Parser) syntaxError() {
-	fmt.Printf("%s: [%d]. Token [%d]\n", "Syntax Error in Line", parser.buffer.Lines(), parser.aheadToken)
+	parser.errCount++
+	parser.diags = append(parser.diags, newError(parser.buffer.Lines(), DIAG_SYNTAX, MSG_SYNTAX_UNEXPECTED, parser.tokenText()))
+	if !parser.quiet {
+		fmt.Println(Msg(MSG_SYNTAX_LINE, parser.buffer.Lines(), parser.aheadToken))
+	}
 	// 获取下一个token,将注释token和错误token过滤
 	for parser.aheadToken, parser.lexeme = parser.scanner.getToken(); parser.aheadToken == COMMENT || parser.aheadToken == ERROR; parser.aheadToken, parser.lexeme = parser.scanner.getToken() {
-		HelpPrintFile(parser.aheadToken, parser.lexeme, parser.buffer.Lines(), FileOut)
+		HelpPrintFile(parser.aheadToken, parser.lexeme, parser.buffer.Lines(), parser.out)
 	}
 }
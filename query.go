@@ -0,0 +1,521 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: query.go
+// Package: scan
+// Description: 本文件定义了抽象语法树上的查询语言,语法类似 CSS 选择器
+// 				FuncDecl[name=main] Call[name=output]  main 函数中对 output 的调用(空格表示任意层的后代)
+// 				While > Compound                         循环体直接是复合语句的 while(> 表示直接子节点)
+// 				While[constant], Call[recursive]         条件为常量的 while 循环以及递归调用(逗号分隔多个选择器)
+// 				谓词的比较运算有 = != < <= > >=,只写属性名时要求属性存在且不为 false
+// 				形参列表、实参列表和类型节点对查询透明,Call > Var 匹配直接作为实参的变量
+
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 查询中可用的节点种类,Decl、Stmt、Expr 和 * 匹配一类节点
+var QUERY_KINDS = map[string]bool{
+	"*": true, "Decl": true, "Stmt": true, "Expr": true,
+	"VarDecl": true, "FuncDecl": true, "Param": true,
+	"Compound": true, "If": true, "While": true, "Return": true,
+	"Var": true, "Assign": true, "Call": true, "Compare": true, "Const": true, "Binary": true,
+}
+
+// 查询中可用的属性
+var QUERY_ATTRS = map[string]string{
+	"name":      "identifier of VarDecl, FuncDecl, Param, Var and Call",
+	"value":     "value of Const",
+	"op":        "operator of Binary and Compare",
+	"line":      "line number of any node",
+	"type":      "int, int[] or void of VarDecl, FuncDecl and Param",
+	"array":     "VarDecl and Param of array type, Var with an index",
+	"args":      "number of arguments of Call",
+	"params":    "number of parameters of FuncDecl",
+	"else":      "If with an else branch",
+	"constant":  "If and While with a constant condition, constant Expr",
+	"recursive": "FuncDecl on a cycle of the call graph, Call that may lead back to its caller",
+}
+
+// 编译后的查询
+type Query struct {
+	src  string
+	sels [][]queryStep // 逗号分隔的选择器,每个选择器由若干步组成
+}
+
+// 选择器中的一步
+type queryStep struct {
+	kind  string // 节点种类
+	child bool   // 与前一步之间为 '>'
+	preds []queryPred
+}
+
+// 谓词,op 为空时只要求属性存在
+type queryPred struct {
+	attr, op, value string
+}
+
+// 编译查询,语法错误时返回带位置的错误
+func CompileQuery(src string) (*Query, error) {
+	qp := &queryParser{src: src}
+	q := &Query{src: src}
+	for {
+		sel, err := qp.selector()
+		if err != nil {
+			return nil, err
+		}
+		q.sels = append(q.sels, sel)
+		if qp.pos >= len(qp.src) {
+			return q, nil
+		}
+		qp.pos++ // 逗号
+	}
+}
+
+// 查询的文本
+func (q *Query) String() string {
+	return q.src
+}
+
+// 在以 root 开始的兄弟序列中查找匹配的节点,按在源程序中出现的顺序返回
+func (q *Query) Match(root *ASTNode) []*ASTNode {
+	m := &queryMatcher{q: q, reach: callGraphReach(root)}
+	m.walk(root, nil)
+	return m.res
+}
+
+// 节点在查询中的种类名,形参列表、实参列表和类型节点返回空串
+func NodeKindName(node *ASTNode) string {
+	switch node.nodeK {
+	case STATEMENT:
+		switch node.nodeT {
+		case VAR_DECLARATION:
+			return "VarDecl"
+		case FUNC_DECLARATION:
+			return "FuncDecl"
+		case COMPOUND:
+			return "Compound"
+		case SELECTION_STMT:
+			return "If"
+		case ITERATION_STMT:
+			return "While"
+		case RETURN_STMT:
+			return "Return"
+		}
+	case EXPRESSION:
+		switch node.nodeT {
+		case VAR:
+			return "Var"
+		case ASSIGNMENT:
+			return "Assign"
+		case CALL:
+			return "Call"
+		case COMPARE:
+			return "Compare"
+		case CONST:
+			return "Const"
+		case OPERATION:
+			return "Binary"
+		}
+	case PARAM:
+		return "Param"
+	}
+	return ""
+}
+
+// 节点的简短描述,如 Call[name=output]
+func DescribeNode(node *ASTNode) string {
+	kind := NodeKindName(node)
+	switch kind {
+	case "VarDecl", "FuncDecl", "Param", "Var", "Call":
+		return fmt.Sprintf("%s[name=%s]", kind, nodeName(node))
+	case "Const":
+		return fmt.Sprintf("%s[value=%d]", kind, nodeValue(node))
+	case "Binary", "Compare":
+		return fmt.Sprintf("%s[op=%s]", kind, tokenSymbol(nodeOp(node)))
+	}
+	return kind
+}
+
+// 打印查询结果,每行一个匹配的节点
+func HelpPrintMatches(filename string, matches []*ASTNode, file *os.File) {
+	for _, node := range matches {
+		fmt.Fprintf(file, "%s:%d: %s\n", filename, node.line, DescribeNode(node))
+	}
+}
+
+// 查询的语法分析器
+type queryParser struct {
+	src string
+	pos int
+}
+
+// 语法错误,id 为消息编号
+func (qp *queryParser) errorf(id string, a ...interface{}) error {
+	return errors.New(Msg(MSG_QUERY_ERROR, Msg(id, a...), qp.pos))
+}
+
+// 跳过空白符,返回是否跳过了空白
+func (qp *queryParser) space() bool {
+	start := qp.pos
+	for qp.pos < len(qp.src) && (qp.src[qp.pos] == ' ' || qp.src[qp.pos] == '\t' || qp.src[qp.pos] == '\n') {
+		qp.pos++
+	}
+	return qp.pos > start
+}
+
+// 下一个字符,结尾时返回0
+func (qp *queryParser) peek() byte {
+	if qp.pos < len(qp.src) {
+		return qp.src[qp.pos]
+	}
+	return 0
+}
+
+// 标识符
+func (qp *queryParser) ident() string {
+	start := qp.pos
+	for qp.pos < len(qp.src) {
+		ch := qp.src[qp.pos]
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_' || ch == '-') {
+			break
+		}
+		qp.pos++
+	}
+	return qp.src[start:qp.pos]
+}
+
+// 选择器,到逗号或结尾为止
+func (qp *queryParser) selector() ([]queryStep, error) {
+	var steps []queryStep
+	child := false
+	for {
+		qp.space()
+		ch := qp.peek()
+		if ch == 0 || ch == ',' {
+			break
+		}
+		if ch == '>' {
+			if child || len(steps) == 0 {
+				return nil, qp.errorf(MSG_QUERY_UNEXPECTED_CHILD)
+			}
+			child = true
+			qp.pos++
+			continue
+		}
+		step, err := qp.step()
+		if err != nil {
+			return nil, err
+		}
+		step.child = child
+		child = false
+		steps = append(steps, step)
+	}
+	if child {
+		return nil, qp.errorf(MSG_QUERY_MISSING_KIND)
+	}
+	if len(steps) == 0 {
+		return nil, qp.errorf(MSG_QUERY_EMPTY)
+	}
+	return steps, nil
+}
+
+// 选择器中的一步: 节点种类及若干谓词
+func (qp *queryParser) step() (queryStep, error) {
+	var step queryStep
+	if qp.peek() == '*' {
+		qp.pos++
+		step.kind = "*"
+	} else {
+		step.kind = qp.ident()
+	}
+	if len(step.kind) == 0 {
+		return step, qp.errorf(MSG_QUERY_UNEXPECTED, qp.peek())
+	}
+	if !QUERY_KINDS[step.kind] {
+		return step, qp.errorf(MSG_QUERY_UNKNOWN_KIND, step.kind)
+	}
+	for qp.peek() == '[' {
+		qp.pos++
+		qp.space()
+		var pred queryPred
+		pred.attr = qp.ident()
+		if _, ok := QUERY_ATTRS[pred.attr]; !ok {
+			return step, qp.errorf(MSG_QUERY_UNKNOWN_ATTR, pred.attr)
+		}
+		qp.space()
+		for _, op := range []string{"!=", "<=", ">=", "=", "<", ">"} {
+			if strings.HasPrefix(qp.src[qp.pos:], op) {
+				pred.op = op
+				qp.pos += len(op)
+				break
+			}
+		}
+		if len(pred.op) != 0 {
+			value, err := qp.value()
+			if err != nil {
+				return step, err
+			}
+			pred.value = value
+		}
+		qp.space()
+		if qp.peek() != ']' {
+			return step, qp.errorf(MSG_QUERY_MISSING_BRACKET)
+		}
+		qp.pos++
+		step.preds = append(step.preds, pred)
+	}
+	return step, nil
+}
+
+// 谓词中的值,可以用双引号括起
+func (qp *queryParser) value() (string, error) {
+	qp.space()
+	if qp.peek() == '"' {
+		end := strings.IndexByte(qp.src[qp.pos+1:], '"')
+		if end < 0 {
+			return "", qp.errorf(MSG_QUERY_UNTERMINATED)
+		}
+		value := qp.src[qp.pos+1 : qp.pos+1+end]
+		qp.pos += end + 2
+		return value, nil
+	}
+	start := qp.pos
+	for qp.pos < len(qp.src) && qp.src[qp.pos] != ']' && qp.src[qp.pos] != ' ' {
+		qp.pos++
+	}
+	if qp.pos == start {
+		return "", qp.errorf(MSG_QUERY_MISSING_VALUE)
+	}
+	return qp.src[start:qp.pos], nil
+}
+
+// 查询的执行
+type queryMatcher struct {
+	q     *Query
+	reach map[string]map[string]bool // 调用图中每个函数可以到达的函数
+	res   []*ASTNode
+}
+
+// 先序遍历,anc 为祖先节点,不含形参列表、实参列表和类型节点
+func (m *queryMatcher) walk(node *ASTNode, anc []*ASTNode) {
+	for ; node != nil; node = node.sibling {
+		inner := anc
+		if len(NodeKindName(node)) != 0 {
+			for _, sel := range m.q.sels {
+				if m.matchSteps(sel, node, anc) {
+					m.res = append(m.res, node)
+					break
+				}
+			}
+			inner = append(anc[:len(anc):len(anc)], node)
+		}
+		m.walk(node.left, inner)
+		m.walk(node.mid, inner)
+		m.walk(node.right, inner)
+	}
+}
+
+// 判断节点及其祖先是否依次匹配选择器的各步
+func (m *queryMatcher) matchSteps(steps []queryStep, node *ASTNode, anc []*ASTNode) bool {
+	last := steps[len(steps)-1]
+	if !m.matchStep(last, node, anc) {
+		return false
+	}
+	if len(steps) == 1 {
+		return true
+	}
+	rest := steps[:len(steps)-1]
+	if last.child {
+		return len(anc) > 0 && m.matchSteps(rest, anc[len(anc)-1], anc[:len(anc)-1])
+	}
+	for i := len(anc) - 1; i >= 0; i-- {
+		if m.matchSteps(rest, anc[i], anc[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断单个节点是否匹配一步
+func (m *queryMatcher) matchStep(step queryStep, node *ASTNode, anc []*ASTNode) bool {
+	kind := NodeKindName(node)
+	switch step.kind {
+	case "*":
+	case "Decl":
+		if kind != "VarDecl" && kind != "FuncDecl" {
+			return false
+		}
+	case "Stmt":
+		if node.nodeK != STATEMENT || kind == "VarDecl" || kind == "FuncDecl" {
+			return false
+		}
+	case "Expr":
+		if node.nodeK != EXPRESSION {
+			return false
+		}
+	default:
+		if kind != step.kind {
+			return false
+		}
+	}
+	for _, pred := range step.preds {
+		val, ok := m.attr(node, pred.attr, anc)
+		if !pred.test(val, ok) {
+			return false
+		}
+	}
+	return true
+}
+
+// 判断属性值是否满足谓词
+func (pred queryPred) test(val string, ok bool) bool {
+	if !ok {
+		return false
+	}
+	switch pred.op {
+	case "":
+		return val != "false"
+	case "=":
+		return val == pred.value
+	case "!=":
+		return val != pred.value
+	}
+	x, err1 := strconv.ParseInt(val, 10, 64)
+	y, err2 := strconv.ParseInt(pred.value, 10, 64)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	switch pred.op {
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	case ">=":
+		return x >= y
+	}
+	return false
+}
+
+// 读取节点的属性,节点没有该属性时返回 false
+func (m *queryMatcher) attr(node *ASTNode, name string, anc []*ASTNode) (string, bool) {
+	kind := NodeKindName(node)
+	switch name {
+	case "line":
+		return strconv.Itoa(node.line), true
+	case "name":
+		switch kind {
+		case "VarDecl", "FuncDecl", "Param", "Var", "Call":
+			return nodeName(node), true
+		}
+	case "value":
+		if kind == "Const" {
+			return strconv.FormatInt(nodeValue(node), 10), true
+		}
+	case "op":
+		if kind == "Binary" || kind == "Compare" {
+			return tokenSymbol(nodeOp(node)), true
+		}
+	case "type":
+		switch kind {
+		case "VarDecl", "FuncDecl", "Param":
+			if node.left != nil {
+				return varTypeName(node.left.varT), true
+			}
+		}
+	case "array":
+		switch kind {
+		case "VarDecl", "Param":
+			return strconv.FormatBool(node.left != nil && node.left.varT == VAR_TYPE_INT_VECTOR), true
+		case "Var":
+			return strconv.FormatBool(node.left != nil), true
+		}
+	case "args":
+		if kind == "Call" {
+			return strconv.Itoa(len(callArgs(node))), true
+		}
+	case "params":
+		if kind == "FuncDecl" {
+			return strconv.Itoa(len(funcParams(node))), true
+		}
+	case "else":
+		if kind == "If" {
+			return strconv.FormatBool(node.right != nil), true
+		}
+	case "constant":
+		if kind == "If" || kind == "While" {
+			_, ok := evalConstExp(node.left)
+			return strconv.FormatBool(ok), true
+		}
+		if node.nodeK == EXPRESSION {
+			_, ok := evalConstExp(node)
+			return strconv.FormatBool(ok), true
+		}
+	case "recursive":
+		switch kind {
+		case "FuncDecl":
+			return strconv.FormatBool(m.reach[nodeName(node)][nodeName(node)]), true
+		case "Call":
+			for i := len(anc) - 1; i >= 0; i-- {
+				if isStmt(anc[i], FUNC_DECLARATION) {
+					caller, callee := nodeName(anc[i]), nodeName(node)
+					return strconv.FormatBool(caller == callee || m.reach[callee][caller]), true
+				}
+			}
+			return "false", true
+		}
+	}
+	return "", false
+}
+
+// 变量类型的文本形式
+func varTypeName(t VarType) string {
+	switch t {
+	case VAR_TYPE_INT:
+		return "int"
+	case VAR_TYPE_INT_VECTOR:
+		return "int[]"
+	}
+	return "void"
+}
+
+// 计算调用图中每个函数可以(经过至少一次调用)到达的函数
+func callGraphReach(root *ASTNode) map[string]map[string]bool {
+	calls := make(map[string][]string)
+	for node := root; node != nil; node = node.sibling {
+		if !isStmt(node, FUNC_DECLARATION) {
+			continue
+		}
+		name := nodeName(node)
+		calls[name] = nil
+		Inspect(node.right, func(n *ASTNode) bool {
+			if isExp(n, CALL) {
+				calls[name] = append(calls[name], nodeName(n))
+			}
+			return true
+		})
+	}
+	reach := make(map[string]map[string]bool)
+	for name := range calls {
+		seen := make(map[string]bool)
+		stack := append([]string(nil), calls[name]...)
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if seen[top] {
+				continue
+			}
+			seen[top] = true
+			stack = append(stack, calls[top]...)
+		}
+		reach[name] = seen
+	}
+	return reach
+}
@@ -0,0 +1,113 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: query_test.go
+// Package: scan
+// Description: 语法树查询语言的测试
+// 				属性谓词、后代和直接子节点的匹配、多个选择器,以及查询的语法错误
+
+package scan
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const querySrc = `int g[10];
+int fact(int n) {
+	if (n <= 1) return 1;
+	return n * fact(n - 1);
+}
+int even(int n) { if (n == 0) return 1; else return odd(n - 1); }
+int odd(int n) { if (n == 0) return 0; return even(n - 1); }
+void fill(int a[], int k) {
+	while (k > 0) {
+		k = k - 1;
+		a[k] = fact(k);
+	}
+	while (1) return;
+}
+void main(void) {
+	int x;
+	x = input();
+	fill(g, 10);
+	output(fact(x) + g[2]);
+	output(even(x));
+}`
+
+func TestQuery(t *testing.T) {
+	root, _, err := ParseProgram(querySrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query, want string // want 为 "行号:描述",以 | 分隔
+	}{
+		{`FuncDecl[name=main] Call[name=output]`, "19:Call[name=output] | 20:Call[name=output]"},
+		// 后代匹配任意层,直接子节点只匹配一层;实参列表对查询透明
+		{`FuncDecl[name=main] Call[name=fact]`, "19:Call[name=fact]"},
+		{`FuncDecl[name=main] > Call[name=fact]`, ""},
+		{`Call[name=output] > Call`, "20:Call[name=even]"},
+		{`Call[name=output] Call`, "19:Call[name=fact] | 20:Call[name=even]"},
+		{`Call > Var`, "11:Var[name=k] | 18:Var[name=g] | 19:Var[name=x] | 20:Var[name=x]"},
+		{`While > Compound > Assign > Var[array]`, "11:Var[name=a]"},
+		// 属性谓词
+		{`Var[array]`, "11:Var[name=a] | 19:Var[name=g]"},
+		{`VarDecl[array], Param[array]`, "1:VarDecl[name=g] | 8:Param[name=a]"},
+		{`Param[type="int[]"]`, "8:Param[name=a]"},
+		{`FuncDecl[type=void][params>=1]`, "8:FuncDecl[name=fill]"},
+		{`FuncDecl[params<1]`, "15:FuncDecl[name=main]"},
+		{`Const[value>=10]`, "1:Const[value=10] | 18:Const[value=10]"},
+		{`Binary[op=*]`, "4:Binary[op=*]"},
+		{`Compare[op!="=="][line<5]`, "3:Compare[op=<=]"},
+		{`If[else]`, "6:If"},
+		{`While[constant]`, "13:While"},
+		{`Call[args=2]`, "18:Call[name=fill]"},
+		{`FuncDecl[recursive]`, "2:FuncDecl[name=fact] | 6:FuncDecl[name=even] | 7:FuncDecl[name=odd]"},
+		{`Call[recursive]`, "4:Call[name=fact] | 6:Call[name=odd] | 7:Call[name=even]"},
+		{`Return > Binary Call[recursive]`, "4:Call[name=fact]"},
+		{`Decl[name=x]`, "16:VarDecl[name=x]"},
+		{`Stmt[line=9]`, "9:While | 9:Compound"},
+		// 一个节点匹配多个选择器时只返回一次
+		{`Call[name=fact], Expr[line=4] Call`, "4:Call[name=fact] | 11:Call[name=fact] | 19:Call[name=fact]"},
+		{`*[name=k]`, "8:Param[name=k] | 9:Var[name=k] | 10:Var[name=k] | 10:Var[name=k] | 11:Var[name=k] | 11:Var[name=k]"},
+	}
+	for _, tt := range tests {
+		q, err := CompileQuery(tt.query)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		var got []string
+		for _, node := range q.Match(root) {
+			got = append(got, fmt.Sprintf("%d:%s", node.line, DescribeNode(node)))
+		}
+		if s := strings.Join(got, " | "); s != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.query, s, tt.want)
+		}
+	}
+}
+
+func TestCompileQueryErrors(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{``, "query: empty selector at offset 0"},
+		{`Call,`, "query: empty selector at offset 5"},
+		{`> Call`, "query: unexpected '>' at offset 0"},
+		{`If > > Call`, "query: unexpected '>' at offset 5"},
+		{`If >`, "query: missing node kind after '>' at offset 4"},
+		{`Loop`, "query: unknown node kind \"Loop\" at offset 4"},
+		{`Call[size=1]`, "query: unknown attribute \"size\" at offset 9"},
+		{`Call[name=f`, "query: missing ']' at offset 11"},
+		{`Call[name="f]`, "query: unterminated string at offset 10"},
+		{`Call[name=]`, "query: missing value at offset 10"},
+		{`Call[name f]`, "query: missing ']' at offset 10"},
+		{`#`, "query: unexpected '#' at offset 0"},
+	}
+	for _, tt := range tests {
+		if _, err := CompileQuery(tt.query); err == nil || err.Error() != tt.want {
+			t.Errorf("%q: got %v, want %s", tt.query, err, tt.want)
+		}
+	}
+}
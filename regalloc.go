@@ -0,0 +1,284 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: regalloc.go
+// Package: scan
+// Description: 本文件定义了基于活跃变量分析和图着色的寄存器分配器
+// 				由活跃信息建立冲突图,按 Chaitin-Briggs 的简化/乐观着色方法分配寄存器
+// 				无法着色的变量溢出到栈槽,可用寄存器的个数由目标机描述决定
+// 				跨越函数调用仍然活跃的变量不能分配调用者保存的寄存器(调用会破坏它们)
+
+package scan
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 目标机寄存器描述
+type RegisterTarget struct {
+	Name        string   // 目标机名字
+	Regs        []string // 可分配的寄存器
+	CallerSaved []string // 其中由调用者保存、会被函数调用破坏的寄存器
+}
+
+// x86-64 上可分配的通用寄存器(%rax、%rdx、%rsp、%rbp 留给代码生成器使用)
+// 按 System V 调用约定,%rcx、%rsi、%rdi、%r8-%r11 由调用者保存
+var X86_64Registers = RegisterTarget{
+	Name:        "x86_64",
+	Regs:        []string{"%rbx", "%rcx", "%rsi", "%rdi", "%r8", "%r9", "%r10", "%r11", "%r12", "%r13", "%r14", "%r15"},
+	CallerSaved: []string{"%rcx", "%rsi", "%rdi", "%r8", "%r9", "%r10", "%r11"},
+}
+
+// 只有寄存器个数的通用目标机,寄存器命名为r0...r(n-1),都由被调用者保存
+func GenericRegisters(n int) RegisterTarget {
+	t := RegisterTarget{Name: fmt.Sprintf("generic%d", n)}
+	for i := 0; i < n; i++ {
+		t.Regs = append(t.Regs, fmt.Sprintf("r%d", i))
+	}
+	return t
+}
+
+// 寄存器分配结果
+type Allocation struct {
+	Func     *IRFunc
+	Reg      map[string]string // 变量分配到的寄存器
+	Slot     map[string]int    // 溢出变量的栈槽编号
+	NumSlots int               // 使用的栈槽数
+}
+
+// 冲突图
+type interference map[string]map[string]bool
+
+func (g interference) addNode(v string) {
+	if g[v] == nil {
+		g[v] = make(map[string]bool)
+	}
+}
+
+func (g interference) addEdge(a, b string) {
+	if a == b {
+		return
+	}
+	g.addNode(a)
+	g.addNode(b)
+	g[a][b] = true
+	g[b][a] = true
+}
+
+// 函数的标量形参,数组形参通过Sym访问,不参与活跃分析
+func scalarParams(fn *IRFunc) []string {
+	var res []string
+	for _, p := range fn.Params {
+		if arr, ok := fn.Arrays[p]; !ok || !arr.IsParam {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// 由活跃变量信息建立冲突图
+// 定义点的目的变量与定义之后所有活跃的变量冲突,复制指令的源变量除外
+func buildInterference(fn *IRFunc, live *Liveness) interference {
+	g := make(interference)
+	params := scalarParams(fn)
+	for _, p := range params {
+		g.addNode(p)
+	}
+	// 形参在入口处同时有效
+	for i, a := range params {
+		for _, b := range params[i+1:] {
+			g.addEdge(a, b)
+		}
+		for v := range live.In[fn.Entry()] {
+			g.addEdge(a, v)
+		}
+	}
+	for _, b := range fn.Blocks {
+		walkLiveAfter(b, live, func(i int, ins *IRInstr, after VarSet) {
+			for _, u := range ins.Uses() {
+				g.addNode(u)
+			}
+			if ins.Dst == "" {
+				return
+			}
+			g.addNode(ins.Dst)
+			for v := range after {
+				if ins.Op == IR_COPY && ins.Args[0].Kind == OPD_VAR && ins.Args[0].Name == v {
+					continue
+				}
+				g.addEdge(ins.Dst, v)
+			}
+		})
+	}
+	return g
+}
+
+// 在函数调用之后仍然活跃的变量(不含调用的返回值)
+func liveAcrossCalls(fn *IRFunc, live *Liveness) VarSet {
+	res := make(VarSet)
+	for _, b := range fn.Blocks {
+		walkLiveAfter(b, live, func(i int, ins *IRInstr, after VarSet) {
+			if ins.Op != IR_CALL {
+				return
+			}
+			for v := range after {
+				if v != ins.Dst {
+					res[v] = true
+				}
+			}
+		})
+	}
+	return res
+}
+
+// 为函数分配寄存器,函数必须不是SSA形式
+// 数组形参(数组首地址)不分配寄存器,直接放在栈槽中
+func AllocateRegisters(fn *IRFunc, target RegisterTarget) *Allocation {
+	live := ComputeLiveness(fn)
+	graph := buildInterference(fn, live)
+	across := liveAcrossCalls(fn, live)
+	clobbered := make(map[string]bool)
+	for _, r := range target.CallerSaved {
+		clobbered[r] = true
+	}
+	// 变量可用的寄存器个数,跨越调用的变量只能用被调用者保存的寄存器
+	kOf := func(v string) int {
+		if across[v] {
+			return len(target.Regs) - len(target.CallerSaved)
+		}
+		return len(target.Regs)
+	}
+
+	// 使用和定义次数,作为溢出代价
+	cost := make(map[string]int)
+	for _, b := range fn.Blocks {
+		for _, ins := range b.Instrs {
+			for _, u := range ins.Uses() {
+				cost[u]++
+			}
+			if ins.Dst != "" {
+				cost[ins.Dst]++
+			}
+		}
+	}
+
+	// 按名字排序保证结果确定
+	var nodes []string
+	for v := range graph {
+		nodes = append(nodes, v)
+	}
+	sort.Strings(nodes)
+
+	// 简化: 反复删除度数小于k的节点,没有时按 代价/度数 最小的节点乐观地压栈
+	degree := make(map[string]int, len(nodes))
+	for _, v := range nodes {
+		degree[v] = len(graph[v])
+	}
+	removed := make(map[string]bool, len(nodes))
+	var stack []string
+	for len(stack) < len(nodes) {
+		pick := ""
+		for _, v := range nodes {
+			if !removed[v] && degree[v] < kOf(v) {
+				pick = v
+				break
+			}
+		}
+		if pick == "" {
+			best := 0.0
+			for _, v := range nodes {
+				if removed[v] {
+					continue
+				}
+				c := float64(cost[v]+1) / float64(degree[v]+1)
+				if pick == "" || c < best {
+					pick, best = v, c
+				}
+			}
+		}
+		removed[pick] = true
+		stack = append(stack, pick)
+		for n := range graph[pick] {
+			degree[n]--
+		}
+	}
+
+	// 着色: 依次弹出节点,选择邻居没有使用的寄存器,没有则溢出
+	alloc := &Allocation{Func: fn, Reg: make(map[string]string), Slot: make(map[string]int)}
+	for _, p := range fn.Params {
+		if arr, ok := fn.Arrays[p]; ok && arr.IsParam {
+			alloc.Slot[p] = alloc.NumSlots
+			alloc.NumSlots++
+		}
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		v := stack[i]
+		used := make(map[string]bool)
+		for n := range graph[v] {
+			if r, ok := alloc.Reg[n]; ok {
+				used[r] = true
+			}
+		}
+		for _, r := range target.Regs {
+			if !used[r] && !(across[v] && clobbered[r]) {
+				alloc.Reg[v] = r
+				break
+			}
+		}
+		if _, ok := alloc.Reg[v]; !ok {
+			alloc.Slot[v] = alloc.NumSlots
+			alloc.NumSlots++
+		}
+	}
+	return alloc
+}
+
+// 打印活跃变量分析结果
+func HelpPrintLiveness(fn *IRFunc, file *os.File) {
+	live := ComputeLiveness(fn)
+	fmt.Fprintf(file, "\nliveness of %s\n", fn.Name)
+	for _, b := range fn.Blocks {
+		fmt.Fprintf(file, "%s:\n", b.Label())
+		fmt.Fprintf(file, "    in:  {%s}\n", strings.Join(sortedVars(live.In[b]), ", "))
+		after := make([]string, len(b.Instrs))
+		walkLiveAfter(b, live, func(i int, ins *IRInstr, set VarSet) {
+			after[i] = strings.Join(sortedVars(set), ", ")
+		})
+		for i, ins := range b.Instrs {
+			fmt.Fprintf(file, "    %-30s ; live: {%s}\n", ins.String(), after[i])
+		}
+		fmt.Fprintf(file, "    out: {%s}\n", strings.Join(sortedVars(live.Out[b]), ", "))
+	}
+}
+
+// 打印寄存器分配结果
+func HelpPrintAllocation(alloc *Allocation, file *os.File) {
+	fmt.Fprintf(file, "\nregisters of %s (%d spill slots)\n", alloc.Func.Name, alloc.NumSlots)
+	var vars []string
+	for v := range alloc.Reg {
+		vars = append(vars, v)
+	}
+	for v := range alloc.Slot {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+	for _, v := range vars {
+		if r, ok := alloc.Reg[v]; ok {
+			fmt.Fprintf(file, "    %-12s %s\n", v, r)
+		} else {
+			fmt.Fprintf(file, "    %-12s [slot %d]\n", v, alloc.Slot[v])
+		}
+	}
+}
+
+// 集合中的变量按名字排序
+func sortedVars(s VarSet) []string {
+	var res []string
+	for v := range s {
+		res = append(res, v)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,110 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: regalloc_test.go
+// Package: scan
+// Description: 寄存器分配的测试
+// 				检查冲突的变量不共用寄存器,跨越调用的变量不分配调用者保存的寄存器
+
+package scan
+
+import (
+	"testing"
+)
+
+// 检查分配结果,返回跨越调用的变量
+func checkAllocation(t *testing.T, name string, fn *IRFunc, target RegisterTarget, alloc *Allocation) VarSet {
+	t.Helper()
+	live := ComputeLiveness(fn)
+	graph := buildInterference(fn, live)
+	across := liveAcrossCalls(fn, live)
+	clobbered := make(map[string]bool)
+	for _, r := range target.CallerSaved {
+		clobbered[r] = true
+	}
+	for v, ns := range graph {
+		r, inReg := alloc.Reg[v]
+		if _, inSlot := alloc.Slot[v]; inReg == inSlot {
+			t.Errorf("%s %s: %s has register %v and slot %v", name, fn.Name, v, inReg, inSlot)
+		}
+		if !inReg {
+			continue
+		}
+		if across[v] && clobbered[r] {
+			t.Errorf("%s %s: %s is live across a call but got caller-saved %s", name, fn.Name, v, r)
+		}
+		for n := range ns {
+			if alloc.Reg[n] == r {
+				t.Errorf("%s %s: %s and %s interfere but share %s", name, fn.Name, v, n, r)
+			}
+		}
+	}
+	return across
+}
+
+func TestRegallocSamples(t *testing.T) {
+	targets := []RegisterTarget{X86_64Registers, GenericRegisters(2), GenericRegisters(4)}
+	for _, target := range targets {
+		for _, s := range samples {
+			prog := irSample(t, s)
+			Optimize(prog, OptLevel(1))
+			for _, fn := range prog.Funcs {
+				checkAllocation(t, target.Name+" "+s.name, fn, target, AllocateRegisters(fn, target))
+			}
+		}
+	}
+}
+
+func TestRegallocCallClobbers(t *testing.T) {
+	root, _, err := ParseProgram(`int f(int a) { return a; }
+void main(void) {
+	int x; int y;
+	x = input();
+	y = f(x);
+	output(x + y);
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := GenerateIR(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := irFunc(prog, "main")
+	alloc := AllocateRegisters(fn, X86_64Registers)
+	across := checkAllocation(t, "clobbers", fn, X86_64Registers, alloc)
+	if !across["x"] {
+		t.Fatal("x is not live across the call")
+	}
+	if _, ok := alloc.Reg["x"]; !ok {
+		t.Error("x was spilled although callee-saved registers are free")
+	}
+}
+
+func TestRegallocCallPressure(t *testing.T) {
+	// 7个变量跨越调用,只有5个被调用者保存的寄存器
+	root, _, err := ParseProgram(`void main(void) {
+	int a; int b; int c; int d; int e; int f; int g;
+	a = input(); b = input(); c = input(); d = input(); e = input(); f = input(); g = input();
+	output(0);
+	output(a + b + c + d + e + f + g);
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := GenerateIR(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := prog.Funcs[0]
+	alloc := AllocateRegisters(fn, X86_64Registers)
+	checkAllocation(t, "pressure", fn, X86_64Registers, alloc)
+	spilled := 0
+	for _, v := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		if _, ok := alloc.Slot[v]; ok {
+			spilled++
+		}
+	}
+	if spilled != 2 {
+		t.Errorf("%d variables spilled, want 2", spilled)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: repl.go
+// Package: scan
+// Description: 本文件定义了交互式解释环境(REPL)
+// 				逐行读入声明、语句和表达式,全局变量和函数在多次输入之间保持,表达式输入打印其值
+// 				花括号没有闭合时继续读入下一行,表达式末尾的分号可以省略
+
+package scan
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// REPL 的提示符
+const (
+	REPL_PROMPT   = "cm> "
+	REPL_CONTINUE = "... "
+)
+
+// 交互式解释环境
+type Repl struct {
+	in  *bufio.Reader
+	out io.Writer
+	it  *Interpreter
+}
+
+// REPL 工厂函数,程序中的 input 与 REPL 共用同一个输入
+func NewRepl(in io.Reader, out io.Writer, opts InterpOptions) *Repl {
+	reader := bufio.NewReader(in)
+	it, _ := NewInterpreter(nil, reader, out, opts)
+	return &Repl{in: reader, out: out, it: it}
+}
+
+// 循环读入并执行,直到输入结束或 :quit
+func (r *Repl) Run() {
+	fmt.Fprintln(r.out, Msg(MSG_REPL_PROMPT_HELP))
+	for {
+		src, ok := r.read()
+		if !ok {
+			fmt.Fprintln(r.out)
+			return
+		}
+		switch strings.TrimSpace(src) {
+		case "":
+			continue
+		case ":q", ":quit":
+			return
+		case ":h", ":help":
+			fmt.Fprintln(r.out, Msg(MSG_REPL_HELP))
+			continue
+		case ":globals":
+			r.printGlobals()
+			continue
+		}
+		r.Eval(src)
+	}
+}
+
+// 读入一个完整的输入,花括号没有闭合时继续读入
+func (r *Repl) read() (string, bool) {
+	var buf strings.Builder
+	depth := 0
+	fmt.Fprint(r.out, REPL_PROMPT)
+	for {
+		line, err := r.in.ReadString('\n')
+		if err != nil && len(line) == 0 {
+			return buf.String(), buf.Len() > 0
+		}
+		buf.WriteString(line)
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+		if depth <= 0 || err != nil {
+			return buf.String(), true
+		}
+		fmt.Fprint(r.out, REPL_CONTINUE)
+	}
+}
+
+// 分析并执行一段输入,打印表达式的值和错误信息
+func (r *Repl) Eval(src string) {
+	src = strings.TrimSpace(src)
+	if !strings.HasSuffix(src, ";") && !strings.HasSuffix(src, "}") {
+		src += ";" // 表达式可以省略分号
+	}
+	root, err := parseUnits(src)
+	if err != nil {
+		fmt.Fprintln(r.out, err.Error())
+		return
+	}
+	for node := root; node != nil; node = node.sibling {
+		unit := *node
+		unit.sibling = nil // 逐个执行,不连带后面的兄弟节点
+		if isStmt(&unit, VAR_DECLARATION) || isStmt(&unit, FUNC_DECLARATION) {
+			if err := r.it.Load(&unit); err != nil {
+				fmt.Fprintln(r.out, err.Error())
+				return
+			}
+			continue
+		}
+		val, isValue, err := r.it.Exec(&unit)
+		if err != nil {
+			if rtErr, ok := err.(*RuntimeError); ok && len(rtErr.Trace) > 1 {
+				fmt.Fprintln(r.out, rtErr.Error())
+				for _, f := range rtErr.Trace[:len(rtErr.Trace)-1] { // 最外层是REPL自身
+					fmt.Fprintln(r.out, Msg(MSG_RUNTIME_AT, f.Func, f.Line))
+				}
+			} else {
+				fmt.Fprintln(r.out, err.Error())
+			}
+			return
+		}
+		if isValue && r.printable(&unit) {
+			fmt.Fprintf(r.out, "= %d\n", val)
+		}
+	}
+}
+
+// 判断表达式的值是否需要打印,赋值、void 函数和 output 的调用不打印
+func (r *Repl) printable(node *ASTNode) bool {
+	if isExp(node, ASSIGNMENT) {
+		return false
+	}
+	if !isExp(node, CALL) {
+		return true
+	}
+	if fn, ok := r.it.funcs[nodeName(node)]; ok {
+		return funcReturnsInt(fn)
+	}
+	return nodeName(node) != BUILTIN_OUTPUT
+}
+
+// 打印全部全局变量
+func (r *Repl) printGlobals() {
+	var names []string
+	for name := range r.it.globals.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := r.it.globals.vars[name]
+		if v.isArray {
+			fmt.Fprintf(r.out, "%s = %s\n", name, formatArray(v.arr))
+		} else {
+			fmt.Fprintf(r.out, "%s = %d\n", name, v.val)
+		}
+	}
+}
+
+// 分析交互式输入,语法错误和分析过程中的panic都作为错误返回
+func parseUnits(src string) (root *ASTNode, err error) {
+	parser := NewParser(NewStringBuffer(src))
+	parser.SetOutput(nil)
+	parser.quiet = true
+	defer func() {
+		if r := recover(); r != nil {
+			root, err = nil, errors.New(Msg(MSG_REPL_IGNORED, Msg(MSG_REPL_NEAR, parser.tokenText())))
+		}
+	}()
+	root = parser.ParseUnits()
+	if diags := parser.Diagnostics(); len(diags) > 0 {
+		return nil, errors.New(Msg(MSG_REPL_IGNORED, diags[0].String()))
+	}
+	return root, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: repl_test.go
+// Package: scan
+// Description: 交互式解释环境的测试
+// 				花括号没有闭合时继续读入,全局变量和函数在多次输入之间保持
+
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRepl(t *testing.T) {
+	in := `int x;
+int a[3];
+int f(int n) {
+	if (n > 0) {
+		return n * f(n - 1);
+	}
+	return 1;
+}
+x = f(5);
+x + 1
+a[1] = x / 2; a[1]
+while (x > 100) {
+	x = x - 50;
+}
+:globals
+output(x)
+y = 1
+1 +
+a[5]
+x
+:quit
+ignored
+`
+	want := REPL_PROMPT + REPL_PROMPT + REPL_PROMPT +
+		strings.Repeat(REPL_CONTINUE, 5) + REPL_PROMPT + REPL_PROMPT +
+		"= 121\n" + REPL_PROMPT +
+		"= 60\n" + REPL_PROMPT +
+		REPL_CONTINUE + REPL_CONTINUE + REPL_PROMPT +
+		"a = {0, 60, 0}\nx = 70\n" + REPL_PROMPT +
+		"70\n" + REPL_PROMPT +
+		"line 1: runtime error: undeclared identifier y\n" + REPL_PROMPT +
+		"Line 1: error [E001]: unexpected \";\", input ignored\n" + REPL_PROMPT +
+		"line 1: runtime error: index 5 out of range for array a of size 3\n" + REPL_PROMPT +
+		"= 70\n" + REPL_PROMPT
+
+	var out strings.Builder
+	NewRepl(strings.NewReader(in), &out, InterpOptions{}).Run()
+	got := strings.TrimPrefix(out.String(), Msg(MSG_REPL_PROMPT_HELP)+"\n")
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestReplRuntimeErrorTrace(t *testing.T) {
+	// 函数中的运行时错误打印调用栈,但不包括 REPL 自身所在的帧
+	var out strings.Builder
+	r := NewRepl(strings.NewReader(""), &out, InterpOptions{})
+	r.Eval("int g(int n) { return 10 / n; }")
+	r.Eval("g(0)")
+	want := "line 1: runtime error: integer division by zero\n    at g (line 1)\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
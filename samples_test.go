@@ -0,0 +1,179 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: samples_test.go
+// Package: scan
+// Description: 各个后端的端到端测试共用的示例程序
+// 				每个示例给出标准输入和期望的输出,期望的输出同时与解释器的结果核对
+
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+// 示例程序
+type sample struct {
+	name  string
+	src   string
+	input string
+	want  string
+}
+
+var samples = []sample{
+	{"output", `void main(void) { output(42); }`, "", "42\n"},
+	{"ifelse", `void main(void) {
+	int n; int t;
+	n = input();
+	if (n > 0) t = 1; else t = 2;
+	output(t);
+}`, "5", "1\n"},
+	{"ifelse-neg", `void main(void) {
+	int n; int t;
+	n = input();
+	if (n > 0) t = 1; else t = 2;
+	output(t);
+}`, "-5", "2\n"},
+	{"while", `void main(void) {
+	int i; int s;
+	i = 0; s = 0;
+	while (i < 10) { s = s + i; i = i + 1; }
+	output(s);
+}`, "", "45\n"},
+	{"nested", `void main(void) {
+	int i; int j; int c;
+	i = 0; c = 0;
+	while (i < 5) {
+		j = 0;
+		while (j < i) {
+			if (j == 2) c = c + 10; else { if (j < 2) c = c + 1; }
+			j = j + 1;
+		}
+		i = i + 1;
+	}
+	output(c);
+}`, "", "27\n"},
+	{"swap", `void main(void) {
+	int a; int b; int t; int i;
+	a = input(); b = input(); i = 0;
+	while (i < 3) { t = a; a = b; b = t; i = i + 1; }
+	output(a); output(b);
+}`, "1 2", "2\n1\n"},
+	{"recursion", `int fact(int n) {
+	if (n <= 1) return 1;
+	return n * fact(n - 1);
+}
+void main(void) { output(fact(input())); }`, "10", "3628800\n"},
+	{"gcd", `int gcd(int u, int v) {
+	if (v == 0) return u;
+	else return gcd(v, u - (u / v) * v);
+}
+void main(void) {
+	int x; int y;
+	x = input(); y = input();
+	output(gcd(x, y));
+}`, "84 36", "12\n"},
+	{"sort", `int x[10];
+int minloc(int a[], int low, int high) {
+	int i; int x; int k;
+	k = low;
+	x = a[low];
+	i = low + 1;
+	while (i < high) {
+		if (a[i] < x) { x = a[i]; k = i; }
+		i = i + 1;
+	}
+	return k;
+}
+void sort(int a[], int low, int high) {
+	int i; int k;
+	i = low;
+	while (i < high - 1) {
+		int t;
+		k = minloc(a, i, high);
+		t = a[k];
+		a[k] = a[i];
+		a[i] = t;
+		i = i + 1;
+	}
+}
+void main(void) {
+	int i;
+	i = 0;
+	while (i < 10) { x[i] = input(); i = i + 1; }
+	sort(x, 0, 10);
+	i = 0;
+	while (i < 10) { output(x[i]); i = i + 1; }
+}`, "5 3 9 -1 0 7 2 8 6 4", "-1\n0\n2\n3\n4\n5\n6\n7\n8\n9\n"},
+	{"args", `int sum(int a, int b, int c, int d, int e, int f, int g, int h) {
+	return a + 2 * b + 3 * c + 4 * d + 5 * e + 6 * f + 7 * g + 8 * h;
+}
+void main(void) { output(sum(1, 2, 3, 4, 5, 6, 7, 8)); }`, "", "204\n"},
+	{"overflow", `void main(void) {
+	int x;
+	x = 2147483647;
+	output(x + 1);
+	output((2147483647 + 1) / 2);
+	output(65536 * 65536);
+	output(input() + 1);
+}`, "2147483647", "-2147483648\n-1073741824\n0\n-2147483648\n"},
+	{"live-across-call", `int f(int a) { return a; }
+void main(void) {
+	int x; int y;
+	x = input();
+	y = f(x);
+	output(x + y);
+}`, "21", "42\n"},
+	{"assoc", `void main(void) {
+	int a;
+	a = input();
+	output(100 / 10 * 2);
+	output(a / 2 * 3);
+	output(20 - a / 4 * 2 - 1);
+}`, "8", "20\n12\n15\n"},
+	{"libc-names", `int EOF; int NULL; int FILE[2];
+int remove(int rename) { return rename - 1; }
+int abs(int exit) { if (exit < 0) return 0 - exit; return exit; }
+void fputs(int fopen) { output(fopen); }
+void main(void) {
+	int getc; int putc; int printf;
+	getc = input(); EOF = remove(getc); NULL = abs(0 - 3);
+	FILE[1] = EOF + NULL; putc = FILE[1]; printf = putc * 2;
+	fputs(printf);
+}`, "5", "14\n"},
+	{"globals", `int g; int a[3];
+void set(int v) { g = v; a[1] = v * 2; }
+void main(void) { set(7); output(g + a[1]); }`, "", "21\n"},
+}
+
+// 分析示例程序,有语法错误时测试失败
+func parseSample(t *testing.T, s sample) *ASTNode {
+	t.Helper()
+	root, _, err := ParseProgram(s.src)
+	if err != nil {
+		t.Fatalf("%s: %v", s.name, err)
+	}
+	return root
+}
+
+// 用解释器执行示例程序,返回输出
+func interpSample(t *testing.T, s sample) string {
+	t.Helper()
+	var out strings.Builder
+	it, err := NewInterpreter(parseSample(t, s), strings.NewReader(s.input), &out, InterpOptions{})
+	if err == nil {
+		err = it.Run()
+	}
+	if err != nil {
+		t.Fatalf("%s: %v", s.name, err)
+	}
+	return out.String()
+}
+
+func TestSamplesInterp(t *testing.T) {
+	for _, s := range samples {
+		if got := interpSample(t, s); got != s.want {
+			t.Errorf("%s: got %q, want %q", s.name, got, s.want)
+		}
+	}
+}
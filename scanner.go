@@ -8,6 +8,7 @@ package scan
 
 import (
 	"fmt"
+	"os"
 	"unicode"
 )
 
@@ -15,7 +16,7 @@ import (
 type Scanner struct {
 	KeyTable map[string]Token // 关键字表
 	buffer   *Buffer          // 输入缓冲区
-
+	out      *os.File         // 扫描结果的输出文件
 }
 
 // 初始化关键字表
@@ -47,9 +48,15 @@ func NewScanner(buf *Buffer) *Scanner {
 	var scanner Scanner
 	scanner.buffer = buf   // 初始化输入缓冲区
 	scanner.initKeyTable() // 初始化关键字表
+	scanner.out = FileOut
 	return &scanner
 }
 
+// 设置扫描结果的输出文件,为 nil 时不输出
+func (scanner *Scanner) SetOutput(file *os.File) {
+	scanner.out = file
+}
+
 // 从输入缓冲中扫描token,返回Token类型和Token的词素
 func (scanner *Scanner) getToken() (Token, TokenString) {
 	state := START         // DFA开始状态
@@ -63,8 +70,8 @@ func (scanner *Scanner) getToken() (Token, TokenString) {
 
 		// 读取下一个字符
 		char = scanner.buffer.Next()
-		if char == EOF_CHAR { // 文件结尾，返回EOF Token
-			token = EOF_TOKEN
+		if char == EOF_CHAR { // 文件结尾，结束当前的词素，没有词素时返回EOF Token
+			token = eofToken(state)
 			break
 		}
 
@@ -224,11 +231,33 @@ func (scanner *Scanner) getToken() (Token, TokenString) {
 	return token, lexeme
 }
 
+// 输入在词素中间结束时词素的Token类型
+// 没有词素或注释没有结束时为EOF Token
+func eofToken(state StateType) Token {
+	switch state {
+	case INID:
+		return ID
+	case INNUM:
+		return NUM
+	case INLT:
+		return LT
+	case INGT:
+		return GT
+	case INEQ:
+		return ASSIGN
+	case NOT:
+		return ERROR
+	case INCOM_B:
+		return DIV
+	}
+	return EOF_TOKEN
+}
+
 // 只进行词法扫描
 func (scanner *Scanner) ScanAll() {
 	// 获取token和词素并打印
 	for token, tokenString := scanner.getToken(); token != EOF_TOKEN; token, tokenString = scanner.getToken() {
-		HelpPrintFile(token, tokenString, scanner.buffer.Lines(), FileOut)
+		HelpPrintFile(token, tokenString, scanner.buffer.Lines(), scanner.out)
 	}
 	fmt.Println("Scanner Done!")
 }
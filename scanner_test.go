@@ -0,0 +1,70 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: scanner_test.go
+// Package: scan
+// Description: 词法分析器的测试
+// 				输入在词素中间结束时不能丢弃词素,输入结尾的行号是最后一行
+
+package scan
+
+import (
+	"testing"
+)
+
+func TestScanEOF(t *testing.T) {
+	tests := []struct {
+		src    string
+		token  Token
+		lexeme string
+	}{
+		{"x", ID, "x"},
+		{"42", NUM, "42"},
+		{"return", RETURN, "return"},
+		{"a <", LT, "<"},
+		{"a >", GT, ">"},
+		{"a =", ASSIGN, "="},
+		{"a /", DIV, "/"},
+		{"a !", ERROR, "!"},
+		{"a <=", LE, "<="},
+		{"a /* */", COMMENT, "/* */"},
+	}
+	for _, tt := range tests {
+		toks := ScanTokens(NewStringBuffer(tt.src))
+		if len(toks) == 0 {
+			t.Errorf("%q: no tokens", tt.src)
+			continue
+		}
+		last := toks[len(toks)-1]
+		if last.Token != tt.token || string(last.Lexeme) != tt.lexeme {
+			t.Errorf("%q: last token %d %q, want %d %q", tt.src, last.Token, last.Lexeme, tt.token, tt.lexeme)
+		}
+	}
+	// 没有结束的注释被丢弃
+	if toks := ScanTokens(NewStringBuffer("x /* open")); len(toks) != 1 {
+		t.Errorf("unterminated comment: %d tokens, want 1", len(toks))
+	}
+}
+
+func TestScanLines(t *testing.T) {
+	tests := []struct {
+		src   string
+		lines []int
+	}{
+		{"a\nb", []int{1, 2}},
+		{"a\nb\n", []int{1, 2}},
+		{"a\n\nb\n", []int{1, 3}},
+		{"a\n/* x\ny */ b", []int{1, 3, 3}},
+	}
+	for _, tt := range tests {
+		toks := ScanTokens(NewStringBuffer(tt.src))
+		if len(toks) != len(tt.lines) {
+			t.Errorf("%q: %d tokens, want %d", tt.src, len(toks), len(tt.lines))
+			continue
+		}
+		for i, tok := range toks {
+			if tok.Line != tt.lines[i] {
+				t.Errorf("%q: token %q on line %d, want %d", tt.src, tok.Lexeme, tok.Line, tt.lines[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: similarity.go
+// Package: scan
+// Description: 本文件定义了提交程序之间的相似度检测,用于发现抄袭
+// 				词法层: 标识符(input、output 除外)和常量归一化后取 k-gram 哈希,用 winnowing 选取指纹
+// 				语法层: 忽略名字和常量值,对节点数不少于 SIM_MIN_SUBTREE 的子树取哈希
+// 				相似度为两层相似度的平均,程序有语法错误时只使用词法层;共同指纹在两个文件中连成的片段为匹配区域
+// 				改名、改常量、调整函数次序和格式、增删注释都不影响结果
+
+package scan
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 相似度检测参数
+const (
+	SIM_KGRAM       = 8  // k-gram 的token个数
+	SIM_WINDOW      = 4  // winnowing 窗口大小,长度不小于 SIM_KGRAM+SIM_WINDOW-1 的相同片段一定能被发现
+	SIM_MIN_SUBTREE = 4  // 参与比较的子树的最少节点数
+	SIM_MIN_REGION  = 16 // 匹配区域的最少token数
+)
+
+// 一份提交的程序
+type Submission struct {
+	Name     string
+	Source   string
+	Err      error            // 语法错误,有语法错误时不参与语法层比较
+	lines    []int            // 各token所在行号
+	prints   []simPrint       // winnowing 选出的指纹
+	subtrees map[uint64]int   // 子树哈希及出现次数
+	printSet map[uint64][]int // 指纹哈希及其在token序列中的位置
+}
+
+// 指纹: k-gram 的哈希值及其第一个token的位置
+type simPrint struct {
+	hash uint64
+	pos  int
+}
+
+// 两份提交中相同的一段程序,行号为闭区间
+type Region struct {
+	AStart, AEnd int
+	BStart, BEnd int
+	Tokens       int // 区域覆盖的token数(以 A 计)
+}
+
+// 两份提交的相似度
+type SimilarityPair struct {
+	A, B       *Submission
+	Score      float64 // 综合相似度,0~1
+	TokenScore float64 // 词法层指纹的 Jaccard 系数
+	ASTScore   float64 // 语法层子树哈希的 Dice 系数
+	Regions    []Region
+}
+
+// 提交程序工厂函数,对程序进行词法和语法分析并计算指纹
+func NewSubmission(name, source string) *Submission {
+	sub := &Submission{Name: name, Source: source, printSet: make(map[uint64][]int)}
+	sub.fingerprint(normalizeTokens(source, &sub.lines))
+	root, _, err := ParseProgram(source)
+	if err != nil {
+		sub.Err = err
+	} else {
+		sub.subtrees = make(map[uint64]int)
+		for node := root; node != nil; node = node.sibling {
+			subtreeHashes(node, sub.subtrees)
+		}
+	}
+	return sub
+}
+
+// 两两比较提交程序,按相似度从高到低返回
+func CompareSubmissions(subs []*Submission) []SimilarityPair {
+	var pairs []SimilarityPair
+	for i := 0; i < len(subs); i++ {
+		for j := i + 1; j < len(subs); j++ {
+			pairs = append(pairs, CompareSubmission(subs[i], subs[j]))
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Score > pairs[j].Score })
+	return pairs
+}
+
+// 比较两份提交程序
+func CompareSubmission(a, b *Submission) SimilarityPair {
+	pair := SimilarityPair{A: a, B: b}
+	common, union := 0, len(b.printSet)
+	for h := range a.printSet {
+		if _, ok := b.printSet[h]; ok {
+			common++
+		} else {
+			union++
+		}
+	}
+	if union > 0 {
+		pair.TokenScore = float64(common) / float64(union)
+	}
+	pair.Score = pair.TokenScore
+	if a.subtrees != nil && b.subtrees != nil {
+		common, total := 0, 0
+		for h, n := range a.subtrees {
+			total += n
+			if m := b.subtrees[h]; m < n {
+				common += m
+			} else {
+				common += n
+			}
+		}
+		for _, m := range b.subtrees {
+			total += m
+		}
+		if total > 0 {
+			pair.ASTScore = 2 * float64(common) / float64(total)
+		}
+		pair.Score = (pair.TokenScore + pair.ASTScore) / 2
+	}
+	pair.Regions = matchRegions(a, b)
+	return pair
+}
+
+// 打印相似度不低于 threshold 的提交对及其匹配区域
+// source 为真时同时打印匹配区域的源程序,A 的行以 '<' 开头,B 的行以 '>' 开头
+func HelpPrintSimilarity(pairs []SimilarityPair, threshold float64, source bool, file *os.File) {
+	for _, p := range pairs {
+		if p.Score < threshold {
+			continue
+		}
+		fmt.Fprintf(file, "%5.1f%%  %s  %s  (tokens %.1f%%, ast %.1f%%)\n", 100*p.Score, p.A.Name, p.B.Name, 100*p.TokenScore, 100*p.ASTScore)
+		for _, r := range p.Regions {
+			fmt.Fprintf(file, "        %s:%d-%d ~ %s:%d-%d  (%d tokens)\n", p.A.Name, r.AStart, r.AEnd, p.B.Name, r.BStart, r.BEnd, r.Tokens)
+			if source {
+				helpPrintLines(p.A.Source, r.AStart, r.AEnd, '<', file)
+				helpPrintLines(p.B.Source, r.BStart, r.BEnd, '>', file)
+			}
+		}
+	}
+}
+
+// 打印源程序中的若干行
+func helpPrintLines(source string, start, end int, mark byte, file *os.File) {
+	lines := strings.Split(source, "\n")
+	for i := start; i <= end && i <= len(lines); i++ {
+		fmt.Fprintf(file, "        %c %4d  %s\n", mark, i, strings.TrimRight(lines[i-1], "\r"))
+	}
+}
+
+// 扫描源程序,返回归一化的token序列,lines 记录各token所在行号
+// 注释和错误token被丢弃,input 和 output 以外的标识符归一为 ID,常数归一为 NUM
+func normalizeTokens(source string, lines *[]int) []string {
+	var res []string
+	buf := NewStringBuffer(source)
+	scanner := NewScanner(buf)
+	for token, lexeme := scanner.getToken(); token != EOF_TOKEN; token, lexeme = scanner.getToken() {
+		var text string
+		switch token {
+		case COMMENT, ERROR:
+			continue
+		case ID:
+			text = "ID"
+			if s := string(lexeme); s == BUILTIN_INPUT || s == BUILTIN_OUTPUT {
+				text = s
+			}
+		case NUM:
+			text = "NUM"
+		default:
+			text = string(lexeme)
+		}
+		res = append(res, text)
+		*lines = append(*lines, buf.Lines())
+	}
+	return res
+}
+
+// 计算 k-gram 哈希并用 winnowing 选取指纹: 每个窗口中取最小的哈希,相同时取最右边的
+func (sub *Submission) fingerprint(tokens []string) {
+	var hashes []uint64
+	for i := 0; i+SIM_KGRAM <= len(tokens); i++ {
+		h := fnv.New64a()
+		for _, t := range tokens[i : i+SIM_KGRAM] {
+			h.Write([]byte(t))
+			h.Write([]byte{0})
+		}
+		hashes = append(hashes, h.Sum64())
+	}
+	last := -1
+	for start := 0; start < len(hashes); start++ {
+		end := start + SIM_WINDOW
+		if end > len(hashes) {
+			if start > 0 {
+				break
+			}
+			end = len(hashes) // 不足一个窗口的短程序
+		}
+		min := start
+		for i := start; i < end; i++ {
+			if hashes[i] <= hashes[min] {
+				min = i
+			}
+		}
+		if min != last {
+			sub.prints = append(sub.prints, simPrint{hash: hashes[min], pos: min})
+			sub.printSet[hashes[min]] = append(sub.printSet[hashes[min]], min)
+			last = min
+		}
+	}
+}
+
+// 计算以 node 为根的子树忽略名字和常量值的哈希,节点数足够的子树记入 res,返回哈希和节点数
+func subtreeHashes(node *ASTNode, res map[uint64]int) (uint64, int) {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%v/%d", node.nodeK, node.nodeT, node.varT)
+	if isExp(node, OPERATION) || isExp(node, COMPARE) {
+		fmt.Fprintf(h, "/%d", nodeOp(node))
+	}
+	if isExp(node, CALL) && (nodeName(node) == BUILTIN_INPUT || nodeName(node) == BUILTIN_OUTPUT) {
+		fmt.Fprintf(h, "/%s", nodeName(node))
+	}
+	size := 1
+	for _, child := range []*ASTNode{node.left, node.mid, node.right} {
+		h.Write([]byte{'('})
+		for c := child; c != nil; c = c.sibling {
+			ch, n := subtreeHashes(c, res)
+			fmt.Fprintf(h, "%x,", ch)
+			size += n
+		}
+		h.Write([]byte{')'})
+	}
+	sum := h.Sum64()
+	if size >= SIM_MIN_SUBTREE {
+		res[sum]++
+	}
+	return sum, size
+}
+
+// 由共同指纹找出匹配区域: 在两个文件中位置差大致不变且彼此相邻的指纹连成一个区域,按大小排列
+func matchRegions(a, b *Submission) []Region {
+	type run struct {
+		startA, lastA, startB, lastB int
+	}
+	var runs []*run
+	for _, p := range a.prints {
+		for _, pb := range b.printSet[p.hash] {
+			var cur *run
+			for _, r := range runs {
+				d := (pb - r.lastB) - (p.pos - r.lastA)
+				if p.pos > r.lastA && p.pos-r.lastA <= SIM_KGRAM+SIM_WINDOW && pb > r.lastB && d >= -2 && d <= 2 {
+					cur = r
+					break
+				}
+			}
+			if cur == nil {
+				runs = append(runs, &run{startA: p.pos, lastA: p.pos, startB: pb, lastB: pb})
+			} else {
+				cur.lastA, cur.lastB = p.pos, pb
+			}
+		}
+	}
+	var res []Region
+	for _, r := range runs {
+		tokens := r.lastA - r.startA + SIM_KGRAM
+		if tokens < SIM_MIN_REGION {
+			continue
+		}
+		res = append(res, Region{
+			AStart: a.lines[r.startA], AEnd: a.lines[r.lastA+SIM_KGRAM-1],
+			BStart: b.lines[r.startB], BEnd: b.lines[r.lastB+SIM_KGRAM-1],
+			Tokens: tokens,
+		})
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Tokens > res[j].Tokens })
+	// 每一行只属于一个区域: 与更大的区域在任一文件中重叠的区域被丢弃
+	var kept []Region
+	for _, r := range res {
+		overlap := false
+		for _, k := range kept {
+			if r.AStart <= k.AEnd && k.AStart <= r.AEnd || r.BStart <= k.BEnd && k.BStart <= r.BEnd {
+				overlap = true
+				break
+			}
+		}
+		if !overlap {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,161 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: similarity_test.go
+// Package: scan
+// Description: 相似度检测的测试
+// 				改名、改常量、调整格式和函数次序、增删注释后的程序与原程序完全相似,
+// 				排序时排在只有部分相同和完全不同的程序之前
+
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+const simOriginal = `int a[10];
+void sort(int n) {
+	int i; int j; int t;
+	i = 0;
+	while (i < n) {
+		j = i + 1;
+		while (j < n) {
+			if (a[j] < a[i]) {
+				t = a[i]; a[i] = a[j]; a[j] = t;
+			}
+			j = j + 1;
+		}
+		i = i + 1;
+	}
+}
+void main(void) {
+	int k;
+	k = 0;
+	while (k < 10) { a[k] = input(); k = k + 1; }
+	sort(10);
+	k = 0;
+	while (k < 10) { output(a[k]); k = k + 1; }
+}
+`
+
+// 改名、改常量、改格式、加注释
+const simRenamed = `int data[8];
+/* 选择排序 */
+void order(int len)
+{
+	int p; int q; int tmp;
+	p = 1;
+	while (p < len)
+	{
+		q = p + 2;
+		while (q < len)
+		{
+			if (data[q] < data[p]) { tmp = data[p]; data[p] = data[q]; data[q] = tmp; }
+			q = q + 2;
+		}
+		p = p + 3;
+	}
+}
+void main(void) {
+	int idx; idx = 1;
+	while (idx < 8) { data[idx] = input(); idx = idx + 1; }  /* 读入 */
+	order(8);
+	idx = 0;
+	while (idx < 8) { output(data[idx]); idx = idx + 1; }
+}
+`
+
+// 只有 main 相同
+const simPartial = `int a[10];
+int max(int n) {
+	int i; int m;
+	m = a[0];
+	i = 1;
+	while (i < n) {
+		if (a[i] > m) m = a[i];
+		i = i + 1;
+	}
+	return m;
+}
+void main(void) {
+	int k;
+	k = 0;
+	while (k < 10) { a[k] = input(); k = k + 1; }
+	output(max(10));
+}
+`
+
+const simDifferent = `int gcd(int u, int v) {
+	if (v == 0) return u;
+	else return gcd(v, u - u / v * v);
+}
+void main(void) {
+	int x; int y;
+	x = input(); y = input();
+	output(gcd(x, y));
+}
+`
+
+func TestSimilarityRenamed(t *testing.T) {
+	// 改名、改常量、改格式和注释不影响任何一层
+	p := CompareSubmission(NewSubmission("a.cm", simOriginal), NewSubmission("b.cm", simRenamed))
+	if p.Score != 1 || p.TokenScore != 1 || p.ASTScore != 1 {
+		t.Errorf("renamed: score %.3f, token %.3f, AST %.3f, want 1", p.Score, p.TokenScore, p.ASTScore)
+	}
+	if len(p.Regions) != 1 || p.Regions[0].AStart != 1 || p.Regions[0].BStart != 1 || p.Regions[0].BEnd < 20 {
+		t.Errorf("renamed: regions %+v, want one region covering the whole program", p.Regions)
+	}
+
+	// 调整函数次序不影响语法层,词法层只有跨越函数边界的 k-gram 不同
+	i := strings.Index(simOriginal, "void main")
+	p = CompareSubmission(NewSubmission("a.cm", simOriginal), NewSubmission("b.cm", simOriginal[i:]+simOriginal[:i]))
+	if p.ASTScore != 1 || p.TokenScore < 0.8 {
+		t.Errorf("reordered: token %.3f, AST %.3f", p.TokenScore, p.ASTScore)
+	}
+	if len(p.Regions) < 2 {
+		t.Errorf("reordered: regions %+v, want one for each function", p.Regions)
+	}
+}
+
+func TestSimilarityRanking(t *testing.T) {
+	subs := []*Submission{
+		NewSubmission("different.cm", simDifferent),
+		NewSubmission("original.cm", simOriginal),
+		NewSubmission("partial.cm", simPartial),
+		NewSubmission("renamed.cm", simRenamed),
+	}
+	pairs := CompareSubmissions(subs)
+	var got []string
+	for _, p := range pairs {
+		got = append(got, p.A.Name+"-"+p.B.Name)
+	}
+	want := []string{"original.cm-renamed.cm", "original.cm-partial.cm", "partial.cm-renamed.cm"}
+	if strings.Join(got[:3], " ") != strings.Join(want, " ") {
+		t.Errorf("ranking %v, want %v first", got, want)
+	}
+	for _, p := range pairs {
+		if p.Score < 0 || p.Score > 1 {
+			t.Errorf("%s-%s: score %.3f out of range", p.A.Name, p.B.Name, p.Score)
+		}
+		if (p.A.Name == "different.cm" || p.B.Name == "different.cm") && p.Score >= pairs[2].Score {
+			t.Errorf("%s-%s: score %.3f not below the similar programs", p.A.Name, p.B.Name, p.Score)
+		}
+	}
+	for i := 1; i < len(pairs); i++ {
+		if pairs[i].Score > pairs[i-1].Score {
+			t.Errorf("pairs not sorted: %v", got)
+		}
+	}
+}
+
+func TestSimilaritySyntaxError(t *testing.T) {
+	// 有语法错误时只使用词法层
+	broken := strings.Replace(simRenamed, "order(8);", "order(8;", 1)
+	p := CompareSubmission(NewSubmission("a.cm", simOriginal), NewSubmission("b.cm", broken))
+	if p.B.Err == nil {
+		t.Fatal("want a syntax error")
+	}
+	if p.ASTScore != 0 || p.Score != p.TokenScore || p.TokenScore < 0.5 {
+		t.Errorf("score %.3f, token %.3f, AST %.3f", p.Score, p.TokenScore, p.ASTScore)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: ssa.go
+// Package: scan
+// Description: 本文件定义了静态单赋值(SSA)形式的构造、校验和消除
+// 				构造: 支配树、支配边界、按活跃信息剪枝的phi插入以及沿支配树的变量重命名
+// 				消除: 拆分关键边,把phi翻译为前驱块末尾和本块开头的复制指令
+// 				phi 的操作数与所在块的Preds一一对应,因此SSA形式上不能调用会改变前驱顺序的优化
+
+package scan
+
+import (
+	"fmt"
+)
+
+// 将条件相同目标的分支改为无条件跳转,保证两个块之间最多一条边
+func simplifyBranches(fn *IRFunc) {
+	for _, b := range fn.Blocks {
+		if t := b.Terminator(); t != nil && t.Op == IR_BRANCH && t.Targets[0] == t.Targets[1] {
+			t.Op = IR_JUMP
+			t.Args = nil
+			t.Targets = t.Targets[:1]
+		}
+	}
+	BuildCFG(fn)
+}
+
+// 计算支配边界,需要先调用ComputeDominators
+func DominanceFrontiers(fn *IRFunc) map[*BasicBlock][]*BasicBlock {
+	df := make(map[*BasicBlock][]*BasicBlock)
+	in := make(map[*BasicBlock]map[*BasicBlock]bool)
+	for _, b := range fn.Blocks {
+		if len(b.Preds) < 2 {
+			continue
+		}
+		for _, p := range b.Preds {
+			for runner := p; runner != nil && runner != b.IDom; runner = runner.IDom {
+				if in[runner] == nil {
+					in[runner] = make(map[*BasicBlock]bool)
+				}
+				if !in[runner][b] {
+					in[runner][b] = true
+					df[runner] = append(df[runner], b)
+				}
+				if runner.IDom == runner { // 到达入口块
+					break
+				}
+			}
+		}
+	}
+	return df
+}
+
+// 将函数转换为SSA形式
+// 每个变量的新版本命名为 原名_n,形参的初始版本沿用原名
+// 在某条路径上未赋值就使用的变量取常量0
+func BuildSSA(fn *IRFunc) {
+	simplifyBranches(fn)
+	ComputeDominators(fn)
+	df := DominanceFrontiers(fn)
+	live := ComputeLiveness(fn)
+
+	// 各个变量的定义块
+	defBlocks := make(map[string][]*BasicBlock)
+	var vars []string
+	addDef := func(v string, b *BasicBlock) {
+		if _, ok := defBlocks[v]; !ok {
+			vars = append(vars, v)
+		}
+		defBlocks[v] = append(defBlocks[v], b)
+	}
+	for _, p := range fn.Params {
+		addDef(p, fn.Entry())
+	}
+	for _, b := range fn.Blocks {
+		for _, ins := range b.Instrs {
+			if ins.Dst != "" {
+				addDef(ins.Dst, b)
+			}
+		}
+	}
+
+	// 在迭代支配边界处插入phi(只在变量活跃时插入)
+	phiVar := make(map[*IRInstr]string)
+	for _, v := range vars {
+		has := make(map[*BasicBlock]bool)
+		work := append([]*BasicBlock(nil), defBlocks[v]...)
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, y := range df[b] {
+				if has[y] || !live.In[y][v] {
+					continue
+				}
+				has[y] = true
+				phi := &IRInstr{Op: IR_PHI, Dst: v, Args: make([]Operand, len(y.Preds))}
+				y.Instrs = append([]*IRInstr{phi}, y.Instrs...)
+				phiVar[phi] = v
+				work = append(work, y)
+			}
+		}
+	}
+
+	// 沿支配树重命名
+	children := DominatorChildren(fn)
+	stacks := make(map[string][]string)
+	counter := make(map[string]int)
+	for _, p := range fn.Params {
+		stacks[p] = []string{p}
+	}
+	top := func(v string) Operand {
+		if s := stacks[v]; len(s) > 0 {
+			return VarOperand(s[len(s)-1])
+		}
+		return ConstOperand(0)
+	}
+
+	var rename func(b *BasicBlock)
+	rename = func(b *BasicBlock) {
+		var pushed []string
+		for _, ins := range b.Instrs {
+			if ins.Op != IR_PHI {
+				for i, a := range ins.Args {
+					if a.Kind == OPD_VAR {
+						ins.Args[i] = top(a.Name)
+					}
+				}
+			}
+			if ins.Dst != "" {
+				v := ins.Dst
+				counter[v]++
+				ins.Dst = fmt.Sprintf("%s_%d", v, counter[v])
+				stacks[v] = append(stacks[v], ins.Dst)
+				pushed = append(pushed, v)
+			}
+		}
+		for _, s := range b.Succs {
+			for j, p := range s.Preds {
+				if p != b {
+					continue
+				}
+				for _, ins := range s.Instrs {
+					if ins.Op != IR_PHI {
+						break
+					}
+					ins.Args[j] = top(phiVar[ins])
+				}
+			}
+		}
+		for _, c := range children[b] {
+			rename(c)
+		}
+		for _, v := range pushed {
+			stacks[v] = stacks[v][:len(stacks[v])-1]
+		}
+	}
+	rename(fn.Entry())
+}
+
+// 校验SSA形式
+// 每个变量只定义一次,phi只出现在块开头且操作数个数与前驱个数相同,
+// 每个使用都被其定义支配(phi的操作数要求定义支配对应的前驱块)
+func VerifySSA(fn *IRFunc) error {
+	ComputeDominators(fn)
+	type defSite struct {
+		block *BasicBlock
+		index int
+	}
+	defs := make(map[string]defSite)
+	for _, p := range fn.Params {
+		defs[p] = defSite{fn.Entry(), -1}
+	}
+	for _, b := range fn.Blocks {
+		inPhis := true
+		for i, ins := range b.Instrs {
+			if ins.Op == IR_PHI {
+				if !inPhis {
+					return fmt.Errorf("%s: phi for %s is not at the start of the block", b.Label(), ins.Dst)
+				}
+				if len(ins.Args) != len(b.Preds) {
+					return fmt.Errorf("%s: phi for %s has %d operands but the block has %d predecessors", b.Label(), ins.Dst, len(ins.Args), len(b.Preds))
+				}
+			} else {
+				inPhis = false
+			}
+			if ins.Dst == "" {
+				continue
+			}
+			if _, ok := defs[ins.Dst]; ok {
+				return fmt.Errorf("%s: %s is defined more than once", b.Label(), ins.Dst)
+			}
+			defs[ins.Dst] = defSite{b, i}
+		}
+	}
+
+	for _, b := range fn.Blocks {
+		for i, ins := range b.Instrs {
+			for j, a := range ins.Args {
+				if a.Kind != OPD_VAR {
+					continue
+				}
+				d, ok := defs[a.Name]
+				if !ok {
+					return fmt.Errorf("%s: %s is used but never defined", b.Label(), a.Name)
+				}
+				if ins.Op == IR_PHI {
+					if pred := b.Preds[j]; !Dominates(d.block, pred) {
+						return fmt.Errorf("%s: definition of %s does not dominate predecessor %s", b.Label(), a.Name, pred.Label())
+					}
+					continue
+				}
+				if d.block == b && d.index >= i || d.block != b && !Dominates(d.block, b) {
+					return fmt.Errorf("%s: use of %s is not dominated by its definition", b.Label(), a.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// 将函数从SSA形式转换回普通IR
+// 先拆分关键边,再为每个phi引入一个临时变量:
+// 每个前驱块末尾给临时变量赋值,本块开头再把临时变量复制给phi的目的变量
+// 这样即使多个phi之间互相引用(交换问题)也能得到正确的结果
+func DestroySSA(fn *IRFunc) {
+	// 拆分关键边,直接修改前驱和后继以保持phi操作数的对应关系
+	for _, s := range append([]*BasicBlock(nil), fn.Blocks...) {
+		if len(s.Preds) < 2 || len(s.Instrs) == 0 || s.Instrs[0].Op != IR_PHI {
+			continue
+		}
+		for j, p := range s.Preds {
+			if len(p.Succs) < 2 {
+				continue
+			}
+			n := fn.NewBlock()
+			n.Instrs = []*IRInstr{{Op: IR_JUMP, Targets: []*BasicBlock{s}}}
+			t := p.Terminator()
+			for k, x := range t.Targets {
+				if x == s {
+					t.Targets[k] = n
+				}
+			}
+			for k, x := range p.Succs {
+				if x == s {
+					p.Succs[k] = n
+				}
+			}
+			n.Preds = []*BasicBlock{p}
+			n.Succs = []*BasicBlock{s}
+			s.Preds[j] = n
+		}
+	}
+
+	for _, b := range fn.Blocks {
+		var copies []*IRInstr
+		k := 0
+		for ; k < len(b.Instrs) && b.Instrs[k].Op == IR_PHI; k++ {
+			phi := b.Instrs[k]
+			tmp := fn.NewTemp()
+			for j, p := range b.Preds {
+				mv := &IRInstr{Op: IR_COPY, Dst: tmp, Args: []Operand{phi.Args[j]}}
+				last := len(p.Instrs) - 1
+				p.Instrs = append(p.Instrs[:last], mv, p.Instrs[last])
+			}
+			copies = append(copies, &IRInstr{Op: IR_COPY, Dst: phi.Dst, Args: []Operand{VarOperand(tmp)}})
+		}
+		if k > 0 {
+			b.Instrs = append(copies, b.Instrs[k:]...)
+		}
+	}
+	BuildCFG(fn)
+}
+
+// 对整个程序构造SSA形式并校验
+func BuildProgramSSA(prog *IRProgram) error {
+	for _, fn := range prog.Funcs {
+		BuildSSA(fn)
+		if err := VerifySSA(fn); err != nil {
+			return fmt.Errorf("func %s: %s", fn.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// 对整个程序消除SSA形式
+func DestroyProgramSSA(prog *IRProgram) {
+	for _, fn := range prog.Funcs {
+		DestroySSA(fn)
+		RenumberBlocks(fn)
+	}
+}
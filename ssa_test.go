@@ -0,0 +1,213 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: ssa_test.go
+// Package: scan
+// Description: SSA 构造、校验和消除的测试
+// 				示例程序转换为SSA后必须通过 VerifySSA,SSA形式和消除phi之后的IR的执行结果都要与转换前相同
+
+package scan
+
+import (
+	"testing"
+)
+
+func TestSSASamples(t *testing.T) {
+	for _, level := range []int{0, 1} {
+		for _, s := range samples {
+			prog := irSample(t, s)
+			Optimize(prog, OptLevel(level))
+			before, err := runIR(prog, s.input)
+			if err != nil {
+				t.Fatalf("%s: %v", s.name, err)
+			}
+			if err := BuildProgramSSA(prog); err != nil {
+				t.Errorf("-O%d %s: %v", level, s.name, err)
+				continue
+			}
+			if got, err := runIR(prog, s.input); err != nil || got != before {
+				t.Errorf("-O%d %s: ssa: got %q (%v), want %q", level, s.name, got, err, before)
+			}
+			DestroyProgramSSA(prog)
+			if got, err := runIR(prog, s.input); err != nil || got != before {
+				t.Errorf("-O%d %s: out of ssa: got %q (%v), want %q", level, s.name, got, err, before)
+			}
+			Optimize(prog, OptLevel(level))
+			if got, err := runIR(prog, s.input); err != nil || got != before {
+				t.Errorf("-O%d %s: optimized out of ssa: got %q (%v), want %q", level, s.name, got, err, before)
+			}
+		}
+	}
+}
+
+func TestSSAGolden(t *testing.T) {
+	tests := []struct {
+		name, src, ssa, out string
+	}{
+		{"if-else", `void main(void) { int n; int t; n = input(); if (n > 0) t = 1; else t = 2; output(t); }`, `B0:
+    %t1_1 = call input()
+    n_1 = %t1_1
+    %t2_1 = n_1 > 0
+    branch %t2_1, B1, B3
+B1:
+    t_1 = 1
+    jump B2
+B2:
+    t_2 = phi(t_1, t_3)
+    call output(t_2)
+    return
+B3:
+    t_3 = 2
+    jump B2
+`, `B0:
+    %t1_1 = call input()
+    n_1 = %t1_1
+    %t2_1 = n_1 > 0
+    branch %t2_1, B1, B3
+B1:
+    t_1 = 1
+    %t3 = t_1
+    jump B2
+B2:
+    t_2 = %t3
+    call output(t_2)
+    return
+B3:
+    t_3 = 2
+    %t3 = t_3
+    jump B2
+`},
+		{"if without else", `void main(void) { int n; n = input(); if (n > 0) n = 0; output(n); }`, `B0:
+    %t1_1 = call input()
+    n_1 = %t1_1
+    %t2_1 = n_1 > 0
+    branch %t2_1, B1, B2
+B1:
+    n_2 = 0
+    jump B2
+B2:
+    n_3 = phi(n_1, n_2)
+    call output(n_3)
+    return
+`, `B0:
+    %t1_1 = call input()
+    n_1 = %t1_1
+    %t2_1 = n_1 > 0
+    branch %t2_1, B1, B3
+B1:
+    n_2 = 0
+    %t3 = n_2
+    jump B2
+B2:
+    n_3 = %t3
+    call output(n_3)
+    return
+B3:
+    %t3 = n_1
+    jump B2
+`},
+		{"loop", `void main(void) { int i; int s; i = 0; s = 0; while (i < 10) { s = s + i; i = i + 1; } output(s); }`, `B0:
+    i_1 = 0
+    s_1 = 0
+    jump B1
+B1:
+    s_2 = phi(s_1, s_3)
+    i_2 = phi(i_1, i_3)
+    %t1_1 = i_2 < 10
+    branch %t1_1, B2, B3
+B2:
+    %t2_1 = s_2 + i_2
+    s_3 = %t2_1
+    %t3_1 = i_2 + 1
+    i_3 = %t3_1
+    jump B1
+B3:
+    call output(s_2)
+    return
+`, `B0:
+    i_1 = 0
+    s_1 = 0
+    %t4 = s_1
+    %t5 = i_1
+    jump B1
+B1:
+    s_2 = %t4
+    i_2 = %t5
+    %t1_1 = i_2 < 10
+    branch %t1_1, B2, B3
+B2:
+    %t2_1 = s_2 + i_2
+    s_3 = %t2_1
+    %t3_1 = i_2 + 1
+    i_3 = %t3_1
+    %t4 = s_3
+    %t5 = i_3
+    jump B1
+B3:
+    call output(s_2)
+    return
+`},
+	}
+	for _, tt := range tests {
+		root, _, err := ParseProgram(tt.src)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		prog, err := GenerateIR(root)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		fn := prog.Funcs[0]
+		BuildSSA(fn)
+		if err := VerifySSA(fn); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if got := irText(fn); got != tt.ssa {
+			t.Errorf("%s: ssa: got\n%s\nwant\n%s", tt.name, got, tt.ssa)
+		}
+		DestroySSA(fn)
+		RenumberBlocks(fn)
+		if got := irText(fn); got != tt.out {
+			t.Errorf("%s: out of ssa: got\n%s\nwant\n%s", tt.name, got, tt.out)
+		}
+	}
+}
+
+func TestVerifySSARejects(t *testing.T) {
+	src := `void main(void) { int i; int s; i = 0; s = 0; while (i < 10) { s = s + i; i = i + 1; } output(s); }`
+	breakers := map[string]func(fn *IRFunc){
+		"redefinition": func(fn *IRFunc) {
+			b := fn.Entry()
+			b.Instrs = append([]*IRInstr{{Op: IR_COPY, Dst: "i_1", Args: []Operand{ConstOperand(1)}}}, b.Instrs...)
+		},
+		"phi operands": func(fn *IRFunc) {
+			phi := fn.Blocks[1].Instrs[0]
+			phi.Args = phi.Args[:1]
+		},
+		"phi position": func(fn *IRFunc) {
+			b := fn.Blocks[1]
+			b.Instrs[0], b.Instrs[2] = b.Instrs[2], b.Instrs[0]
+		},
+		"undefined": func(fn *IRFunc) {
+			fn.Blocks[3].Instrs[0].Args[0] = VarOperand("s_9")
+		},
+		"not dominated": func(fn *IRFunc) {
+			fn.Blocks[3].Instrs[0].Args[0] = VarOperand("s_3")
+		},
+	}
+	for name, brk := range breakers {
+		root, _, err := ParseProgram(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog, err := GenerateIR(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn := prog.Funcs[0]
+		BuildSSA(fn)
+		brk(fn)
+		if err := VerifySSA(fn); err == nil {
+			t.Errorf("%s: accepted\n%s", name, irText(fn))
+		}
+	}
+}
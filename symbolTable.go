@@ -101,40 +101,59 @@ func (node *SymbolTableNode) Get(key string) interface{} {
 }
 
 // 将符号表向下移动
-func MoveDown() {
-	nextTable = curTable.Next()          // 下一层的最右节点
-	if hasSiblings && nextTable != nil { // 下一节点必然非空
+// 符号表的当前位置保存在语法分析器中,多个语法分析器可以同时工作
+func (parser *Parser) moveDown() {
+	nextTable := parser.table.Next()         // 下一层的最右节点
+	if parser.siblings && nextTable != nil { // 下一节点必然非空
 		// 创建新的同层节点
 		newNode := NewTable()
 		nextTable.SetRightSibling(newNode)
-		newNode.SetPrev(curTable)
+		newNode.SetPrev(parser.table)
 		nextTable = newNode
 	} else { // 下一节点必然为空
 		nextTable = NewTable()
-		nextTable.SetPrev(curTable)
-		curTable.SetNext(nextTable)
+		nextTable.SetPrev(parser.table)
+		parser.table.SetNext(nextTable)
 	}
-	curTable = nextTable // 重置当前节点
-	hasSiblings = false
+	parser.table = nextTable // 重置当前节点
+	parser.siblings = false
 }
 
 // 将符号表向上移动
-func MoveUp() {
-	hasSiblings = true
-	curTable = curTable.Prev()
-	if curTable == nil {
-		ParserConst.syntaxError()
+func (parser *Parser) moveUp() {
+	parser.siblings = true
+	prev := parser.table.Prev()
+	if prev == nil {
+		parser.syntaxError() // 多余的'}',停留在最外层
+		return
 	}
+	parser.table = prev
 }
 
 // 向符号表添加标识符
-func AddIdentifier(lexeme string) {
-	err := curTable.Put(lexeme, NewContent(BufferConst.Lines()))
+func (parser *Parser) addIdentifier(lexeme string) {
+	err := parser.table.Put(lexeme, NewContent(parser.buffer.Lines()))
 	if err != nil {
 		//fmt.Println(err.Error(), ":", lexeme)
 	}
 }
 
+// 以下三个函数对 ParserConst 的符号表进行操作,保留以兼容原有接口
+// 将 ParserConst 的符号表向下移动
+func MoveDown() {
+	ParserConst.moveDown()
+}
+
+// 将 ParserConst 的符号表向上移动
+func MoveUp() {
+	ParserConst.moveUp()
+}
+
+// 向 ParserConst 的符号表添加标识符
+func AddIdentifier(lexeme string) {
+	ParserConst.addIdentifier(lexeme)
+}
+
 // 标识符属性域
 // 标识符分为函数名、变量名、形参名。。。
 // 函数标识符属性：参数列表，返回值类型，
@@ -60,6 +60,11 @@ func (node *ASTNode) SetAttr(attr interface{}) {
 	node.attribute = attr
 }
 
+// 返回节点所处行号
+func (node *ASTNode) Line() int {
+	return node.line
+}
+
 // 结构体工厂函数，返回一个节点指针
 func NewASTNode(k NodeKind, t interface{}, l int) *ASTNode {
 	var newNode ASTNode
@@ -0,0 +1,190 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: uninit.go
+// Package: scan
+// Description: 本文件定义了局部变量的确定赋值分析
+// 				C-Minus 的变量声明没有初值,在语法树上沿控制流传递两个集合:
+// 				必定已赋值(所有路径上都赋过值)和可能已赋值(至少一条路径上赋过值)
+// 				读取标量局部变量时,不在任何路径上赋过值的报告为未初始化,只在部分路径上赋过值的报告为可能未初始化
+// 				数组元素无法逐个跟踪,保守地认为数组总是已初始化,不报告;形参和全局变量也视为已初始化
+
+package scan
+
+// 被跟踪的标量局部变量
+type daVar struct {
+	node     *ASTNode // 声明节点
+	reported bool     // 是否已经报告过,每个变量只报告一次
+}
+
+// 确定赋值分析的状态
+type daState struct {
+	must map[*daVar]bool // 必定已赋值
+	may  map[*daVar]bool // 可能已赋值
+	dead bool            // 当前位置不可达(return 之后)
+}
+
+// 复制状态
+func (st *daState) copy() *daState {
+	res := &daState{must: make(map[*daVar]bool, len(st.must)), may: make(map[*daVar]bool, len(st.may)), dead: st.dead}
+	for v := range st.must {
+		res.must[v] = true
+	}
+	for v := range st.may {
+		res.may[v] = true
+	}
+	return res
+}
+
+// 合并两条路径的状态,不可达的路径不参与合并
+func (st *daState) join(other *daState) *daState {
+	if st.dead {
+		return other.copy()
+	}
+	if other.dead {
+		return st.copy()
+	}
+	res := st.copy()
+	for v := range res.must {
+		if !other.must[v] {
+			delete(res.must, v)
+		}
+	}
+	for v := range other.may {
+		res.may[v] = true
+	}
+	return res
+}
+
+// 记录变量被赋值
+func (st *daState) assign(v *daVar) {
+	st.must[v] = true
+	st.may[v] = true
+}
+
+// 确定赋值分析的作用域,值为nil表示不跟踪的标识符(形参、数组)
+type daScope struct {
+	vars map[string]*daVar
+	prev *daScope
+}
+
+// 由内向外查找标识符
+func (scope *daScope) lookup(name string) *daVar {
+	for s := scope; s != nil; s = s.prev {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// 确定赋值分析器
+type assignChecker struct {
+	diags  []Diagnostic
+	report bool // 循环体第一遍分析时只计算状态,不报告
+}
+
+// 对每个函数进行确定赋值分析,返回按行号排序的诊断信息
+func CheckUninitialized(root *ASTNode) []Diagnostic {
+	c := &assignChecker{report: true}
+	for node := root; node != nil; node = node.sibling {
+		if !isStmt(node, FUNC_DECLARATION) {
+			continue
+		}
+		params := &daScope{vars: make(map[string]*daVar)}
+		for _, p := range funcParams(node) {
+			params.vars[nodeName(p)] = nil
+		}
+		st := &daState{must: make(map[*daVar]bool), may: make(map[*daVar]bool)}
+		c.stmt(node.right, params, st)
+	}
+	SortDiagnostics(c.diags)
+	return c.diags
+}
+
+// 分析一条语句,返回语句之后的状态
+func (c *assignChecker) stmt(node *ASTNode, scope *daScope, st *daState) *daState {
+	if node == nil {
+		return st
+	}
+	switch {
+	case isStmt(node, COMPOUND):
+		inner := &daScope{vars: make(map[string]*daVar), prev: scope}
+		for d := node.left; d != nil; d = d.sibling {
+			if declInfo(d, SYM_LOCAL).isArray {
+				inner.vars[nodeName(d)] = nil
+			} else {
+				inner.vars[nodeName(d)] = &daVar{node: d}
+			}
+		}
+		for s := node.right; s != nil; s = s.sibling {
+			st = c.stmt(s, inner, st)
+		}
+		return st
+	case isStmt(node, SELECTION_STMT):
+		st = c.exp(node.left, scope, st)
+		thenSt := c.stmt(node.mid, scope, st.copy())
+		elseSt := c.stmt(node.right, scope, st.copy())
+		return thenSt.join(elseSt)
+	case isStmt(node, ITERATION_STMT):
+		// 第一遍计算循环体结束时可能已赋值的变量,第二遍把它们并入循环入口后再报告
+		report := c.report
+		c.report = false
+		first := c.stmt(node.mid, scope, c.exp(node.left, scope, st.copy()))
+		c.report = report
+		entry := st.join(first)
+		entry.must = st.copy().must
+		entry.dead = st.dead
+		head := c.exp(node.left, scope, entry)
+		c.stmt(node.mid, scope, head.copy())
+		return head
+	case isStmt(node, RETURN_STMT):
+		st = c.exp(node.left, scope, st)
+		st.dead = true
+		return st
+	}
+	return c.exp(node, scope, st)
+}
+
+// 分析表达式,按求值顺序处理变量的读取和赋值
+func (c *assignChecker) exp(node *ASTNode, scope *daScope, st *daState) *daState {
+	if node == nil {
+		return st
+	}
+	switch {
+	case isExp(node, ASSIGNMENT):
+		st = c.exp(node.right, scope, st)
+		if lhs := node.left; lhs != nil {
+			st = c.exp(lhs.left, scope, st) // 数组下标
+			if v := scope.lookup(nodeName(lhs)); v != nil {
+				st.assign(v)
+			}
+		}
+		return st
+	case isExp(node, VAR):
+		st = c.exp(node.left, scope, st)
+		if v := scope.lookup(nodeName(node)); v != nil {
+			c.use(v, node, st)
+		}
+		return st
+	case isExp(node, CALL):
+		for _, a := range callArgs(node) {
+			st = c.exp(a, scope, st)
+		}
+		return st
+	}
+	st = c.exp(node.left, scope, st)
+	return c.exp(node.right, scope, st)
+}
+
+// 检查一次变量读取
+func (c *assignChecker) use(v *daVar, node *ASTNode, st *daState) {
+	if !c.report || st.dead || v.reported || st.must[v] {
+		return
+	}
+	v.reported = true
+	if st.may[v] {
+		c.diags = append(c.diags, newWarning(node.line, DIAG_MAYBE_UNINIT, MSG_MAYBE_UNINIT, nodeName(node)))
+	} else {
+		c.diags = append(c.diags, newWarning(node.line, DIAG_UNINIT, MSG_UNINIT, nodeName(node)))
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: uninit_test.go
+// Package: scan
+// Description: 确定赋值分析的测试
+// 				检查未初始化和可能未初始化的读取报告在哪一行,每个变量只在第一次有问题的读取处报告
+
+package scan
+
+import (
+	"testing"
+)
+
+func TestCheckUninitialized(t *testing.T) {
+	checkDiagnostics(t, "uninit", CheckUninitialized, []struct{ name, src, want string }{
+		{"assigned", `void main(void) {
+	int x;
+	x = 1;
+	output(x);
+}`, ""},
+		{"never assigned", `void main(void) {
+	int x;
+	output(x);
+}`, "3:W301"},
+		{"one branch", `void main(void) {
+	int x;
+	if (input() > 0)
+		x = 1;
+	output(x);
+}`, "5:W302"},
+		{"both branches", `void main(void) {
+	int x;
+	if (input() > 0) x = 1;
+	else x = 2;
+	output(x);
+}`, ""},
+		{"loop carried", `void main(void) {
+	int i; int s;
+	i = 0;
+	while (i < 10) {
+		s = s + i;
+		i = i + 1;
+	}
+	output(s);
+}`, "5:W302"}, // 第一次迭代时未赋值,之后的迭代已赋值;每个变量只报告一次
+		{"assigned in loop", `void main(void) {
+	int i; int t;
+	i = 0;
+	while (i < 3) {
+		t = i;
+		i = i + 1;
+	}
+	output(t);
+}`, "8:W302"},
+		{"self assignment", `void main(void) {
+	int x;
+	x = x + 1;
+	output(x);
+}`, "3:W301"},
+		{"params and globals", `int g;
+int f(int a) { return a + g; }
+void main(void) { int a[2]; output(f(a[0])); }`, ""},
+	})
+}
@@ -0,0 +1,126 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: walk.go
+// Package: scan
+// Description: 本文件定义了抽象语法树的遍历和改写接口,用法与 go/ast 的 Walk、Inspect 相同
+// 				遍历按 left、mid、right 的顺序深度优先访问子节点,并沿 sibling 访问整个兄弟序列
+// 				Apply 在遍历的同时通过 Cursor 替换或删除节点,兄弟序列中的节点删除后由其后的兄弟节点补上
+
+package scan
+
+// 访问者,Visit 返回的访问者用于访问该节点的子节点,返回 nil 时跳过子节点
+// 子节点访问完后以 nil 再调用一次 w.Visit
+type Visitor interface {
+	Visit(node *ASTNode) (w Visitor)
+}
+
+// 深度优先遍历以 node 开始的兄弟序列及其全部子树
+func Walk(node *ASTNode, v Visitor) {
+	for ; node != nil; node = node.sibling {
+		w := v.Visit(node)
+		if w == nil {
+			continue
+		}
+		Walk(node.left, w)
+		Walk(node.mid, w)
+		Walk(node.right, w)
+		w.Visit(nil)
+	}
+}
+
+// 把函数包装为访问者
+type inspector func(*ASTNode) bool
+
+func (f inspector) Visit(node *ASTNode) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// 深度优先遍历,对每个节点调用 f,f 返回 false 时跳过该节点的子节点
+// 子节点访问完后以 nil 调用 f
+func Inspect(node *ASTNode, f func(*ASTNode) bool) {
+	Walk(node, inspector(f))
+}
+
+// Apply 遍历时对每个节点调用的函数
+type ApplyFunc func(*Cursor) bool
+
+// 指向遍历中当前节点的游标
+type Cursor struct {
+	parent *ASTNode
+	slot   **ASTNode // 指向当前节点的指针: 父节点的子节点指针或前一个兄弟的 sibling
+	node   *ASTNode  // 当前节点,删除后为 nil
+}
+
+// 返回当前节点,节点被删除后返回 nil
+func (c *Cursor) Node() *ASTNode {
+	return c.node
+}
+
+// 返回当前节点的父节点,兄弟序列中的节点返回序列第一个节点的父节点,顶层节点返回 nil
+func (c *Cursor) Parent() *ASTNode {
+	return c.parent
+}
+
+// 用 n 替换当前节点,n 接替当前节点在兄弟序列中的位置
+// n 为 nil 时等同于 Delete
+func (c *Cursor) Replace(n *ASTNode) {
+	if n == nil {
+		c.Delete()
+		return
+	}
+	if c.node == nil {
+		panic("Cursor.Replace: node already deleted")
+	}
+	n.sibling = c.node.sibling
+	*c.slot = n
+	c.node = n
+}
+
+// 删除当前节点,其后的兄弟节点移到当前位置
+// 删除 if 的条件、运算的操作数等必需的子节点会得到不合法的语法树,由调用者负责
+func (c *Cursor) Delete() {
+	if c.node == nil {
+		return
+	}
+	*c.slot = c.node.sibling
+	c.node = nil
+}
+
+// 遍历并改写以 root 开始的兄弟序列,返回改写后的序列
+// 对每个节点先调用 pre,pre 返回 true 时遍历子节点再调用 post;pre 返回 false 时跳过子节点和 post
+// post 返回 false 时终止整个遍历;pre 或 post 为 nil 时视为总是返回 true
+// pre 替换的节点,遍历的是替换后节点的子节点;被删除的节点不再访问其子节点
+func Apply(root *ASTNode, pre, post ApplyFunc) *ASTNode {
+	a := applier{pre: pre, post: post}
+	a.chain(nil, &root)
+	return root
+}
+
+// 语法树改写器
+type applier struct {
+	pre, post ApplyFunc
+}
+
+// 遍历 slot 指向的兄弟序列,返回 false 表示终止遍历
+func (a *applier) chain(parent *ASTNode, slot **ASTNode) bool {
+	for *slot != nil {
+		c := &Cursor{parent: parent, slot: slot, node: *slot}
+		if (a.pre == nil || a.pre(c)) && c.node != nil {
+			n := c.node
+			if !a.chain(n, &n.left) || !a.chain(n, &n.mid) || !a.chain(n, &n.right) {
+				return false
+			}
+			if a.post != nil && !a.post(c) {
+				return false
+			}
+		}
+		if c.node == nil {
+			continue // 节点已删除,slot 现在指向其后的兄弟
+		}
+		slot = &c.node.sibling
+	}
+	return true
+}
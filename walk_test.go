@@ -0,0 +1,188 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: walk_test.go
+// Package: scan
+// Description: 语法树遍历和改写的测试
+// 				检查遍历顺序,以及 Apply 在兄弟序列的开头、中间和末尾删除、替换节点后序列仍然完整
+
+package scan
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 节点的简短描述,用于比较遍历顺序
+func walkLabel(node *ASTNode) string {
+	if val, ok := constValue(node); ok {
+		return strconv.FormatInt(val, 10)
+	}
+	if node.nodeK == ARGS {
+		return "args"
+	}
+	if name := nodeName(node); len(name) != 0 {
+		return name
+	}
+	return tokenSymbol(nodeOp(node))
+}
+
+func TestInspectOrder(t *testing.T) {
+	root, _, err := ParseExpression("f(a[1], b + 2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	Inspect(root, func(node *ASTNode) bool {
+		if node == nil {
+			got = append(got, ")")
+			return true
+		}
+		got = append(got, walkLabel(node))
+		return !isExp(node, VAR) // 不进入变量的下标
+	})
+	// 实参序列挂在 ARGS 节点下;跳过子节点时不以 nil 调用
+	if got, want := strings.Join(got, " "), "f args a + b 2 ) ) ) )"; got != want {
+		t.Errorf("Inspect order: %s, want %s", got, want)
+	}
+}
+
+// output(n) 语句的实参,不是这样的语句时返回 -1
+func outputArg(node *ASTNode) int64 {
+	if isExp(node, CALL) && nodeName(node) == BUILTIN_OUTPUT {
+		if val, ok := constValue(callArgs(node)[0]); ok {
+			return val
+		}
+	}
+	return -1
+}
+
+// 执行改写后的程序,返回输出
+func runApplied(t *testing.T, src string, pre, post ApplyFunc) string {
+	t.Helper()
+	root, _, err := ParseProgram(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root = Apply(root, pre, post)
+	var out strings.Builder
+	it, err := NewInterpreter(root, strings.NewReader(""), &out, InterpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := it.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+const walkSrc = `void main(void) { output(1); output(2); output(3); output(4); output(5); }`
+
+func TestApplyDelete(t *testing.T) {
+	tests := []struct {
+		del  string // 要删除的 output 语句的实参
+		want string
+	}{
+		{"1", "2 3 4 5"},  // 序列开头,父节点的子节点指针改为指向下一个
+		{"3", "1 2 4 5"},  // 序列中间
+		{"5", "1 2 3 4"},  // 序列末尾
+		{"2 3 4", "1 5"},  // 连续删除,删除后继续访问补上来的兄弟节点
+		{"1 2 3 4 5", ""}, // 删除全部
+		{"1 3 5", "2 4"},  // 间隔删除
+	}
+	for _, tt := range tests {
+		del := make(map[int64]bool)
+		for _, f := range strings.Fields(tt.del) {
+			n, _ := strconv.ParseInt(f, 10, 64)
+			del[n] = true
+		}
+		visited := 0
+		got := runApplied(t, walkSrc, func(c *Cursor) bool {
+			if n := outputArg(c.Node()); n > 0 {
+				visited++
+				if del[n] {
+					c.Delete()
+					if c.Node() != nil {
+						t.Errorf("delete %s: Node() after Delete = %v", tt.del, c.Node())
+					}
+				}
+			}
+			return true
+		}, nil)
+		if got = strings.Join(strings.Fields(got), " "); got != tt.want {
+			t.Errorf("delete %s: output %q, want %q", tt.del, got, tt.want)
+		}
+		if visited != 5 {
+			t.Errorf("delete %s: visited %d statements, want 5", tt.del, visited)
+		}
+	}
+}
+
+func TestApplyReplace(t *testing.T) {
+	// 在 pre 中替换时遍历替换后节点的子节点,替换后的节点接上原来的兄弟节点
+	stmt := func(src string) *ASTNode {
+		node, _, err := ParseExpression(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return node
+	}
+	var consts []string
+	got := runApplied(t, walkSrc, func(c *Cursor) bool {
+		switch outputArg(c.Node()) {
+		case 1:
+			c.Replace(stmt("output(10)"))
+		case 3:
+			c.Replace(stmt("output(30 + 0)"))
+		case 5:
+			c.Replace(nil) // 等同于 Delete
+		}
+		if val, ok := constValue(c.Node()); ok {
+			consts = append(consts, strconv.FormatInt(val, 10))
+		}
+		return true
+	}, func(c *Cursor) bool {
+		// post 中替换整个运算
+		if isExp(c.Node(), OPERATION) {
+			if p := c.Parent(); p == nil || p.nodeK != ARGS {
+				t.Errorf("parent of %s is %v", walkLabel(c.Node()), c.Parent())
+			}
+			c.Replace(newConst(31, c.Node().line))
+		}
+		return true
+	})
+	if want := "10\n2\n31\n4\n"; got != want {
+		t.Errorf("output %q, want %q", got, want)
+	}
+	if want := "10 2 30 0 4"; strings.Join(consts, " ") != want {
+		t.Errorf("visited constants %v, want %s", consts, want)
+	}
+}
+
+func TestApplyStop(t *testing.T) {
+	// pre 返回 false 跳过子节点,post 返回 false 终止遍历
+	root, _, err := ParseProgram(walkSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pre, post []string
+	Apply(root, func(c *Cursor) bool {
+		if n := outputArg(c.Node()); n > 0 {
+			pre = append(pre, strconv.FormatInt(n, 10))
+			return n != 2
+		}
+		return true
+	}, func(c *Cursor) bool {
+		n := outputArg(c.Node())
+		if n > 0 {
+			post = append(post, strconv.FormatInt(n, 10))
+		}
+		return n != 3
+	})
+	if got := strings.Join(pre, " "); got != "1 2 3" {
+		t.Errorf("pre visited %s, want 1 2 3", got)
+	}
+	if got := strings.Join(post, " "); got != "1 3" {
+		t.Errorf("post visited %s, want 1 3", got)
+	}
+}
@@ -0,0 +1,560 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: wat.go
+// Package: scan
+// Description: 本文件定义了WebAssembly文本格式(WAT)目标代码生成器以及一个简单的WAT校验器
+// 				int 映射为 i32,标量局部变量映射为wasm局部变量
+// 				全局变量和数组全部放在线性内存中,局部数组由内存高端向下增长的栈分配
+// 				input/output 从宿主环境 "env" 模块导入,main 和 memory 导出
+
+package scan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 线性内存布局
+const (
+	WAT_GLOBAL_BASE = 1024      // 全局变量起始地址
+	WAT_STACK_SIZE  = 64 * 1024 // 局部数组使用的栈大小
+	WAT_PAGE_SIZE   = 64 * 1024 // wasm 页大小
+)
+
+// WAT 代码生成器
+type WATGenerator struct {
+	out     io.Writer           // 输出
+	buf     *bytes.Buffer       // 生成的WAT文本,校验通过后再写到输出
+	globals *scopeEnv           // 全局作用域
+	funcs   map[string]*ASTNode // 函数声明
+	env     *scopeEnv           // 当前作用域
+	locals  []string            // 当前函数声明的wasm局部变量
+	frame   int                 // 当前函数局部数组占用的栈空间(字节)
+	arrays  int                 // 当前函数已经分配的局部数组空间(字节)
+	indent  int                 // 缩进层次
+	label   int                 // 标号计数器
+	err     error               // 生成过程中遇到的第一个错误
+}
+
+// WAT 代码生成器工厂函数
+func NewWATGenerator(out io.Writer) *WATGenerator {
+	return &WATGenerator{out: out, buf: new(bytes.Buffer)}
+}
+
+// 生成整个程序的WAT模块,生成后先进行校验再输出
+func (g *WATGenerator) Generate(root *ASTNode) error {
+	if root == nil {
+		return errors.New(Msg(MSG_GEN_EMPTY))
+	}
+	env, globals, funcs, err := collectProgram(root)
+	if err != nil {
+		return err
+	}
+	g.globals = env
+	g.funcs = make(map[string]*ASTNode, len(funcs))
+	for _, fn := range funcs {
+		g.funcs[nodeName(fn)] = fn
+	}
+	if _, ok := g.funcs["main"]; !ok {
+		return errors.New(Msg(MSG_GEN_NO_MAIN))
+	}
+
+	// 为全局变量分配线性内存地址
+	addr := WAT_GLOBAL_BASE
+	for _, info := range globals {
+		info.slot = addr
+		if info.isArray {
+			addr += 4 * int(info.size)
+		} else {
+			addr += 4
+		}
+	}
+	pages := (addr + WAT_STACK_SIZE + WAT_PAGE_SIZE - 1) / WAT_PAGE_SIZE
+
+	g.line(";; Generated by CMinusParser, target wasm (WebAssembly text format)")
+	g.open("(module")
+	if _, ok := g.funcs[BUILTIN_INPUT]; !ok {
+		g.line(`(import "env" "input" (func $input (result i32)))`)
+	}
+	if _, ok := g.funcs[BUILTIN_OUTPUT]; !ok {
+		g.line(`(import "env" "output" (func $output (param i32)))`)
+	}
+	g.line(`(memory (export "memory") %d)`, pages)
+	g.line(`(global $sp (mut i32) (i32.const %d))`, pages*WAT_PAGE_SIZE)
+	for _, info := range globals {
+		g.line(";; global %s at %d", info.name, info.slot)
+	}
+	for _, fn := range funcs {
+		g.genFunc(fn)
+		if g.err != nil {
+			return g.err
+		}
+	}
+	g.line(`(export "main" (func $main))`)
+	g.close(")")
+
+	if err := ValidateWAT(g.buf.String()); err != nil {
+		return errors.New(Msg(MSG_GEN_INVALID_WAT, err.Error()))
+	}
+	_, err = g.out.Write(g.buf.Bytes())
+	return err
+}
+
+// 记录第一个错误,id 为消息编号
+func (g *WATGenerator) errorf(node *ASTNode, id string, args ...interface{}) {
+	if g.err == nil {
+		g.err = errors.New(Msg(MSG_AT_LINE, node.line, Msg(id, args...)))
+	}
+}
+
+// 按当前缩进输出一行
+func (g *WATGenerator) line(format string, args ...interface{}) {
+	g.buf.WriteString(strings.Repeat("  ", g.indent))
+	fmt.Fprintf(g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+// 输出一行并增加缩进
+func (g *WATGenerator) open(format string, args ...interface{}) {
+	g.line(format, args...)
+	g.indent++
+}
+
+// 减少缩进并输出一行
+func (g *WATGenerator) close(format string, args ...interface{}) {
+	g.indent--
+	g.line(format, args...)
+}
+
+// 新建一个标号
+func (g *WATGenerator) newLabel(prefix string) string {
+	g.label++
+	return fmt.Sprintf("$%s%d", prefix, g.label)
+}
+
+// 新建一个wasm局部变量,名字加序号避免不同作用域的同名变量冲突
+func (g *WATGenerator) newLocal(name string) string {
+	local := fmt.Sprintf("$%s_%d", name, len(g.locals))
+	g.locals = append(g.locals, local)
+	return local
+}
+
+// 生成函数
+// 局部变量信息保存在symInfo.slot中: 标量为locals下标, 局部数组为相对$fp的偏移
+func (g *WATGenerator) genFunc(fn *ASTNode) {
+	name := nodeName(fn)
+	g.env = newScopeEnv(g.globals)
+	g.locals = nil
+	g.arrays = 0
+	g.frame = 4 * frameArrayCells(fn.right)
+
+	// 函数体先生成到临时缓冲区,局部变量声明需要放在函数开头
+	header := fmt.Sprintf("(func $%s", name)
+	for i, p := range funcParams(fn) {
+		info := declInfo(p, SYM_PARAM)
+		info.index = i
+		if g.env.put(info) != nil {
+			g.errorf(p, MSG_GEN_REDECLARED, info.name)
+			return
+		}
+		header += fmt.Sprintf(" (param $%s i32)", info.name)
+	}
+	if funcReturnsInt(fn) {
+		header += " (result i32)"
+	}
+
+	saved, savedIndent := g.buf, g.indent
+	g.buf = new(bytes.Buffer)
+	g.indent = savedIndent + 1
+	g.open("(block $_exit")
+	g.genStmt(fn.right)
+	g.close(")")
+	body := g.buf
+	g.buf, g.indent = saved, savedIndent
+
+	g.open("%s", header)
+	if len(g.locals) > 0 {
+		g.line("(local %s i32)", strings.Join(g.locals, " i32) (local "))
+	}
+	// C-Minus 标识符只含字母,带下划线的名字不会和用户变量冲突
+	g.line("(local $_ret i32) (local $_fp i32) (local $_tmp i32)")
+	// 分配局部数组的栈空间
+	if g.frame > 0 {
+		g.line("global.get $sp")
+		g.line("i32.const %d", g.frame)
+		g.line("i32.sub")
+		g.line("local.tee $_fp")
+		g.line("global.set $sp")
+	}
+	g.buf.Write(body.Bytes())
+	if g.frame > 0 {
+		g.line("local.get $_fp")
+		g.line("i32.const %d", g.frame)
+		g.line("i32.add")
+		g.line("global.set $sp")
+	}
+	if funcReturnsInt(fn) {
+		g.line("local.get $_ret")
+	}
+	g.close(")")
+}
+
+// 计算函数体中局部数组的元素总数
+func frameArrayCells(node *ASTNode) int {
+	if node == nil {
+		return 0
+	}
+	cells := 0
+	if isStmt(node, VAR_DECLARATION) {
+		if info := declInfo(node, SYM_LOCAL); info.isArray {
+			cells += int(info.size)
+		}
+	} else {
+		cells += frameArrayCells(node.left) + frameArrayCells(node.mid) + frameArrayCells(node.right)
+	}
+	return cells + frameArrayCells(node.sibling)
+}
+
+// 生成语句序列
+func (g *WATGenerator) genStmtList(node *ASTNode) {
+	for ; node != nil && g.err == nil; node = node.sibling {
+		g.genStmt(node)
+	}
+}
+
+// 生成单条语句
+func (g *WATGenerator) genStmt(node *ASTNode) {
+	if node == nil {
+		return
+	}
+	if node.nodeK == EXPRESSION { // 表达式语句,丢弃结果
+		if g.genExpr(node) {
+			g.line("drop")
+		}
+		return
+	}
+
+	switch node.nodeT {
+	case COMPOUND:
+		g.env = newScopeEnv(g.env)
+		for d := node.left; d != nil; d = d.sibling {
+			info := declInfo(d, SYM_LOCAL)
+			if info.isArray {
+				info.slot = g.arrays
+				g.arrays += 4 * int(info.size)
+			} else {
+				info.slot = len(g.locals)
+				g.newLocal(info.name)
+			}
+			if g.env.put(info) != nil {
+				g.errorf(d, MSG_GEN_REDECLARED, info.name)
+				return
+			}
+		}
+		g.genStmtList(node.right)
+		g.env = g.env.prev
+	case SELECTION_STMT:
+		g.genValue(node.left)
+		g.open("(if")
+		g.open("(then")
+		g.genStmt(node.mid)
+		g.close(")")
+		if node.right != nil {
+			g.open("(else")
+			g.genStmt(node.right)
+			g.close(")")
+		}
+		g.close(")")
+	case ITERATION_STMT:
+		brk, cont := g.newLabel("brk"), g.newLabel("cont")
+		g.open("(block %s", brk)
+		g.open("(loop %s", cont)
+		g.genValue(node.left)
+		g.line("i32.eqz")
+		g.line("br_if %s", brk)
+		g.genStmt(node.mid)
+		g.line("br %s", cont)
+		g.close(")")
+		g.close(")")
+	case RETURN_STMT:
+		if node.left != nil {
+			g.genValue(node.left)
+			g.line("local.set $_ret")
+		}
+		g.line("br $_exit")
+	default:
+		g.errorf(node, MSG_GEN_UNEXPECTED_STMT)
+	}
+}
+
+// 生成必须产生值的表达式
+func (g *WATGenerator) genValue(node *ASTNode) {
+	if node == nil {
+		return
+	}
+	if !g.genExpr(node) {
+		g.errorf(node, MSG_GEN_VOID_VALUE)
+	}
+}
+
+// 计算数组元素或全局变量的内存地址,压入栈顶
+func (g *WATGenerator) genAddr(node *ASTNode, info *symInfo) {
+	switch {
+	case info.kind == SYM_GLOBAL:
+		g.line("i32.const %d", info.slot)
+	case info.kind == SYM_PARAM: // 数组形参保存的是地址
+		g.line("local.get $%s", info.name)
+	default: // 局部数组
+		g.line("local.get $_fp")
+		if info.slot != 0 {
+			g.line("i32.const %d", info.slot)
+			g.line("i32.add")
+		}
+	}
+	if node.left != nil { // 数组元素
+		g.genValue(node.left)
+		g.line("i32.const 4")
+		g.line("i32.mul")
+		g.line("i32.add")
+	}
+}
+
+// 查找变量并检查下标使用是否正确
+func (g *WATGenerator) lookupVar(node *ASTNode) *symInfo {
+	name := nodeName(node)
+	info := g.env.lookup(name)
+	if info == nil {
+		g.errorf(node, MSG_GEN_UNDEFINED_VAR, name)
+		return nil
+	}
+	if node.left != nil && !info.isArray {
+		g.errorf(node, MSG_GEN_NOT_ARRAY, name)
+		return nil
+	}
+	return info
+}
+
+// 标量局部变量对应的wasm局部变量名
+func (g *WATGenerator) localName(info *symInfo) string {
+	if info.kind == SYM_PARAM {
+		return "$" + info.name
+	}
+	return g.locals[info.slot]
+}
+
+// 判断变量是否保存在wasm局部变量中
+func watInLocal(node *ASTNode, info *symInfo) bool {
+	if info.kind == SYM_GLOBAL {
+		return false
+	}
+	// 标量,或者不带下标使用的数组形参
+	return !info.isArray || (info.kind == SYM_PARAM && node.left == nil)
+}
+
+// 生成表达式,返回是否在栈顶留下一个i32值
+func (g *WATGenerator) genExpr(node *ASTNode) bool {
+	if node == nil || g.err != nil {
+		return false
+	}
+	switch node.nodeT {
+	case CONST:
+		g.line("i32.const %d", int32(nodeValue(node)))
+	case VAR:
+		info := g.lookupVar(node)
+		if info == nil {
+			return false
+		}
+		switch {
+		case watInLocal(node, info):
+			g.line("local.get %s", g.localName(info))
+		case info.isArray && node.left == nil: // 数组名作为地址使用
+			g.genAddr(node, info)
+		default:
+			g.genAddr(node, info)
+			g.line("i32.load")
+		}
+	case ASSIGNMENT:
+		info := g.lookupVar(node.left)
+		if info == nil {
+			return false
+		}
+		if watInLocal(node.left, info) {
+			g.genValue(node.right)
+			g.line("local.tee %s", g.localName(info))
+		} else {
+			g.genAddr(node.left, info)
+			g.genValue(node.right)
+			g.line("local.tee $_tmp")
+			g.line("i32.store")
+			g.line("local.get $_tmp")
+		}
+	case CALL:
+		return g.genCall(node)
+	case OPERATION, COMPARE:
+		g.genValue(node.left)
+		g.genValue(node.right)
+		ins, ok := map[Token]string{
+			PLUS: "i32.add", MINUS: "i32.sub", MUL: "i32.mul", DIV: "i32.div_s",
+			LT: "i32.lt_s", LE: "i32.le_s", GT: "i32.gt_s", GE: "i32.ge_s", EQ: "i32.eq", NOT_EQ: "i32.ne",
+		}[nodeOp(node)]
+		if !ok {
+			g.errorf(node, MSG_GEN_UNKNOWN_OP)
+			return false
+		}
+		g.line("%s", ins)
+	default:
+		g.errorf(node, MSG_GEN_UNEXPECTED_EXPR)
+		return false
+	}
+	return true
+}
+
+// 生成函数调用,返回是否有返回值
+func (g *WATGenerator) genCall(node *ASTNode) bool {
+	name := nodeName(node)
+	args := callArgs(node)
+	fn, ok := g.funcs[name]
+	switch {
+	case ok:
+		if len(funcParams(fn)) != len(args) {
+			g.errorf(node, MSG_GEN_ARG_COUNT, name, len(funcParams(fn)), len(args))
+			return false
+		}
+	case name == BUILTIN_INPUT || name == BUILTIN_OUTPUT:
+	default:
+		g.errorf(node, MSG_GEN_UNDEFINED_FUNC, name)
+		return false
+	}
+	for _, a := range args {
+		g.genValue(a)
+	}
+	g.line("call $%s", name)
+	if ok {
+		return funcReturnsInt(fn)
+	}
+	return name == BUILTIN_INPUT
+}
+
+// 校验WAT文本
+// 检查括号和块结构是否匹配、call引用的函数是否声明、
+// local.get/local.set/local.tee引用的局部变量是否在所在函数中声明、br引用的标号是否在外层
+// 这不是完整的wasm校验器,但足以在没有外部工具链时离线发现生成器的错误
+func ValidateWAT(src string) error {
+	toks := watTokens(src)
+
+	// 收集所有函数名
+	funcs := make(map[string]bool)
+	for i := 0; i+1 < len(toks); i++ {
+		if toks[i] == "(" && i+2 < len(toks) && toks[i+1] == "func" && strings.HasPrefix(toks[i+2], "$") {
+			funcs[toks[i+2]] = true
+		}
+	}
+
+	var stack []string         // 打开的括号对应的关键字
+	var labels []string        // 当前可见的块标号
+	var locals map[string]bool // 当前函数的局部变量
+	for i := 0; i < len(toks); i++ {
+		tok := toks[i]
+		switch tok {
+		case "(":
+			if i+1 >= len(toks) {
+				return errors.New(Msg(MSG_WAT_EOF))
+			}
+			kw := toks[i+1]
+			stack = append(stack, kw)
+			switch kw {
+			case "func":
+				locals = make(map[string]bool)
+			case "param", "local":
+				if len(stack) >= 2 && stack[len(stack)-2] == "func" && i+2 < len(toks) && strings.HasPrefix(toks[i+2], "$") {
+					locals[toks[i+2]] = true
+				}
+			case "block", "loop":
+				label := ""
+				if i+2 < len(toks) && strings.HasPrefix(toks[i+2], "$") {
+					label = toks[i+2]
+				}
+				labels = append(labels, label)
+			case "if":
+				labels = append(labels, "")
+			}
+			i++
+		case ")":
+			if len(stack) == 0 {
+				return errors.New(Msg(MSG_WAT_UNBALANCED_CLOS))
+			}
+			switch stack[len(stack)-1] {
+			case "block", "loop", "if":
+				labels = labels[:len(labels)-1]
+			case "func":
+				locals = nil
+			}
+			stack = stack[:len(stack)-1]
+		case "call":
+			if i+1 >= len(toks) || !funcs[toks[i+1]] {
+				return errors.New(Msg(MSG_WAT_UNDECLARED_CALL, i))
+			}
+		case "local.get", "local.set", "local.tee":
+			if locals == nil {
+				return errors.New(Msg(MSG_WAT_OUTSIDE_FUNC, tok))
+			}
+			if i+1 >= len(toks) || !locals[toks[i+1]] {
+				return errors.New(Msg(MSG_WAT_UNDECLARED_LOC, tok, i))
+			}
+		case "br", "br_if":
+			if i+1 >= len(toks) {
+				return errors.New(Msg(MSG_WAT_NO_LABEL, tok))
+			}
+			found := false
+			for _, l := range labels {
+				if l == toks[i+1] {
+					found = true
+				}
+			}
+			if !found {
+				return errors.New(Msg(MSG_WAT_UNKNOWN_LABEL, tok, toks[i+1]))
+			}
+		}
+	}
+	if len(stack) != 0 {
+		return errors.New(Msg(MSG_WAT_UNBALANCED_OPEN))
+	}
+	return nil
+}
+
+// 将WAT文本切分为括号、关键字和字符串,忽略;;行注释
+func watTokens(src string) []string {
+	var toks []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ';' && i+1 < len(src) && src[i+1] == ';':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '(' || c == ')':
+			toks = append(toks, string(c))
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				j++
+			}
+			toks = append(toks, src[i:j+1])
+			i = j + 1
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		default:
+			j := i
+			for j < len(src) && !strings.ContainsRune(" \t\n\r()", rune(src[j])) {
+				j++
+			}
+			toks = append(toks, src[i:j])
+			i = j
+		}
+	}
+	return toks
+}
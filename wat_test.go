@@ -0,0 +1,449 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: wat_test.go
+// Package: scan
+// Description: WAT 后端的测试
+// 				示例程序生成的模块必须通过 ValidateWAT,并由一个只支持生成器所用指令子集的参考解释器执行,比较输出
+// 				参考解释器直接解释文本格式,不依赖外部工具链
+
+package scan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWATValidate(t *testing.T) {
+	for _, s := range samples {
+		var out strings.Builder
+		if err := NewWATGenerator(&out).Generate(parseSample(t, s)); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if err := ValidateWAT(out.String()); err != nil {
+			t.Errorf("%s: %v", s.name, err)
+		}
+	}
+}
+
+func TestWATValidateRejects(t *testing.T) {
+	bad := map[string]string{
+		"unbalanced":     `(module (func $main)`,
+		"extra paren":    `(module (func $main)))`,
+		"unknown call":   `(module (func $main call $f))`,
+		"unknown local":  `(module (func $main local.get $x drop))`,
+		"unknown label":  `(module (func $main (block $a br $b)))`,
+		"label scope":    `(module (func $main (block $a) br $a))`,
+		"local in other": `(module (func $f (local $x i32)) (func $main local.get $x drop))`,
+	}
+	for name, src := range bad {
+		if err := ValidateWAT(src); err == nil {
+			t.Errorf("%s: accepted %s", name, src)
+		}
+	}
+}
+
+func TestWATExecute(t *testing.T) {
+	for _, s := range samples {
+		var out strings.Builder
+		if err := NewWATGenerator(&out).Generate(parseSample(t, s)); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		got, err := runWAT(out.String(), s.input)
+		if err != nil {
+			t.Errorf("%s: %v", s.name, err)
+			continue
+		}
+		if got != s.want {
+			t.Errorf("%s: got %q, want %q", s.name, got, s.want)
+		}
+	}
+}
+
+// S 表达式
+type watSexp struct {
+	atom string
+	list []*watSexp
+}
+
+func (e *watSexp) isList() bool {
+	return e.list != nil
+}
+
+// 列表的关键字
+func (e *watSexp) head() string {
+	if len(e.list) == 0 || e.list[0].isList() {
+		return ""
+	}
+	return e.list[0].atom
+}
+
+// 把记号序列解析为 S 表达式
+func parseWATSexp(toks []string, pos *int) (*watSexp, error) {
+	if *pos >= len(toks) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	tok := toks[*pos]
+	*pos++
+	if tok == ")" {
+		return nil, fmt.Errorf("unexpected ')'")
+	}
+	if tok != "(" {
+		return &watSexp{atom: tok}, nil
+	}
+	e := &watSexp{list: []*watSexp{}}
+	for *pos < len(toks) && toks[*pos] != ")" {
+		sub, err := parseWATSexp(toks, pos)
+		if err != nil {
+			return nil, err
+		}
+		e.list = append(e.list, sub)
+	}
+	if *pos >= len(toks) {
+		return nil, fmt.Errorf("missing ')'")
+	}
+	*pos++
+	return e, nil
+}
+
+// 参考解释器中的函数
+type watFunc struct {
+	params []string
+	result bool
+	locals []string
+	body   []*watSexp
+}
+
+// 参考解释器
+type watMachine struct {
+	funcs   map[string]*watFunc
+	globals map[string]int32
+	mem     []byte
+	in      *strings.Reader
+	out     strings.Builder
+	steps   int
+	depth   int
+}
+
+// 跳出到标号的控制信号
+type watBranch struct {
+	label string
+}
+
+// 解释执行 WAT 模块的 main,input/output 使用给定的输入并收集输出
+func runWAT(src, input string) (string, error) {
+	pos := 0
+	mod, err := parseWATSexp(watTokens(src), &pos)
+	if err != nil {
+		return "", err
+	}
+	if mod.head() != "module" {
+		return "", fmt.Errorf("not a module")
+	}
+	m := &watMachine{funcs: make(map[string]*watFunc), globals: make(map[string]int32), in: strings.NewReader(input)}
+	for _, item := range mod.list[1:] {
+		switch item.head() {
+		case "memory":
+			pages, err := strconv.Atoi(item.list[len(item.list)-1].atom)
+			if err != nil {
+				return "", fmt.Errorf("memory: %v", err)
+			}
+			m.mem = make([]byte, pages*65536)
+		case "global":
+			init := item.list[len(item.list)-1]
+			if init.head() != "i32.const" {
+				return "", fmt.Errorf("global: unsupported initializer")
+			}
+			v, err := strconv.ParseInt(init.list[1].atom, 10, 32)
+			if err != nil {
+				return "", err
+			}
+			m.globals[item.list[1].atom] = int32(v)
+		case "func":
+			fn := &watFunc{}
+			for _, e := range item.list[2:] {
+				switch e.head() {
+				case "param":
+					fn.params = append(fn.params, e.list[1].atom)
+				case "local":
+					fn.locals = append(fn.locals, e.list[1].atom)
+				case "result":
+					fn.result = true
+				case "export":
+				default:
+					fn.body = append(fn.body, e)
+				}
+			}
+			m.funcs[item.list[1].atom] = fn
+		}
+	}
+	if _, err := m.call("$main", nil); err != nil {
+		return m.out.String(), err
+	}
+	return m.out.String(), nil
+}
+
+// 调用函数
+func (m *watMachine) call(name string, args []int32) (int32, error) {
+	switch name {
+	case "$input":
+		var v int64
+		if _, err := fmt.Fscan(m.in, &v); err != nil {
+			return 0, fmt.Errorf("input: %v", err)
+		}
+		return int32(v), nil
+	case "$output":
+		fmt.Fprintf(&m.out, "%d\n", args[0])
+		return 0, nil
+	}
+	fn, ok := m.funcs[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown function %s", name)
+	}
+	if m.depth++; m.depth > 10000 {
+		return 0, fmt.Errorf("call depth exceeded")
+	}
+	defer func() { m.depth-- }()
+	locals := make(map[string]int32)
+	for i, p := range fn.params {
+		locals[p] = args[i]
+	}
+	for _, l := range fn.locals {
+		locals[l] = 0
+	}
+	var stack []int32
+	if br, err := m.exec(fn.body, locals, &stack); err != nil {
+		return 0, err
+	} else if br != nil {
+		return 0, fmt.Errorf("branch to %s escapes function", br.label)
+	}
+	if fn.result {
+		if len(stack) != 1 {
+			return 0, fmt.Errorf("%s: %d values on stack at return", name, len(stack))
+		}
+		return stack[0], nil
+	}
+	if len(stack) != 0 {
+		return 0, fmt.Errorf("%s: %d values left on stack", name, len(stack))
+	}
+	return 0, nil
+}
+
+// 执行指令序列,遇到跳出时返回跳出的标号
+func (m *watMachine) exec(seq []*watSexp, locals map[string]int32, stack *[]int32) (*watBranch, error) {
+	pop := func() (int32, error) {
+		n := len(*stack)
+		if n == 0 {
+			return 0, fmt.Errorf("stack underflow")
+		}
+		v := (*stack)[n-1]
+		*stack = (*stack)[:n-1]
+		return v, nil
+	}
+	push := func(v int32) {
+		*stack = append(*stack, v)
+	}
+	addr := func(a int32) (int, error) {
+		if a < 0 || int(a)+4 > len(m.mem) {
+			return 0, fmt.Errorf("memory access out of bounds: %d", a)
+		}
+		return int(a), nil
+	}
+	for i := 0; i < len(seq); i++ {
+		if m.steps++; m.steps > 10000000 {
+			return nil, fmt.Errorf("step limit exceeded")
+		}
+		e := seq[i]
+		if e.isList() {
+			var body []*watSexp
+			label := ""
+			switch e.head() {
+			case "block", "loop":
+				body = e.list[1:]
+				if len(body) > 0 && !body[0].isList() && strings.HasPrefix(body[0].atom, "$") {
+					label, body = body[0].atom, body[1:]
+				}
+			case "if":
+				cond, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				for _, arm := range e.list[1:] {
+					if arm.head() == "then" && cond != 0 || arm.head() == "else" && cond == 0 {
+						body = arm.list[1:]
+					}
+				}
+			default:
+				return nil, fmt.Errorf("unsupported form (%s", e.head())
+			}
+			for {
+				br, err := m.exec(body, locals, stack)
+				if err != nil {
+					return nil, err
+				}
+				if br == nil {
+					break
+				}
+				if br.label != label || label == "" {
+					return br, nil
+				}
+				if e.head() != "loop" {
+					break
+				}
+			}
+			continue
+		}
+
+		op := e.atom
+		arg := ""
+		switch op {
+		case "i32.const", "local.get", "local.set", "local.tee", "global.get", "global.set", "br", "br_if", "call":
+			i++
+			if i >= len(seq) {
+				return nil, fmt.Errorf("%s without operand", op)
+			}
+			arg = seq[i].atom
+		}
+		switch op {
+		case "i32.const":
+			v, err := strconv.ParseInt(arg, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			push(int32(v))
+		case "local.get":
+			push(locals[arg])
+		case "local.set", "local.tee":
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			locals[arg] = v
+			if op == "local.tee" {
+				push(v)
+			}
+		case "global.get":
+			push(m.globals[arg])
+		case "global.set":
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			m.globals[arg] = v
+		case "drop":
+			if _, err := pop(); err != nil {
+				return nil, err
+			}
+		case "br":
+			return &watBranch{label: arg}, nil
+		case "br_if":
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			if v != 0 {
+				return &watBranch{label: arg}, nil
+			}
+		case "call":
+			fn := m.funcs[arg]
+			n := 0
+			switch {
+			case fn != nil:
+				n = len(fn.params)
+			case arg == "$output":
+				n = 1
+			}
+			args := make([]int32, n)
+			for k := n - 1; k >= 0; k-- {
+				v, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				args[k] = v
+			}
+			v, err := m.call(arg, args)
+			if err != nil {
+				return nil, err
+			}
+			if fn != nil && fn.result || arg == "$input" {
+				push(v)
+			}
+		case "i32.load":
+			a, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			p, err := addr(a)
+			if err != nil {
+				return nil, err
+			}
+			push(int32(binary.LittleEndian.Uint32(m.mem[p:])))
+		case "i32.store":
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			a, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			p, err := addr(a)
+			if err != nil {
+				return nil, err
+			}
+			binary.LittleEndian.PutUint32(m.mem[p:], uint32(v))
+		case "i32.eqz":
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			push(watBool(v == 0))
+		default:
+			y, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			x, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			switch op {
+			case "i32.add":
+				push(x + y)
+			case "i32.sub":
+				push(x - y)
+			case "i32.mul":
+				push(x * y)
+			case "i32.div_s":
+				if y == 0 || x == -2147483648 && y == -1 {
+					return nil, fmt.Errorf("integer divide trap")
+				}
+				push(x / y)
+			case "i32.eq":
+				push(watBool(x == y))
+			case "i32.ne":
+				push(watBool(x != y))
+			case "i32.lt_s":
+				push(watBool(x < y))
+			case "i32.le_s":
+				push(watBool(x <= y))
+			case "i32.gt_s":
+				push(watBool(x > y))
+			case "i32.ge_s":
+				push(watBool(x >= y))
+			default:
+				return nil, fmt.Errorf("unsupported instruction %s", op)
+			}
+		}
+	}
+	return nil, nil
+}
+
+func watBool(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,502 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: x86.go
+// Package: scan
+// Description: 本文件定义了x86-64目标代码生成器(System V ABI, GNU as AT&T语法)
+// 				采用栈式求值,表达式结果保存在%rax中
+// 				int 为32位: 变量和中间结果以符号扩展后的64位值保存,算术运算使用32位指令后再符号扩展,与解释器的回绕一致
+// 				生成的汇编自带一个极小的运行时(_start、input、output),只依赖Linux系统调用
+// 				可以直接使用 as prog.s -o prog.o && ld prog.o -o prog 生成可执行文件
+
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// System V ABI 整数参数寄存器
+var x86ArgRegs = []string{"%rdi", "%rsi", "%rdx", "%rcx", "%r8", "%r9"}
+
+// x86-64 代码生成器
+type X86Generator struct {
+	out      io.Writer           // 汇编输出
+	globals  *scopeEnv           // 全局作用域
+	funcs    map[string]*ASTNode // 函数声明
+	env      *scopeEnv           // 当前作用域
+	offset   int                 // 当前函数已经分配的栈空间(字节)
+	depth    int                 // 表达式求值时的压栈深度,用于保证调用时栈16字节对齐
+	label    int                 // 标号计数器
+	retLabel string              // 当前函数的返回标号
+	err      error               // 生成过程中遇到的第一个错误
+}
+
+// x86-64 代码生成器工厂函数
+func NewX86Generator(out io.Writer) *X86Generator {
+	return &X86Generator{out: out}
+}
+
+// 生成整个程序的汇编代码
+func (g *X86Generator) Generate(root *ASTNode) error {
+	if root == nil {
+		return errors.New(Msg(MSG_GEN_EMPTY))
+	}
+	env, globals, funcs, err := collectProgram(root)
+	if err != nil {
+		return err
+	}
+	g.globals = env
+	g.funcs = make(map[string]*ASTNode, len(funcs))
+	for _, fn := range funcs {
+		g.funcs[nodeName(fn)] = fn
+	}
+	if _, ok := g.funcs["main"]; !ok {
+		return errors.New(Msg(MSG_GEN_NO_MAIN))
+	}
+
+	fmt.Fprintln(g.out, "# Generated by CMinusParser, target x86_64 (System V, GNU as)")
+	// 全局变量放在.bss段
+	if len(globals) > 0 {
+		fmt.Fprintln(g.out, "\t.bss")
+		for _, info := range globals {
+			size := int64(8)
+			if info.isArray {
+				size = 8 * info.size
+			}
+			fmt.Fprintln(g.out, "\t.align 8")
+			fmt.Fprintf(g.out, "%s:\n", x86GlobalLabel(info.name))
+			fmt.Fprintf(g.out, "\t.zero %d\n", size)
+		}
+	}
+
+	fmt.Fprintln(g.out, "\t.text")
+	for _, fn := range funcs {
+		g.genFunc(fn)
+		if g.err != nil {
+			return g.err
+		}
+	}
+	io.WriteString(g.out, x86Runtime)
+	return nil
+}
+
+// 全局变量对应的汇编标号
+func x86GlobalLabel(name string) string {
+	return "cmg_" + name
+}
+
+// 函数对应的汇编标号,加前缀避免与运行时符号冲突
+func x86FuncLabel(name string) string {
+	return "cm_" + name
+}
+
+// 记录第一个错误,id 为消息编号
+func (g *X86Generator) errorf(node *ASTNode, id string, args ...interface{}) {
+	if g.err == nil {
+		g.err = errors.New(Msg(MSG_AT_LINE, node.line, Msg(id, args...)))
+	}
+}
+
+// 输出一条指令
+func (g *X86Generator) emit(format string, args ...interface{}) {
+	fmt.Fprintf(g.out, "\t"+format+"\n", args...)
+}
+
+// 新建一个标号
+func (g *X86Generator) newLabel() string {
+	g.label++
+	return fmt.Sprintf(".L%d", g.label)
+}
+
+func (g *X86Generator) push() {
+	g.emit("pushq %%rax")
+	g.depth++
+}
+
+func (g *X86Generator) pop(reg string) {
+	g.emit("popq %s", reg)
+	g.depth--
+}
+
+// 计算函数体中局部变量所需的栈槽数
+func frameSlots(node *ASTNode) int {
+	if node == nil {
+		return 0
+	}
+	slots := 0
+	if isStmt(node, VAR_DECLARATION) {
+		info := declInfo(node, SYM_LOCAL)
+		if info.isArray {
+			slots += int(info.size)
+		} else {
+			slots++
+		}
+	} else {
+		slots += frameSlots(node.left) + frameSlots(node.mid) + frameSlots(node.right)
+	}
+	return slots + frameSlots(node.sibling)
+}
+
+// 为当前函数分配栈空间,返回相对于%rbp的偏移
+func (g *X86Generator) alloc(slots int) int {
+	g.offset += 8 * slots
+	return -g.offset
+}
+
+// 生成函数
+func (g *X86Generator) genFunc(fn *ASTNode) {
+	name := nodeName(fn)
+	params := funcParams(fn)
+	g.env = newScopeEnv(g.globals)
+	g.offset = 0
+	g.depth = 0
+	g.retLabel = ".Lret_" + name
+
+	frame := 8 * (len(params) + frameSlots(fn.right))
+	frame = (frame + 15) / 16 * 16
+
+	fmt.Fprintf(g.out, "\n\t.globl %s\n", x86FuncLabel(name))
+	fmt.Fprintf(g.out, "\t.type %s, @function\n", x86FuncLabel(name))
+	fmt.Fprintf(g.out, "%s:\n", x86FuncLabel(name))
+	g.emit("pushq %%rbp")
+	g.emit("movq %%rsp, %%rbp")
+	if frame > 0 {
+		g.emit("subq $%d, %%rsp", frame)
+	}
+
+	// 形参全部保存到栈上,前6个来自寄存器,其余来自调用者的栈帧
+	for i, p := range params {
+		info := declInfo(p, SYM_PARAM)
+		info.index = i
+		info.slot = g.alloc(1)
+		if g.env.put(info) != nil {
+			g.errorf(p, MSG_GEN_REDECLARED, info.name)
+			return
+		}
+		if i < len(x86ArgRegs) {
+			g.emit("movq %s, %d(%%rbp)", x86ArgRegs[i], info.slot)
+		} else {
+			g.emit("movq %d(%%rbp), %%rax", 16+8*(i-len(x86ArgRegs)))
+			g.emit("movq %%rax, %d(%%rbp)", info.slot)
+		}
+	}
+
+	g.genStmt(fn.right)
+
+	// 函数末尾没有return时返回0
+	g.emit("movq $0, %%rax")
+	fmt.Fprintf(g.out, "%s:\n", g.retLabel)
+	g.emit("leave")
+	g.emit("ret")
+}
+
+// 生成语句序列
+func (g *X86Generator) genStmtList(node *ASTNode) {
+	for ; node != nil && g.err == nil; node = node.sibling {
+		g.genStmt(node)
+	}
+}
+
+// 生成单条语句
+func (g *X86Generator) genStmt(node *ASTNode) {
+	if node == nil {
+		return
+	}
+	if node.nodeK == EXPRESSION { // 表达式语句
+		g.genExpr(node)
+		return
+	}
+
+	switch node.nodeT {
+	case COMPOUND:
+		g.env = newScopeEnv(g.env)
+		for d := node.left; d != nil; d = d.sibling {
+			info := declInfo(d, SYM_LOCAL)
+			if info.isArray {
+				info.slot = g.alloc(int(info.size))
+			} else {
+				info.slot = g.alloc(1)
+			}
+			if g.env.put(info) != nil {
+				g.errorf(d, MSG_GEN_REDECLARED, info.name)
+				return
+			}
+		}
+		g.genStmtList(node.right)
+		g.env = g.env.prev
+	case SELECTION_STMT:
+		els, end := g.newLabel(), g.newLabel()
+		g.genExpr(node.left)
+		g.emit("cmpq $0, %%rax")
+		g.emit("je %s", els)
+		g.genStmt(node.mid)
+		g.emit("jmp %s", end)
+		fmt.Fprintf(g.out, "%s:\n", els)
+		g.genStmt(node.right)
+		fmt.Fprintf(g.out, "%s:\n", end)
+	case ITERATION_STMT:
+		begin, end := g.newLabel(), g.newLabel()
+		fmt.Fprintf(g.out, "%s:\n", begin)
+		g.genExpr(node.left)
+		g.emit("cmpq $0, %%rax")
+		g.emit("je %s", end)
+		g.genStmt(node.mid)
+		g.emit("jmp %s", begin)
+		fmt.Fprintf(g.out, "%s:\n", end)
+	case RETURN_STMT:
+		if node.left != nil {
+			g.genExpr(node.left)
+		} else {
+			g.emit("movq $0, %%rax")
+		}
+		g.emit("jmp %s", g.retLabel)
+	default:
+		g.errorf(node, MSG_GEN_UNEXPECTED_STMT)
+	}
+}
+
+// 计算变量的地址,结果保存在%rax
+func (g *X86Generator) genAddr(node *ASTNode) {
+	name := nodeName(node)
+	info := g.env.lookup(name)
+	if info == nil {
+		g.errorf(node, MSG_GEN_UNDEFINED_VAR, name)
+		return
+	}
+
+	// 变量(或数组首元素)的地址
+	switch {
+	case info.kind == SYM_GLOBAL:
+		g.emit("leaq %s(%%rip), %%rax", x86GlobalLabel(name))
+	case info.kind == SYM_PARAM && info.isArray: // 数组形参保存的是指针
+		g.emit("movq %d(%%rbp), %%rax", info.slot)
+	default:
+		g.emit("leaq %d(%%rbp), %%rax", info.slot)
+	}
+
+	if node.left != nil { // 数组元素
+		if !info.isArray {
+			g.errorf(node, MSG_GEN_NOT_ARRAY, name)
+			return
+		}
+		g.push()
+		g.genExpr(node.left)
+		g.emit("movq %%rax, %%rdi")
+		g.pop("%rax")
+		g.emit("leaq (%%rax,%%rdi,8), %%rax")
+	}
+}
+
+// 生成表达式,结果保存在%rax
+func (g *X86Generator) genExpr(node *ASTNode) {
+	if node == nil || g.err != nil {
+		return
+	}
+	switch node.nodeT {
+	case CONST:
+		g.emit("movq $%d, %%rax", int32(nodeValue(node)))
+	case VAR:
+		g.genAddr(node)
+		info := g.env.lookup(nodeName(node))
+		// 不带下标的数组名作为地址使用(传递给数组形参)
+		if info != nil && (!info.isArray || node.left != nil) {
+			g.emit("movq (%%rax), %%rax")
+		}
+	case ASSIGNMENT:
+		g.genAddr(node.left)
+		g.push()
+		g.genExpr(node.right)
+		g.pop("%rdi")
+		g.emit("movq %%rax, (%%rdi)")
+	case CALL:
+		g.genCall(node)
+	case OPERATION, COMPARE:
+		g.genExpr(node.left)
+		g.push()
+		g.genExpr(node.right)
+		g.emit("movq %%rax, %%rdi")
+		g.pop("%rax")
+		g.genBinary(node)
+	default:
+		g.errorf(node, MSG_GEN_UNEXPECTED_EXPR)
+	}
+}
+
+// 生成二元运算,左操作数在%rax,右操作数在%rdi
+// 算术运算按32位进行,结果符号扩展到%rax
+func (g *X86Generator) genBinary(node *ASTNode) {
+	op := nodeOp(node)
+	switch op {
+	case PLUS:
+		g.emit("addl %%edi, %%eax")
+		g.emit("cltq")
+	case MINUS:
+		g.emit("subl %%edi, %%eax")
+		g.emit("cltq")
+	case MUL:
+		g.emit("imull %%edi, %%eax")
+		g.emit("cltq")
+	case DIV:
+		g.emit("cltd")
+		g.emit("idivl %%edi")
+		g.emit("cltq")
+	case LT, LE, GT, GE, EQ, NOT_EQ:
+		set := map[Token]string{LT: "setl", LE: "setle", GT: "setg", GE: "setge", EQ: "sete", NOT_EQ: "setne"}[op]
+		g.emit("cmpq %%rdi, %%rax")
+		g.emit("%s %%al", set)
+		g.emit("movzbq %%al, %%rax")
+	default:
+		g.errorf(node, MSG_GEN_UNKNOWN_OP)
+	}
+}
+
+// 生成函数调用
+// 实参从左到右求值并压栈,再把前6个装入寄存器,其余按逆序重新压栈
+func (g *X86Generator) genCall(node *ASTNode) {
+	name := nodeName(node)
+	args := callArgs(node)
+
+	label := x86FuncLabel(name)
+	switch name {
+	case BUILTIN_INPUT, BUILTIN_OUTPUT:
+		if _, ok := g.funcs[name]; ok { // 用户自定义的同名函数优先
+			break
+		}
+		label = "cmrt_" + name
+	default:
+		fn, ok := g.funcs[name]
+		if !ok {
+			g.errorf(node, MSG_GEN_UNDEFINED_FUNC, name)
+			return
+		}
+		if len(funcParams(fn)) != len(args) {
+			g.errorf(node, MSG_GEN_ARG_COUNT, name, len(funcParams(fn)), len(args))
+			return
+		}
+	}
+
+	n := len(args)
+	stackArgs := 0
+	if n > len(x86ArgRegs) {
+		stackArgs = n - len(x86ArgRegs)
+	}
+	// 保证call指令执行时栈16字节对齐
+	pad := (g.depth + n + stackArgs) % 2
+	if pad == 1 {
+		g.emit("subq $8, %%rsp")
+		g.depth++
+	}
+
+	for _, a := range args {
+		g.genExpr(a)
+		g.push()
+	}
+	// 第i个实参当前位于 (n-1-i)*8(%rsp)
+	for i := n - 1; i >= len(x86ArgRegs); i-- {
+		g.emit("pushq %d(%%rsp)", 2*(n-1-i)*8)
+		g.depth++
+	}
+	for i := 0; i < n && i < len(x86ArgRegs); i++ {
+		g.emit("movq %d(%%rsp), %s", (n-1-i+stackArgs)*8, x86ArgRegs[i])
+	}
+	g.emit("call %s", label)
+
+	if drop := n + stackArgs + pad; drop > 0 {
+		g.emit("addq $%d, %%rsp", 8*drop)
+		g.depth -= drop
+	}
+}
+
+// 运行时: 程序入口以及input/output,只使用Linux系统调用
+const x86Runtime = `
+# ---- CMinusParser runtime ----
+	.globl _start
+_start:
+	call cm_main
+	movq $60, %rax
+	xorq %rdi, %rdi
+	syscall
+
+# int input(void): 从标准输入读取一个带符号十进制整数,回绕到32位
+cmrt_input:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $16, %rsp
+	xorq %r8, %r8
+	xorq %r9, %r9
+	xorq %r10, %r10
+.Lcmrt_in_read:
+	movq $0, %rax
+	movq $0, %rdi
+	leaq -1(%rbp), %rsi
+	movq $1, %rdx
+	syscall
+	cmpq $1, %rax
+	jne .Lcmrt_in_done
+	movzbq -1(%rbp), %rax
+	cmpq $48, %rax
+	jl .Lcmrt_in_other
+	cmpq $57, %rax
+	jg .Lcmrt_in_other
+	subq $48, %rax
+	imulq $10, %r8
+	addq %rax, %r8
+	movq $1, %r10
+	jmp .Lcmrt_in_read
+.Lcmrt_in_other:
+	testq %r10, %r10
+	jnz .Lcmrt_in_done
+	cmpq $45, %rax
+	jne .Lcmrt_in_read
+	movq $1, %r9
+	jmp .Lcmrt_in_read
+.Lcmrt_in_done:
+	movq %r8, %rax
+	testq %r9, %r9
+	jz .Lcmrt_in_ret
+	negq %rax
+.Lcmrt_in_ret:
+	cltq
+	leave
+	ret
+
+# void output(int x): 输出一个整数并换行
+cmrt_output:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $32, %rsp
+	movq %rdi, %rax
+	leaq -1(%rbp), %rsi
+	movb $10, (%rsi)
+	movq $1, %rcx
+	xorq %r8, %r8
+	testq %rax, %rax
+	jns .Lcmrt_out_loop
+	negq %rax
+	movq $1, %r8
+.Lcmrt_out_loop:
+	xorq %rdx, %rdx
+	movq $10, %r9
+	divq %r9
+	addb $48, %dl
+	decq %rsi
+	movb %dl, (%rsi)
+	incq %rcx
+	testq %rax, %rax
+	jnz .Lcmrt_out_loop
+	testq %r8, %r8
+	jz .Lcmrt_out_write
+	decq %rsi
+	movb $45, (%rsi)
+	incq %rcx
+.Lcmrt_out_write:
+	movq %rcx, %rdx
+	movq $1, %rax
+	movq $1, %rdi
+	syscall
+	leave
+	ret
+
+	.section .note.GNU-stack,"",@progbits
+`
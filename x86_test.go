@@ -0,0 +1,63 @@
+// Copyright 2020. All rights reserved.
+// Author: Zhifei Liu, 2020/6
+// Filename: x86_test.go
+// Package: scan
+// Description: x86-64 后端的端到端测试
+// 				有 as 和 ld 时汇编、链接并执行示例程序,比较输出
+
+package scan
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestX86Generate(t *testing.T) {
+	for _, s := range samples {
+		var out strings.Builder
+		if err := NewX86Generator(&out).Generate(parseSample(t, s)); err != nil {
+			t.Errorf("%s: %v", s.name, err)
+		}
+	}
+}
+
+func TestX86EndToEnd(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("needs linux/amd64")
+	}
+	for _, tool := range []string{"as", "ld"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+	dir := t.TempDir()
+	for _, s := range samples {
+		var asm strings.Builder
+		if err := NewX86Generator(&asm).Generate(parseSample(t, s)); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		obj := filepath.Join(dir, s.name+".o")
+		bin := filepath.Join(dir, s.name)
+		as := exec.Command("as", "-o", obj, "-")
+		as.Stdin = strings.NewReader(asm.String())
+		if out, err := as.CombinedOutput(); err != nil {
+			t.Fatalf("%s: as: %v\n%s", s.name, err, out)
+		}
+		if out, err := exec.Command("ld", "-o", bin, obj).CombinedOutput(); err != nil {
+			t.Fatalf("%s: ld: %v\n%s", s.name, err, out)
+		}
+		cmd := exec.Command(bin)
+		cmd.Stdin = strings.NewReader(s.input)
+		got, err := cmd.Output()
+		if err != nil {
+			t.Errorf("%s: %v", s.name, err)
+			continue
+		}
+		if string(got) != s.want {
+			t.Errorf("%s: got %q, want %q", s.name, got, s.want)
+		}
+	}
+}